@@ -0,0 +1,43 @@
+// Package apierror answers failed LiteLLM calls the same way on every route,
+// whether the call was made by a handler or by middleware.
+package apierror
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+// Detail returns LiteLLM's explanation of a rejected request, which is
+// worth showing the caller because it usually names the offending field.
+func Detail(err error) string {
+	var upstream *services.UpstreamError
+	if !errors.As(err, &upstream) || upstream.Kind != services.ErrValidation {
+		return ""
+	}
+	return upstream.Message()
+}
+
+// SetRetryAfter tells the caller when to try again, if err says: LiteLLM's
+// own Retry-After, or how long the breaker of the failing instance stays
+// open.
+func SetRetryAfter(c echo.Context, err error) {
+	if wait := services.RetryDelay(err); wait > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	}
+}
+
+// Upstream answers a failed LiteLLM call with the status
+// services.ResponseStatus maps err to. msg says what failed, e.g. "Failed to
+// generate key".
+func Upstream(c echo.Context, err error, msg string) error {
+	SetRetryAfter(c, err)
+	body := map[string]string{"error": msg}
+	if detail := Detail(err); detail != "" {
+		body["detail"] = detail
+	}
+	return c.JSON(services.ResponseStatus(err), body)
+}
@@ -0,0 +1,221 @@
+// Package audit records mutating actions in a hash-chained audit log.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/example/llmreq/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Event describes one action to record. Before and After are marshalled to
+// JSON; leave them nil when there is no prior or resulting state.
+type Event struct {
+	Actor      string
+	UserID     string
+	Action     string
+	TargetType string
+	TargetID   string
+	IP         string
+	RequestID  string
+	Before     interface{}
+	After      interface{}
+}
+
+// Logger appends events to the audit_events table and, once stored,
+// publishes them to Stream. A nil *Logger records nothing, so callers may
+// leave it unset where auditing is not wanted.
+type Logger struct {
+	DB     *gorm.DB
+	Stream *Stream // nil when no sinks are configured
+}
+
+func NewLogger(db *gorm.DB) *Logger {
+	return &Logger{DB: db, Stream: DefaultStream}
+}
+
+// chainMu serialises appends so that each event links to the one before it,
+// even when several Loggers share a database.
+var chainMu sync.Mutex
+
+// Record appends e to the log. Failures are logged rather than returned: an
+// audit write must not undo an action that already happened in LiteLLM.
+func (l *Logger) Record(e Event) {
+	if l == nil {
+		return
+	}
+	event, err := l.append(e)
+	if err != nil {
+		log.Printf("Failed to record audit event %s: %v", e.Action, err)
+		return
+	}
+	l.Stream.Publish(auditEntry(event))
+}
+
+// RecordRequest fills in the actor, client IP and request ID from c before
+// recording e. Fields already set on e are kept.
+func (l *Logger) RecordRequest(c echo.Context, e Event) {
+	if l == nil {
+		return
+	}
+	if e.Actor == "" {
+		e.Actor, _ = c.Get("user_id").(string)
+	}
+	if e.UserID == "" {
+		e.UserID = e.Actor
+	}
+	e.IP = c.RealIP()
+	e.RequestID = RequestID(c)
+	l.Record(e)
+}
+
+// RequestID returns the ID assigned to the request by the request ID
+// middleware, or the one the client sent.
+func RequestID(c echo.Context) string {
+	if id := c.Response().Header().Get(echo.HeaderXRequestID); id != "" {
+		return id
+	}
+	return c.Request().Header.Get(echo.HeaderXRequestID)
+}
+
+func (l *Logger) append(e Event) (*models.AuditEvent, error) {
+	before, err := marshalState(e.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := marshalState(e.After)
+	if err != nil {
+		return nil, err
+	}
+
+	event := models.AuditEvent{
+		CreatedAt:  time.Now().UTC().Truncate(time.Microsecond),
+		Actor:      e.Actor,
+		UserID:     e.UserID,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		IP:         e.IP,
+		RequestID:  e.RequestID,
+		Before:     before,
+		After:      after,
+	}
+
+	chainMu.Lock()
+	defer chainMu.Unlock()
+
+	err = l.DB.Transaction(func(tx *gorm.DB) error {
+		var last models.AuditEvent
+		if err := tx.Order("id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+		event.PrevHash = last.Hash
+		event.Hash = Hash(&event)
+		return tx.Create(&event).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func marshalState(v interface{}) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// Hash computes the chain hash of e from its content and PrevHash. The ID is
+// not included because it is assigned by the database after hashing.
+func Hash(e *models.AuditEvent) string {
+	content, _ := json.Marshal([]string{
+		e.PrevHash,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.Actor,
+		e.UserID,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.IP,
+		e.RequestID,
+		e.Before,
+		e.After,
+	})
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyResult reports the outcome of walking the chain. FirstInvalidID is
+// the first event whose hash or link does not match, or 0 when the chain is
+// intact.
+type VerifyResult struct {
+	Valid          bool `json:"valid"`
+	Checked        int  `json:"checked"`
+	FirstInvalidID uint `json:"first_invalid_id,omitempty"`
+}
+
+// Verify recomputes every hash in order and checks that each event links to
+// its predecessor.
+func Verify(db *gorm.DB) (VerifyResult, error) {
+	result := VerifyResult{Valid: true}
+	prevHash := ""
+
+	var batch []models.AuditEvent
+	err := db.Order("id").FindInBatches(&batch, 500, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			e := &batch[i]
+			result.Checked++
+			if e.PrevHash != prevHash || Hash(e) != e.Hash {
+				result.Valid = false
+				result.FirstInvalidID = e.ID
+				return errStop
+			}
+			prevHash = e.Hash
+		}
+		return nil
+	}).Error
+	if err != nil && err != errStop {
+		return result, err
+	}
+	return result, nil
+}
+
+var errStop = errors.New("stop verification")
+
+// KeyState is the audited view of a key's history row.
+func KeyState(k *models.KeyHistory) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":     k.UserID,
+		"team_id":     k.TeamID,
+		"name":        k.KeyName,
+		"type":        k.KeyType,
+		"status":      k.Status,
+		"expires_at":  k.ExpiresAt,
+		"revoked_at":  k.RevokedAt,
+		"renew_count": k.RenewCount,
+		"blocked":     k.Blocked,
+	}
+}
+
+// UserState is the audited view of a local user record.
+func UserState(u *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"tier":        u.Tier,
+		"tier_source": u.TierSource,
+		"status":      u.Status,
+		"successor":   u.Successor,
+		"final_spend": u.FinalSpend,
+	}
+}
@@ -0,0 +1,84 @@
+package audit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/example/llmreq/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return db
+}
+
+func TestLoggerChainAndVerify(t *testing.T) {
+	db := setupTestDB(t)
+	l := NewLogger(db)
+
+	l.Record(Event{Actor: "system:sync", UserID: "a@example.com", Action: "key.sync_revoke", TargetType: "key", TargetID: "sk-1",
+		Before: map[string]string{"status": "active"}, After: map[string]string{"status": "revoked"}})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/keys", nil)
+	req.Header.Set(echo.HeaderXRealIP, "10.0.0.7")
+	req.Header.Set(echo.HeaderXRequestID, "req-42")
+	c := e.NewContext(req, httptest.NewRecorder())
+	c.Set("user_id", "a@example.com")
+	l.RecordRequest(c, Event{Action: "key.create", TargetType: "key", TargetID: "sk-2", After: map[string]string{"name": "k"}})
+
+	var events []models.AuditEvent
+	db.Order("id").Find(&events)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if events[0].PrevHash != "" || events[1].PrevHash != events[0].Hash {
+		t.Errorf("Events are not chained: %+v", events)
+	}
+	if events[1].Actor != "a@example.com" || events[1].UserID != "a@example.com" || events[1].IP != "10.0.0.7" || events[1].RequestID != "req-42" {
+		t.Errorf("Request context not captured: %+v", events[1])
+	}
+	if events[0].Before != `{"status":"active"}` || events[1].Before != "" {
+		t.Errorf("Unexpected state encoding: %q %q", events[0].Before, events[1].Before)
+	}
+
+	result, err := Verify(db)
+	if err != nil || !result.Valid || result.Checked != 2 {
+		t.Fatalf("Expected intact chain, got %+v, %v", result, err)
+	}
+
+	// Tampering with a row is detected at that row.
+	db.Model(&models.AuditEvent{}).Where("id = ?", events[0].ID).Update("actor", "someone-else")
+	result, err = Verify(db)
+	if err != nil || result.Valid || result.FirstInvalidID != events[0].ID {
+		t.Errorf("Expected tampering detected at %d, got %+v, %v", events[0].ID, result, err)
+	}
+
+	// A nil logger is a no-op.
+	var nilLogger *Logger
+	nilLogger.Record(Event{Action: "noop"})
+}
+
+func TestVerifyDetectsDeletion(t *testing.T) {
+	db := setupTestDB(t)
+	l := NewLogger(db)
+	for _, action := range []string{"a", "b", "c"} {
+		l.Record(Event{Actor: "admin@example.com", Action: action})
+	}
+
+	var middle models.AuditEvent
+	db.Where("action = ?", "b").First(&middle)
+	db.Delete(&middle)
+
+	result, err := Verify(db)
+	if err != nil || result.Valid || result.Checked != 2 {
+		t.Errorf("Expected deletion detected, got %+v, %v", result, err)
+	}
+}
@@ -0,0 +1,231 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// WriterSink writes each entry as one line of JSON.
+type WriterSink struct {
+	W io.Writer
+}
+
+// NewStdoutSink writes entries to standard output for log collectors that
+// scrape container output.
+func NewStdoutSink() *WriterSink {
+	return &WriterSink{W: os.Stdout}
+}
+
+func (s *WriterSink) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.W.Write(append(line, '\n'))
+	return err
+}
+
+func (s *WriterSink) Close() error {
+	return nil
+}
+
+// JSONLSink appends entries as newline-delimited JSON to a file. Once the
+// file would exceed MaxSize bytes it is renamed to path.1, older files move
+// up one number and the oldest beyond MaxFiles is removed.
+type JSONLSink struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+
+	file *os.File
+	size int64
+}
+
+// NewJSONLSink opens path for appending. A maxSize of 0 disables rotation.
+func NewJSONLSink(path string, maxSize int64, maxFiles int) (*JSONLSink, error) {
+	s := &JSONLSink{Path: path, MaxSize: maxSize, MaxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *JSONLSink) open() error {
+	f, err := os.OpenFile(s.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func (s *JSONLSink) Write(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	if s.MaxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.MaxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+func (s *JSONLSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	if s.MaxFiles < 1 {
+		// No backups are kept, so the current file is simply replaced.
+		os.Remove(s.Path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", s.Path, s.MaxFiles))
+		for i := s.MaxFiles - 1; i >= 1; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", s.Path, i), fmt.Sprintf("%s.%d", s.Path, i+1))
+		}
+		if err := os.Rename(s.Path, s.Path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate audit file: %w", err)
+		}
+	}
+	return s.open()
+}
+
+func (s *JSONLSink) Close() error {
+	return s.file.Close()
+}
+
+// Syslog severity and facility used for every message.
+const (
+	syslogFacilityAuthPriv = 10
+	syslogSeverityNotice   = 5
+
+	// syslogEnterpriseID is the private enterprise number used in structured
+	// data IDs. 32473 is reserved for documentation by RFC 5612.
+	syslogEnterpriseID = "32473"
+)
+
+// SyslogSink sends RFC 5424 messages over UDP, one per datagram, or over TCP
+// with octet-counting framing (RFC 6587). A failed TCP connection is
+// re-established on the next write.
+type SyslogSink struct {
+	Network  string
+	Addr     string
+	Hostname string
+	AppName  string
+	Timeout  time.Duration
+
+	conn net.Conn
+}
+
+// NewSyslogSink parses a target such as udp://siem:514 or tcp://siem:601.
+// Connecting is deferred to the first write so that an unreachable
+// collector does not stop the service from starting.
+func NewSyslogSink(target string) (*SyslogSink, error) {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" || (u.Scheme != "udp" && u.Scheme != "tcp") {
+		return nil, fmt.Errorf("invalid syslog address %q: expected udp://host:port or tcp://host:port", target)
+	}
+	hostname, _ := os.Hostname()
+	return &SyslogSink{
+		Network:  u.Scheme,
+		Addr:     u.Host,
+		Hostname: hostname,
+		AppName:  "llmreq",
+		Timeout:  5 * time.Second,
+	}, nil
+}
+
+func (s *SyslogSink) Write(e Entry) error {
+	msg, err := s.Format(e)
+	if err != nil {
+		return err
+	}
+	if s.Network == "tcp" {
+		msg = fmt.Sprintf("%d %s", len(msg), msg)
+	}
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout(s.Network, s.Addr, s.Timeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(s.Timeout))
+	if _, err := io.WriteString(s.conn, msg); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Format renders e as an RFC 5424 message. The action is the MSGID, the
+// main fields are repeated as structured data for filtering, and the message
+// body is the entry as JSON.
+func (s *SyslogSink) Format(e Entry) (string, error) {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+
+	sd := fmt.Sprintf(`[audit@%s kind="%s" actor="%s" user="%s" target="%s"]`, syslogEnterpriseID,
+		sdEscape(e.Kind), sdEscape(e.Actor), sdEscape(e.UserID), sdEscape(e.TargetID))
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		syslogFacilityAuthPriv*8+syslogSeverityNotice,
+		e.Time.UTC().Format(time.RFC3339Nano),
+		headerField(s.Hostname, 255),
+		headerField(s.AppName, 48),
+		os.Getpid(),
+		headerField(e.Action, 32),
+		sd,
+		body,
+	), nil
+}
+
+func (s *SyslogSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// headerField makes v a valid RFC 5424 header field: printable ASCII without
+// spaces, at most max characters, or "-" when empty.
+func headerField(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, v)
+	if v == "" {
+		return "-"
+	}
+	if len(v) > max {
+		v = v[:max]
+	}
+	return v
+}
+
+// sdEscape escapes the characters RFC 5424 reserves in parameter values.
+func sdEscape(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
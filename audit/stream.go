@@ -0,0 +1,211 @@
+package audit
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+)
+
+// Entry is the form in which audit events leave the service.
+type Entry struct {
+	Kind       string          `json:"kind"` // always "audit"
+	ID         uint            `json:"id"`
+	Time       time.Time       `json:"time"`
+	Actor      string          `json:"actor"`
+	UserID     string          `json:"user_id,omitempty"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	Hash       string          `json:"hash,omitempty"`
+}
+
+func auditEntry(e *models.AuditEvent) Entry {
+	entry := Entry{
+		Kind:       "audit",
+		ID:         e.ID,
+		Time:       e.CreatedAt,
+		Actor:      e.Actor,
+		UserID:     e.UserID,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		IP:         e.IP,
+		RequestID:  e.RequestID,
+		Hash:       e.Hash,
+	}
+	if e.Before != "" {
+		entry.Before = json.RawMessage(e.Before)
+	}
+	if e.After != "" {
+		entry.After = json.RawMessage(e.After)
+	}
+	return entry
+}
+
+// Sink delivers entries to one destination. Write is only called from the
+// sink's own goroutine, so implementations need not be safe for concurrent
+// use.
+type Sink interface {
+	Write(e Entry) error
+	Close() error
+}
+
+// Stream fans entries out to its sinks. Each sink has its own buffered queue
+// drained by its own goroutine, so a slow or unreachable sink neither blocks
+// the caller nor delays the other sinks. When a queue is full the entry is
+// dropped for that sink and counted; the database remains the complete
+// record.
+type Stream struct {
+	queues []*sinkQueue
+	wg     sync.WaitGroup
+
+	// mu guards closed. Publish holds it for reading while it queues, so
+	// Close cannot close a queue in the middle of a send.
+	mu     sync.RWMutex
+	closed bool
+}
+
+type sinkQueue struct {
+	name    string
+	sink    Sink
+	entries chan Entry
+	dropped atomic.Int64
+}
+
+// DefaultStream is used by Loggers created with NewLogger. It is nil, and
+// nothing is streamed, until main configures sinks.
+var DefaultStream *Stream
+
+// NewStreamFromConfig builds the stream described by the audit sink settings
+// in config.AppConfig. It returns nil when no sink is configured.
+func NewStreamFromConfig() (*Stream, error) {
+	cfg := config.AppConfig
+	named := make(map[string]Sink)
+	if cfg.AuditJSONLPath != "" {
+		sink, err := NewJSONLSink(cfg.AuditJSONLPath, int64(cfg.AuditJSONLMaxSizeMB)<<20, cfg.AuditJSONLMaxFiles)
+		if err != nil {
+			return nil, err
+		}
+		named["jsonl"] = sink
+	}
+	if cfg.AuditSyslogAddr != "" {
+		sink, err := NewSyslogSink(cfg.AuditSyslogAddr)
+		if err != nil {
+			return nil, err
+		}
+		named["syslog"] = sink
+	}
+	if cfg.AuditStdout {
+		named["stdout"] = NewStdoutSink()
+	}
+	if len(named) == 0 {
+		return nil, nil
+	}
+	return NewStream(cfg.AuditBufferSize, named), nil
+}
+
+// NewStream starts one goroutine per sink, each with a queue of bufferSize
+// entries. Sinks are keyed by a name used in log messages.
+func NewStream(bufferSize int, sinks map[string]Sink) *Stream {
+	if bufferSize < 1 {
+		bufferSize = 1
+	}
+	s := &Stream{}
+	for name, sink := range sinks {
+		q := &sinkQueue{name: name, sink: sink, entries: make(chan Entry, bufferSize)}
+		s.queues = append(s.queues, q)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			q.run()
+		}()
+	}
+	return s
+}
+
+// Publish queues e on every sink without blocking. A nil or closed *Stream
+// publishes nothing.
+func (s *Stream) Publish(e Entry) {
+	if s == nil {
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return
+	}
+	for _, q := range s.queues {
+		select {
+		case q.entries <- e:
+		default:
+			// Logging every drop would flood the log exactly when the sink
+			// is struggling, so only the first of each hundred is reported.
+			if n := q.dropped.Add(1); n%100 == 1 {
+				log.Printf("Audit sink %s is falling behind; %d event(s) dropped so far", q.name, n)
+			}
+		}
+	}
+}
+
+// Dropped returns how many entries each sink has dropped because its queue
+// was full.
+func (s *Stream) Dropped() map[string]int64 {
+	dropped := make(map[string]int64)
+	if s == nil {
+		return dropped
+	}
+	for _, q := range s.queues {
+		dropped[q.name] = q.dropped.Load()
+	}
+	return dropped
+}
+
+// Close stops accepting entries, waits up to timeout for queued entries to
+// be written and closes the sinks. Entries published afterwards are
+// discarded.
+func (s *Stream) Close(timeout time.Duration) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for _, q := range s.queues {
+		close(q.entries)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Printf("Audit sinks did not drain within %s", timeout)
+	}
+}
+
+func (q *sinkQueue) run() {
+	for e := range q.entries {
+		if err := q.sink.Write(e); err != nil {
+			log.Printf("Audit sink %s failed to write event %s: %v", q.name, e.Action, err)
+		}
+	}
+	if err := q.sink.Close(); err != nil {
+		log.Printf("Audit sink %s failed to close: %v", q.name, err)
+	}
+}
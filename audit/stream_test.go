@@ -0,0 +1,217 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJSONLSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	sink, err := NewJSONLSink(path, 300, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := sink.Write(Entry{Kind: "audit", Action: "key.create", Actor: "a@example.com", Time: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sink.Close()
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Expected %s to exist: %v", name, err)
+		}
+		if len(data) > 300 {
+			t.Errorf("%s exceeds max size: %d bytes", name, len(data))
+		}
+		for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+			var e Entry
+			if err := json.Unmarshal([]byte(line), &e); err != nil || e.Action != "key.create" {
+				t.Errorf("Invalid line in %s: %q", name, line)
+			}
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 rotated files")
+	}
+}
+
+func TestSyslogSink(t *testing.T) {
+	entry := Entry{
+		Kind:     "audit",
+		Time:     time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+		Actor:    "admin@example.com",
+		UserID:   `bob"]@example.com`,
+		Action:   "user.suspend",
+		TargetID: "bob@example.com",
+	}
+
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		sink, err := NewSyslogSink("udp://" + conn.LocalAddr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink.Hostname = "host1"
+		defer sink.Close()
+		if err := sink.Write(entry); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 4096)
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := string(buf[:n])
+		prefix := "<85>1 2026-01-02T03:04:05Z host1 llmreq "
+		if !strings.HasPrefix(msg, prefix) {
+			t.Errorf("Unexpected header: %q", msg)
+		}
+		if !strings.Contains(msg, ` user.suspend [audit@32473 kind="audit" actor="admin@example.com" user="bob\"\]@example.com" target="bob@example.com"] {`) {
+			t.Errorf("Unexpected MSGID or structured data: %q", msg)
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		lines := make(chan string, 2)
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			r := bufio.NewReader(conn)
+			for {
+				prefix, err := r.ReadString(' ')
+				if err != nil {
+					return
+				}
+				size, _ := strconv.Atoi(strings.TrimSpace(prefix))
+				msg := make([]byte, size)
+				if _, err := io.ReadFull(r, msg); err != nil {
+					return
+				}
+				lines <- string(msg)
+			}
+		}()
+
+		sink, err := NewSyslogSink("tcp://" + ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+		for i := 0; i < 2; i++ {
+			if err := sink.Write(entry); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i := 0; i < 2; i++ {
+			select {
+			case msg := <-lines:
+				if !strings.HasPrefix(msg, "<85>1 ") || !strings.HasSuffix(msg, "}") {
+					t.Errorf("Unexpected framed message: %q", msg)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timed out waiting for syslog message")
+			}
+		}
+	})
+
+	if _, err := NewSyslogSink("siem:514"); err == nil {
+		t.Error("Expected an error for an address without a scheme")
+	}
+}
+
+// blockingSink stalls every write until release is closed.
+type blockingSink struct {
+	release chan struct{}
+	written chan Entry
+}
+
+func (s *blockingSink) Write(e Entry) error {
+	<-s.release
+	s.written <- e
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestStreamDoesNotBlockOnSlowSink(t *testing.T) {
+	slow := &blockingSink{release: make(chan struct{}), written: make(chan Entry, 20)}
+	fast := &blockingSink{release: make(chan struct{}), written: make(chan Entry, 20)}
+	close(fast.release)
+	stream := NewStream(10, map[string]Sink{"slow": slow, "fast": fast})
+
+	start := time.Now()
+	for i := 0; i < 15; i++ {
+		stream.Publish(Entry{Action: "key.create"})
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Publish blocked for %s", elapsed)
+	}
+
+	// The fast sink keeps up independently of the stalled one.
+	want := 15 - int(stream.Dropped()["fast"])
+	for i := 0; i < want; i++ {
+		select {
+		case <-fast.written:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Fast sink received only %d of %d entries", i, want)
+		}
+	}
+
+	// The slow sink holds at most one entry in Write and ten in its queue.
+	if dropped := stream.Dropped()["slow"]; dropped < 4 {
+		t.Errorf("Expected the slow sink to drop entries, got %d", dropped)
+	}
+	close(slow.release)
+	stream.Close(2 * time.Second)
+	if len(slow.written) < 10 {
+		t.Errorf("Expected queued entries to be drained on close, got %d", len(slow.written))
+	}
+}
+
+// Publishing while the stream closes must neither panic on a closed queue
+// nor be reported by the race detector.
+func TestStreamPublishDuringClose(t *testing.T) {
+	sink := &blockingSink{release: make(chan struct{}), written: make(chan Entry, 1000)}
+	close(sink.release)
+	stream := NewStream(10, map[string]Sink{"sink": sink})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				stream.Publish(Entry{Action: "key.create"})
+			}
+		}()
+	}
+	stream.Close(2 * time.Second)
+	stream.Close(time.Second)
+	wg.Wait()
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/services"
+)
+
+// runCaptureLiteLLM records the responses of a running LiteLLM release into
+// a fixture set for services/contract_test.go. It seeds the data every set
+// describes, then makes each admin call llmreq makes and keeps the body of
+// the first successful response per endpoint. Point it at a disposable
+// instance: it creates users, keys and a team there.
+func runCaptureLiteLLM(args []string) {
+	flags := flag.NewFlagSet("capture-litellm", flag.ExitOnError)
+	url := flags.String("url", envOr("LITELLM_API_URL", "http://localhost:4000"), "LiteLLM to capture from")
+	masterKey := flags.String("master-key", envOr("LITELLM_MASTER_KEY", "sk-master-key"), "LiteLLM master key")
+	release := flags.String("release", "", "release the instance runs, e.g. v1.72.0; names the fixture set")
+	out := flags.String("out", "services/testdata/litellm", "directory the fixture set is written under")
+	_ = flags.Parse(args)
+
+	if !strings.HasPrefix(*release, "v") {
+		log.Fatal("capture-litellm: -release must name the LiteLLM release, e.g. v1.72.0")
+	}
+	dir := filepath.Join(*out, *release)
+	if err := os.RemoveAll(dir); err != nil {
+		log.Fatalf("capture-litellm: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("capture-litellm: %v", err)
+	}
+	if err := captureFixtures(context.Background(), *url, *masterKey, dir); err != nil {
+		log.Fatalf("capture-litellm: %v", err)
+	}
+	log.Printf("Captured LiteLLM %s fixtures in %s", *release, dir)
+}
+
+// fixtureRecorder writes the body of the first 200 response of each path
+// into dir, named the way the contract test's fixture server looks it up.
+type fixtureRecorder struct {
+	dir  string
+	next http.RoundTripper
+}
+
+func (r *fixtureRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	if resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+
+	path := strings.Trim(req.URL.Path, "/")
+	if strings.HasPrefix(path, "user/info/") {
+		path = "user/info"
+	}
+	file := filepath.Join(r.dir, strings.ReplaceAll(path, "/", "_")+".json")
+	if _, err := os.Stat(file); err == nil {
+		return resp, nil
+	}
+	var pretty bytes.Buffer
+	if err := json.Indent(&pretty, body, "", "  "); err != nil {
+		return nil, fmt.Errorf("%s did not answer JSON: %w", req.URL.Path, err)
+	}
+	pretty.WriteByte('\n')
+	if err := os.WriteFile(file, pretty.Bytes(), 0o644); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// captureFixtures seeds the fixture data and records every client call.
+// The seeding requests set fields llmreq never sends, such as spend, and
+// are not recorded.
+func captureFixtures(ctx context.Context, url, masterKey, dir string) error {
+	config.AppConfig = &config.Config{}
+	client := &http.Client{Timeout: 30 * time.Second}
+	litellm := &services.LiteLLMService{
+		BaseURL:   url,
+		MasterKey: config.NewSecret(masterKey),
+		Client:    &http.Client{Timeout: 30 * time.Second, Transport: &fixtureRecorder{dir: dir, next: http.DefaultTransport}},
+	}
+	seed := func(path string, payload map[string]interface{}) error {
+		body, _ := json.Marshal(payload)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+masterKey)
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			msg, _ := io.ReadAll(resp.Body)
+			return fmt.Errorf("seeding %s: %s: %s", path, resp.Status, msg)
+		}
+		return nil
+	}
+
+	const alice, bob, carol = "alice@example.com", "bob@example.com", "carol@example.com"
+	if _, err := litellm.DetectVersion(ctx); err != nil {
+		return err
+	}
+	if err := litellm.CreateUser(ctx, services.NewUserRequest{UserID: alice, UserEmail: alice, MaxBudget: 10}); err != nil {
+		return err
+	}
+	seeds := []struct {
+		path    string
+		payload map[string]interface{}
+	}{
+		{"/user/update", map[string]interface{}{"user_id": alice, "spend": 1.25}},
+		{"/user/new", map[string]interface{}{"user_id": bob, "user_email": bob}},
+		{"/user/new", map[string]interface{}{"user_id": carol, "user_email": carol}},
+		{"/key/generate", map[string]interface{}{"user_id": alice, "key_alias": "laptop", "spend": 0.5}},
+		{"/key/generate", map[string]interface{}{"user_id": alice, "key_alias": "ci", "spend": 0.25}},
+	}
+	for _, s := range seeds {
+		if err := seed(s.path, s.payload); err != nil {
+			return err
+		}
+	}
+
+	team, err := litellm.CreateTeam(ctx, services.NewTeamRequest{
+		TeamAlias: "platform",
+		Members: []services.LiteLLMTeamMember{
+			{UserID: alice, Role: "admin"},
+			{UserID: bob, Role: "user"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := litellm.GetTeamInfo(ctx, team.TeamID); err != nil {
+		return err
+	}
+	if _, err := litellm.GetUserInfo(ctx, alice); err != nil {
+		return err
+	}
+	// A release that lists bare tokens still answers 200, so its listing is
+	// recorded and the contract test reports what the adapter makes of it.
+	if _, err := litellm.ListKeys(ctx, alice); err != nil && !errors.Is(err, services.ErrIncompleteListing) {
+		return err
+	}
+	if _, err := litellm.ListModels(ctx); err != nil {
+		return err
+	}
+	gen, err := litellm.GenerateKey(ctx, services.GenerateKeyRequest{UserID: alice, KeyAlias: "laptop2"})
+	if err != nil {
+		return err
+	}
+
+	budget := 5.0
+	for _, call := range []func() error{
+		func() error {
+			return litellm.UpdateUser(ctx, services.UpdateUserRequest{UserID: alice, MaxBudget: &budget})
+		},
+		func() error { return litellm.UpdateKey(ctx, services.UpdateKeyRequest{Key: gen.Key, Duration: "60d"}) },
+		func() error { return litellm.BlockKey(ctx, gen.Key) },
+		func() error { return litellm.UnblockKey(ctx, gen.Key) },
+		func() error { return litellm.DeleteKey(ctx, gen.Key) },
+		func() error {
+			return litellm.UpdateTeam(ctx, services.UpdateTeamRequest{TeamID: team.TeamID, MaxBudget: &budget})
+		},
+		func() error {
+			return litellm.AddTeamMember(ctx, team.TeamID, services.LiteLLMTeamMember{UserID: carol, Role: "user"})
+		},
+		func() error { return litellm.UpdateTeamMember(ctx, team.TeamID, bob, "admin") },
+		func() error { return litellm.RemoveTeamMember(ctx, team.TeamID, bob) },
+	} {
+		if err := call(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
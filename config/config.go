@@ -13,7 +13,16 @@ import (
 type Config struct {
 	Prefix              string
 	LiteLLMAPIURL       string
-	LiteLLMMasterKey    string
+	LiteLLMMasterKey    *Secret
+	LiteLLMRetries      int
+	LiteLLMRetryBackoff time.Duration
+	LiteLLMCacheTTL     time.Duration
+	LiteLLMTimeout      time.Duration
+	ModelCatalogTTL     time.Duration
+
+	LiteLLMBreakerThreshold int
+	LiteLLMBreakerCooldown  time.Duration
+
 	DatabaseURL         string
 	DefaultBudget       float64
 	LongTermKeyLifetime time.Duration
@@ -21,6 +30,57 @@ type Config struct {
 	LongTermKeyLimit    int
 	LongTermKeyBudget   float64
 	MaxActiveKeys       int
+	ShutdownTimeout     time.Duration
+
+	SpendSnapshotInterval  time.Duration
+	SpendSnapshotRetention time.Duration
+
+	KeyReconcileInterval time.Duration
+
+	AlertThresholds  []float64
+	AlertNotifyUsers bool
+	AlertAdminEmails []string
+	AlertWebhookURL  string
+	SMTPHost         string
+	SMTPPort         int
+	SMTPUsername     string
+	SMTPPassword     *Secret
+	SMTPFrom         string
+
+	ExpiryReminderDays     []int
+	ExpiryReminderInterval time.Duration
+	DashboardURL           string
+
+	KeyRenewalWindow time.Duration
+	MaxKeyRenewals   int
+
+	AdminEmails    []string
+	GroupsClaim    string
+	GroupsJWKSURL  string
+	GroupsIssuer   string
+	GroupsAudience string
+	SCIMToken      *Secret
+
+	AuditJSONLPath      string
+	AuditJSONLMaxSizeMB int
+	AuditJSONLMaxFiles  int
+	AuditSyslogAddr     string
+	AuditStdout         bool
+	AuditBufferSize     int
+
+	SecretsReloadInterval time.Duration
+
+	// Settings too structured for environment variables are read from the
+	// JSON file named by LLMREQ_CONFIG_FILE.
+	UserTiers       []UserTier
+	DefaultUserTier string
+	TeamMappings    []TeamMapping
+	LiteLLMTimeouts map[string]time.Duration
+
+	// LiteLLMInstances are the named proxies besides the default one, and
+	// InstanceRules decide which of them serves a user, team or key.
+	LiteLLMInstances []LiteLLMInstance
+	InstanceRules    []InstanceRule
 }
 
 var AppConfig *Config
@@ -29,19 +89,88 @@ func LoadConfig() {
 	_ = godotenv.Load() // Load from .env if it exists, ignore error if not
 
 	AppConfig = &Config{
-		Prefix:              getEnv("LLMREQ_PREFIX", "/api"),
-		LiteLLMAPIURL:       getEnv("LITELLM_API_URL", "http://litellm:4000"),
-		LiteLLMMasterKey:    getEnv("LITELLM_MASTER_KEY", ""),
-		DatabaseURL:         getEnv("LLMREQ_DATABASE_URL", "file:app.db?cache=shared&mode=rwc"),
-		DefaultBudget:       getEnvFloat("LLMREQ_DEFAULT_BUDGET", 1.0),
-		LongTermKeyLifetime: getEnvDuration("LLMREQ_LONGTERM_KEY_LIFETIME", 9600*time.Hour),
-		StandardKeyLifetime: getEnvDurationExtended("LLMREQ_DEFAULT_KEY_EXPIRE", 60*24*time.Hour),
-		LongTermKeyLimit:    getEnvInt("LLMREQ_LONGTERM_KEY_LIMIT", 1),
-		LongTermKeyBudget:   getEnvFloat("LLMREQ_LONGTERM_KEY_BUDGET", 20.0),
-		MaxActiveKeys:       getEnvInt("LLMREQ_MAX_ACTIVE_KEY", 10),
-	}
-
-	if AppConfig.LiteLLMMasterKey == "" {
+		Prefix:                  getEnv("LLMREQ_PREFIX", "/api"),
+		LiteLLMAPIURL:           getEnv("LITELLM_API_URL", "http://litellm:4000"),
+		LiteLLMMasterKey:        getEnvSecret("LITELLM_MASTER_KEY"),
+		LiteLLMRetries:          getEnvInt("LLMREQ_LITELLM_RETRIES", 2),
+		LiteLLMRetryBackoff:     getEnvDuration("LLMREQ_LITELLM_RETRY_BACKOFF", 200*time.Millisecond),
+		LiteLLMCacheTTL:         getEnvDurationExtended("LLMREQ_LITELLM_CACHE_TTL", 0),
+		LiteLLMTimeout:          getEnvDuration("LLMREQ_LITELLM_TIMEOUT", 10*time.Second),
+		ModelCatalogTTL:         getEnvDurationExtended("LLMREQ_MODEL_CATALOG_TTL", 5*time.Minute),
+		LiteLLMBreakerThreshold: getEnvInt("LLMREQ_LITELLM_BREAKER_THRESHOLD", 5),
+		LiteLLMBreakerCooldown:  getEnvDuration("LLMREQ_LITELLM_BREAKER_COOLDOWN", 30*time.Second),
+		ShutdownTimeout:         getEnvDuration("LLMREQ_SHUTDOWN_TIMEOUT", 15*time.Second),
+		DatabaseURL:             getEnv("LLMREQ_DATABASE_URL", "file:app.db?cache=shared&mode=rwc"),
+		DefaultBudget:           getEnvFloat("LLMREQ_DEFAULT_BUDGET", 1.0),
+		LongTermKeyLifetime:     getEnvDuration("LLMREQ_LONGTERM_KEY_LIFETIME", 9600*time.Hour),
+		StandardKeyLifetime:     getEnvDurationExtended("LLMREQ_DEFAULT_KEY_EXPIRE", 60*24*time.Hour),
+		LongTermKeyLimit:        getEnvInt("LLMREQ_LONGTERM_KEY_LIMIT", 1),
+		LongTermKeyBudget:       getEnvFloat("LLMREQ_LONGTERM_KEY_BUDGET", 20.0),
+		MaxActiveKeys:           getEnvInt("LLMREQ_MAX_ACTIVE_KEY", 10),
+
+		SpendSnapshotInterval:  getEnvDurationExtended("LLMREQ_SPEND_SNAPSHOT_INTERVAL", time.Hour),
+		SpendSnapshotRetention: getEnvDurationExtended("LLMREQ_SPEND_SNAPSHOT_RETENTION", 365*24*time.Hour),
+
+		KeyReconcileInterval: getEnvDurationExtended("LLMREQ_KEY_RECONCILE_INTERVAL", 15*time.Minute),
+
+		AlertThresholds:  getEnvFloatList("LLMREQ_ALERT_THRESHOLDS", []float64{50, 80, 100}),
+		AlertNotifyUsers: getEnvBool("LLMREQ_ALERT_NOTIFY_USERS", true),
+		AlertAdminEmails: getEnvList("LLMREQ_ALERT_ADMIN_EMAILS", nil),
+		AlertWebhookURL:  getEnv("LLMREQ_ALERT_WEBHOOK_URL", ""),
+		SMTPHost:         getEnv("LLMREQ_SMTP_HOST", ""),
+		SMTPPort:         getEnvInt("LLMREQ_SMTP_PORT", 587),
+		SMTPUsername:     getEnv("LLMREQ_SMTP_USERNAME", ""),
+		SMTPPassword:     getEnvSecret("LLMREQ_SMTP_PASSWORD"),
+		SMTPFrom:         getEnv("LLMREQ_SMTP_FROM", ""),
+
+		ExpiryReminderDays:     getEnvIntList("LLMREQ_EXPIRY_REMINDER_DAYS", []int{14, 3, 1}),
+		ExpiryReminderInterval: getEnvDurationExtended("LLMREQ_EXPIRY_REMINDER_INTERVAL", time.Hour),
+		DashboardURL:           strings.TrimSuffix(getEnv("LLMREQ_DASHBOARD_URL", ""), "/"),
+
+		KeyRenewalWindow: getEnvDurationExtended("LLMREQ_KEY_RENEWAL_WINDOW", 30*24*time.Hour),
+		MaxKeyRenewals:   getEnvInt("LLMREQ_MAX_KEY_RENEWALS", 3),
+
+		AdminEmails:    lowerAll(getEnvList("LLMREQ_ADMIN_EMAILS", nil)),
+		GroupsClaim:    getEnv("LLMREQ_GROUPS_CLAIM", "groups"),
+		GroupsJWKSURL:  getEnv("LLMREQ_GROUPS_JWKS_URL", ""),
+		GroupsIssuer:   getEnv("LLMREQ_GROUPS_ISSUER", ""),
+		GroupsAudience: getEnv("LLMREQ_GROUPS_AUDIENCE", ""),
+		SCIMToken:      getEnvSecret("LLMREQ_SCIM_TOKEN"),
+
+		AuditJSONLPath:      getEnv("LLMREQ_AUDIT_JSONL_PATH", ""),
+		AuditJSONLMaxSizeMB: getEnvInt("LLMREQ_AUDIT_JSONL_MAX_SIZE_MB", 100),
+		AuditJSONLMaxFiles:  getEnvInt("LLMREQ_AUDIT_JSONL_MAX_FILES", 5),
+		AuditSyslogAddr:     getEnv("LLMREQ_AUDIT_SYSLOG_ADDR", ""),
+		AuditStdout:         getEnvBool("LLMREQ_AUDIT_STDOUT", false),
+		AuditBufferSize:     getEnvInt("LLMREQ_AUDIT_BUFFER_SIZE", 1000),
+
+		SecretsReloadInterval: getEnvDuration("LLMREQ_SECRETS_RELOAD_INTERVAL", 30*time.Second),
+	}
+
+	if path := getEnv("LLMREQ_CONFIG_FILE", ""); path != "" {
+		if err := AppConfig.loadFile(path); err != nil {
+			log.Fatalf("Failed to load config file %s: %v", path, err)
+		}
+	}
+
+	if len(AppConfig.UserTiers) == 0 {
+		AppConfig.UserTiers = []UserTier{{
+			Name:           "default",
+			MaxBudget:      getEnvFloat("LLMREQ_USER_MAX_BUDGET", 0),
+			BudgetDuration: getEnv("LLMREQ_USER_BUDGET_DURATION", ""),
+		}}
+	}
+	if AppConfig.DefaultUserTier == "" {
+		AppConfig.DefaultUserTier = AppConfig.UserTiers[0].Name
+	}
+
+	// A token signed by the provider for another application must not grant
+	// groups here.
+	if AppConfig.GroupsJWKSURL != "" && (AppConfig.GroupsIssuer == "" || AppConfig.GroupsAudience == "") {
+		log.Fatal("LLMREQ_GROUPS_ISSUER and LLMREQ_GROUPS_AUDIENCE are required with LLMREQ_GROUPS_JWKS_URL")
+	}
+
+	if AppConfig.LiteLLMMasterKey.Value() == "" {
 		log.Println("Warning: LITELLM_MASTER_KEY is not set.")
 	}
 }
@@ -64,6 +193,13 @@ func getEnvFloat(key string, fallback float64) float64 {
 	return fallback
 }
 
+func lowerAll(values []string) []string {
+	for i, v := range values {
+		values[i] = strings.ToLower(v)
+	}
+	return values
+}
+
 func getEnvInt(key string, fallback int) int {
 	strValue := getEnv(key, "")
 	if strValue == "" {
@@ -75,6 +211,64 @@ func getEnvInt(key string, fallback int) int {
 	return fallback
 }
 
+func getEnvBool(key string, fallback bool) bool {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	if value, err := strconv.ParseBool(strValue); err == nil {
+		return value
+	}
+	return fallback
+}
+
+// getEnvList splits a comma-separated variable, dropping empty entries.
+func getEnvList(key string, fallback []string) []string {
+	strValue := getEnv(key, "")
+	if strValue == "" {
+		return fallback
+	}
+	var values []string
+	for _, v := range strings.Split(strValue, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func getEnvFloatList(key string, fallback []float64) []float64 {
+	strValues := getEnvList(key, nil)
+	if len(strValues) == 0 {
+		return fallback
+	}
+	values := make([]float64, 0, len(strValues))
+	for _, v := range strValues {
+		value, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fallback
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func getEnvIntList(key string, fallback []int) []int {
+	strValues := getEnvList(key, nil)
+	if len(strValues) == 0 {
+		return fallback
+	}
+	values := make([]int, 0, len(strValues))
+	for _, v := range strValues {
+		value, err := strconv.Atoi(v)
+		if err != nil {
+			return fallback
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
 func getEnvDuration(key string, fallback time.Duration) time.Duration {
 	strValue := getEnv(key, "")
 	if strValue == "" {
@@ -97,6 +291,12 @@ func getEnvDurationExtended(key string, fallback time.Duration) time.Duration {
 	return fallback
 }
 
+// ParseDurationExtended parses a Go duration string, additionally accepting
+// a whole number of days such as "14d".
+func ParseDurationExtended(s string) (time.Duration, error) {
+	return parseDurationExtended(s)
+}
+
 func parseDurationExtended(s string) (time.Duration, error) {
 	if strings.HasSuffix(s, "d") {
 		daysStr := strings.TrimSuffix(s, "d")
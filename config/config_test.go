@@ -1,7 +1,9 @@
 package config
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -78,3 +80,200 @@ func TestLoadConfig_StandardKeyLifetime(t *testing.T) {
 		t.Errorf("Expected default %v, got %v", expectedDefault, AppConfig.StandardKeyLifetime)
 	}
 }
+
+func TestLoadConfig_UserTiers(t *testing.T) {
+	// Without a config file a single default tier is built from the environment.
+	os.Unsetenv("LLMREQ_CONFIG_FILE")
+	os.Setenv("LLMREQ_USER_MAX_BUDGET", "25")
+	os.Setenv("LLMREQ_USER_BUDGET_DURATION", "30d")
+	defer os.Unsetenv("LLMREQ_USER_MAX_BUDGET")
+	defer os.Unsetenv("LLMREQ_USER_BUDGET_DURATION")
+	LoadConfig()
+	if len(AppConfig.UserTiers) != 1 || AppConfig.UserTiers[0].MaxBudget != 25 || AppConfig.UserTiers[0].BudgetDuration != "30d" {
+		t.Errorf("Unexpected default tiers %+v", AppConfig.UserTiers)
+	}
+	if AppConfig.DefaultUserTier != "default" {
+		t.Errorf("Expected default tier name, got %s", AppConfig.DefaultUserTier)
+	}
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(path, []byte(`{
+		"default_user_tier": "basic",
+		"user_tiers": [
+			{"name": "basic", "max_budget": 5, "budget_duration": "30d"},
+			{"name": "partner", "max_budget": 10, "email_domains": ["partner.com"]},
+			{"name": "research", "max_budget": 100, "groups": ["ml-research"]}
+		]
+	}`), 0o600)
+	os.Setenv("LLMREQ_CONFIG_FILE", path)
+	defer os.Unsetenv("LLMREQ_CONFIG_FILE")
+	LoadConfig()
+
+	tests := []struct {
+		email  string
+		groups []string
+		tier   string
+	}{
+		{"a@example.com", nil, "basic"},
+		{"a@Partner.com", nil, "partner"},
+		{"a@partner.com", []string{"ml-research"}, "research"},
+	}
+	for _, test := range tests {
+		if tier := AppConfig.ResolveUserTier(test.email, test.groups); tier.Name != test.tier {
+			t.Errorf("%s %v: expected %s, got %s", test.email, test.groups, test.tier, tier.Name)
+		}
+	}
+}
+
+func TestLoadFile_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(path, []byte(`{"default_user_tier": "gold", "user_tiers": [{"name": "basic"}]}`), 0o600)
+
+	c := &Config{}
+	if err := c.loadFile(path); err == nil {
+		t.Error("Expected error for undefined default tier")
+	}
+}
+
+func TestLoadFile_TeamMappings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(path, []byte(`{"team_mappings": [
+		{"group": "eng", "team_id": "team-eng"},
+		{"group": "eng-leads", "team_id": "team-eng", "role": "admin"},
+		{"group": "data", "team_id": "team-data"}
+	]}`), 0o600)
+
+	c := &Config{}
+	if err := c.loadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if c.TeamMappings[0].Role != "user" {
+		t.Errorf("Expected role to default to user, got %q", c.TeamMappings[0].Role)
+	}
+
+	teams := c.ResolveTeams([]string{"eng", "eng-leads"})
+	if len(teams) != 1 || teams["team-eng"].Role != "admin" {
+		t.Errorf("Expected admin role to win, got %+v", teams)
+	}
+
+	_ = os.WriteFile(path, []byte(`{"team_mappings": [{"group": "eng", "team_id": "team-eng", "role": "owner"}]}`), 0o600)
+	if err := (&Config{}).loadFile(path); err == nil {
+		t.Error("Expected error for invalid role")
+	}
+}
+
+func TestLoadFile_LiteLLMTimeouts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(path, []byte(`{"litellm_timeouts": {"list_keys": "30s"}}`), 0o600)
+
+	c := &Config{}
+	if err := c.loadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if c.LiteLLMTimeouts["list_keys"] != 30*time.Second {
+		t.Errorf("Expected 30s for list_keys, got %v", c.LiteLLMTimeouts)
+	}
+
+	_ = os.WriteFile(path, []byte(`{"litellm_timeouts": {"list_keys": "soon"}}`), 0o600)
+	if err := (&Config{}).loadFile(path); err == nil {
+		t.Error("Expected error for invalid timeout")
+	}
+}
+
+func TestLoadFile_LiteLLMInstances(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	_ = os.WriteFile(path, []byte(`{
+		"litellm_instances": [
+			{"name": "eu", "url": "http://eu:4000", "master_key": "sk-eu", "fallback": "eu-replica"},
+			{"name": "eu-replica", "url": "http://eu-replica:4000", "master_key": "sk-eu"},
+			{"name": "gpu", "url": "http://gpu:4000"}
+		],
+		"litellm_routing": [
+			{"instance": "gpu", "key_types": ["service"], "groups": ["ml"]},
+			{"instance": "eu", "email_domains": ["example.eu"]},
+			{"instance": "eu", "teams": ["team-paris"]}
+		]
+	}`), 0o600)
+
+	c := &Config{LiteLLMAPIURL: "http://litellm:4000"}
+	if err := c.loadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	instances := c.Instances()
+	if len(instances) != 4 || instances[0].Name != DefaultInstance || instances[0].URL != "http://litellm:4000" {
+		t.Errorf("Expected default instance first from the environment, got %+v", instances)
+	}
+	if instances[1].Key.Value() != "sk-eu" {
+		t.Errorf("Expected eu's master key, got %q", instances[1].Key.Value())
+	}
+
+	if got := c.ResolveInstance(Route{Email: "Ana@Example.EU"}); got != "eu" {
+		t.Errorf("Expected eu for an example.eu user, got %q", got)
+	}
+	if got := c.ResolveInstance(Route{TeamID: "team-paris"}); got != "eu" {
+		t.Errorf("Expected eu for team-paris, got %q", got)
+	}
+	if got := c.ResolveInstance(Route{Email: "bob@example.com", Groups: []string{"ml"}}); got != DefaultInstance {
+		t.Errorf("Expected key_types rules not to place users, got %q", got)
+	}
+
+	if got, ok := c.ResolveKeyInstance(Route{Groups: []string{"ml"}, KeyType: "service"}); !ok || got != "gpu" {
+		t.Errorf("Expected gpu for an ml service key, got %q, %v", got, ok)
+	}
+	if _, ok := c.ResolveKeyInstance(Route{Groups: []string{"ml"}, KeyType: "standard"}); ok {
+		t.Error("Expected no key rule for a standard key")
+	}
+
+	for _, body := range []string{
+		`{"litellm_instances": [{"name": "eu"}]}`,
+		`{"litellm_instances": [{"name": "eu", "url": "http://a"}, {"name": "eu", "url": "http://b"}]}`,
+		`{"litellm_instances": [{"name": "eu", "url": "http://a", "fallback": "us"}]}`,
+		`{"litellm_instances": [{"name": "eu", "url": "http://a", "fallback": "eu"}]}`,
+		`{"litellm_routing": [{"instance": "eu", "email_domains": ["example.eu"]}]}`,
+		`{"litellm_instances": [{"name": "eu", "url": "http://a"}], "litellm_routing": [{"instance": "eu"}]}`,
+	} {
+		_ = os.WriteFile(path, []byte(body), 0o600)
+		if err := (&Config{}).loadFile(path); err == nil {
+			t.Errorf("Expected error for %s", body)
+		}
+	}
+}
+
+func TestSecretFromFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "master_key")
+	_ = os.WriteFile(path, []byte("sk-old\n"), 0o600)
+	os.Setenv("LITELLM_MASTER_KEY_FILE", path)
+	defer os.Unsetenv("LITELLM_MASTER_KEY_FILE")
+
+	LoadConfig()
+	c := AppConfig
+	key := c.LiteLLMMasterKey
+	if key.Value() != "sk-old" {
+		t.Fatalf("Expected the key from the file without its newline, got %q", key.Value())
+	}
+	if _, ok := c.Secrets()["LITELLM_MASTER_KEY"]; !ok {
+		t.Errorf("Expected the master key to be reloadable, got %v", c.Secrets())
+	}
+
+	// SIGHUP reloads it in place.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	hup := make(chan os.Signal)
+	go c.WatchSecrets(ctx, hup, 0)
+	_ = os.WriteFile(path, []byte("sk-new"), 0o600)
+	hup <- os.Interrupt
+	hup <- os.Interrupt // returns once the first reload is done
+	if key.Value() != "sk-new" {
+		t.Errorf("Expected sk-new after reload, got %q", key.Value())
+	}
+
+	// A missing or empty file keeps the previous value.
+	_ = os.WriteFile(path, nil, 0o600)
+	if _, err := key.Reload(); err == nil || key.Value() != "sk-new" {
+		t.Errorf("Expected an empty file to be rejected, got %v, %q", err, key.Value())
+	}
+	os.Remove(path)
+	if _, err := key.Reload(); err == nil || key.Value() != "sk-new" {
+		t.Errorf("Expected a missing file to be rejected, got %v, %q", err, key.Value())
+	}
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// UserTier is a named set of account-level LiteLLM limits applied to a user
+// when they are provisioned. A tier is picked for a user by group first, then
+// by email domain, and otherwise falls back to the default tier.
+type UserTier struct {
+	Name           string   `json:"name"`
+	MaxBudget      float64  `json:"max_budget"`
+	BudgetDuration string   `json:"budget_duration"`
+	Models         []string `json:"models"`
+	EmailDomains   []string `json:"email_domains"`
+	Groups         []string `json:"groups"`
+}
+
+// TeamMapping grants members of an identity provider group a role in a
+// LiteLLM team. Memberships created from mappings are reconciled on login.
+type TeamMapping struct {
+	Group     string `json:"group"`
+	TeamID    string `json:"team_id"`
+	TeamAlias string `json:"team_alias"`
+	Role      string `json:"role"` // "admin" or "user"; defaults to "user"
+}
+
+// LiteLLMInstance is a named LiteLLM proxy. Unless the file defines one
+// named "default", that instance is LITELLM_API_URL with LITELLM_MASTER_KEY.
+type LiteLLMInstance struct {
+	Name      string `json:"name"`
+	URL       string `json:"url"`
+	MasterKey string `json:"master_key"`
+	// MasterKeyFile is read instead of MasterKey when set, and re-read
+	// like the _FILE secrets.
+	MasterKeyFile string `json:"master_key_file"`
+	// Fallback names the instance that serves reads while this one is
+	// unreachable. It must front the same LiteLLM database.
+	Fallback string `json:"fallback"`
+
+	// Key is the master key as loaded.
+	Key *Secret `json:"-"`
+}
+
+// InstanceRule places users, teams or keys on a LiteLLM instance. Every
+// criterion that is set must match, any value of a list will do, and the
+// first matching rule wins. Rules with key_types only place keys; the others
+// place users when they are provisioned and teams by ID.
+type InstanceRule struct {
+	Instance     string   `json:"instance"`
+	EmailDomains []string `json:"email_domains"`
+	Groups       []string `json:"groups"`
+	Teams        []string `json:"teams"`
+	KeyTypes     []string `json:"key_types"`
+}
+
+// Route describes what is being placed by ResolveInstance.
+type Route struct {
+	Email   string
+	Groups  []string
+	TeamID  string
+	KeyType string
+}
+
+type fileConfig struct {
+	UserTiers       []UserTier    `json:"user_tiers"`
+	DefaultUserTier string        `json:"default_user_tier"`
+	TeamMappings    []TeamMapping `json:"team_mappings"`
+	// LiteLLMTimeouts overrides LLMREQ_LITELLM_TIMEOUT per operation, e.g.
+	// {"list_keys": "30s"}.
+	LiteLLMTimeouts  map[string]string `json:"litellm_timeouts"`
+	LiteLLMInstances []LiteLLMInstance `json:"litellm_instances"`
+	LiteLLMRouting   []InstanceRule    `json:"litellm_routing"`
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var fc fileConfig
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return err
+	}
+
+	c.UserTiers = fc.UserTiers
+	c.DefaultUserTier = fc.DefaultUserTier
+	c.TeamMappings = fc.TeamMappings
+	c.LiteLLMInstances = fc.LiteLLMInstances
+	c.InstanceRules = fc.LiteLLMRouting
+
+	c.LiteLLMTimeouts = make(map[string]time.Duration, len(fc.LiteLLMTimeouts))
+	for op, value := range fc.LiteLLMTimeouts {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid timeout %q for LiteLLM operation %q", value, op)
+		}
+		c.LiteLLMTimeouts[op] = d
+	}
+
+	seen := make(map[string]struct{})
+	for _, tier := range c.UserTiers {
+		if tier.Name == "" {
+			return fmt.Errorf("user tier without a name")
+		}
+		if _, ok := seen[tier.Name]; ok {
+			return fmt.Errorf("duplicate user tier %q", tier.Name)
+		}
+		seen[tier.Name] = struct{}{}
+	}
+	if c.DefaultUserTier != "" {
+		if _, ok := seen[c.DefaultUserTier]; !ok {
+			return fmt.Errorf("default user tier %q is not defined", c.DefaultUserTier)
+		}
+	}
+
+	for i := range c.TeamMappings {
+		mapping := &c.TeamMappings[i]
+		if mapping.Group == "" || mapping.TeamID == "" {
+			return fmt.Errorf("team mapping needs a group and a team_id")
+		}
+		if mapping.Role == "" {
+			mapping.Role = "user"
+		}
+		if mapping.Role != "user" && mapping.Role != "admin" {
+			return fmt.Errorf("team mapping for group %q has invalid role %q", mapping.Group, mapping.Role)
+		}
+	}
+
+	names := map[string]bool{DefaultInstance: true}
+	for i := range c.LiteLLMInstances {
+		inst := &c.LiteLLMInstances[i]
+		if inst.Name == "" || inst.URL == "" {
+			return fmt.Errorf("LiteLLM instance needs a name and a url")
+		}
+		if names[inst.Name] && inst.Name != DefaultInstance {
+			return fmt.Errorf("duplicate LiteLLM instance %q", inst.Name)
+		}
+		names[inst.Name] = true
+
+		inst.Key = NewSecret(inst.MasterKey)
+		if inst.MasterKeyFile != "" {
+			if inst.Key, err = NewFileSecret(inst.MasterKeyFile); err != nil {
+				return fmt.Errorf("LiteLLM instance %q: %v", inst.Name, err)
+			}
+		}
+	}
+	for _, inst := range c.LiteLLMInstances {
+		if inst.Fallback != "" && (!names[inst.Fallback] || inst.Fallback == inst.Name) {
+			return fmt.Errorf("LiteLLM instance %q has invalid fallback %q", inst.Name, inst.Fallback)
+		}
+	}
+	for _, rule := range c.InstanceRules {
+		if !names[rule.Instance] {
+			return fmt.Errorf("routing rule names unknown LiteLLM instance %q", rule.Instance)
+		}
+		if len(rule.EmailDomains)+len(rule.Groups)+len(rule.Teams)+len(rule.KeyTypes) == 0 {
+			return fmt.Errorf("routing rule for instance %q has no criteria", rule.Instance)
+		}
+	}
+
+	return nil
+}
+
+// DefaultInstance names the LiteLLM instance used when no rule applies, and
+// for users, teams and keys recorded before instances existed.
+const DefaultInstance = "default"
+
+// Instances returns every configured LiteLLM instance, the default one
+// first.
+func (c *Config) Instances() []LiteLLMInstance {
+	instances := []LiteLLMInstance{{Name: DefaultInstance, URL: c.LiteLLMAPIURL, Key: c.LiteLLMMasterKey}}
+	for _, inst := range c.LiteLLMInstances {
+		if inst.Name == DefaultInstance {
+			instances[0] = inst
+		} else {
+			instances = append(instances, inst)
+		}
+	}
+	return instances
+}
+
+// ResolveInstance returns the instance of the first rule without key_types
+// that matches r, or DefaultInstance.
+func (c *Config) ResolveInstance(r Route) string {
+	for _, rule := range c.InstanceRules {
+		if len(rule.KeyTypes) == 0 && rule.matches(r) {
+			return rule.Instance
+		}
+	}
+	return DefaultInstance
+}
+
+// ResolveKeyInstance returns the instance of the first rule with key_types
+// that matches r. Keys no such rule matches live with their owner.
+func (c *Config) ResolveKeyInstance(r Route) (string, bool) {
+	for _, rule := range c.InstanceRules {
+		if len(rule.KeyTypes) > 0 && rule.matches(r) {
+			return rule.Instance, true
+		}
+	}
+	return "", false
+}
+
+func (rule InstanceRule) matches(r Route) bool {
+	if len(rule.EmailDomains) > 0 && !containsFold(rule.EmailDomains, emailDomain(r.Email)) {
+		return false
+	}
+	if len(rule.Groups) > 0 && !containsAny(rule.Groups, r.Groups) {
+		return false
+	}
+	if len(rule.Teams) > 0 && !containsAny(rule.Teams, []string{r.TeamID}) {
+		return false
+	}
+	if len(rule.KeyTypes) > 0 && !containsAny(rule.KeyTypes, []string{r.KeyType}) {
+		return false
+	}
+	return true
+}
+
+func emailDomain(email string) string {
+	if i := strings.LastIndex(email, "@"); i >= 0 {
+		return email[i+1:]
+	}
+	return email
+}
+
+func containsFold(values []string, v string) bool {
+	for _, value := range values {
+		if strings.EqualFold(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, value := range values {
+		for _, c := range candidates {
+			if value == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UserTier looks up a tier by name.
+func (c *Config) UserTier(name string) (UserTier, bool) {
+	for _, tier := range c.UserTiers {
+		if tier.Name == name {
+			return tier, true
+		}
+	}
+	return UserTier{}, false
+}
+
+// ResolveUserTier picks the tier for a user from their email and identity
+// provider groups.
+func (c *Config) ResolveUserTier(email string, groups []string) UserTier {
+	for _, tier := range c.UserTiers {
+		for _, g := range tier.Groups {
+			for _, userGroup := range groups {
+				if g == userGroup {
+					return tier
+				}
+			}
+		}
+	}
+
+	domain := emailDomain(email)
+	for _, tier := range c.UserTiers {
+		for _, d := range tier.EmailDomains {
+			if strings.EqualFold(d, domain) {
+				return tier
+			}
+		}
+	}
+
+	if tier, ok := c.UserTier(c.DefaultUserTier); ok {
+		return tier
+	}
+	if len(c.UserTiers) > 0 {
+		return c.UserTiers[0]
+	}
+	return UserTier{Name: "default"}
+}
+
+// IsAdmin reports whether userID is listed in LLMREQ_ADMIN_EMAILS.
+func (c *Config) IsAdmin(userID string) bool {
+	for _, admin := range c.AdminEmails {
+		if admin == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveTeams maps identity provider groups to the teams they grant, keyed
+// by team ID. When several groups grant the same team the admin role wins.
+func (c *Config) ResolveTeams(groups []string) map[string]TeamMapping {
+	teams := make(map[string]TeamMapping)
+	for _, mapping := range c.TeamMappings {
+		for _, g := range groups {
+			if g != mapping.Group {
+				continue
+			}
+			if current, ok := teams[mapping.TeamID]; !ok || current.Role != "admin" {
+				teams[mapping.TeamID] = mapping
+			}
+		}
+	}
+	return teams
+}
@@ -0,0 +1,140 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Secret is a credential that may be replaced while llmreq runs. Set from
+// KEY_FILE rather than KEY, it is read from that file, Docker and Kubernetes
+// secrets style, and re-read by WatchSecrets. The value is swapped
+// atomically, so a request sees either the old value or the new one.
+type Secret struct {
+	// Path is the file the value is read from, or "" for a fixed value.
+	Path string
+
+	value atomic.Pointer[string]
+}
+
+// NewSecret returns a fixed secret.
+func NewSecret(value string) *Secret {
+	s := &Secret{}
+	s.value.Store(&value)
+	return s
+}
+
+// NewFileSecret returns a secret read from path.
+func NewFileSecret(path string) (*Secret, error) {
+	s := &Secret{Path: path}
+	if _, err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Value returns the current value, or "" for a nil Secret.
+func (s *Secret) Value() string {
+	if s == nil {
+		return ""
+	}
+	if v := s.value.Load(); v != nil {
+		return *v
+	}
+	return ""
+}
+
+// Reload re-reads a file-backed secret and reports whether it changed. On
+// error the previous value is kept.
+func (s *Secret) Reload() (bool, error) {
+	if s == nil || s.Path == "" {
+		return false, nil
+	}
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return false, err
+	}
+	value := strings.TrimRight(string(data), "\r\n")
+	if value == "" {
+		return false, fmt.Errorf("secret file %s is empty", s.Path)
+	}
+	if value == s.Value() {
+		return false, nil
+	}
+	s.value.Store(&value)
+	return true, nil
+}
+
+// getEnvSecret reads key from the file named by key_FILE if that is set,
+// else from key itself.
+func getEnvSecret(key string) *Secret {
+	path := getEnv(key+"_FILE", "")
+	if path == "" {
+		return NewSecret(getEnv(key, ""))
+	}
+	s, err := NewFileSecret(path)
+	if err != nil {
+		log.Fatalf("Failed to read %s_FILE: %v", key, err)
+	}
+	return s
+}
+
+// Secrets returns every file-backed secret, by the setting it belongs to.
+func (c *Config) Secrets() map[string]*Secret {
+	secrets := make(map[string]*Secret)
+	add := func(name string, s *Secret) {
+		if s != nil && s.Path != "" {
+			secrets[name] = s
+		}
+	}
+	add("LITELLM_MASTER_KEY", c.LiteLLMMasterKey)
+	add("LLMREQ_SMTP_PASSWORD", c.SMTPPassword)
+	add("LLMREQ_SCIM_TOKEN", c.SCIMToken)
+	for _, inst := range c.LiteLLMInstances {
+		add("litellm_instances."+inst.Name+".master_key", inst.Key)
+	}
+	return secrets
+}
+
+// ReloadSecrets re-reads every file-backed secret. A secret whose file
+// cannot be read keeps its value, so a half-written rotation does not lock
+// llmreq out.
+func (c *Config) ReloadSecrets() {
+	for name, s := range c.Secrets() {
+		changed, err := s.Reload()
+		if err != nil {
+			log.Printf("Failed to reload %s from %s: %v", name, s.Path, err)
+		} else if changed {
+			log.Printf("Reloaded %s from %s", name, s.Path)
+		}
+	}
+}
+
+// WatchSecrets reloads the secrets whenever hup delivers and, unless interval
+// is 0, every interval to pick up changed files. It returns when ctx is done.
+func (c *Config) WatchSecrets(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	if len(c.Secrets()) == 0 {
+		return
+	}
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Println("SIGHUP received, reloading secrets")
+			c.ReloadSecrets()
+		case <-tick:
+			c.ReloadSecrets()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/example/llmreq/litellmfake"
+)
+
+// runFakeLiteLLM serves the in-process fake LiteLLM proxy, so llmreq can be
+// run locally without Docker: point LITELLM_API_URL at -addr and use the
+// same master key.
+func runFakeLiteLLM(args []string) {
+	flags := flag.NewFlagSet("fake-litellm", flag.ExitOnError)
+	addr := flags.String("addr", ":4000", "address to listen on")
+	masterKey := flags.String("master-key", envOr("LITELLM_MASTER_KEY", "sk-master-key"), "master key accepted for admin requests")
+	latency := flags.Duration("latency", 0, "delay added to every response")
+	errorRate := flags.Float64("error-rate", 0, "fraction of requests, from 0 to 1, answered with -error-status")
+	errorStatus := flags.Int("error-status", http.StatusServiceUnavailable, "status of injected errors")
+	pageSize := flags.Int("page-size", 0, "cap on the /key/list page size; 0 keeps LiteLLM's 100")
+	_ = flags.Parse(args)
+
+	fake := litellmfake.New(*masterKey)
+	fake.SetFaults(litellmfake.Faults{
+		Latency:     *latency,
+		ErrorRate:   *errorRate,
+		ErrorStatus: *errorStatus,
+		MaxPageSize: *pageSize,
+	})
+	log.Printf("Fake LiteLLM %s listening on %s", litellmfake.Version, *addr)
+	log.Fatal(http.ListenAndServe(*addr, fake))
+}
+
+func envOr(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
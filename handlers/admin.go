@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/example/llmreq/apierror"
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+type SetUserTierRequest struct {
+	Tier string `json:"tier"`
+}
+
+type SuspendUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+type OffboardUserRequest struct {
+	Successor string `json:"successor"` // optional user who receives long-term and team keys
+	Reason    string `json:"reason"`
+}
+
+// ListUserTiers godoc
+// @Summary List user budget tiers
+// @Description List the configured user budget tiers (admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Success 200 {array} config.UserTier
+// @Failure 403 {object} map[string]string
+// @Router /admin/tiers [get]
+func (h *Handler) ListUserTiers(c echo.Context) error {
+	return c.JSON(http.StatusOK, config.AppConfig.UserTiers)
+}
+
+// GetUser godoc
+// @Summary Get a user
+// @Description Fetch the local record of a user, including their tier (admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Success 200 {object} models.User
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /admin/users/{id} [get]
+func (h *Handler) GetUser(c echo.Context) error {
+	userID := strings.ToLower(c.Param("id"))
+
+	var user models.User
+	if err := h.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// SetUserTier godoc
+// @Summary Change a user's tier
+// @Description Assign a budget tier to a user and apply its limits in LiteLLM. The limits of a user who is not active are applied once they are active again (admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Param request body SetUserTierRequest true "Tier"
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /admin/users/{id}/tier [put]
+func (h *Handler) SetUserTier(c echo.Context) error {
+	userID := strings.ToLower(c.Param("id"))
+
+	var req SetUserTierRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	tier, ok := config.AppConfig.UserTier(req.Tier)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown tier"})
+	}
+
+	var user models.User
+	if err := h.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	// A user who is not active keeps the limits their suspension,
+	// offboarding or deactivation left; the tier is applied once they are
+	// active again.
+	if user.Status == "active" {
+		if err := h.LiteLLMService.UpdateUser(c.Request().Context(), services.UpdateUserRequestForTier(userID, tier)); err != nil {
+			log.Printf("Failed to apply tier %s to %s: %v", tier.Name, userID, err)
+			return apierror.Upstream(c, err, "Failed to update user in LiteLLM")
+		}
+	}
+
+	before := audit.UserState(&user)
+	user.Tier = tier.Name
+	user.TierSource = "admin"
+	h.DB.Save(&user)
+
+	h.recordAudit(c, audit.Event{UserID: userID, Action: "user.set_tier", TargetType: "user", TargetID: userID, Before: before, After: audit.UserState(&user)})
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// SuspendUser godoc
+// @Summary Suspend a user
+// @Description Block all of the user's keys in LiteLLM and reject their requests until unsuspended (admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Param request body SuspendUserRequest false "Reason"
+// @Success 200 {object} models.User
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /admin/users/{id}/suspend [post]
+func (h *Handler) SuspendUser(c echo.Context) error {
+	var req SuspendUserRequest
+	_ = c.Bind(&req)
+	return h.userLifecycleAction(c, "user.suspend", req.Reason, func(l *services.UserLifecycle, user *models.User) error {
+		return l.Suspend(c.Request().Context(), user)
+	})
+}
+
+// UnsuspendUser godoc
+// @Summary Unsuspend a user
+// @Description Unblock the user's keys and let them back in (admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Success 200 {object} models.User
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /admin/users/{id}/unsuspend [post]
+func (h *Handler) UnsuspendUser(c echo.Context) error {
+	return h.userLifecycleAction(c, "user.unsuspend", "", func(l *services.UserLifecycle, user *models.User) error {
+		return l.Unsuspend(c.Request().Context(), user)
+	})
+}
+
+// OffboardUser godoc
+// @Summary Start offboarding a user
+// @Description Block the user's keys, capture their final spend and name a successor. Nothing is deleted until the offboarding is finalized (admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Param request body OffboardUserRequest false "Offboarding options"
+// @Success 200 {object} models.User
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /admin/users/{id}/offboard [post]
+func (h *Handler) OffboardUser(c echo.Context) error {
+	var req OffboardUserRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	successor := strings.ToLower(req.Successor)
+	if successor != "" {
+		var user models.User
+		err := h.DB.Where("id = ? AND status = ?", successor, "active").First(&user).Error
+		if err != nil || successor == strings.ToLower(c.Param("id")) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Successor must be another active user"})
+		}
+	}
+
+	return h.userLifecycleAction(c, "user.offboard_start", req.Reason, func(l *services.UserLifecycle, user *models.User) error {
+		return l.StartOffboarding(c.Request().Context(), user, successor)
+	})
+}
+
+// CancelOffboarding godoc
+// @Summary Cancel offboarding
+// @Description Return the user to their previous status and unblock their keys (admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Success 200 {object} models.User
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /admin/users/{id}/offboard/cancel [post]
+func (h *Handler) CancelOffboarding(c echo.Context) error {
+	return h.userLifecycleAction(c, "user.offboard_cancel", "", func(l *services.UserLifecycle, user *models.User) error {
+		return l.CancelOffboarding(c.Request().Context(), user)
+	})
+}
+
+// FinalizeOffboarding godoc
+// @Summary Finalize offboarding
+// @Description Transfer long-term and team keys to the successor, delete all other keys and remove team memberships. This cannot be undone (admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Success 200 {object} models.User
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /admin/users/{id}/offboard/finalize [post]
+func (h *Handler) FinalizeOffboarding(c echo.Context) error {
+	return h.userLifecycleAction(c, "user.offboard_finalize", "", func(l *services.UserLifecycle, user *models.User) error {
+		return l.FinalizeOffboarding(c.Request().Context(), user)
+	})
+}
+
+// lifecycleActions are the audit actions of admin lifecycle requests. Their
+// individual steps are audited by system:lifecycle.
+var lifecycleActions = []string{"user.suspend", "user.unsuspend", "user.offboard_start", "user.offboard_cancel", "user.offboard_finalize"}
+
+// GetUserEvents godoc
+// @Summary List a user's lifecycle events
+// @Description List the audit events of suspensions, offboarding and deactivation for a user, including each step taken on their keys and teams, oldest first (admin only)
+// @Tags admin
+// @Produce json
+// @Param id path string true "User ID (email)"
+// @Success 200 {array} AuditEventResponse
+// @Failure 500 {object} map[string]string
+// @Router /admin/users/{id}/events [get]
+func (h *Handler) GetUserEvents(c echo.Context) error {
+	var rows []models.AuditEvent
+	err := h.DB.Where("user_id = ? AND (actor = ? OR action IN ?)", strings.ToLower(c.Param("id")), "system:lifecycle", lifecycleActions).
+		Order("id").Find(&rows).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch user events"})
+	}
+
+	events := make([]AuditEventResponse, 0, len(rows))
+	for i := range rows {
+		events = append(events, auditEventResponse(&rows[i], true))
+	}
+	return c.JSON(http.StatusOK, events)
+}
+
+// userLifecycleAction loads the user named in the path and runs action on
+// them, mapping lifecycle errors to responses. Successful actions are
+// audited under auditAction, with the admin's reason when one was given.
+func (h *Handler) userLifecycleAction(c echo.Context, auditAction, reason string, action func(*services.UserLifecycle, *models.User) error) error {
+	userID := strings.ToLower(c.Param("id"))
+
+	var user models.User
+	if err := h.DB.Where("id = ?", userID).First(&user).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
+	}
+
+	before := audit.UserState(&user)
+	err := action(services.NewUserLifecycle(h.LiteLLMService, h.DB), &user)
+	if errors.Is(err, services.ErrInvalidTransition) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Action not allowed while the user is " + user.Status})
+	}
+	if errors.Is(err, services.ErrSuccessorKeyLimit) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Successor would exceed a key limit", "detail": err.Error()})
+	}
+	if err != nil {
+		log.Printf("Lifecycle action on %s failed: %v", userID, err)
+		return apierror.Upstream(c, err, "Failed to update user in LiteLLM")
+	}
+
+	after := audit.UserState(&user)
+	if reason != "" {
+		after["reason"] = reason
+	}
+	h.recordAudit(c, audit.Event{UserID: userID, Action: auditAction, TargetType: "user", TargetID: userID, Before: before, After: after})
+
+	return c.JSON(http.StatusOK, user)
+}
@@ -0,0 +1,273 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+func TestSetUserTier(t *testing.T) {
+	var updates []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/update" {
+			var req map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			updates = append(updates, req)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{
+		UserTiers: []config.UserTier{
+			{Name: "basic", MaxBudget: 5},
+			{Name: "gold", MaxBudget: 50, BudgetDuration: "30d"},
+		},
+	}
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+
+	db.Create(&models.User{ID: "user@example.com", Email: "user@example.com", Tier: "basic", TierSource: "auto"})
+
+	setTier := func(userID, body string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPut, "/api/admin/users/"+userID+"/tier", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues(userID)
+		c.Set("user_id", "admin@example.com")
+		if err := h.SetUserTier(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	if rec := setTier("user@example.com", `{"tier": "platinum"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unknown tier, got %d", rec.Code)
+	}
+	if rec := setTier("nobody@example.com", `{"tier": "gold"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for unknown user, got %d", rec.Code)
+	}
+
+	rec := setTier("User@example.com", `{"tier": "gold"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if len(updates) != 1 || updates[0]["user_id"] != "user@example.com" || updates[0]["max_budget"] != 50.0 || updates[0]["budget_duration"] != "30d" {
+		t.Errorf("Unexpected LiteLLM update %+v", updates)
+	}
+
+	var user models.User
+	db.Where("id = ?", "user@example.com").First(&user)
+	if user.Tier != "gold" || user.TierSource != "admin" {
+		t.Errorf("Unexpected local user %+v", user)
+	}
+
+	// A deactivated user keeps their zeroed budget; only the tier is
+	// recorded.
+	db.Create(&models.User{ID: "gone@example.com", Email: "gone@example.com", Tier: "basic", Status: "deactivated", PriorStatus: "active"})
+	if rec := setTier("gone@example.com", `{"tier": "gold"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if len(updates) != 1 {
+		t.Errorf("Expected no LiteLLM update for a deactivated user, got %+v", updates[1:])
+	}
+	var gone models.User
+	db.Where("id = ?", "gone@example.com").First(&gone)
+	if gone.Tier != "gold" || gone.Status != "deactivated" {
+		t.Errorf("Expected the tier to be recorded only, got %+v", gone)
+	}
+
+	// It is applied when they are reactivated.
+	if err := services.NewUserLifecycle(svc, db).Reactivate(context.Background(), &gone); err != nil {
+		t.Fatal(err)
+	}
+	if len(updates) != 2 || updates[1]["user_id"] != "gone@example.com" || updates[1]["max_budget"] != 50.0 {
+		t.Errorf("Expected the gold tier on reactivation, got %+v", updates)
+	}
+}
+
+func TestSuspendAndOffboardUser(t *testing.T) {
+	blocked := map[string]bool{}
+	var deleted []string
+	var transfers []services.UpdateKeyRequest
+	var removedFrom []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		switch r.URL.Path {
+		case "/key/list":
+			_, _ = w.Write([]byte(`{"keys": [
+				{"key": "sk-std", "user_id": "leaver@example.com", "spend": 0.5},
+				{"key": "sk-long", "user_id": "leaver@example.com", "spend": 2.0}
+			]}`))
+		case "/user/info/leaver@example.com":
+			_, _ = w.Write([]byte(`{"user_id": "leaver@example.com", "spend": 2.5, "max_budget": 10}`))
+		case "/key/block":
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			blocked[body["key"].(string)] = true
+		case "/key/unblock":
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			delete(blocked, body["key"].(string))
+		case "/key/update":
+			var req services.UpdateKeyRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			transfers = append(transfers, req)
+		case "/key/delete":
+			var req services.DeleteKeyRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			deleted = append(deleted, req.Keys...)
+		case "/team/member_delete":
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			removedFrom = append(removedFrom, body["team_id"].(string))
+		}
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{MaxActiveKeys: 10, LongTermKeyLimit: 1}
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+
+	db.Create(&models.User{ID: "leaver@example.com", Email: "leaver@example.com"})
+	db.Create(&models.User{ID: "heir@example.com", Email: "heir@example.com"})
+	db.Create(&models.KeyHistory{UserID: "leaver@example.com", LiteLLMKeyID: "sk-std", KeyType: "standard", Status: "active"})
+	db.Create(&models.KeyHistory{UserID: "leaver@example.com", LiteLLMKeyID: "sk-long", KeyType: "long-term", Status: "active"})
+	db.Create(&models.KeyHistory{UserID: "leaver@example.com", TeamID: "team-1", LiteLLMKeyID: "sk-team", KeyType: "standard", Status: "active"})
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "leaver@example.com", Role: "user"})
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "heir@example.com", Role: "user"})
+
+	act := func(handler echo.HandlerFunc, body string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("leaver@example.com")
+		c.Set("user_id", "admin@example.com")
+		if err := handler(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+	status := func() models.User {
+		var user models.User
+		db.Where("id = ?", "leaver@example.com").First(&user)
+		return user
+	}
+
+	// Suspension blocks every key and is reversible.
+	if rec := act(h.SuspendUser, `{"reason": "investigation"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if len(blocked) != 3 || status().Status != "suspended" {
+		t.Errorf("Expected 3 blocked keys and suspended status, got %v %s", blocked, status().Status)
+	}
+	if rec := act(h.SuspendUser, `{}`); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 when already suspended, got %d", rec.Code)
+	}
+	if rec := act(h.UnsuspendUser, ``); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if len(blocked) != 0 || status().Status != "active" {
+		t.Errorf("Expected keys unblocked and active status, got %v %s", blocked, status().Status)
+	}
+
+	// Offboarding can be cancelled before it is finalized.
+	if rec := act(h.OffboardUser, `{"successor": "leaver@example.com"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for self as successor, got %d", rec.Code)
+	}
+	if rec := act(h.OffboardUser, `{"successor": "heir@example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if user := status(); user.Status != "offboarding" || user.FinalSpend == nil || *user.FinalSpend != 2.5 {
+		t.Errorf("Expected offboarding with final spend captured, got %+v", user)
+	}
+	var snapshots int64
+	db.Model(&models.SpendSnapshot{}).Where("user_id = ?", "leaver@example.com").Count(&snapshots)
+	if snapshots != 3 {
+		t.Errorf("Expected user and key spend snapshots, got %d", snapshots)
+	}
+	if rec := act(h.CancelOffboarding, ``); rec.Code != http.StatusOK || status().Status != "active" || len(blocked) != 0 {
+		t.Errorf("Expected cancel to restore active status, got %d %s %v", rec.Code, status().Status, blocked)
+	}
+
+	act(h.OffboardUser, `{"successor": "heir@example.com"}`)
+
+	// A transfer that would take the successor past their long-term key
+	// limit is refused before anything is deleted.
+	heirKey := models.KeyHistory{UserID: "heir@example.com", LiteLLMKeyID: "sk-heir", KeyType: "long-term", Status: "active"}
+	db.Create(&heirKey)
+	if rec := act(h.FinalizeOffboarding, ``); rec.Code != http.StatusConflict || len(deleted) != 0 || status().Status != "offboarding" {
+		t.Errorf("Expected 409 without deletions, got %d %v %s", rec.Code, deleted, status().Status)
+	}
+	db.Delete(&heirKey)
+
+	if rec := act(h.FinalizeOffboarding, ``); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if user := status(); user.Status != "offboarded" || user.OffboardedAt == nil {
+		t.Errorf("Expected offboarded, got %+v", user)
+	}
+	if len(deleted) != 1 || deleted[0] != "sk-std" {
+		t.Errorf("Expected only the standard key deleted, got %v", deleted)
+	}
+	if len(transfers) != 2 || transfers[0].UserID != "heir@example.com" || transfers[1].Metadata["created_by"] != "heir@example.com" {
+		t.Errorf("Unexpected transfers %+v", transfers)
+	}
+	var heirKeys int64
+	db.Model(&models.KeyHistory{}).Where("user_id = ? AND status = ?", "heir@example.com", "active").Count(&heirKeys)
+	if heirKeys != 2 {
+		t.Errorf("Expected 2 keys transferred to the successor, got %d", heirKeys)
+	}
+	if len(removedFrom) != 1 || blocked["sk-long"] || blocked["sk-team"] {
+		t.Errorf("Expected team membership removed and transferred keys unblocked, got %v %v", removedFrom, blocked)
+	}
+	if rec := act(h.CancelOffboarding, ``); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 once finalized, got %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("leaver@example.com")
+	if err := h.GetUserEvents(c); err != nil {
+		t.Fatal(err)
+	}
+	var events []AuditEventResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &events)
+	actions := make(map[string]int)
+	for _, e := range events {
+		actions[e.Action]++
+	}
+	if len(events) == 0 || events[0].Action != "key.block" || events[len(events)-1].Action != "user.offboard_finalize" {
+		t.Errorf("Unexpected events %+v", events)
+	}
+	if actions["user.suspend"] != 1 || actions["key.transfer"] != 2 || actions["key.revoke"] != 1 || actions["team.member_remove"] != 1 {
+		t.Errorf("Expected each lifecycle step audited once, got %v", actions)
+	}
+	var suspend AuditEventResponse
+	for _, e := range events {
+		if e.Action == "user.suspend" {
+			suspend = e
+		}
+	}
+	if suspend.Actor != "admin@example.com" || !strings.Contains(string(suspend.After), `"reason":"investigation"`) {
+		t.Errorf("Expected the suspension audited with the admin and reason, got %+v", suspend)
+	}
+}
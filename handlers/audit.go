@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+type AuditEventResponse struct {
+	ID         uint            `json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	Actor      string          `json:"actor"`
+	UserID     string          `json:"user_id"`
+	Action     string          `json:"action"`
+	TargetType string          `json:"target_type,omitempty"`
+	TargetID   string          `json:"target_id,omitempty"`
+	IP         string          `json:"ip,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty" swaggertype:"object"`
+	After      json.RawMessage `json:"after,omitempty" swaggertype:"object"`
+	Hash       string          `json:"hash,omitempty"`
+}
+
+type AuditEventList struct {
+	Events []AuditEventResponse `json:"events"`
+	// NextBeforeID is passed as before_id to fetch the next, older page. It
+	// is omitted on the last page.
+	NextBeforeID uint `json:"next_before_id,omitempty"`
+}
+
+// ListAuditEvents godoc
+// @Summary Query the audit log
+// @Description List audit events, newest first, optionally filtered (admin only)
+// @Tags admin
+// @Produce json
+// @Param user_id query string false "Effective user"
+// @Param actor query string false "Actor who performed the action"
+// @Param action query string false "Action, e.g. key.create"
+// @Param target_id query string false "Target ID, e.g. a key ID"
+// @Param since query string false "Only events at or after this RFC 3339 time"
+// @Param until query string false "Only events before this RFC 3339 time"
+// @Param limit query int false "Page size, at most 1000" default(100)
+// @Param before_id query int false "Only events older than this ID"
+// @Success 200 {object} AuditEventList
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/audit [get]
+func (h *Handler) ListAuditEvents(c echo.Context) error {
+	query := h.DB.Model(&models.AuditEvent{})
+	if v := c.QueryParam("user_id"); v != "" {
+		query = query.Where("user_id = ?", strings.ToLower(v))
+	}
+	if v := c.QueryParam("actor"); v != "" {
+		query = query.Where("actor = ?", strings.ToLower(v))
+	}
+	if v := c.QueryParam("action"); v != "" {
+		query = query.Where("action = ?", v)
+	}
+	if v := c.QueryParam("target_id"); v != "" {
+		query = query.Where("target_id = ?", v)
+	}
+	for _, bound := range []struct{ param, cond string }{
+		{"since", "created_at >= ?"},
+		{"until", "created_at < ?"},
+	} {
+		v := c.QueryParam(bound.param)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid " + bound.param + " parameter"})
+		}
+		query = query.Where(bound.cond, t.UTC())
+	}
+
+	events, next, err := h.auditPage(c, query, true)
+	if err != nil {
+		if err == errInvalidPage {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit or before_id parameter"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch audit events"})
+	}
+	return c.JSON(http.StatusOK, AuditEventList{Events: events, NextBeforeID: next})
+}
+
+// VerifyAuditLog godoc
+// @Summary Verify the audit log
+// @Description Recompute the hash chain and report the first event that was altered or removed (admin only)
+// @Tags admin
+// @Produce json
+// @Success 200 {object} audit.VerifyResult
+// @Failure 500 {object} map[string]string
+// @Router /admin/audit/verify [get]
+func (h *Handler) VerifyAuditLog(c echo.Context) error {
+	result, err := audit.Verify(h.DB)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to verify audit log"})
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetMyActivity godoc
+// @Summary Get my activity
+// @Description List audit events about the current user, newest first
+// @Tags user
+// @Produce json
+// @Param limit query int false "Page size, at most 1000" default(100)
+// @Param before_id query int false "Only events older than this ID"
+// @Success 200 {object} AuditEventList
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /me/activity [get]
+func (h *Handler) GetMyActivity(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	query := h.DB.Model(&models.AuditEvent{}).Where("user_id = ?", userID)
+
+	events, next, err := h.auditPage(c, query, false)
+	if err != nil {
+		if err == errInvalidPage {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit or before_id parameter"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch activity"})
+	}
+	return c.JSON(http.StatusOK, AuditEventList{Events: events, NextBeforeID: next})
+}
+
+var errInvalidPage = errors.New("invalid limit or before_id")
+
+// auditPage applies the limit and before_id cursor to query. Client IPs and
+// hashes are only included for admins.
+func (h *Handler) auditPage(c echo.Context, query *gorm.DB, admin bool) ([]AuditEventResponse, uint, error) {
+	limit := auditDefaultLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, 0, errInvalidPage
+		}
+		limit = min(n, auditMaxLimit)
+	}
+	if v := c.QueryParam("before_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return nil, 0, errInvalidPage
+		}
+		query = query.Where("id < ?", id)
+	}
+
+	// One extra row tells whether an older page exists.
+	var rows []models.AuditEvent
+	if err := query.Order("id DESC").Limit(limit + 1).Find(&rows).Error; err != nil {
+		return nil, 0, err
+	}
+	var next uint
+	if len(rows) > limit {
+		rows = rows[:limit]
+		next = rows[limit-1].ID
+	}
+
+	events := make([]AuditEventResponse, 0, len(rows))
+	for i := range rows {
+		events = append(events, auditEventResponse(&rows[i], admin))
+	}
+	return events, next, nil
+}
+
+func auditEventResponse(e *models.AuditEvent, admin bool) AuditEventResponse {
+	resp := AuditEventResponse{
+		ID:         e.ID,
+		CreatedAt:  e.CreatedAt,
+		Actor:      e.Actor,
+		UserID:     e.UserID,
+		Action:     e.Action,
+		TargetType: e.TargetType,
+		TargetID:   e.TargetID,
+		RequestID:  e.RequestID,
+	}
+	if e.Before != "" {
+		resp.Before = json.RawMessage(e.Before)
+	}
+	if e.After != "" {
+		resp.After = json.RawMessage(e.After)
+	}
+	if admin {
+		resp.IP = e.IP
+		resp.Hash = e.Hash
+	}
+	return resp
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+func TestAuditLogEndpoints(t *testing.T) {
+	db := setupTestDB(t)
+	h := NewHandler(services.NewLiteLLMService(), db)
+
+	for _, e := range []audit.Event{
+		{Actor: "alice@example.com", UserID: "alice@example.com", Action: "key.create", TargetType: "key", TargetID: "sk-1", IP: "10.0.0.1", After: map[string]string{"name": "a"}},
+		{Actor: "system:sync", UserID: "alice@example.com", Action: "key.sync_revoke", TargetType: "key", TargetID: "sk-1", IP: "10.0.0.1"},
+		{Actor: "admin@example.com", UserID: "bob@example.com", Action: "user.suspend", TargetType: "user", TargetID: "bob@example.com"},
+	} {
+		h.Audit.Record(e)
+	}
+
+	get := func(handler echo.HandlerFunc, target, userID string) (int, AuditEventList) {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec)
+		c.Set("user_id", userID)
+		if err := handler(c); err != nil {
+			t.Fatal(err)
+		}
+		var list AuditEventList
+		json.Unmarshal(rec.Body.Bytes(), &list)
+		return rec.Code, list
+	}
+
+	// Filters and newest-first order.
+	code, list := get(h.ListAuditEvents, "/api/admin/audit?user_id=Alice@example.com", "admin@example.com")
+	if code != http.StatusOK || len(list.Events) != 2 || list.Events[0].Action != "key.sync_revoke" {
+		t.Fatalf("Unexpected filtered list: %d %+v", code, list)
+	}
+	if list.Events[1].IP != "10.0.0.1" || string(list.Events[1].After) != `{"name":"a"}` {
+		t.Errorf("Expected IP and raw state for admins, got %+v", list.Events[1])
+	}
+
+	// Cursor pagination.
+	_, page := get(h.ListAuditEvents, "/api/admin/audit?limit=2", "admin@example.com")
+	if len(page.Events) != 2 || page.NextBeforeID == 0 {
+		t.Fatalf("Expected a full first page with a cursor, got %+v", page)
+	}
+	_, page = get(h.ListAuditEvents, "/api/admin/audit?limit=2&before_id="+strconv.FormatUint(uint64(page.NextBeforeID), 10), "admin@example.com")
+	if len(page.Events) != 1 || page.NextBeforeID != 0 || page.Events[0].Action != "key.create" {
+		t.Errorf("Unexpected last page: %+v", page)
+	}
+
+	if code, _ := get(h.ListAuditEvents, "/api/admin/audit?since=yesterday", "admin@example.com"); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for invalid since, got %d", code)
+	}
+
+	// Users only see their own events, without client IPs.
+	_, mine := get(h.GetMyActivity, "/api/me/activity", "alice@example.com")
+	if len(mine.Events) != 2 || mine.Events[0].IP != "" || mine.Events[0].Hash != "" {
+		t.Errorf("Unexpected activity feed: %+v", mine)
+	}
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	if err := h.VerifyAuditLog(e.NewContext(httptest.NewRequest(http.MethodGet, "/api/admin/audit/verify", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	var result audit.VerifyResult
+	json.Unmarshal(rec.Body.Bytes(), &result)
+	if !result.Valid || result.Checked != 3 {
+		t.Errorf("Expected intact chain of 3, got %+v", result)
+	}
+}
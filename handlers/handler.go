@@ -1,18 +1,32 @@
 package handlers
 
 import (
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
 	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
 )
 
 type Handler struct {
-	LiteLLMService *services.LiteLLMService
+	LiteLLMService services.LiteLLMClient
 	DB             *gorm.DB
+	Audit          *audit.Logger
+	Models         *services.ModelCatalog
+	Reconciler     *services.KeyReconciler
 }
 
-func NewHandler(service *services.LiteLLMService, db *gorm.DB) *Handler {
+func NewHandler(service services.LiteLLMClient, db *gorm.DB) *Handler {
 	return &Handler{
 		LiteLLMService: service,
 		DB:             db,
+		Audit:          audit.NewLogger(db),
+		Models:         services.NewModelCatalog(service, config.AppConfig.ModelCatalogTTL),
+		Reconciler:     services.NewKeyReconciler(service, db),
 	}
 }
+
+// recordAudit logs a mutating action taken while serving c.
+func (h *Handler) recordAudit(c echo.Context, e audit.Event) {
+	h.Audit.RecordRequest(c, e)
+}
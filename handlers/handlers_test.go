@@ -1,14 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/example/llmreq/apierror"
 	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
 	"github.com/example/llmreq/models"
 	"github.com/example/llmreq/services"
 	"github.com/labstack/echo/v4"
@@ -18,29 +22,36 @@ import (
 
 func setupTestDB(t *testing.T) *gorm.DB {
 	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
-	if err := db.AutoMigrate(&models.KeyHistory{}); err != nil {
+	if err := models.Migrate(db); err != nil {
 		t.Fatalf("failed to migrate: %v", err)
 	}
 	db.Exec("DELETE FROM key_histories")
+	db.Exec("DELETE FROM spend_snapshots")
 	return db
 }
 
+// reconcile runs the key reconciler, which applies what GetActiveKeys only
+// shows.
+func reconcile(t *testing.T, h *Handler) {
+	if _, err := h.Reconciler.RunOnce(context.Background(), "manual"); err != nil {
+		t.Fatalf("reconcile failed: %v", err)
+	}
+}
+
+// newFakeLiteLLM serves an empty fake LiteLLM for the test and returns it
+// with a client for it.
+func newFakeLiteLLM(t *testing.T) (*litellmfake.Server, *services.LiteLLMService) {
+	fake := litellmfake.New("sk-master")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, &services.LiteLLMService{BaseURL: server.URL, MasterKey: config.NewSecret("sk-master"), Client: server.Client()}
+}
+
 func TestGetMe(t *testing.T) {
-	// Mock LiteLLM
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if strings.Contains(r.URL.Path, "/user/info/") {
-			w.WriteHeader(http.StatusOK)
-			_ = json.NewEncoder(w).Encode(services.LiteLLMUser{
-				UserID: "test@example.com",
-			})
-			return
-		}
-	}))
-	defer server.Close()
+	fake, svc := newFakeLiteLLM(t)
+	fake.AddUser(litellmfake.User{ID: "test@example.com", Email: "test@example.com"})
 
 	config.AppConfig = &config.Config{DefaultBudget: 1.0}
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -55,44 +66,31 @@ func TestGetMe(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if rec.Code != http.StatusOK {
-		t.Errorf("Expected 200, got %d", rec.Code)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"user_email":"test@example.com"`) {
+		t.Errorf("Expected 200 with the user, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// LiteLLM answers an unknown user with a null user_info.
+	rec = httptest.NewRecorder()
+	c = e.NewContext(httptest.NewRequest(http.MethodGet, "/api/me", nil), rec)
+	c.Set("user_id", "other@example.com")
+	if err := h.GetMe(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 for an unknown user, got %d", rec.Code)
 	}
 }
 
 func TestCreateKey(t *testing.T) {
-	callCount := 0
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/generate" {
-			w.WriteHeader(http.StatusOK)
-			_ = json.NewEncoder(w).Encode(services.GenerateKeyResponse{
-				Key: "sk-12345678",
-			})
-			return
-		}
-		if r.URL.Path == "/key/list" {
-			callCount++
-			w.WriteHeader(http.StatusOK)
-			if callCount == 1 {
-				// Limit check
-				_, _ = w.Write([]byte(`{"keys": []}`))
-			} else {
-				// Sync check
-				_, _ = w.Write([]byte(`{"keys": [{"key": "sk-1234...", "key_alias": "test-key"}]}`))
-			}
-			return
-		}
-		w.WriteHeader(http.StatusNotFound)
-	}))
-	defer server.Close()
+	fake, svc := newFakeLiteLLM(t)
 
 	config.AppConfig = &config.Config{
-		DefaultBudget: 1.0,
-		MaxActiveKeys: 10,
+		DefaultBudget:       1.0,
+		MaxActiveKeys:       10,
+		StandardKeyLifetime: 60 * 24 * time.Hour,
 	}
 
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -112,27 +110,113 @@ func TestCreateKey(t *testing.T) {
 		t.Errorf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
 	}
 
-	// Check DB
+	// The key is recorded under the token LiteLLM lists it by.
+	var resp services.GenerateKeyResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	created, ok := fake.Key(resp.Key)
+	if !ok || created.KeyAlias != "test-key" || created.Expires == nil || time.Until(*created.Expires) < 59*24*time.Hour {
+		t.Fatalf("Expected a 60-day key in LiteLLM, got %+v", created)
+	}
 	var key models.KeyHistory
 	db.Where("user_id = ?", "test@example.com").First(&key)
-	if key.LiteLLMKeyID != "sk-1234..." {
-		t.Errorf("Expected synced key ID sk-1234..., got %s", key.LiteLLMKeyID)
+	if key.LiteLLMKeyID != created.Token {
+		t.Errorf("Expected synced key ID %s, got %s", created.Token, key.LiteLLMKeyID)
+	}
+	if key.ExpiresAt == nil {
+		t.Error("Expected ExpiresAt to be recorded")
+	}
+}
+
+// generateHookClient calls afterGenerate right after generating a key,
+// before CreateKey records it.
+type generateHookClient struct {
+	services.LiteLLMClient
+	afterGenerate func(context.Context, *services.GenerateKeyResponse)
+}
+
+func (c *generateHookClient) GenerateKey(ctx context.Context, req services.GenerateKeyRequest) (*services.GenerateKeyResponse, error) {
+	resp, err := c.LiteLLMClient.GenerateKey(ctx, req)
+	if err == nil {
+		c.afterGenerate(ctx, resp)
+	}
+	return resp, err
+}
+
+func TestCreateKeyAfterImport(t *testing.T) {
+	fake, svc := newFakeLiteLLM(t)
+	config.AppConfig = &config.Config{
+		DefaultBudget:       1.0,
+		MaxActiveKeys:       10,
+		StandardKeyLifetime: 60 * 24 * time.Hour,
+	}
+	db := setupTestDB(t)
+	db.Create(&models.User{ID: "test@example.com"})
+	// A scheduled run races CreateKey.
+	reconciler := services.NewKeyReconciler(svc, db)
+	h := NewHandler(&generateHookClient{LiteLLMClient: svc, afterGenerate: func(ctx context.Context, _ *services.GenerateKeyResponse) {
+		_, _ = reconciler.RunOnce(ctx, "schedule")
+	}}, db)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(`{"name": "raced", "type": "standard"}`))
+	req.Header.Set("Content-Type", "application/json")
+	c := echo.New().NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+	if err := h.CreateKey(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	// The imported row takes what CreateKey knows instead of being doubled.
+	var resp services.GenerateKeyResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	created, _ := fake.Key(resp.Key)
+	var rows []models.KeyHistory
+	db.Where("litellm_key_id = ?", created.Token).Find(&rows)
+	if len(rows) != 1 || rows[0].MaxBudget != 1.0 || rows[0].KeyName != "raced" || rows[0].ExpiresAt == nil {
+		t.Errorf("Expected one row with the key's budget and expiry, got %+v", rows)
+	}
+}
+
+func TestCreateKeyRecordedForAnotherUser(t *testing.T) {
+	fake, svc := newFakeLiteLLM(t)
+	config.AppConfig = &config.Config{
+		DefaultBudget:       1.0,
+		MaxActiveKeys:       10,
+		StandardKeyLifetime: 60 * 24 * time.Hour,
+	}
+	db := setupTestDB(t)
+	db.Create(&models.User{ID: "test@example.com"})
+	h := NewHandler(&generateHookClient{LiteLLMClient: svc, afterGenerate: func(_ context.Context, resp *services.GenerateKeyResponse) {
+		created, _ := fake.Key(resp.Key)
+		db.Create(&models.KeyHistory{UserID: "other@example.com", LiteLLMKeyID: created.Token, KeyName: "theirs", Status: "active"})
+	}}, db)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(`{"name": "mine", "type": "standard"}`))
+	req.Header.Set("Content-Type", "application/json")
+	c := echo.New().NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+	if err := h.CreateKey(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("Expected 500 for an unrecorded key, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var rows []models.KeyHistory
+	db.Find(&rows)
+	if len(rows) != 1 || rows[0].UserID != "other@example.com" || rows[0].KeyName != "theirs" {
+		t.Errorf("Expected the other user's row to be left alone, got %+v", rows)
 	}
 }
 
 func TestGetActiveKeys(t *testing.T) {
-	// Mock LiteLLM
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/list" {
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{"keys": [{"key": "sk-active", "key_alias": "active-key", "spend": 1.0, "user_id": "test@example.com"}]}`))
-			return
-		}
-	}))
-	defer server.Close()
+	fake, svc := newFakeLiteLLM(t)
+	active := fake.AddKey(litellmfake.Key{UserID: "test@example.com", KeyAlias: "active-key"})
 
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -146,13 +230,22 @@ func TestGetActiveKeys(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if rec.Code != http.StatusOK {
-		t.Errorf("Expected 200, got %d", rec.Code)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), active.Token) {
+		t.Errorf("Expected the listed key, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	// GET is read-only
+	var count int64
+	db.Model(&models.KeyHistory{}).Count(&count)
+	if count != 0 {
+		t.Errorf("Expected GetActiveKeys not to write, found %d rows", count)
 	}
 
-	// Check DB synced
+	// Check DB synced; the reconciler walks provisioned users
+	db.Create(&models.User{ID: "test@example.com"})
+	reconcile(t, h)
 	var key models.KeyHistory
-	if err := db.Where("litellm_key_id = ?", "sk-active").First(&key).Error; err != nil {
+	if err := db.Where("litellm_key_id = ?", active.Token).First(&key).Error; err != nil {
 		t.Fatal("Expected key to be synced to DB")
 	}
 	if key.Status != "active" {
@@ -162,17 +255,9 @@ func TestGetActiveKeys(t *testing.T) {
 
 func TestGetActiveKeysSyncAlias(t *testing.T) {
 	// Test matching by alias when ID differs
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/list" {
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{"keys": [{"key": "sk-new-id", "key_alias": "alias-match", "user_id": "test@example.com"}]}`))
-			return
-		}
-	}))
-	defer server.Close()
+	fake, svc := newFakeLiteLLM(t)
+	renamed := fake.AddKey(litellmfake.Key{UserID: "test@example.com", KeyAlias: "alias-match"})
 
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -194,42 +279,39 @@ func TestGetActiveKeysSyncAlias(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	if !strings.Contains(rec.Body.String(), renamed.Token) {
+		t.Errorf("Expected the key under its new ID, got %s", rec.Body.String())
+	}
+
 	// Check DB updated
+	reconcile(t, h)
 	var key models.KeyHistory
 	db.Where("key_name = ?", "alias-match").First(&key)
-	if key.LiteLLMKeyID != "sk-new-id" {
-		t.Errorf("Expected updated ID sk-new-id, got %s", key.LiteLLMKeyID)
+	if key.LiteLLMKeyID != renamed.Token {
+		t.Errorf("Expected updated ID %s, got %s", renamed.Token, key.LiteLLMKeyID)
 	}
 }
 
 func TestDeleteKey(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/delete" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-	}))
-	defer server.Close()
-
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
+	fake, svc := newFakeLiteLLM(t)
+	deleted := fake.AddKey(litellmfake.Key{UserID: "test@example.com"})
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
 	// Seed DB
 	db.Create(&models.KeyHistory{
 		UserID:       "test@example.com",
-		LiteLLMKeyID: "sk-delete",
+		LiteLLMKeyID: deleted.Token,
 		Status:       "active",
 	})
 
 	e := echo.New()
-	req := httptest.NewRequest(http.MethodDelete, "/api/keys/sk-delete", nil)
+	req := httptest.NewRequest(http.MethodDelete, "/api/keys/"+deleted.Token, nil)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 	c.SetPath("/api/keys/:key_id")
 	c.SetParamNames("key_id")
-	c.SetParamValues("sk-delete")
+	c.SetParamValues(deleted.Token)
 	c.Set("user_id", "test@example.com")
 
 	if err := h.DeleteKey(c); err != nil {
@@ -241,48 +323,57 @@ func TestDeleteKey(t *testing.T) {
 	}
 
 	var key models.KeyHistory
-	db.Where("litellm_key_id = ?", "sk-delete").First(&key)
-	if key.Status != "revoked" {
-		t.Errorf("Expected status revoked, got %s", key.Status)
+	db.Where("litellm_key_id = ?", deleted.Token).First(&key)
+	if _, ok := fake.Key(deleted.Token); ok || key.Status != "revoked" {
+		t.Errorf("Expected the key to be deleted and revoked, got status %s", key.Status)
+	}
+
+	var event models.AuditEvent
+	db.Where("action = ? AND target_id = ?", "key.revoke", deleted.Token).First(&event)
+	if event.Actor != "test@example.com" || event.UserID != "test@example.com" || event.Before == "" || event.After == "" {
+		t.Errorf("Expected key.revoke audit event, got %+v", event)
+	}
+	// A key LiteLLM no longer has counts as deleted; a failed delete leaves
+	// the key active.
+	failing := fake.AddKey(litellmfake.Key{UserID: "test@example.com"})
+	for keyID, want := range map[string]struct {
+		code   int
+		status string
+	}{
+		"sk-gone":     {http.StatusOK, "revoked"},
+		failing.Token: {http.StatusServiceUnavailable, "active"},
+	} {
+		if keyID == failing.Token {
+			fake.FailNext(1, http.StatusBadGateway)
+		}
+		db.Create(&models.KeyHistory{UserID: "test@example.com", LiteLLMKeyID: keyID, Status: "active"})
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodDelete, "/api/keys/"+keyID, nil), rec)
+		c.SetParamNames("key_id")
+		c.SetParamValues(keyID)
+		c.Set("user_id", "test@example.com")
+		if err := h.DeleteKey(c); err != nil {
+			t.Fatal(err)
+		}
+		var key models.KeyHistory
+		db.Where("litellm_key_id = ?", keyID).First(&key)
+		if rec.Code != want.code || key.Status != want.status {
+			t.Errorf("%s: expected %d and %s, got %d and %s", keyID, want.code, want.status, rec.Code, key.Status)
+		}
 	}
 }
 
 func TestExpiredKey(t *testing.T) {
-	// Mock LiteLLM returning an expired key
-	expiredTime := time.Now().Add(-24 * time.Hour).Format(time.RFC3339)
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/list" {
-			w.WriteHeader(http.StatusOK)
-			// Return one expired key
-			resp := map[string]interface{}{
-				"keys": []map[string]interface{}{
-					{
-						"key":       "sk-expired",
-						"key_alias": "expired-key",
-						"user_id":   "test@example.com",
-						"expires":   expiredTime,
-					},
-				},
-			}
-			_ = json.NewEncoder(w).Encode(resp)
-			return
-		}
-		if r.URL.Path == "/key/generate" {
-			w.WriteHeader(http.StatusOK)
-			_ = json.NewEncoder(w).Encode(services.GenerateKeyResponse{
-				Key: "sk-new",
-			})
-			return
-		}
-	}))
-	defer server.Close()
+	// LiteLLM holds an expired key
+	fake, svc := newFakeLiteLLM(t)
+	past := time.Now().Add(-24 * time.Hour)
+	expired := fake.AddKey(litellmfake.Key{UserID: "test@example.com", KeyAlias: "expired-key", Expires: &past})
 
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
-	// 1. GetActiveKeys should mark it as expired in DB
+	// 1. GetActiveKeys should not show it, and the reconciler should mark
+	// it as expired in DB
 	e := echo.New()
 	req := httptest.NewRequest(http.MethodGet, "/api/keys/active", nil)
 	rec := httptest.NewRecorder()
@@ -297,9 +388,15 @@ func TestExpiredKey(t *testing.T) {
 		t.Errorf("Expected 200, got %d", rec.Code)
 	}
 
+	if strings.Contains(rec.Body.String(), expired.Token) {
+		t.Errorf("Expected the expired key to be hidden, got %s", rec.Body.String())
+	}
+
 	// Verify DB status
+	db.Create(&models.User{ID: "test@example.com"})
+	reconcile(t, h)
 	var key models.KeyHistory
-	if err := db.Where("litellm_key_id = ?", "sk-expired").First(&key).Error; err != nil {
+	if err := db.Where("litellm_key_id = ?", expired.Token).First(&key).Error; err != nil {
 		t.Fatal("Expected key to be synced to DB")
 	}
 	if key.Status != "expired" {
@@ -314,7 +411,7 @@ func TestExpiredKey(t *testing.T) {
 		MaxActiveKeys:       1,
 		StandardKeyLifetime: 60 * 24 * time.Hour,
 	} // Limit is 1
-	// We have 1 expired key in LiteLLM (mocked above). Creating another one should succeed because expired one doesn't count.
+	// We have 1 expired key in LiteLLM (seeded above). Creating another one should succeed because expired one doesn't count.
 
 	body := `{"name": "new-key", "type": "standard"}`
 	req2 := httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(body))
@@ -365,24 +462,13 @@ func TestGetKeyHistory(t *testing.T) {
 }
 
 func TestCreateKeyLimits(t *testing.T) {
-	// Mock
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/list" {
-			w.WriteHeader(http.StatusOK)
-			// Return max active keys
-			keys := make([]map[string]interface{}, 10)
-			for i := 0; i < 10; i++ {
-				keys[i] = map[string]interface{}{"key": "k", "user_id": "test@example.com"}
-			}
-			_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
-			return
-		}
-	}))
-	defer server.Close()
+	// LiteLLM holds max active keys
+	fake, svc := newFakeLiteLLM(t)
+	for i := 0; i < 10; i++ {
+		fake.AddKey(litellmfake.Key{UserID: "test@example.com"})
+	}
 
 	config.AppConfig = &config.Config{MaxActiveKeys: 10}
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -401,13 +487,9 @@ func TestCreateKeyLimits(t *testing.T) {
 }
 
 func TestGetActiveKeysError(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusInternalServerError)
-	}))
-	defer server.Close()
+	fake, svc := newFakeLiteLLM(t)
+	fake.SetFaults(litellmfake.Faults{ErrorRate: 1, ErrorStatus: http.StatusInternalServerError})
 
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -423,23 +505,45 @@ func TestGetActiveKeysError(t *testing.T) {
 	}
 }
 
+func TestCreateKeyRejectedUpstream(t *testing.T) {
+	_, svc := newFakeLiteLLM(t)
+
+	config.AppConfig = &config.Config{
+		DefaultBudget:       1.0,
+		MaxActiveKeys:       10,
+		StandardKeyLifetime: 60 * 24 * time.Hour,
+	}
+
+	// The member's team is known locally but not to LiteLLM.
+	db := setupTestDB(t)
+	db.Create(&models.TeamMember{TeamID: "team-gone", UserID: "test@example.com", Role: "user"})
+	h := NewHandler(svc, db)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(`{"name": "test-key", "type": "standard", "team_id": "team-gone"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+
+	_ = h.CreateKey(c)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("Expected 400, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var body map[string]string
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if body["detail"] != "Team not found, team_id=team-gone" {
+		t.Errorf("Expected LiteLLM's message as detail, got %v", body)
+	}
+}
+
 func TestCreateLongTermKeyLimit(t *testing.T) {
-	// Mock
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/list" {
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{"keys": []}`))
-			return
-		}
-	}))
-	defer server.Close()
+	_, svc := newFakeLiteLLM(t)
 
 	config.AppConfig = &config.Config{
 		MaxActiveKeys:    10,
 		LongTermKeyLimit: 1,
 	}
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -465,18 +569,8 @@ func TestCreateLongTermKeyLimit(t *testing.T) {
 }
 
 func TestSyncRevokedKeys(t *testing.T) {
-	// Mock LiteLLM returning empty list
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/list" {
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{"keys": []}`))
-			return
-		}
-	}))
-	defer server.Close()
-
-	svc := services.NewLiteLLMService()
-	svc.BaseURL = server.URL
+	// LiteLLM holds no keys
+	_, svc := newFakeLiteLLM(t)
 	db := setupTestDB(t)
 	h := NewHandler(svc, db)
 
@@ -502,9 +596,217 @@ func TestSyncRevokedKeys(t *testing.T) {
 	}
 
 	// Check DB updated to revoked
+	reconcile(t, h)
 	var key models.KeyHistory
 	db.Where("litellm_key_id = ?", "sk-missing").First(&key)
 	if key.Status != "revoked" {
 		t.Errorf("Expected status revoked, got %s", key.Status)
 	}
 }
+
+func TestSyncSkipsRevokeOnIncompleteListing(t *testing.T) {
+	// LiteLLM lists bare tokens, which do not say whose keys they are
+	fake, svc := newFakeLiteLLM(t)
+	fake.SetFaults(litellmfake.Faults{BareTokens: true})
+	listed := fake.AddKey(litellmfake.Key{UserID: "test@example.com"})
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+
+	db.Create(&models.KeyHistory{UserID: "test@example.com", LiteLLMKeyID: listed.Token, Status: "active"})
+	db.Create(&models.KeyHistory{UserID: "test@example.com", LiteLLMKeyID: "sk-unlisted", Status: "active"})
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/keys/active", nil), rec)
+	c.Set("user_id", "test@example.com")
+
+	if err := h.GetActiveKeys(c); err != nil {
+		t.Fatal(err)
+	}
+	// Keys without an owner are not shown, but a partial listing is no error.
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	reconcile(t, h)
+	var key models.KeyHistory
+	db.Where("litellm_key_id = ?", "sk-unlisted").First(&key)
+	if key.Status != "active" {
+		t.Errorf("Expected unlisted key to stay active on a partial listing, got %s", key.Status)
+	}
+}
+
+func TestGetExpiringKeys(t *testing.T) {
+	config.AppConfig = &config.Config{DashboardURL: "https://llmreq.example.com"}
+	db := setupTestDB(t)
+	h := NewHandler(nil, db)
+
+	soon := time.Now().Add(3 * 24 * time.Hour)
+	later := time.Now().Add(30 * 24 * time.Hour)
+	past := time.Now().Add(-time.Hour)
+	db.Create(&[]models.KeyHistory{
+		{UserID: "test@example.com", LiteLLMKeyID: "sk-soon", Status: "active", ExpiresAt: &soon},
+		{UserID: "test@example.com", LiteLLMKeyID: "sk-later", Status: "active", ExpiresAt: &later},
+		{UserID: "test@example.com", LiteLLMKeyID: "sk-past", Status: "active", ExpiresAt: &past},
+		{UserID: "other@example.com", LiteLLMKeyID: "sk-other", Status: "active", ExpiresAt: &soon},
+	})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/keys/expiring", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+
+	if err := h.GetExpiringKeys(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp []ExpiringKeyResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp) != 1 || resp[0].KeyID != "sk-soon" {
+		t.Fatalf("Expected only sk-soon, got %+v", resp)
+	}
+	if resp[0].RenewURL == "" {
+		t.Error("Expected renewal URL")
+	}
+
+	// Wider window
+	req = httptest.NewRequest(http.MethodGet, "/api/keys/expiring?within=60d", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+	_ = h.GetExpiringKeys(c)
+	resp = nil
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp) != 2 {
+		t.Errorf("Expected 2 keys within 60d, got %d", len(resp))
+	}
+
+	// Invalid window
+	req = httptest.NewRequest(http.MethodGet, "/api/keys/expiring?within=soon", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+	_ = h.GetExpiringKeys(c)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+}
+
+func TestRenewKey(t *testing.T) {
+	fake, svc := newFakeLiteLLM(t)
+
+	config.AppConfig = &config.Config{
+		StandardKeyLifetime: 60 * 24 * time.Hour,
+		LongTermKeyLifetime: 9600 * time.Hour,
+		KeyRenewalWindow:    14 * 24 * time.Hour,
+		MaxKeyRenewals:      1,
+	}
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+
+	soon := time.Now().Add(5 * 24 * time.Hour)
+	later := time.Now().Add(40 * 24 * time.Hour)
+	soonKey := fake.AddKey(litellmfake.Key{UserID: "test@example.com", Expires: &soon})
+	laterKey := fake.AddKey(litellmfake.Key{UserID: "test@example.com", Expires: &later})
+	failing := fake.AddKey(litellmfake.Key{UserID: "test@example.com", Expires: &soon})
+	// Expired in LiteLLM while the sync has not marked its row yet.
+	past := time.Now().Add(-time.Hour)
+	expiredKey := fake.AddKey(litellmfake.Key{UserID: "test@example.com", Expires: &past})
+	db.Create(&[]models.KeyHistory{
+		{UserID: "test@example.com", LiteLLMKeyID: soonKey.Token, KeyType: "long-term", Status: "active", ExpiresAt: &soon},
+		{UserID: "test@example.com", LiteLLMKeyID: laterKey.Token, KeyType: "standard", Status: "active", ExpiresAt: &later},
+		{UserID: "test@example.com", LiteLLMKeyID: failing.Token, KeyType: "standard", Status: "active", ExpiresAt: &soon},
+		{UserID: "test@example.com", LiteLLMKeyID: expiredKey.Token, KeyType: "standard", Status: "active", ExpiresAt: &past},
+	})
+
+	renew := func(keyID string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/keys/"+keyID+"/renew", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetPath("/api/keys/:key_id/renew")
+		c.SetParamNames("key_id")
+		c.SetParamValues(keyID)
+		c.Set("user_id", "test@example.com")
+		if err := h.RenewKey(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// Outside the renewal window
+	if rec := renew(laterKey.Token); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 outside window, got %d", rec.Code)
+	}
+
+	// Unknown key
+	if rec := renew("sk-unknown"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404, got %d", rec.Code)
+	}
+
+	// An expired key is not brought back, even while its row is active.
+	if rec := renew(expiredKey.Token); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an expired key, got %d", rec.Code)
+	}
+	var expiredRow models.KeyHistory
+	db.Where("litellm_key_id = ?", expiredKey.Token).First(&expiredRow)
+	if expired, _ := fake.Key(expiredKey.Token); expiredRow.RenewCount != 0 || !expired.Expires.Equal(past) {
+		t.Errorf("Expected the expired key left alone, got renew count %d and expiry %v", expiredRow.RenewCount, expired.Expires)
+	}
+
+	// Within window
+	rec := renew(soonKey.Token)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if renewed, _ := fake.Key(soonKey.Token); renewed.Expires == nil || time.Until(*renewed.Expires) < 9000*time.Hour {
+		t.Errorf("Expected LiteLLM to extend the key, got %+v", renewed)
+	}
+
+	var key models.KeyHistory
+	db.Where("litellm_key_id = ?", soonKey.Token).First(&key)
+	if key.RenewCount != 1 {
+		t.Errorf("Expected renew count 1, got %d", key.RenewCount)
+	}
+	if key.ExpiresAt == nil || time.Until(*key.ExpiresAt) < 9000*time.Hour {
+		t.Errorf("Expected expiry to be extended, got %v", key.ExpiresAt)
+	}
+
+	// Limit reached: move the expiry back into the window and try again.
+	db.Model(&key).Update("expires_at", soon)
+	if rec := renew(soonKey.Token); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 when renewal limit reached, got %d", rec.Code)
+	}
+
+	// A renewal LiteLLM refuses does not use up the limit.
+	fake.FailNext(1, http.StatusBadGateway)
+	if rec := renew(failing.Token); rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 when LiteLLM fails, got %d", rec.Code)
+	}
+	var failingRow models.KeyHistory
+	db.Where("litellm_key_id = ?", failing.Token).First(&failingRow)
+	if failingRow.RenewCount != 0 {
+		t.Errorf("Expected the failed renewal to be released, got renew count %d", failingRow.RenewCount)
+	}
+}
+
+func TestUpstreamErrorRetryAfter(t *testing.T) {
+	breaker := services.NewCircuitBreaker(1, 90*time.Second)
+	client := breaker.Wrap(&services.LiteLLMService{BaseURL: "http://127.0.0.1:1", Client: &http.Client{}})
+	client.GetUserInfo(context.Background(), "a@example.com")
+	_, err := client.GetUserInfo(context.Background(), "a@example.com")
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	apierror.Upstream(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), fmt.Errorf("failed to fetch user: %w", err), "Failed to fetch user")
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "90" {
+		t.Errorf("Expected 503 with Retry-After 90 from the open breaker, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	rec = httptest.NewRecorder()
+	apierror.Upstream(e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec), services.ErrValidation, "Failed to fetch user")
+	if got := rec.Header().Get("Retry-After"); got != "" {
+		t.Errorf("Expected no Retry-After for a rejected request, got %q", got)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// readinessTimeout bounds each dependency check of /readyz, so a hanging
+// LiteLLM fails the probe instead of outlasting the orchestrator's timeout.
+const readinessTimeout = 3 * time.Second
+
+// HealthCheck is the result of checking one dependency.
+type HealthCheck struct {
+	Name    string   `json:"name"`
+	Status  string   `json:"status"` // ok, fail, or degraded while a fallback serves reads
+	Error   string   `json:"error,omitempty"`
+	Pending []string `json:"pending,omitempty"` // migrations check only
+	Version string   `json:"version,omitempty"` // LiteLLM checks only
+	Breaker string   `json:"breaker,omitempty"` // LiteLLM checks only
+}
+
+type ReadinessResponse struct {
+	Status string        `json:"status"` // ready or not_ready
+	Checks []HealthCheck `json:"checks"`
+}
+
+// HealthHandler serves the liveness and readiness probes. They are mounted
+// outside the authenticated API so orchestrators can reach them.
+type HealthHandler struct {
+	DB *gorm.DB
+	// Backends are the LiteLLM instances by name. They are called directly,
+	// bypassing retries and circuit breakers, so a probe neither waits for
+	// retries nor counts towards opening a breaker.
+	Backends map[string]*services.LiteLLMService
+	// Fallbacks names the instance that serves reads while an instance is
+	// unreachable.
+	Fallbacks map[string]string
+
+	// Migrations only run at startup, so their state is checked once.
+	pending    []string
+	pendingErr error
+}
+
+func NewHealthHandler(db *gorm.DB, backends map[string]*services.LiteLLMService) *HealthHandler {
+	h := &HealthHandler{DB: db, Backends: backends, Fallbacks: make(map[string]string)}
+	for _, inst := range config.AppConfig.Instances() {
+		if inst.Fallback != "" {
+			h.Fallbacks[inst.Name] = inst.Fallback
+		}
+	}
+	h.pending, h.pendingErr = models.PendingMigrations(db)
+	return h
+}
+
+// Healthz reports that the process is up and serving. It checks no
+// dependency, so an outage elsewhere does not get llmreq restarted.
+func (h *HealthHandler) Healthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz checks SQLite, its migration state and every LiteLLM instance:
+// that it answers with the master key and that its circuit breaker is not
+// open. An instance that fails while its fallback passes is only degraded,
+// since reads still succeed. It answers 503 if any check fails.
+func (h *HealthHandler) Readyz(c echo.Context) error {
+	ctx := c.Request().Context()
+	checks := []HealthCheck{h.checkDatabase(ctx), h.checkMigrations()}
+
+	names := make([]string, 0, len(h.Backends))
+	for name := range h.Backends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	litellm := make([]HealthCheck, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			litellm[i] = h.checkLiteLLM(ctx, name, h.Backends[name])
+		}()
+	}
+	wg.Wait()
+	passed := make(map[string]bool)
+	for i, name := range names {
+		passed[name] = litellm[i].Status == "ok"
+	}
+	for i, name := range names {
+		if fallback := h.Fallbacks[name]; litellm[i].Status == "fail" && passed[fallback] {
+			litellm[i].Status = "degraded"
+			litellm[i].Error += "; reads fall back to " + fallback
+		}
+	}
+	checks = append(checks, litellm...)
+
+	resp := ReadinessResponse{Status: "ready", Checks: checks}
+	for _, check := range checks {
+		if check.Status == "fail" {
+			resp.Status = "not_ready"
+			return c.JSON(http.StatusServiceUnavailable, resp)
+		}
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *HealthHandler) checkDatabase(ctx context.Context) HealthCheck {
+	check := HealthCheck{Name: "database", Status: "ok"}
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	sqlDB, err := h.DB.DB()
+	if err == nil {
+		err = sqlDB.PingContext(ctx)
+	}
+	if err != nil {
+		check.Status, check.Error = "fail", err.Error()
+	}
+	return check
+}
+
+func (h *HealthHandler) checkMigrations() HealthCheck {
+	check := HealthCheck{Name: "migrations", Status: "ok"}
+	if h.pendingErr != nil {
+		check.Status, check.Error = "fail", h.pendingErr.Error()
+	} else if len(h.pending) > 0 {
+		check.Status, check.Pending = "fail", h.pending
+	}
+	return check
+}
+
+func (h *HealthHandler) checkLiteLLM(ctx context.Context, name string, backend *services.LiteLLMService) HealthCheck {
+	check := HealthCheck{Name: "litellm:" + name, Status: "ok", Version: backend.Version}
+	if breaker := services.Breakers[name]; breaker != nil {
+		check.Breaker = breaker.State()
+	}
+	if check.Breaker == services.BreakerOpen {
+		check.Status, check.Error = "fail", "circuit breaker is open"
+		return check
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, readinessTimeout)
+	defer cancel()
+	if err := backend.Ping(ctx); err != nil {
+		check.Status, check.Error = "fail", err.Error()
+	}
+	return check
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestReadyz(t *testing.T) {
+	masterKey := "sk-master"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+masterKey {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"keys": []}`))
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{}
+	backend := services.NewLiteLLMService()
+	backend.BaseURL = server.URL
+	backend.MasterKey = config.NewSecret("sk-master")
+	h := NewHealthHandler(setupTestDB(t), map[string]*services.LiteLLMService{config.DefaultInstance: backend})
+
+	readyz := func() (int, ReadinessResponse) {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/readyz", nil), rec)
+		if err := h.Readyz(c); err != nil {
+			t.Fatal(err)
+		}
+		var resp ReadinessResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return rec.Code, resp
+	}
+
+	if code, resp := readyz(); code != http.StatusOK || resp.Status != "ready" || len(resp.Checks) != 3 {
+		t.Errorf("Expected ready, got %d %+v", code, resp)
+	}
+
+	// A rejected master key fails the LiteLLM check.
+	masterKey = "sk-rotated"
+	code, resp := readyz()
+	if code != http.StatusServiceUnavailable || resp.Status != "not_ready" || resp.Checks[2].Status != "fail" {
+		t.Errorf("Expected not ready with a rejected key, got %d %+v", code, resp)
+	}
+	backend.MasterKey = config.NewSecret("sk-rotated")
+
+	// So does an open circuit breaker, without calling LiteLLM.
+	breaker := services.NewCircuitBreaker(1, time.Minute)
+	services.Breakers = map[string]*services.CircuitBreaker{config.DefaultInstance: breaker}
+	defer func() { services.Breakers = nil }()
+	if code, resp := readyz(); code != http.StatusOK || resp.Checks[2].Breaker != services.BreakerClosed {
+		t.Errorf("Expected ready with a closed breaker, got %d %+v", code, resp)
+	}
+	_ = breaker.Intercept(context.Background(), "ping", func(context.Context) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	})
+	if code, resp := readyz(); code != http.StatusServiceUnavailable || resp.Checks[2].Breaker != services.BreakerOpen {
+		t.Errorf("Expected not ready with an open breaker, got %d %+v", code, resp)
+	}
+
+	// An instance that is down only degrades readiness while its fallback
+	// is up.
+	services.Breakers = nil
+	replica := services.NewLiteLLMService()
+	replica.BaseURL = server.URL
+	replica.MasterKey = config.NewSecret("sk-rotated")
+	h.Backends["replica"] = replica
+	h.Fallbacks[config.DefaultInstance] = "replica"
+	backend.MasterKey = config.NewSecret("sk-stale")
+	if code, resp := readyz(); code != http.StatusOK || resp.Checks[2].Status != "degraded" || resp.Checks[3].Status != "ok" {
+		t.Errorf("Expected ready with a degraded instance, got %d %+v", code, resp)
+	}
+	replica.MasterKey = config.NewSecret("sk-stale")
+	if code, resp := readyz(); code != http.StatusServiceUnavailable || resp.Checks[2].Status != "fail" {
+		t.Errorf("Expected not ready once the fallback is down too, got %d %+v", code, resp)
+	}
+
+	// An unmigrated database is reported with what is missing.
+	empty, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"-empty?mode=memory&cache=shared"), &gorm.Config{})
+	h = NewHealthHandler(empty, h.Backends)
+	code, resp = readyz()
+	if code != http.StatusServiceUnavailable || resp.Checks[0].Status != "ok" || len(resp.Checks[1].Pending) == 0 {
+		t.Errorf("Expected pending migrations, got %d %+v", code, resp)
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/healthz", nil), rec)
+	if err := (&HealthHandler{}).Healthz(c); err != nil || rec.Code != http.StatusOK {
+		t.Errorf("Expected 200, got %d, %v", rec.Code, err)
+	}
+}
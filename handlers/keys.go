@@ -1,20 +1,27 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/example/llmreq/apierror"
+	"github.com/example/llmreq/audit"
 	"github.com/example/llmreq/config"
 	"github.com/example/llmreq/models"
 	"github.com/example/llmreq/services"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type CreateKeyRequest struct {
 	Name   string  `json:"name"`
 	Budget float64 `json:"budget"`
-	Type   string  `json:"type"` // "standard" or "long-term"
+	Type   string  `json:"type"`              // "standard" or "long-term"
+	TeamID string  `json:"team_id,omitempty"` // create a key owned by this team
 }
 
 type ActiveKeyResponse struct {
@@ -29,7 +36,7 @@ type ActiveKeyResponse struct {
 
 // GetActiveKeys godoc
 // @Summary Get active keys
-// @Description Fetch active keys from LiteLLM and sync with local DB
+// @Description Fetch active keys from LiteLLM, matched with the local key history. Nothing is written; the key reconciler applies the changes.
 // @Tags keys
 // @Accept json
 // @Produce json
@@ -41,148 +48,32 @@ func (h *Handler) GetActiveKeys(c echo.Context) error {
 
 	// Fetch DB keys first
 	var dbKeys []models.KeyHistory
-	if err := h.DB.Where("user_id = ?", userID).Find(&dbKeys).Error; err != nil {
+	if err := h.DB.Where("user_id = ? AND team_id = ?", userID, "").Find(&dbKeys).Error; err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch local keys"})
 	}
-	dbKeyMap := make(map[string]*models.KeyHistory)
-	for i := range dbKeys {
-		dbKeyMap[dbKeys[i].LiteLLMKeyID] = &dbKeys[i]
-	}
 
-	keys, err := h.LiteLLMService.ListKeys(userID)
-	if err != nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Failed to fetch keys from LiteLLM"})
+	// Partial listings are shown as far as they go.
+	keys, err := h.LiteLLMService.ListKeys(c.Request().Context(), userID)
+	if err != nil && !errors.Is(err, services.ErrIncompleteListing) {
+		return apierror.Upstream(c, err, "Failed to fetch keys from LiteLLM")
 	}
 
+	// Keys are shown as the reconciler will record them, so a key created
+	// outside llmreq appears before its next run.
 	responseKeys := []ActiveKeyResponse{}
-	processedDBIDs := make(map[uint]struct{})
-
-	for _, k := range keys {
-		// Strict user check
-		if k.User != userID {
+	for _, k := range services.PlanKeys(userID, dbKeys, keys, false, time.Now()).Keys {
+		if k.Row.Status != "active" {
 			continue
 		}
-
-		id := k.Key // Usually masked key
-
-		// Parse expiration
-		var expiresAt *time.Time
-		if k.Expires != "" && k.Expires != "null" {
-			if t, err := time.Parse(time.RFC3339, k.Expires); err == nil {
-				expiresAt = &t
-			}
-		}
-
-		isExpired := expiresAt != nil && expiresAt.Before(time.Now())
-
-		dbKey, exists := dbKeyMap[id]
-		if exists {
-			// Found in DB. Ensure active.
-			processedDBIDs[dbKey.ID] = struct{}{}
-
-			dbKey.ExpiresAt = expiresAt
-			if isExpired {
-				if dbKey.Status != "expired" {
-					dbKey.Status = "expired"
-					h.DB.Save(dbKey)
-				}
-			} else {
-				if dbKey.Status != "active" {
-					dbKey.Status = "active"
-					dbKey.RevokedAt = nil
-					h.DB.Save(dbKey)
-				}
-				responseKeys = append(responseKeys, ActiveKeyResponse{
-					Mask:      dbKey.KeyMask,
-					Name:      dbKey.KeyName,
-					CreatedAt: dbKey.CreatedAt,
-					ExpiresAt: dbKey.ExpiresAt,
-					Spend:     k.Spend,
-					Type:      dbKey.KeyType,
-					KeyID:     dbKey.LiteLLMKeyID,
-				})
-			}
-		} else {
-			// Not in DB. Try to match by alias?
-			// If alias matches a dbKey, update its ID?
-			// This handles re-sync if ID changed or we guessed wrong.
-			var matchedByAlias *models.KeyHistory
-			for i := range dbKeys {
-				if dbKeys[i].KeyName == k.KeyAlias && dbKeys[i].LiteLLMKeyID != id {
-					matchedByAlias = &dbKeys[i]
-					break
-				}
-			}
-
-			if matchedByAlias != nil {
-				processedDBIDs[matchedByAlias.ID] = struct{}{}
-
-				// Update ID
-				matchedByAlias.LiteLLMKeyID = id
-				matchedByAlias.ExpiresAt = expiresAt
-
-				if isExpired {
-					if matchedByAlias.Status != "expired" {
-						matchedByAlias.Status = "expired"
-						h.DB.Save(matchedByAlias)
-					}
-				} else {
-					matchedByAlias.Status = "active"
-					matchedByAlias.RevokedAt = nil
-					h.DB.Save(matchedByAlias)
-
-					responseKeys = append(responseKeys, ActiveKeyResponse{
-						Mask:      matchedByAlias.KeyMask,
-						Name:      matchedByAlias.KeyName,
-						CreatedAt: matchedByAlias.CreatedAt,
-						ExpiresAt: matchedByAlias.ExpiresAt,
-						Spend:     k.Spend,
-						Type:      matchedByAlias.KeyType,
-						KeyID:     matchedByAlias.LiteLLMKeyID,
-					})
-				}
-			} else {
-				// Create new
-				newKey := models.KeyHistory{
-					UserID:       userID,
-					LiteLLMKeyID: id,
-					KeyName:      k.KeyAlias,
-					KeyMask:      k.Key,
-					KeyType:      "standard",
-					CreatedAt:    time.Now(),
-					ExpiresAt:    expiresAt,
-					Status:       "active",
-				}
-				if isExpired {
-					newKey.Status = "expired"
-				}
-				h.DB.Create(&newKey)
-
-				if !isExpired {
-					responseKeys = append(responseKeys, ActiveKeyResponse{
-						Mask:      newKey.KeyMask,
-						Name:      newKey.KeyName,
-						CreatedAt: newKey.CreatedAt,
-						ExpiresAt: newKey.ExpiresAt,
-						Spend:     k.Spend,
-						Type:      newKey.KeyType,
-						KeyID:     newKey.LiteLLMKeyID,
-					})
-				}
-			}
-		}
-	}
-
-	// Revoke keys not in LiteLLM list (and not matched/processed)
-	for _, dbKey := range dbKeys {
-		if _, ok := processedDBIDs[dbKey.ID]; !ok {
-			if dbKey.Status == "active" {
-				dbKey.Status = "revoked"
-				now := time.Now()
-				dbKey.RevokedAt = &now
-				h.DB.Save(&dbKey)
-			}
-		}
+		responseKeys = append(responseKeys, ActiveKeyResponse{
+			Mask:      k.Row.KeyMask,
+			Name:      k.Row.KeyName,
+			CreatedAt: k.Row.CreatedAt,
+			ExpiresAt: k.Row.ExpiresAt,
+			Spend:     k.Spend,
+			Type:      k.Row.KeyType,
+			KeyID:     k.Row.LiteLLMKeyID,
+		})
 	}
 
 	return c.JSON(http.StatusOK, responseKeys)
@@ -205,6 +96,64 @@ func (h *Handler) GetKeyHistory(c echo.Context) error {
 	return c.JSON(http.StatusOK, history)
 }
 
+type ExpiringKeyResponse struct {
+	KeyID     string    `json:"key_id"`
+	Name      string    `json:"name"`
+	Mask      string    `json:"mask"`
+	Type      string    `json:"type"`
+	ExpiresAt time.Time `json:"expires_at"`
+	DaysLeft  int       `json:"days_left"`
+	RenewURL  string    `json:"renew_url,omitempty"`
+}
+
+// GetExpiringKeys godoc
+// @Summary Get keys expiring soon
+// @Description List active keys that expire within the given window
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Param within query string false "Window, e.g. 14d or 48h" default(14d)
+// @Success 200 {array} ExpiringKeyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /keys/expiring [get]
+func (h *Handler) GetExpiringKeys(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+
+	within := 14 * 24 * time.Hour
+	if v := c.QueryParam("within"); v != "" {
+		d, err := config.ParseDurationExtended(v)
+		if err != nil || d <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid within parameter"})
+		}
+		within = d
+	}
+
+	now := time.Now()
+	var keys []models.KeyHistory
+	err := h.DB.Where("user_id = ? AND status = ? AND expires_at IS NOT NULL AND expires_at > ? AND expires_at <= ?", userID, "active", now, now.Add(within)).
+		Order("expires_at").
+		Find(&keys).Error
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch local keys"})
+	}
+
+	resp := []ExpiringKeyResponse{}
+	for _, k := range keys {
+		resp = append(resp, ExpiringKeyResponse{
+			KeyID:     k.LiteLLMKeyID,
+			Name:      k.KeyName,
+			Mask:      k.KeyMask,
+			Type:      k.KeyType,
+			ExpiresAt: *k.ExpiresAt,
+			DaysLeft:  int(k.ExpiresAt.Sub(now).Hours() / 24),
+			RenewURL:  services.RenewalURL(k.LiteLLMKeyID),
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
 // CreateKey godoc
 // @Summary Create a new API key
 // @Description Create a new API key with optional budget and type
@@ -215,6 +164,7 @@ func (h *Handler) GetKeyHistory(c echo.Context) error {
 // @Success 200 {object} services.GenerateKeyResponse
 // @Failure 400 {object} map[string]string
 // @Failure 500 {object} map[string]string
+// @Failure 503 {object} map[string]string
 // @Router /keys [post]
 func (h *Handler) CreateKey(c echo.Context) error {
 	userID := c.Get("user_id").(string)
@@ -227,16 +177,26 @@ func (h *Handler) CreateKey(c echo.Context) error {
 		req.Type = "standard"
 	}
 
+	// Team keys count against the team's limits instead of personal ones
+	var memberCap *float64
+	if req.TeamID != "" {
+		var member models.TeamMember
+		if err := h.DB.Where("team_id = ? AND user_id = ?", req.TeamID, userID).First(&member).Error; err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Not a member of this team"})
+		}
+		memberCap = member.MaxSpend
+	}
+
 	// Check Global Limit
 	// Use LiteLLM list to count active keys
-	activeKeys, err := h.LiteLLMService.ListKeys(userID)
+	activeKeys, err := h.listOwnerKeys(c.Request().Context(), userID, req.TeamID)
 	if err != nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "Failed to fetch key count"})
+		return apierror.Upstream(c, err, "Failed to fetch key count")
 	}
 
 	userActiveKeyCount := 0
 	for _, k := range activeKeys {
-		if k.User == userID {
+		if ownsKey(k, userID, req.TeamID) {
 			// Check expiration
 			if k.Expires != "" && k.Expires != "null" {
 				if t, err := time.Parse(time.RFC3339, k.Expires); err == nil {
@@ -256,7 +216,13 @@ func (h *Handler) CreateKey(c echo.Context) error {
 	// Check Long-term Limit
 	if req.Type == "long-term" {
 		var count int64
-		h.DB.Model(&models.KeyHistory{}).Where("user_id = ? AND key_type = ? AND status = ?", userID, "long-term", "active").Count(&count)
+		query := h.DB.Model(&models.KeyHistory{}).Where("key_type = ? AND status = ?", "long-term", "active")
+		if req.TeamID != "" {
+			query = query.Where("team_id = ?", req.TeamID)
+		} else {
+			query = query.Where("user_id = ? AND team_id = ?", userID, "")
+		}
+		query.Count(&count)
 		if int(count) >= config.AppConfig.LongTermKeyLimit {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Long-term key limit reached"})
 		}
@@ -264,38 +230,79 @@ func (h *Handler) CreateKey(c echo.Context) error {
 
 	// Determine Budget and Duration
 	var maxBudget float64
-	var duration string
 
 	if req.Type == "long-term" {
 		maxBudget = config.AppConfig.LongTermKeyBudget
 		if req.Budget > 0 && req.Budget < maxBudget {
 			maxBudget = req.Budget
 		}
-		duration = config.AppConfig.LongTermKeyLifetime.String()
 	} else {
 		maxBudget = config.AppConfig.DefaultBudget
 		if req.Budget > 0 && req.Budget < maxBudget {
 			maxBudget = req.Budget
 		}
-		duration = config.AppConfig.StandardKeyLifetime.String()
 	}
 
+	// A member with a spend cap may only get a key budget that fits in what
+	// is left of their share of the team budget once their other keys'
+	// budgets are taken out.
+	if memberCap != nil {
+		committed, err := h.memberTeamCommitted(c.Request().Context(), req.TeamID, userID)
+		if err != nil {
+			return apierror.Upstream(c, err, "Failed to fetch team spend")
+		}
+		remaining := *memberCap - committed
+		if remaining <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Team member spend cap reached"})
+		}
+		if maxBudget > remaining {
+			maxBudget = remaining
+		}
+	}
+
+	lifetime := keyLifetime(req.Type)
+	duration := lifetime.String()
+
 	// Call LiteLLM
 	genReq := services.GenerateKeyRequest{
 		UserID:    userID,
 		KeyAlias:  req.Name,
 		MaxBudget: maxBudget,
 		Duration:  duration,
+		// The reconciler compares the recorded type with this
+		Metadata: map[string]interface{}{"key_type": req.Type},
+	}
+	if req.TeamID != "" {
+		// Without a user_id the key's spend is tracked against the team only
+		genReq.UserID = ""
+		genReq.TeamID = req.TeamID
+		genReq.Metadata["created_by"] = userID
+	}
+
+	// Keys of a type routed to another LiteLLM instance live apart from
+	// their owner
+	ctx := c.Request().Context()
+	groups, _ := c.Get("groups").([]string)
+	if instance, ok := config.AppConfig.ResolveKeyInstance(config.Route{Email: userID, Groups: groups, TeamID: req.TeamID, KeyType: req.Type}); ok {
+		ctx = services.WithInstance(ctx, instance)
 	}
 
-	genResp, err := h.LiteLLMService.GenerateKey(genReq)
+	genResp, err := h.LiteLLMService.GenerateKey(ctx, genReq)
 	if err != nil {
 		log.Printf("Failed to generate key: %v", err)
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to generate key"})
+		return apierror.Upstream(c, err, "Failed to generate key")
 	}
 
 	// Fetch keys to find the correct ID (sync immediately)
-	keys, err := h.LiteLLMService.ListKeys(userID)
+	filter := services.KeyFilter{UserID: userID, KeyAlias: req.Name}
+	if req.TeamID != "" {
+		filter = services.KeyFilter{TeamID: req.TeamID, KeyAlias: req.Name}
+	}
+	instance := genResp.Instance
+	if instance == "" {
+		instance = config.DefaultInstance
+	}
+	keys, err := h.LiteLLMService.FindKeys(services.WithInstance(ctx, instance), filter)
 	var correctID string
 	var mask string
 
@@ -317,17 +324,44 @@ func (h *Handler) CreateKey(c echo.Context) error {
 		}
 	}
 
+	now := time.Now()
 	newKey := models.KeyHistory{
 		UserID:       userID,
 		LiteLLMKeyID: correctID,
 		KeyName:      req.Name,
 		KeyMask:      mask,
 		KeyType:      req.Type,
-		CreatedAt:    time.Now(),
+		CreatedAt:    now,
 		Status:       "active",
+		MaxBudget:    maxBudget,
+		TeamID:       req.TeamID,
+		Instance:     genResp.Instance,
+	}
+	if lifetime > 0 {
+		expiresAt := now.Add(lifetime)
+		newKey.ExpiresAt = &expiresAt
+	}
+
+	// The reconciler may have imported the key since it was generated; the
+	// row then takes what llmreq knows about the key. A row of the same ID
+	// recorded for someone else is never taken over.
+	result := h.DB.Clauses(clause.OnConflict{
+		Columns:     []clause.Column{{Name: "litellm_key_id"}},
+		TargetWhere: clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "litellm_key_id <> '' AND status <> 'superseded'"}}},
+		DoUpdates:   clause.AssignmentColumns([]string{"key_name", "key_mask", "key_type", "expires_at", "status", "max_budget", "team_id", "instance"}),
+		Where:       clause.Where{Exprs: []clause.Expression{clause.Expr{SQL: "key_histories.user_id = excluded.user_id"}}},
+	}).Create(&newKey)
+	if result.Error == nil && result.RowsAffected == 0 {
+		result.Error = errors.New("recorded for another user")
+	}
+	if result.Error != nil {
+		log.Printf("Failed to record key %s of %s: %v", newKey.LiteLLMKeyID, userID, result.Error)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to record key"})
 	}
 
-	h.DB.Create(&newKey)
+	after := audit.KeyState(&newKey)
+	after["max_budget"] = maxBudget
+	h.recordAudit(c, audit.Event{Action: "key.create", TargetType: "key", TargetID: newKey.LiteLLMKeyID, After: after})
 
 	return c.JSON(http.StatusOK, genResp)
 }
@@ -341,6 +375,7 @@ func (h *Handler) CreateKey(c echo.Context) error {
 // @Param key_id path string true "Key ID"
 // @Success 200 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
 // @Router /keys/{key_id} [delete]
 func (h *Handler) DeleteKey(c echo.Context) error {
 	keyID := c.Param("key_id")
@@ -348,18 +383,143 @@ func (h *Handler) DeleteKey(c echo.Context) error {
 
 	// Verify ownership
 	var dbKey models.KeyHistory
-	if err := h.DB.Where("user_id = ? AND litellm_key_id = ?", userID, keyID).First(&dbKey).Error; err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{"error": "Key not found"})
+	if err := h.DB.Where("user_id = ? AND litellm_key_id = ? AND status <> ?", userID, keyID, "superseded").First(&dbKey).Error; err != nil {
+		// Team admins may revoke any key of their team
+		if err := h.DB.Where("litellm_key_id = ? AND team_id <> ? AND status <> ?", keyID, "", "superseded").First(&dbKey).Error; err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Key not found"})
+		}
+		if role, ok := h.teamRole(dbKey.TeamID, userID); !ok || role != "admin" {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Key not found"})
+		}
 	}
 
-	if err := h.LiteLLMService.DeleteKey(keyID); err != nil {
+	// A key LiteLLM no longer knows is already gone; any other failure
+	// leaves it usable, so it stays active here too.
+	err := h.LiteLLMService.DeleteKey(c.Request().Context(), keyID)
+	if err != nil && !errors.Is(err, services.ErrNotFound) {
 		log.Printf("Failed to delete key in LiteLLM: %v", err)
+		return apierror.Upstream(c, err, "Failed to delete key")
 	}
 
+	before := audit.KeyState(&dbKey)
 	dbKey.Status = "revoked"
 	now := time.Now()
 	dbKey.RevokedAt = &now
 	h.DB.Save(&dbKey)
 
+	h.recordAudit(c, audit.Event{
+		UserID:     dbKey.UserID,
+		Action:     "key.revoke",
+		TargetType: "key",
+		TargetID:   keyID,
+		Before:     before,
+		After:      audit.KeyState(&dbKey),
+	})
+
 	return c.JSON(http.StatusOK, map[string]string{"status": "deleted"})
 }
+
+type RenewKeyResponse struct {
+	KeyID             string    `json:"key_id"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	RenewalsRemaining int       `json:"renewals_remaining"`
+}
+
+// RenewKey godoc
+// @Summary Renew an API key
+// @Description Extend a key's expiry by its type's lifetime. Only allowed within the renewal window before expiry, not once the key has expired, and a limited number of times.
+// @Tags keys
+// @Accept json
+// @Produce json
+// @Param key_id path string true "Key ID"
+// @Success 200 {object} RenewKeyResponse
+// @Failure 400 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /keys/{key_id}/renew [post]
+func (h *Handler) RenewKey(c echo.Context) error {
+	keyID := c.Param("key_id")
+	userID := c.Get("user_id").(string)
+
+	var dbKey models.KeyHistory
+	if err := h.DB.Where("user_id = ? AND litellm_key_id = ? AND status = ?", userID, keyID, "active").First(&dbKey).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Key not found"})
+	}
+
+	if dbKey.ExpiresAt == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Key does not expire"})
+	}
+	now := time.Now()
+	if !dbKey.ExpiresAt.After(now) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Key has expired"})
+	}
+	if dbKey.ExpiresAt.Sub(now) > config.AppConfig.KeyRenewalWindow {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Key is not yet within the renewal window"})
+	}
+	// Claim a renewal before extending the key, in one conditional update so
+	// that concurrent renewals cannot exceed the limit, and a key that was
+	// revoked, expired or renewed since it was read is not extended.
+	claim := h.DB.Model(&models.KeyHistory{}).
+		Where("id = ? AND user_id = ? AND status = ? AND expires_at > ? AND expires_at <= ? AND renew_count < ?",
+			dbKey.ID, userID, "active", now, now.Add(config.AppConfig.KeyRenewalWindow), config.AppConfig.MaxKeyRenewals).
+		Update("renew_count", gorm.Expr("renew_count + 1"))
+	if claim.Error != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to renew key"})
+	}
+	if claim.RowsAffected == 0 {
+		if dbKey.RenewCount >= config.AppConfig.MaxKeyRenewals {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Key renewal limit reached"})
+		}
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Key changed during renewal, try again"})
+	}
+
+	lifetime := keyLifetime(dbKey.KeyType)
+	err := h.LiteLLMService.UpdateKey(c.Request().Context(), services.UpdateKeyRequest{
+		Key:      keyID,
+		Duration: lifetime.String(),
+	})
+	if err != nil {
+		log.Printf("Failed to renew key in LiteLLM: %v", err)
+		h.DB.Model(&models.KeyHistory{}).Where("id = ?", dbKey.ID).Update("renew_count", gorm.Expr("renew_count - 1"))
+		return apierror.Upstream(c, err, "Failed to renew key")
+	}
+
+	before := audit.KeyState(&dbKey)
+	expiresAt := time.Now().Add(lifetime)
+	h.DB.Model(&models.KeyHistory{}).Where("id = ?", dbKey.ID).Update("expires_at", expiresAt)
+	h.DB.First(&dbKey, dbKey.ID)
+
+	h.recordAudit(c, audit.Event{Action: "key.renew", TargetType: "key", TargetID: keyID, Before: before, After: audit.KeyState(&dbKey)})
+
+	return c.JSON(http.StatusOK, RenewKeyResponse{
+		KeyID:             dbKey.LiteLLMKeyID,
+		ExpiresAt:         expiresAt,
+		RenewalsRemaining: config.AppConfig.MaxKeyRenewals - dbKey.RenewCount,
+	})
+}
+
+// listOwnerKeys lists the LiteLLM keys of a team when teamID is set, and the
+// user's keys otherwise.
+func (h *Handler) listOwnerKeys(ctx context.Context, userID, teamID string) ([]services.LiteLLMKey, error) {
+	if teamID != "" {
+		return h.LiteLLMService.ListTeamKeys(ctx, teamID)
+	}
+	return h.LiteLLMService.ListKeys(ctx, userID)
+}
+
+func ownsKey(k services.LiteLLMKey, userID, teamID string) bool {
+	if teamID != "" {
+		return k.TeamId == teamID
+	}
+	return k.User == userID
+}
+
+// keyLifetime returns how long a newly created or renewed key of the given
+// type stays valid.
+func keyLifetime(keyType string) time.Duration {
+	if keyType == "long-term" {
+		return config.AppConfig.LongTermKeyLifetime
+	}
+	return config.AppConfig.StandardKeyLifetime
+}
@@ -0,0 +1,113 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+
+	"github.com/example/llmreq/apierror"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+// ModelResponse is a LiteLLM model with its pricing and what the caller may
+// use it through.
+type ModelResponse struct {
+	Model              string  `json:"model"`
+	Mode               string  `json:"mode,omitempty"`
+	InputCostPerToken  float64 `json:"input_cost_per_token"`
+	OutputCostPerToken float64 `json:"output_cost_per_token"`
+	// Per million tokens, for display.
+	InputCostPerMillion  float64 `json:"input_cost_per_million"`
+	OutputCostPerMillion float64 `json:"output_cost_per_million"`
+	ContextWindow        int     `json:"context_window,omitempty"`
+	MaxOutputTokens      int     `json:"max_output_tokens,omitempty"`
+
+	// Available is true when any of the entitlements below allows the model.
+	Available bool `json:"available"`
+	// KeyTypes are the personal key types that may call the model; all of
+	// them inherit the models of the caller's tier.
+	KeyTypes []string `json:"key_types"`
+	// Teams are the caller's teams whose keys may call the model.
+	Teams []string `json:"teams"`
+}
+
+// personalKeyTypes are the key types a user can create for themselves.
+var personalKeyTypes = []string{"standard", "long-term"}
+
+// ListModels godoc
+// @Summary List models
+// @Description List the models LiteLLM serves with their per-token pricing and context window, and whether the caller's tier and teams allow them
+// @Tags models
+// @Accept json
+// @Produce json
+// @Success 200 {array} ModelResponse
+// @Failure 503 {object} map[string]string
+// @Router /models [get]
+func (h *Handler) ListModels(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	ctx := c.Request().Context()
+
+	var user models.User
+	h.DB.Where("id = ?", userID).Limit(1).Find(&user)
+	if user.Instance != "" {
+		ctx = services.WithInstance(ctx, user.Instance)
+	}
+	tier, ok := config.AppConfig.UserTier(user.Tier)
+	if !ok {
+		groups, _ := c.Get("groups").([]string)
+		tier = config.AppConfig.ResolveUserTier(userID, groups)
+	}
+
+	catalog, err := h.Models.Models(ctx)
+	if err != nil {
+		return apierror.Upstream(c, err, "LiteLLM unavailable")
+	}
+
+	// A team LiteLLM cannot tell us about grants nothing, rather than
+	// failing the whole catalog.
+	var memberships []models.TeamMember
+	h.DB.Where("user_id = ?", userID).Order("team_id").Find(&memberships)
+	teamModels := make(map[string][]string)
+	for _, m := range memberships {
+		team, err := h.LiteLLMService.GetTeamInfo(c.Request().Context(), m.TeamID)
+		if err == nil && team != nil {
+			teamModels[m.TeamID] = team.Models
+		}
+	}
+
+	resp := make([]ModelResponse, 0, len(catalog))
+	for _, m := range catalog {
+		model := ModelResponse{
+			Model:                m.ModelName,
+			Mode:                 m.Mode,
+			InputCostPerToken:    m.InputCostPerToken,
+			OutputCostPerToken:   m.OutputCostPerToken,
+			InputCostPerMillion:  perMillion(m.InputCostPerToken),
+			OutputCostPerMillion: perMillion(m.OutputCostPerToken),
+			ContextWindow:        m.MaxInputTokens,
+			MaxOutputTokens:      m.MaxOutputTokens,
+			KeyTypes:             []string{},
+			Teams:                []string{},
+		}
+		if services.AllowsModel(tier.Models, m.ModelName) {
+			model.KeyTypes = personalKeyTypes
+		}
+		for _, membership := range memberships {
+			allowed, ok := teamModels[membership.TeamID]
+			if ok && services.AllowsModel(allowed, m.ModelName) {
+				model.Teams = append(model.Teams, membership.TeamID)
+			}
+		}
+		model.Available = len(model.KeyTypes) > 0 || len(model.Teams) > 0
+		resp = append(resp, model)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+// perMillion converts a per-token cost, rounding away the float error of
+// values like 0.000002.
+func perMillion(costPerToken float64) float64 {
+	return math.Round(costPerToken*1e12) / 1e6
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
+	"github.com/example/llmreq/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestListModels(t *testing.T) {
+	litellm, svc := newFakeLiteLLM(t)
+	litellm.Models = append(litellmfake.DefaultModels, litellmfake.Model{
+		Name:               "gpt-4o",
+		InputCostPerToken:  0.0000025,
+		OutputCostPerToken: 0.00001,
+		MaxInputTokens:     128000,
+	})
+	litellm.AddTeam(litellmfake.Team{ID: "team-1", Models: []string{"gpt-4o"}})
+
+	config.AppConfig = &config.Config{
+		UserTiers: []config.UserTier{
+			{Name: "basic", Models: []string{"fake-gpt-test"}},
+			{Name: "research", Models: []string{"gpt-4o"}, Groups: []string{"ml"}},
+		},
+		DefaultUserTier: "basic",
+	}
+	db := setupTestDB(t)
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "test@example.com", Role: "user"})
+	h := NewHandler(svc, db)
+
+	list := func(userID string, groups ...string) []ModelResponse {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/models", nil), rec)
+		c.Set("user_id", userID)
+		c.Set("groups", groups)
+		if err := h.ListModels(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		var resp []ModelResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		return resp
+	}
+
+	resp := list("test@example.com")
+	if len(resp) != 2 {
+		t.Fatalf("Expected 2 models, got %+v", resp)
+	}
+	fake, gpt := resp[0], resp[1]
+	if fake.Model != "fake-gpt-test" || fake.InputCostPerMillion != 1 || fake.OutputCostPerMillion != 2 || fake.ContextWindow != 16385 || fake.Mode != "chat" {
+		t.Errorf("Unexpected pricing for fake-gpt-test: %+v", fake)
+	}
+	if !fake.Available || len(fake.KeyTypes) != 2 || len(fake.Teams) != 0 {
+		t.Errorf("Expected fake-gpt-test through the tier only, got %+v", fake)
+	}
+	if !gpt.Available || len(gpt.KeyTypes) != 0 || len(gpt.Teams) != 1 || gpt.Teams[0] != "team-1" {
+		t.Errorf("Expected gpt-4o through team-1 only, got %+v", gpt)
+	}
+
+	// Without a team gpt-4o is listed but not available.
+	if resp := list("other@example.com"); resp[1].Available {
+		t.Errorf("Expected gpt-4o to be unavailable, got %+v", resp[1])
+	}
+
+	// A user without a local record gets the tier of their groups.
+	if resp := list("other@example.com", "ml"); !resp[1].Available || len(resp[1].KeyTypes) != 2 || resp[0].Available {
+		t.Errorf("Expected the ml group's tier to allow only gpt-4o, got %+v", resp)
+	}
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+const reconcileRunsDefaultLimit = 20
+
+// ReconcileRunResponse reports one pass of the key reconciler. The counts
+// are key_history rows changed, by kind of change.
+type ReconcileRunResponse struct {
+	ID          uint      `json:"id"`
+	Trigger     string    `json:"trigger"`
+	Status      string    `json:"status"`               // "pending", "running" or "finished"
+	ReportID    *uint     `json:"report_id,omitempty"`  // the drift report applied; omitted for a full run
+	Categories  []string  `json:"categories,omitempty"` // omitted when every category was applied
+	StartedAt   time.Time `json:"started_at"`
+	FinishedAt  time.Time `json:"finished_at"`
+	Users       int       `json:"users"`
+	Teams       int       `json:"teams"`
+	Imported    int       `json:"imported"`
+	Rematched   int       `json:"rematched"`
+	Reactivated int       `json:"reactivated"`
+	Expired     int       `json:"expired"`
+	Revoked     int       `json:"revoked"`
+	Retyped     int       `json:"retyped"`
+	Redated     int       `json:"redated"`
+	Skipped     int       `json:"skipped"` // reviewed changes that no longer applied
+	Failed      int       `json:"failed"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// ApplyReconcileRequest names the drift report whose changes to apply and
+// limits them to some drift categories.
+type ApplyReconcileRequest struct {
+	ReportID   uint     `json:"report_id,omitempty"` // omitted reconciles every user and team
+	Categories []string `json:"categories"`          // empty applies all
+}
+
+// ReconcileReportResponse is a requested drift report. Report is set once
+// Status is "ready", and Error once it is "failed".
+type ReconcileReportResponse struct {
+	ID          uint                  `json:"id"`
+	Status      string                `json:"status"`
+	RequestedAt time.Time             `json:"requested_at"`
+	FinishedAt  *time.Time            `json:"finished_at,omitempty"`
+	Error       string                `json:"error,omitempty"`
+	Report      *services.DriftReport `json:"report,omitempty"`
+}
+
+func reconcileReportResponse(record *models.ReconcileReport) ReconcileReportResponse {
+	resp := ReconcileReportResponse{
+		ID:          record.ID,
+		Status:      record.Status,
+		RequestedAt: record.CreatedAt,
+		FinishedAt:  record.FinishedAt,
+		Error:       record.Error,
+	}
+	if record.Report != "" {
+		var report services.DriftReport
+		if err := json.Unmarshal([]byte(record.Report), &report); err == nil {
+			resp.Report = &report
+		}
+	}
+	return resp
+}
+
+func reconcileRunResponse(run *models.ReconcileRun) ReconcileRunResponse {
+	var categories []string
+	if run.Categories != "" {
+		categories = strings.Split(run.Categories, ",")
+	}
+	return ReconcileRunResponse{
+		ID:          run.ID,
+		Trigger:     run.Trigger,
+		Status:      run.Status,
+		ReportID:    run.ReportID,
+		Categories:  categories,
+		StartedAt:   run.StartedAt,
+		FinishedAt:  run.FinishedAt,
+		Users:       run.Users,
+		Teams:       run.Teams,
+		Imported:    run.Imported,
+		Rematched:   run.Rematched,
+		Reactivated: run.Reactivated,
+		Expired:     run.Expired,
+		Revoked:     run.Revoked,
+		Retyped:     run.Retyped,
+		Redated:     run.Redated,
+		Skipped:     run.Skipped,
+		Failed:      run.Failed,
+		Error:       run.Error,
+	}
+}
+
+// RequestReconcileReport godoc
+// @Summary Request a key drift report
+// @Description Queue a dry run of the key reconciler, which lists the differences between LiteLLM and the local key history, by category, and the change that would resolve each. Nothing is changed; fetch the report with GET /admin/reconcile and apply it with POST /admin/reconcile (admin only)
+// @Tags admin
+// @Produce json
+// @Success 202 {object} ReconcileReportResponse
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/reconcile/reports [post]
+func (h *Handler) RequestReconcileReport(c echo.Context) error {
+	report, err := h.Reconciler.QueueReport()
+	if err != nil {
+		log.Printf("Failed to queue key drift report: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to request key drift report"})
+	}
+	return c.JSON(http.StatusAccepted, reconcileReportResponse(report))
+}
+
+// GetReconcileDrift godoc
+// @Summary Get a key drift report
+// @Description Fetch a requested key drift report, or the latest one. It is returned with 202 while still being worked out (admin only)
+// @Tags admin
+// @Produce json
+// @Param dry_run query bool true "Must be true"
+// @Param report_id query int false "Report ID; the latest by default"
+// @Success 200 {object} ReconcileReportResponse
+// @Success 202 {object} ReconcileReportResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /admin/reconcile [get]
+func (h *Handler) GetReconcileDrift(c echo.Context) error {
+	if c.QueryParam("dry_run") != "true" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Only dry_run=true is supported; apply with POST"})
+	}
+
+	query := h.DB.Order("id DESC")
+	if v := c.QueryParam("report_id"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid report_id parameter"})
+		}
+		query = query.Where("id = ?", id)
+	}
+	var report models.ReconcileReport
+	if err := query.First(&report).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Drift report not found"})
+	}
+
+	status := http.StatusOK
+	if report.Status == "pending" {
+		status = http.StatusAccepted
+	}
+	return c.JSON(status, reconcileReportResponse(&report))
+}
+
+// RunReconcile godoc
+// @Summary Reconcile keys now
+// @Description Queue a run of the key reconciler without waiting for the next scheduled one, optionally only for some drift categories. With a report_id only the changes of that drift report are made, and only those that still apply; otherwise every user and team is reconciled. Follow the run with GET /admin/reconcile/runs (admin only)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Param request body ApplyReconcileRequest false "Drift report and categories to apply"
+// @Success 202 {object} ReconcileRunResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 409 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/reconcile [post]
+func (h *Handler) RunReconcile(c echo.Context) error {
+	var req ApplyReconcileRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	for _, category := range req.Categories {
+		if !slices.Contains(services.DriftCategories, category) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Unknown drift category: " + category})
+		}
+	}
+
+	run, err := h.Reconciler.QueueRun(req.ReportID, req.Categories)
+	if errors.Is(err, services.ErrReportNotFound) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Drift report not found"})
+	}
+	if errors.Is(err, services.ErrReportNotReady) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Drift report is not ready"})
+	}
+	if err != nil {
+		log.Printf("Failed to queue key reconciliation: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to reconcile keys"})
+	}
+
+	h.recordAudit(c, audit.Event{
+		Action:     "keys.reconcile",
+		TargetType: "reconcile_run",
+		TargetID:   strconv.FormatUint(uint64(run.ID), 10),
+		After:      reconcileRunResponse(run),
+	})
+	return c.JSON(http.StatusAccepted, reconcileRunResponse(run))
+}
+
+// ListReconcileRuns godoc
+// @Summary List key reconciler runs
+// @Description List the most recent runs of the key reconciler with their counts of changes, newest first (admin only)
+// @Tags admin
+// @Produce json
+// @Param limit query int false "Number of runs" default(20)
+// @Success 200 {array} ReconcileRunResponse
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /admin/reconcile/runs [get]
+func (h *Handler) ListReconcileRuns(c echo.Context) error {
+	limit := reconcileRunsDefaultLimit
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid limit parameter"})
+		}
+		limit = n
+	}
+
+	var runs []models.ReconcileRun
+	if err := h.DB.Order("id DESC").Limit(limit).Find(&runs).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch reconciler runs"})
+	}
+
+	resp := make([]ReconcileRunResponse, 0, len(runs))
+	for i := range runs {
+		resp = append(resp, reconcileRunResponse(&runs[i]))
+	}
+	return c.JSON(http.StatusOK, resp)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
+	"github.com/example/llmreq/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestRunReconcile(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	_, svc := newFakeLiteLLM(t)
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+	db.Create(&models.KeyHistory{UserID: "test@example.com", LiteLLMKeyID: "sk-gone", Status: "active"})
+
+	e := echo.New()
+	post := func(body string) (*httptest.ResponseRecorder, ReconcileRunResponse) {
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/reconcile", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		if err := h.RunReconcile(e.NewContext(req, rec)); err != nil {
+			t.Fatal(err)
+		}
+		var run ReconcileRunResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &run)
+		h.Reconciler.ProcessPending(context.Background())
+		return rec, run
+	}
+	listRuns := func() []ReconcileRunResponse {
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/api/admin/reconcile/runs?limit=1", nil), rec)
+		if err := h.ListReconcileRuns(c); err != nil {
+			t.Fatal(err)
+		}
+		var runs []ReconcileRunResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &runs)
+		return runs
+	}
+
+	if rec, _ := post(`{"categories": ["bogus"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected an unknown category to be rejected, got %d", rec.Code)
+	}
+	if rec, _ := post(`{"report_id": 99}`); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected an unknown report to be rejected, got %d", rec.Code)
+	}
+	if rec, _ := post(`{"categories": ["unknown_upstream"]}`); rec.Code != http.StatusAccepted {
+		t.Errorf("Expected the run to be queued, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if runs := listRuns(); len(runs) != 1 || runs[0].Status != "finished" || runs[0].Revoked != 0 || len(runs[0].Categories) != 1 {
+		t.Errorf("Expected nothing to be revoked, got %+v", runs)
+	}
+	rec, run := post("")
+	if rec.Code != http.StatusAccepted || run.Trigger != "manual" || run.Status != "pending" || run.Categories != nil {
+		t.Errorf("Expected a pending manual run, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if runs := listRuns(); len(runs) != 1 || runs[0].ID != run.ID || runs[0].Users != 1 || runs[0].Revoked != 1 {
+		t.Errorf("Expected one revocation, got %+v", runs)
+	}
+}
+
+func TestGetReconcileDrift(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	fake, svc := newFakeLiteLLM(t)
+	fake.AddKey(litellmfake.Key{UserID: "test@example.com", KeyAlias: "laptop"})
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+	db.Create(&models.KeyHistory{UserID: "test@example.com", LiteLLMKeyID: "sk-gone", Status: "active"})
+
+	e := echo.New()
+	get := func(target string) (*httptest.ResponseRecorder, ReconcileReportResponse) {
+		rec := httptest.NewRecorder()
+		if err := h.GetReconcileDrift(e.NewContext(httptest.NewRequest(http.MethodGet, target, nil), rec)); err != nil {
+			t.Fatal(err)
+		}
+		var report ReconcileReportResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &report)
+		return rec, report
+	}
+
+	if rec, _ := get("/api/admin/reconcile"); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected dry_run to be required, got %d", rec.Code)
+	}
+	if rec, _ := get("/api/admin/reconcile?dry_run=true"); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected no report yet, got %d", rec.Code)
+	}
+
+	rec := httptest.NewRecorder()
+	if err := h.RequestReconcileReport(e.NewContext(httptest.NewRequest(http.MethodPost, "/api/admin/reconcile/reports", nil), rec)); err != nil {
+		t.Fatal(err)
+	}
+	var queued ReconcileReportResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &queued)
+	if rec.Code != http.StatusAccepted || queued.Status != "pending" {
+		t.Fatalf("Expected the report to be queued, got %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec, _ := get("/api/admin/reconcile?dry_run=true"); rec.Code != http.StatusAccepted {
+		t.Errorf("Expected the pending report, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/reconcile", strings.NewReader(`{"report_id": `+strconv.FormatUint(uint64(queued.ID), 10)+`}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec = httptest.NewRecorder()
+	if err := h.RunReconcile(e.NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected a pending report not to be applied, got %d", rec.Code)
+	}
+
+	h.Reconciler.ProcessPending(context.Background())
+	rec, report := get("/api/admin/reconcile?dry_run=true&report_id=" + strconv.FormatUint(uint64(queued.ID), 10))
+	if rec.Code != http.StatusOK || report.Status != "ready" || report.Report == nil ||
+		report.Report.Counts["unknown_upstream"] != 1 || report.Report.Counts["missing_upstream"] != 1 || len(report.Report.Items) != 2 {
+		t.Errorf("Expected an import and a revocation, got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var count int64
+	if db.Model(&models.KeyHistory{}).Where("status = ?", "active").Count(&count); count != 1 {
+		t.Errorf("Expected the dry run to change nothing, got %d active rows", count)
+	}
+}
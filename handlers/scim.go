@@ -0,0 +1,828 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/example/llmreq/apierror"
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+const (
+	scimUserSchema     = "urn:ietf:params:scim:schemas:core:2.0:User"
+	scimGroupSchema    = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	scimListSchema     = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	scimErrorSchema    = "urn:ietf:params:scim:api:messages:2.0:Error"
+	scimContentType    = "application/scim+json"
+	scimDefaultPerPage = 100
+)
+
+// ScimUser is the SCIM representation of a user. The SCIM id is the
+// lower-cased email, the same value used as the LiteLLM user_id.
+type ScimUser struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id,omitempty"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Active     *bool       `json:"active,omitempty"`
+	Emails     []ScimValue `json:"emails,omitempty"`
+	Groups     []ScimValue `json:"groups,omitempty"`
+	Meta       *ScimMeta   `json:"meta,omitempty"`
+}
+
+type ScimGroup struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  string      `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []ScimValue `json:"members"`
+	Meta        *ScimMeta   `json:"meta,omitempty"`
+}
+
+type ScimValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type ScimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+type ScimListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type ScimPatchRequest struct {
+	Schemas    []string      `json:"schemas"`
+	Operations []ScimPatchOp `json:"Operations"`
+}
+
+type ScimPatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+var (
+	scimFilterPattern       = regexp.MustCompile(`^(\w+)\s+(?i:eq)\s+"([^"]*)"$`)
+	scimMemberFilterPattern = regexp.MustCompile(`^members\[value\s+(?i:eq)\s+"([^"]*)"\]$`)
+)
+
+// ListScimUsers godoc
+// @Summary List SCIM users
+// @Description List users, optionally filtered with userName eq "..." or externalId eq "..."
+// @Tags scim
+// @Produce json
+// @Param filter query string false "SCIM filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size"
+// @Success 200 {object} ScimListResponse
+// @Failure 400 {object} map[string]interface{}
+// @Failure 401 {object} map[string]interface{}
+// @Router /scim/v2/Users [get]
+func (h *Handler) ListScimUsers(c echo.Context) error {
+	query := h.DB.Model(&models.User{})
+	if filter := c.QueryParam("filter"); filter != "" {
+		attr, value, ok := parseScimFilter(filter)
+		switch {
+		case ok && strings.EqualFold(attr, "userName"):
+			query = query.Where("id = ?", strings.ToLower(value))
+		case ok && strings.EqualFold(attr, "externalId"):
+			query = query.Where("external_id = ?", value)
+		default:
+			return scimError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter")
+		}
+	}
+
+	var users []models.User
+	total, startIndex, err := scimPage(c, query.Order("id"), &users)
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "Failed to list users")
+	}
+
+	resources := make([]interface{}, 0, len(users))
+	for i := range users {
+		resources = append(resources, h.scimUser(&users[i]))
+	}
+	return scimJSON(c, http.StatusOK, ScimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// GetScimUser godoc
+// @Summary Get a SCIM user
+// @Tags scim
+// @Produce json
+// @Param id path string true "User ID"
+// @Success 200 {object} ScimUser
+// @Failure 404 {object} map[string]interface{}
+// @Router /scim/v2/Users/{id} [get]
+func (h *Handler) GetScimUser(c echo.Context) error {
+	user, err := h.findScimUser(c.Param("id"))
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "", "User not found")
+	}
+	return scimJSON(c, http.StatusOK, h.scimUser(user))
+}
+
+// CreateScimUser godoc
+// @Summary Provision a user over SCIM
+// @Description Create the user locally and in LiteLLM ahead of their first login. A user who already logged in is linked instead.
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param request body ScimUser true "User"
+// @Success 201 {object} ScimUser
+// @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /scim/v2/Users [post]
+func (h *Handler) CreateScimUser(c echo.Context) error {
+	var req ScimUser
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return scimError(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+	}
+	userID := scimUserID(req)
+	if userID == "" {
+		return scimError(c, http.StatusBadRequest, "invalidValue", "userName or a primary email address is required")
+	}
+
+	var user models.User
+	if err := h.DB.Where("id = ?", userID).Limit(1).Find(&user).Error; err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "Failed to load user")
+	}
+	if user.ID != "" && user.ExternalID != "" {
+		return scimError(c, http.StatusConflict, "uniqueness", "User already exists")
+	}
+
+	lifecycle := services.NewUserLifecycle(h.LiteLLMService, h.DB)
+	if user.ID == "" {
+		groups, _ := models.ScimGroupNames(h.DB, userID)
+		tier := config.AppConfig.ResolveUserTier(userID, groups)
+		instance := services.StoredInstance(config.AppConfig.ResolveInstance(config.Route{Email: userID, Groups: groups}))
+		user = models.User{ID: userID, Email: userID, Tier: tier.Name, TierSource: "auto", Status: "active", Instance: instance}
+		if err := lifecycle.Provision(c.Request().Context(), &user); err != nil {
+			log.Printf("Failed to provision SCIM user %s: %v", userID, err)
+			return scimUpstreamError(c, err, "Failed to create user in LiteLLM")
+		}
+		if err := h.DB.Create(&user).Error; err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "Failed to record user")
+		}
+	}
+
+	user.ExternalID = req.ExternalID
+	h.DB.Model(&user).Update("external_id", user.ExternalID)
+
+	if err := setScimUserActive(c.Request().Context(), lifecycle, &user, req.Active == nil || *req.Active); err != nil {
+		return scimUpstreamError(c, err, "Failed to update user in LiteLLM")
+	}
+
+	h.auditScim(c, "scim.user_create", user.ID, "user", user.ID, nil, audit.UserState(&user))
+	return scimJSON(c, http.StatusCreated, h.scimUser(&user))
+}
+
+// ReplaceScimUser godoc
+// @Summary Replace a SCIM user
+// @Description Update externalId and active; without active the status is left unchanged. Deactivating zeroes the user's budget and model access in LiteLLM and revokes all their keys
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body ScimUser true "User"
+// @Success 200 {object} ScimUser
+// @Failure 404 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /scim/v2/Users/{id} [put]
+func (h *Handler) ReplaceScimUser(c echo.Context) error {
+	user, err := h.findScimUser(c.Param("id"))
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "", "User not found")
+	}
+
+	var req ScimUser
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return scimError(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+	}
+
+	before := audit.UserState(user)
+	user.ExternalID = req.ExternalID
+	h.DB.Model(user).Update("external_id", user.ExternalID)
+
+	// Without active the client is not asking for a status change.
+	if req.Active != nil {
+		lifecycle := services.NewUserLifecycle(h.LiteLLMService, h.DB)
+		if err := setScimUserActive(c.Request().Context(), lifecycle, user, *req.Active); err != nil {
+			return scimUpstreamError(c, err, "Failed to update user in LiteLLM")
+		}
+	}
+
+	h.auditScim(c, "scim.user_update", user.ID, "user", user.ID, before, audit.UserState(user))
+	return scimJSON(c, http.StatusOK, h.scimUser(user))
+}
+
+// PatchScimUser godoc
+// @Summary Patch a SCIM user
+// @Description Supports replacing active and externalId
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "User ID"
+// @Param request body ScimPatchRequest true "Patch operations"
+// @Success 200 {object} ScimUser
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /scim/v2/Users/{id} [patch]
+func (h *Handler) PatchScimUser(c echo.Context) error {
+	user, err := h.findScimUser(c.Param("id"))
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "", "User not found")
+	}
+
+	var req ScimPatchRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return scimError(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+	}
+
+	before := audit.UserState(user)
+	active := user.Status != "deactivated"
+	for _, op := range req.Operations {
+		if !strings.EqualFold(op.Op, "replace") && !strings.EqualFold(op.Op, "add") {
+			continue
+		}
+
+		values := map[string]json.RawMessage{}
+		if op.Path == "" {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return scimError(c, http.StatusBadRequest, "invalidValue", "Invalid patch value")
+			}
+		} else {
+			values[op.Path] = op.Value
+		}
+
+		for path, value := range values {
+			switch strings.ToLower(path) {
+			case "active":
+				parsed, ok := scimBool(value)
+				if !ok {
+					return scimError(c, http.StatusBadRequest, "invalidValue", "active must be a boolean")
+				}
+				active = parsed
+			case "externalid":
+				var externalID string
+				if err := json.Unmarshal(value, &externalID); err == nil {
+					user.ExternalID = externalID
+					h.DB.Model(user).Update("external_id", externalID)
+				}
+			}
+		}
+	}
+
+	lifecycle := services.NewUserLifecycle(h.LiteLLMService, h.DB)
+	if err := setScimUserActive(c.Request().Context(), lifecycle, user, active); err != nil {
+		return scimUpstreamError(c, err, "Failed to update user in LiteLLM")
+	}
+
+	h.auditScim(c, "scim.user_update", user.ID, "user", user.ID, before, audit.UserState(user))
+	return scimJSON(c, http.StatusOK, h.scimUser(user))
+}
+
+// DeleteScimUser godoc
+// @Summary Deprovision a SCIM user
+// @Description Deactivates the user. The local record is kept, inactive, so that key history survives.
+// @Tags scim
+// @Param id path string true "User ID"
+// @Success 204
+// @Failure 404 {object} map[string]interface{}
+// @Failure 503 {object} map[string]interface{}
+// @Router /scim/v2/Users/{id} [delete]
+func (h *Handler) DeleteScimUser(c echo.Context) error {
+	user, err := h.findScimUser(c.Param("id"))
+	if err != nil {
+		return scimError(c, http.StatusNotFound, "", "User not found")
+	}
+
+	before := audit.UserState(user)
+	lifecycle := services.NewUserLifecycle(h.LiteLLMService, h.DB)
+	if err := setScimUserActive(c.Request().Context(), lifecycle, user, false); err != nil {
+		return scimUpstreamError(c, err, "Failed to update user in LiteLLM")
+	}
+	h.DB.Where("user_id = ?", user.ID).Delete(&models.ScimGroupMember{})
+
+	h.auditScim(c, "scim.user_delete", user.ID, "user", user.ID, before, audit.UserState(user))
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListScimGroups godoc
+// @Summary List SCIM groups
+// @Description List groups, optionally filtered with displayName eq "..." or externalId eq "..."
+// @Tags scim
+// @Produce json
+// @Param filter query string false "SCIM filter"
+// @Param startIndex query int false "1-based index of the first result"
+// @Param count query int false "Page size"
+// @Success 200 {object} ScimListResponse
+// @Failure 400 {object} map[string]interface{}
+// @Router /scim/v2/Groups [get]
+func (h *Handler) ListScimGroups(c echo.Context) error {
+	query := h.DB.Model(&models.ScimGroup{})
+	if filter := c.QueryParam("filter"); filter != "" {
+		attr, value, ok := parseScimFilter(filter)
+		switch {
+		case ok && strings.EqualFold(attr, "displayName"):
+			query = query.Where("display_name = ?", value)
+		case ok && strings.EqualFold(attr, "externalId"):
+			query = query.Where("external_id = ?", value)
+		default:
+			return scimError(c, http.StatusBadRequest, "invalidFilter", "Unsupported filter")
+		}
+	}
+
+	var groups []models.ScimGroup
+	total, startIndex, err := scimPage(c, query.Order("display_name"), &groups)
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "Failed to list groups")
+	}
+
+	resources := make([]interface{}, 0, len(groups))
+	for i := range groups {
+		resources = append(resources, h.scimGroup(&groups[i]))
+	}
+	return scimJSON(c, http.StatusOK, ScimListResponse{
+		Schemas:      []string{scimListSchema},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// GetScimGroup godoc
+// @Summary Get a SCIM group
+// @Tags scim
+// @Produce json
+// @Param id path string true "Group ID"
+// @Success 200 {object} ScimGroup
+// @Failure 404 {object} map[string]interface{}
+// @Router /scim/v2/Groups/{id} [get]
+func (h *Handler) GetScimGroup(c echo.Context) error {
+	var group models.ScimGroup
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&group).Error; err != nil {
+		return scimError(c, http.StatusNotFound, "", "Group not found")
+	}
+	return scimJSON(c, http.StatusOK, h.scimGroup(&group))
+}
+
+// CreateScimGroup godoc
+// @Summary Create a SCIM group
+// @Description Group names count like X-Forwarded-Groups values for tier and team mapping
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param request body ScimGroup true "Group"
+// @Success 201 {object} ScimGroup
+// @Failure 400 {object} map[string]interface{}
+// @Failure 409 {object} map[string]interface{}
+// @Router /scim/v2/Groups [post]
+func (h *Handler) CreateScimGroup(c echo.Context) error {
+	var req ScimGroup
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.DisplayName == "" {
+		return scimError(c, http.StatusBadRequest, "invalidValue", "displayName is required")
+	}
+
+	var count int64
+	h.DB.Model(&models.ScimGroup{}).Where("display_name = ?", req.DisplayName).Count(&count)
+	if count > 0 {
+		return scimError(c, http.StatusConflict, "uniqueness", "Group already exists")
+	}
+
+	group := models.ScimGroup{ID: newScimID(), DisplayName: req.DisplayName, ExternalID: req.ExternalID}
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		return setScimGroupMembers(tx, group.ID, req.Members)
+	})
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "Failed to create group")
+	}
+
+	resp := h.scimGroup(&group)
+	h.auditScim(c, "scim.group_create", "", "group", group.ID, nil, resp)
+	return scimJSON(c, http.StatusCreated, resp)
+}
+
+// ReplaceScimGroup godoc
+// @Summary Replace a SCIM group
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param request body ScimGroup true "Group"
+// @Success 200 {object} ScimGroup
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /scim/v2/Groups/{id} [put]
+func (h *Handler) ReplaceScimGroup(c echo.Context) error {
+	var group models.ScimGroup
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&group).Error; err != nil {
+		return scimError(c, http.StatusNotFound, "", "Group not found")
+	}
+
+	var req ScimGroup
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil || req.DisplayName == "" {
+		return scimError(c, http.StatusBadRequest, "invalidValue", "displayName is required")
+	}
+
+	before := h.scimGroup(&group)
+	group.DisplayName = req.DisplayName
+	group.ExternalID = req.ExternalID
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&group).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&models.ScimGroupMember{}).Error; err != nil {
+			return err
+		}
+		return setScimGroupMembers(tx, group.ID, req.Members)
+	})
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "Failed to update group")
+	}
+
+	resp := h.scimGroup(&group)
+	h.auditScim(c, "scim.group_update", "", "group", group.ID, before, resp)
+	return scimJSON(c, http.StatusOK, resp)
+}
+
+// PatchScimGroup godoc
+// @Summary Patch a SCIM group
+// @Description Supports adding, removing and replacing members and replacing displayName
+// @Tags scim
+// @Accept json
+// @Produce json
+// @Param id path string true "Group ID"
+// @Param request body ScimPatchRequest true "Patch operations"
+// @Success 200 {object} ScimGroup
+// @Failure 400 {object} map[string]interface{}
+// @Failure 404 {object} map[string]interface{}
+// @Router /scim/v2/Groups/{id} [patch]
+func (h *Handler) PatchScimGroup(c echo.Context) error {
+	var group models.ScimGroup
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&group).Error; err != nil {
+		return scimError(c, http.StatusNotFound, "", "Group not found")
+	}
+
+	var req ScimPatchRequest
+	if err := json.NewDecoder(c.Request().Body).Decode(&req); err != nil {
+		return scimError(c, http.StatusBadRequest, "invalidSyntax", "Invalid request body")
+	}
+
+	before := h.scimGroup(&group)
+	err := h.DB.Transaction(func(tx *gorm.DB) error {
+		for _, op := range req.Operations {
+			if err := patchScimGroup(tx, &group, op); err != nil {
+				return err
+			}
+		}
+		return tx.Save(&group).Error
+	})
+	if err != nil {
+		if scimErr, ok := err.(scimPatchError); ok {
+			return scimError(c, http.StatusBadRequest, "invalidValue", string(scimErr))
+		}
+		return scimError(c, http.StatusInternalServerError, "", "Failed to update group")
+	}
+
+	resp := h.scimGroup(&group)
+	h.auditScim(c, "scim.group_update", "", "group", group.ID, before, resp)
+	return scimJSON(c, http.StatusOK, resp)
+}
+
+// DeleteScimGroup godoc
+// @Summary Delete a SCIM group
+// @Tags scim
+// @Param id path string true "Group ID"
+// @Success 204
+// @Failure 404 {object} map[string]interface{}
+// @Router /scim/v2/Groups/{id} [delete]
+func (h *Handler) DeleteScimGroup(c echo.Context) error {
+	var group models.ScimGroup
+	if err := h.DB.Where("id = ?", c.Param("id")).First(&group).Error; err != nil {
+		return scimError(c, http.StatusNotFound, "", "Group not found")
+	}
+
+	before := h.scimGroup(&group)
+	h.DB.Where("group_id = ?", group.ID).Delete(&models.ScimGroupMember{})
+	h.DB.Delete(&group)
+
+	h.auditScim(c, "scim.group_delete", "", "group", group.ID, before, nil)
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type scimPatchError string
+
+func (e scimPatchError) Error() string { return string(e) }
+
+func patchScimGroup(tx *gorm.DB, group *models.ScimGroup, op ScimPatchOp) error {
+	path := op.Path
+	if m := scimMemberFilterPattern.FindStringSubmatch(path); m != nil {
+		if !strings.EqualFold(op.Op, "remove") {
+			return scimPatchError("Only remove is supported on a member filter")
+		}
+		return tx.Where("group_id = ? AND user_id = ?", group.ID, strings.ToLower(m[1])).Delete(&models.ScimGroupMember{}).Error
+	}
+
+	values := map[string]json.RawMessage{}
+	if path == "" {
+		if len(op.Value) > 0 {
+			if err := json.Unmarshal(op.Value, &values); err != nil {
+				return scimPatchError("Invalid patch value")
+			}
+		}
+	} else {
+		values[path] = op.Value
+	}
+
+	for attr, value := range values {
+		switch strings.ToLower(attr) {
+		case "displayname":
+			if err := json.Unmarshal(value, &group.DisplayName); err != nil || group.DisplayName == "" {
+				return scimPatchError("displayName must be a non-empty string")
+			}
+		case "externalid":
+			_ = json.Unmarshal(value, &group.ExternalID)
+		case "members":
+			var members []ScimValue
+			if len(value) > 0 {
+				if err := json.Unmarshal(value, &members); err != nil {
+					return scimPatchError("members must be a list")
+				}
+			}
+			switch strings.ToLower(op.Op) {
+			case "add":
+				if err := setScimGroupMembers(tx, group.ID, members); err != nil {
+					return err
+				}
+			case "remove":
+				query := tx.Where("group_id = ?", group.ID)
+				if len(members) > 0 {
+					ids := make([]string, 0, len(members))
+					for _, m := range members {
+						ids = append(ids, strings.ToLower(m.Value))
+					}
+					query = query.Where("user_id IN ?", ids)
+				}
+				if err := query.Delete(&models.ScimGroupMember{}).Error; err != nil {
+					return err
+				}
+			case "replace":
+				if err := tx.Where("group_id = ?", group.ID).Delete(&models.ScimGroupMember{}).Error; err != nil {
+					return err
+				}
+				if err := setScimGroupMembers(tx, group.ID, members); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// setScimGroupMembers adds members to a group, ignoring ones already in it.
+// Members may reference users who have not been provisioned yet.
+func setScimGroupMembers(tx *gorm.DB, groupID string, members []ScimValue) error {
+	for _, m := range members {
+		if m.Value == "" {
+			continue
+		}
+		member := models.ScimGroupMember{GroupID: groupID, UserID: strings.ToLower(m.Value)}
+		if err := tx.Where(member).FirstOrCreate(&member).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setScimUserActive applies an active flag from the identity provider,
+// deactivating or reactivating the user only when it changes.
+func setScimUserActive(ctx context.Context, lifecycle *services.UserLifecycle, user *models.User, active bool) error {
+	if active == (user.Status != "deactivated") {
+		return nil
+	}
+
+	var err error
+	if active {
+		err = lifecycle.Reactivate(ctx, user)
+	} else {
+		err = lifecycle.Deactivate(ctx, user)
+	}
+	if err != nil {
+		log.Printf("Failed to set active=%t for %s: %v", active, user.ID, err)
+	}
+	return err
+}
+
+func (h *Handler) findScimUser(id string) (*models.User, error) {
+	var user models.User
+	if err := h.DB.Where("id = ?", strings.ToLower(id)).First(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (h *Handler) scimUser(user *models.User) ScimUser {
+	active := user.Status != "deactivated"
+	resp := ScimUser{
+		Schemas:    []string{scimUserSchema},
+		ID:         user.ID,
+		ExternalID: user.ExternalID,
+		UserName:   user.ID,
+		Active:     &active,
+		Emails:     []ScimValue{{Value: user.Email, Primary: true}},
+		Groups:     []ScimValue{},
+		Meta: &ScimMeta{
+			ResourceType: "User",
+			Created:      user.CreatedAt,
+			LastModified: user.UpdatedAt,
+			Location:     "/scim/v2/Users/" + user.ID,
+		},
+	}
+
+	var groups []models.ScimGroup
+	h.DB.Joins("JOIN scim_group_members ON scim_group_members.group_id = scim_groups.id").
+		Where("scim_group_members.user_id = ?", user.ID).
+		Order("scim_groups.display_name").
+		Find(&groups)
+	for _, g := range groups {
+		resp.Groups = append(resp.Groups, ScimValue{Value: g.ID, Display: g.DisplayName})
+	}
+	return resp
+}
+
+func (h *Handler) scimGroup(group *models.ScimGroup) ScimGroup {
+	resp := ScimGroup{
+		Schemas:     []string{scimGroupSchema},
+		ID:          group.ID,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     []ScimValue{},
+		Meta: &ScimMeta{
+			ResourceType: "Group",
+			Created:      group.CreatedAt,
+			LastModified: group.UpdatedAt,
+			Location:     "/scim/v2/Groups/" + group.ID,
+		},
+	}
+
+	var members []models.ScimGroupMember
+	h.DB.Where("group_id = ?", group.ID).Order("user_id").Find(&members)
+	for _, m := range members {
+		resp.Members = append(resp.Members, ScimValue{Value: m.UserID, Display: m.UserID})
+	}
+	return resp
+}
+
+// scimUserID derives the user ID from userName, falling back to the primary
+// email when userName is not an email address.
+func scimUserID(req ScimUser) string {
+	if strings.Contains(req.UserName, "@") {
+		return strings.ToLower(req.UserName)
+	}
+	for _, e := range req.Emails {
+		if e.Primary && e.Value != "" {
+			return strings.ToLower(e.Value)
+		}
+	}
+	if len(req.Emails) > 0 {
+		return strings.ToLower(req.Emails[0].Value)
+	}
+	return ""
+}
+
+// parseScimFilter understands the single `attribute eq "value"` form that
+// identity providers use to look up resources.
+func parseScimFilter(filter string) (string, string, bool) {
+	m := scimFilterPattern.FindStringSubmatch(strings.TrimSpace(filter))
+	if m == nil {
+		return "", "", false
+	}
+	return m[1], m[2], true
+}
+
+// scimPage applies startIndex and count to query and returns the total
+// number of matches along with the effective start index.
+func scimPage(c echo.Context, query *gorm.DB, dest interface{}) (int, int, error) {
+	startIndex, _ := strconv.Atoi(c.QueryParam("startIndex"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.QueryParam("count"))
+	if err != nil || count < 0 {
+		count = scimDefaultPerPage
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return 0, 0, err
+	}
+	if err := query.Offset(startIndex - 1).Limit(count).Find(dest).Error; err != nil {
+		return 0, 0, err
+	}
+	return int(total), startIndex, nil
+}
+
+// scimBool accepts JSON booleans as well as the "True"/"False" strings some
+// identity providers send.
+func scimBool(value json.RawMessage) (bool, bool) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, true
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		if parsed, err := strconv.ParseBool(s); err == nil {
+			return parsed, true
+		}
+	}
+	return false, false
+}
+
+func newScimID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// auditScim records a change made by the identity provider. SCIM requests
+// carry no user, so the actor is "scim".
+func (h *Handler) auditScim(c echo.Context, action, userID, targetType, targetID string, before, after interface{}) {
+	h.recordAudit(c, audit.Event{
+		Actor:      "scim",
+		UserID:     userID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+	})
+}
+
+func scimJSON(c echo.Context, status int, v interface{}) error {
+	c.Response().Header().Set(echo.HeaderContentType, scimContentType)
+	return c.JSON(status, v)
+}
+
+func scimError(c echo.Context, status int, scimType, detail string) error {
+	body := map[string]interface{}{
+		"schemas": []string{scimErrorSchema},
+		"status":  fmt.Sprintf("%d", status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	return scimJSON(c, status, body)
+}
+
+// scimUpstreamError is upstreamError for SCIM clients.
+func scimUpstreamError(c echo.Context, err error, detail string) error {
+	apierror.SetRetryAfter(c, err)
+	status := services.ResponseStatus(err)
+	scimType := ""
+	if status == http.StatusBadRequest {
+		scimType = "invalidValue"
+		if msg := apierror.Detail(err); msg != "" {
+			detail += ": " + msg
+		}
+	}
+	return scimError(c, status, scimType, detail)
+}
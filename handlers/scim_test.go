@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+func scimRequest(t *testing.T, handler echo.HandlerFunc, method, target, id, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	e := echo.New()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", scimContentType)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	if id != "" {
+		c.SetParamNames("id")
+		c.SetParamValues(id)
+	}
+	if err := handler(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestScimUserLifecycle(t *testing.T) {
+	var createdUsers []services.NewUserRequest
+	var updates []map[string]interface{}
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/user/info/"):
+			w.WriteHeader(http.StatusNotFound)
+		case r.URL.Path == "/user/new":
+			var req services.NewUserRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			createdUsers = append(createdUsers, req)
+		case r.URL.Path == "/user/update":
+			var req map[string]interface{}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			updates = append(updates, req)
+		case r.URL.Path == "/key/list":
+			_, _ = w.Write([]byte(`{"keys": [{"key": "sk-personal", "user_id": "new@example.com"}]}`))
+		case r.URL.Path == "/key/delete":
+			var req services.DeleteKeyRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			deleted = append(deleted, req.Keys...)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{
+		UserTiers:       []config.UserTier{{Name: "basic", MaxBudget: 5}},
+		DefaultUserTier: "basic",
+	}
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+
+	rec := scimRequest(t, h.CreateScimUser, http.MethodPost, "/scim/v2/Users", "",
+		`{"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"], "userName": "New@example.com", "externalId": "ext-1", "active": true}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if len(createdUsers) != 1 || createdUsers[0].UserID != "new@example.com" || createdUsers[0].MaxBudget != 5 {
+		t.Errorf("Expected user provisioned in LiteLLM with tier limits, got %+v", createdUsers)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, scimContentType) {
+		t.Errorf("Expected SCIM content type, got %s", ct)
+	}
+
+	// A second create for the same user conflicts.
+	rec = scimRequest(t, h.CreateScimUser, http.MethodPost, "/scim/v2/Users", "", `{"userName": "new@example.com", "externalId": "ext-1"}`)
+	if rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409, got %d", rec.Code)
+	}
+
+	// Lookup by filter
+	rec = scimRequest(t, h.ListScimUsers, http.MethodGet, `/scim/v2/Users?filter=userName+eq+%22new@example.com%22`, "", "")
+	var list ScimListResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &list)
+	if list.TotalResults != 1 {
+		t.Errorf("Expected one user from filter, got %+v", list)
+	}
+	rec = scimRequest(t, h.ListScimUsers, http.MethodGet, `/scim/v2/Users?filter=name.givenName+sw+%22N%22`, "", "")
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for unsupported filter, got %d", rec.Code)
+	}
+
+	// Deactivate through PATCH, using the string form some IdPs send.
+	db.Create(&models.KeyHistory{UserID: "new@example.com", LiteLLMKeyID: "sk-personal", Status: "active"})
+	db.Create(&models.KeyHistory{UserID: "new@example.com", TeamID: "team-1", LiteLLMKeyID: "sk-team", Status: "active"})
+	rec = scimRequest(t, h.PatchScimUser, http.MethodPatch, "/scim/v2/Users/new@example.com", "new@example.com",
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "Replace", "path": "active", "value": "False"}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	var user models.User
+	db.Where("id = ?", "new@example.com").First(&user)
+	if user.Status != "deactivated" || user.DeactivatedAt == nil {
+		t.Errorf("Expected user deactivated, got %+v", user)
+	}
+	if len(updates) != 1 || updates[0]["max_budget"] != 0.0 {
+		t.Errorf("Expected LiteLLM budget zeroed, got %v", updates)
+	}
+	if len(deleted) != 2 {
+		t.Errorf("Expected personal and team keys deleted, got %v", deleted)
+	}
+	var revoked int64
+	db.Model(&models.KeyHistory{}).Where("status = ? AND revoked_reason = ?", "revoked", "user_deactivated").Count(&revoked)
+	if revoked != 2 {
+		t.Errorf("Expected 2 key history rows revoked, got %d", revoked)
+	}
+
+	// A replace that leaves out active does not reactivate the user.
+	rec = scimRequest(t, h.ReplaceScimUser, http.MethodPut, "/scim/v2/Users/new@example.com", "new@example.com",
+		`{"userName": "new@example.com", "externalId": "ext-2"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	db.Where("id = ?", "new@example.com").First(&user)
+	if user.Status != "deactivated" || user.ExternalID != "ext-2" || len(updates) != 1 {
+		t.Errorf("Expected only externalId changed, got %+v and updates %v", user, updates)
+	}
+
+	// Reactivating restores the tier limits.
+	rec = scimRequest(t, h.ReplaceScimUser, http.MethodPut, "/scim/v2/Users/new@example.com", "new@example.com",
+		`{"userName": "new@example.com", "externalId": "ext-1", "active": true}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if len(updates) != 2 || updates[1]["max_budget"] != 5.0 {
+		t.Errorf("Expected tier limits restored, got %v", updates)
+	}
+}
+
+func TestScimDeactivateCutsOffModels(t *testing.T) {
+	config.AppConfig = &config.Config{UserTiers: []config.UserTier{{Name: "basic", MaxBudget: 5}}, DefaultUserTier: "basic"}
+	litellm, svc := newFakeLiteLLM(t)
+	litellm.AddUser(litellmfake.User{ID: "new@example.com"})
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+	db.Create(&models.User{ID: "new@example.com", Email: "new@example.com", Tier: "basic", ExternalID: "ext-1"})
+
+	rec := scimRequest(t, h.PatchScimUser, http.MethodPatch, "/scim/v2/Users/new@example.com", "new@example.com",
+		`{"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"], "Operations": [{"op": "replace", "path": "active", "value": false}]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+
+	// An empty models list would allow every model in LiteLLM.
+	user, _ := litellm.User("new@example.com")
+	if user.MaxBudget == nil || *user.MaxBudget != 0 || !reflect.DeepEqual(user.Models, []string{services.NoDefaultModels}) {
+		t.Errorf("Expected no budget and no models in LiteLLM, got budget %v and models %v", user.MaxBudget, user.Models)
+	}
+}
+
+func TestScimGroups(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	db := setupTestDB(t)
+	h := NewHandler(services.NewLiteLLMService(), db)
+
+	rec := scimRequest(t, h.CreateScimGroup, http.MethodPost, "/scim/v2/Groups", "",
+		`{"displayName": "eng", "members": [{"value": "A@example.com"}]}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	var group ScimGroup
+	_ = json.Unmarshal(rec.Body.Bytes(), &group)
+	if group.ID == "" || len(group.Members) != 1 || group.Members[0].Value != "a@example.com" {
+		t.Fatalf("Unexpected group %+v", group)
+	}
+
+	rec = scimRequest(t, h.PatchScimGroup, http.MethodPatch, "/scim/v2/Groups/"+group.ID, group.ID, `{"Operations": [
+		{"op": "add", "path": "members", "value": [{"value": "b@example.com"}]},
+		{"op": "remove", "path": "members[value eq \"a@example.com\"]"},
+		{"op": "replace", "value": {"displayName": "engineering"}}
+	]}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &group)
+	if group.DisplayName != "engineering" || len(group.Members) != 1 || group.Members[0].Value != "b@example.com" {
+		t.Errorf("Unexpected patched group %+v", group)
+	}
+
+	names, err := models.ScimGroupNames(db, "b@example.com")
+	if err != nil || len(names) != 1 || names[0] != "engineering" {
+		t.Errorf("Expected group names for member, got %v, %v", names, err)
+	}
+
+	rec = scimRequest(t, h.DeleteScimGroup, http.MethodDelete, "/scim/v2/Groups/"+group.ID, group.ID, "")
+	if rec.Code != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", rec.Code)
+	}
+	if names, _ := models.ScimGroupNames(db, "b@example.com"); len(names) != 0 {
+		t.Errorf("Expected no groups after delete, got %v", names)
+	}
+}
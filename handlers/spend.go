@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/labstack/echo/v4"
+)
+
+type SpendPoint struct {
+	Time      time.Time `json:"time"`
+	Spend     float64   `json:"spend"`
+	MaxBudget float64   `json:"max_budget"`
+}
+
+type SpendSeries struct {
+	KeyID   string       `json:"key_id,omitempty"`
+	KeyName string       `json:"key_name,omitempty"`
+	Points  []SpendPoint `json:"points"`
+}
+
+type SpendHistoryResponse struct {
+	Since time.Time     `json:"since"`
+	User  SpendSeries   `json:"user"`
+	Keys  []SpendSeries `json:"keys"`
+}
+
+type KeySpendDelta struct {
+	KeyID   string  `json:"key_id"`
+	KeyName string  `json:"key_name"`
+	Status  string  `json:"status"`
+	Spend   float64 `json:"spend"`
+}
+
+type SpendSummaryResponse struct {
+	Since time.Time       `json:"since"`
+	Spend float64         `json:"spend"`
+	Keys  []KeySpendDelta `json:"keys"`
+}
+
+// GetSpendHistory godoc
+// @Summary Get spend history
+// @Description Fetch recorded spend snapshots for the current user and their keys as time series
+// @Tags spend
+// @Accept json
+// @Produce json
+// @Param since query string false "Look-back window, e.g. 30d or 12h" default(30d)
+// @Param key_id query string false "Only return the series for this key"
+// @Success 200 {object} SpendHistoryResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /spend/history [get]
+func (h *Handler) GetSpendHistory(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+
+	since, err := parseSince(c.QueryParam("since"), 30*24*time.Hour)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid since parameter"})
+	}
+
+	query := h.DB.Where("user_id = ? AND captured_at >= ?", userID, since)
+	if keyID := c.QueryParam("key_id"); keyID != "" {
+		query = query.Where("litellm_key_id = ?", keyID)
+	}
+
+	var snapshots []models.SpendSnapshot
+	if err := query.Order("captured_at").Find(&snapshots).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch spend history"})
+	}
+
+	resp := SpendHistoryResponse{
+		Since: since,
+		User:  SpendSeries{Points: []SpendPoint{}},
+		Keys:  []SpendSeries{},
+	}
+	keyIndex := make(map[string]int)
+	for _, s := range snapshots {
+		point := SpendPoint{Time: s.CapturedAt, Spend: s.Spend, MaxBudget: s.MaxBudget}
+		if s.LiteLLMKeyID == "" {
+			resp.User.Points = append(resp.User.Points, point)
+			continue
+		}
+		i, ok := keyIndex[s.LiteLLMKeyID]
+		if !ok {
+			i = len(resp.Keys)
+			keyIndex[s.LiteLLMKeyID] = i
+			resp.Keys = append(resp.Keys, SpendSeries{KeyID: s.LiteLLMKeyID})
+		}
+		// Aliases can change over time; report the most recent one.
+		resp.Keys[i].KeyName = s.KeyName
+		resp.Keys[i].Points = append(resp.Keys[i].Points, point)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetSpendSummary godoc
+// @Summary Get spend over a window
+// @Description Compute how much the current user and each of their keys spent within a window, including keys that have since been revoked
+// @Tags spend
+// @Accept json
+// @Produce json
+// @Param window query string false "Window length, e.g. 7d" default(7d)
+// @Success 200 {object} SpendSummaryResponse
+// @Failure 400 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /spend/summary [get]
+func (h *Handler) GetSpendSummary(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+
+	since, err := parseSince(c.QueryParam("window"), 7*24*time.Hour)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid window parameter"})
+	}
+
+	// The latest snapshot at or before the window start is the baseline. When
+	// none exists, the first snapshot inside the window is used instead.
+	var snapshots []models.SpendSnapshot
+	if err := h.DB.Where("user_id = ?", userID).Order("captured_at").Find(&snapshots).Error; err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch spend history"})
+	}
+
+	type span struct {
+		name     string
+		baseline *float64
+		latest   float64
+		inWindow bool
+	}
+	spans := make(map[string]*span)
+	var order []string
+	for _, s := range snapshots {
+		sp, ok := spans[s.LiteLLMKeyID]
+		if !ok {
+			sp = &span{}
+			spans[s.LiteLLMKeyID] = sp
+			order = append(order, s.LiteLLMKeyID)
+		}
+		sp.name = s.KeyName
+		spend := s.Spend
+		if s.CapturedAt.After(since) {
+			if sp.baseline == nil {
+				sp.baseline = &spend
+			}
+			sp.latest = spend
+			sp.inWindow = true
+		} else {
+			sp.baseline = &spend
+			sp.latest = spend
+		}
+	}
+
+	var dbKeys []models.KeyHistory
+	h.DB.Where("user_id = ?", userID).Find(&dbKeys)
+	statuses := make(map[string]string)
+	for _, k := range dbKeys {
+		statuses[k.LiteLLMKeyID] = k.Status
+	}
+
+	resp := SpendSummaryResponse{Since: since, Keys: []KeySpendDelta{}}
+	for _, id := range order {
+		sp := spans[id]
+		if !sp.inWindow {
+			continue
+		}
+		delta := sp.latest - *sp.baseline
+		if id == "" {
+			resp.Spend = delta
+			continue
+		}
+		resp.Keys = append(resp.Keys, KeySpendDelta{
+			KeyID:   id,
+			KeyName: sp.name,
+			Status:  statuses[id],
+			Spend:   delta,
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// parseSince converts a look-back window such as "7d" into its start time.
+func parseSince(window string, fallback time.Duration) (time.Time, error) {
+	d := fallback
+	if window != "" {
+		parsed, err := config.ParseDurationExtended(window)
+		if err != nil {
+			return time.Time{}, err
+		}
+		d = parsed
+	}
+	return time.Now().Add(-d), nil
+}
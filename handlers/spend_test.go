@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/models"
+	"github.com/labstack/echo/v4"
+)
+
+func TestGetSpendHistory(t *testing.T) {
+	db := setupTestDB(t)
+	h := NewHandler(nil, db)
+
+	now := time.Now()
+	db.Create(&[]models.SpendSnapshot{
+		{UserID: "test@example.com", Spend: 1, CapturedAt: now.Add(-2 * time.Hour)},
+		{UserID: "test@example.com", Spend: 2, CapturedAt: now.Add(-time.Hour)},
+		{UserID: "test@example.com", LiteLLMKeyID: "sk-a", KeyName: "a", Spend: 0.5, CapturedAt: now.Add(-time.Hour)},
+		{UserID: "test@example.com", Spend: 0.1, CapturedAt: now.Add(-72 * time.Hour)},
+		{UserID: "other@example.com", Spend: 5, CapturedAt: now.Add(-time.Hour)},
+	})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/spend/history?since=1d", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+
+	if err := h.GetSpendHistory(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	var resp SpendHistoryResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if len(resp.User.Points) != 2 {
+		t.Errorf("Expected 2 user points, got %d", len(resp.User.Points))
+	}
+	if len(resp.Keys) != 1 || resp.Keys[0].KeyID != "sk-a" || len(resp.Keys[0].Points) != 1 {
+		t.Errorf("Unexpected key series: %+v", resp.Keys)
+	}
+
+	// Invalid window
+	req = httptest.NewRequest(http.MethodGet, "/api/spend/history?since=bogus", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+	_ = h.GetSpendHistory(c)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400, got %d", rec.Code)
+	}
+}
+
+func TestGetSpendSummary(t *testing.T) {
+	db := setupTestDB(t)
+	h := NewHandler(nil, db)
+
+	now := time.Now()
+	db.Create(&[]models.SpendSnapshot{
+		{UserID: "test@example.com", Spend: 1, CapturedAt: now.Add(-10 * 24 * time.Hour)},
+		{UserID: "test@example.com", Spend: 4, CapturedAt: now.Add(-time.Hour)},
+		// Revoked key: its last snapshot is within the window.
+		{UserID: "test@example.com", LiteLLMKeyID: "sk-gone", KeyName: "gone", Spend: 0.5, CapturedAt: now.Add(-10 * 24 * time.Hour)},
+		{UserID: "test@example.com", LiteLLMKeyID: "sk-gone", KeyName: "gone", Spend: 2, CapturedAt: now.Add(-2 * 24 * time.Hour)},
+		// Key that stopped reporting before the window is omitted.
+		{UserID: "test@example.com", LiteLLMKeyID: "sk-old", KeyName: "old", Spend: 3, CapturedAt: now.Add(-9 * 24 * time.Hour)},
+	})
+	db.Create(&models.KeyHistory{UserID: "test@example.com", LiteLLMKeyID: "sk-gone", Status: "revoked"})
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/spend/summary?window=7d", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", "test@example.com")
+
+	if err := h.GetSpendSummary(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var resp SpendSummaryResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Spend != 3 {
+		t.Errorf("Expected user spend delta 3, got %f", resp.Spend)
+	}
+	if len(resp.Keys) != 1 {
+		t.Fatalf("Expected 1 key delta, got %d", len(resp.Keys))
+	}
+	if resp.Keys[0].KeyID != "sk-gone" || resp.Keys[0].Spend != 1.5 || resp.Keys[0].Status != "revoked" {
+		t.Errorf("Unexpected key delta: %+v", resp.Keys[0])
+	}
+}
@@ -0,0 +1,582 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/example/llmreq/apierror"
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+type CreateTeamRequest struct {
+	Alias          string   `json:"alias"`
+	Models         []string `json:"models"`
+	MaxBudget      float64  `json:"max_budget"`      // shared by all team keys; 0 means unlimited
+	BudgetDuration string   `json:"budget_duration"` // e.g. "30d"; empty means the budget never resets
+}
+
+type UpdateTeamRequest struct {
+	Alias          *string  `json:"alias"`
+	Models         []string `json:"models"`
+	MaxBudget      *float64 `json:"max_budget"`
+	BudgetDuration *string  `json:"budget_duration"`
+}
+
+type AddTeamMemberRequest struct {
+	UserID   string   `json:"user_id"`
+	Role     string   `json:"role"`      // "admin" or "user"
+	MaxSpend *float64 `json:"max_spend"` // optional cap on the member's share of the team budget
+}
+
+type SetTeamMemberCapRequest struct {
+	MaxSpend *float64 `json:"max_spend"` // null removes the cap
+}
+
+type TeamSpendResponse struct {
+	TeamID        string            `json:"team_id"`
+	Spend         float64           `json:"spend"`
+	MaxBudget     float64           `json:"max_budget"`
+	BudgetResetAt string            `json:"budget_reset_at,omitempty"`
+	Members       []TeamMemberSpend `json:"members"`
+	Keys          []TeamKeyResponse `json:"keys"`
+}
+
+type TeamMemberSpend struct {
+	UserID   string   `json:"user_id"`
+	Role     string   `json:"role"`
+	Spend    float64  `json:"spend"`
+	MaxSpend *float64 `json:"max_spend"`
+	KeyCount int      `json:"key_count"`
+}
+
+type TeamResponse struct {
+	TeamID  string                `json:"team_id"`
+	Alias   string                `json:"alias"`
+	Role    string                `json:"role,omitempty"`
+	Team    *services.LiteLLMTeam `json:"litellm,omitempty"`
+	Members []models.TeamMember   `json:"members,omitempty"`
+}
+
+type TeamKeyResponse struct {
+	ActiveKeyResponse
+	CreatedBy string `json:"created_by"`
+}
+
+// CreateTeam godoc
+// @Summary Create a team
+// @Description Create a LiteLLM team; the caller becomes its admin
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Param request body CreateTeamRequest true "Create Team Request"
+// @Success 200 {object} TeamResponse
+// @Failure 400 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /teams [post]
+func (h *Handler) CreateTeam(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	var req CreateTeamRequest
+	if err := c.Bind(&req); err != nil || strings.TrimSpace(req.Alias) == "" || req.MaxBudget < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if !validBudgetDuration(req.BudgetDuration) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid budget_duration"})
+	}
+
+	team, err := h.LiteLLMService.CreateTeam(c.Request().Context(), services.NewTeamRequest{
+		TeamAlias:      req.Alias,
+		Models:         req.Models,
+		MaxBudget:      req.MaxBudget,
+		BudgetDuration: req.BudgetDuration,
+		Members:        []services.LiteLLMTeamMember{{UserID: userID, Role: "admin"}},
+	})
+	if err != nil {
+		log.Printf("Failed to create team: %v", err)
+		return apierror.Upstream(c, err, "Failed to create team")
+	}
+
+	localTeam := models.Team{ID: team.TeamID, Alias: req.Alias, CreatedBy: userID, Instance: team.Instance}
+	h.DB.Create(&localTeam)
+	member := models.TeamMember{TeamID: team.TeamID, UserID: userID, Role: "admin"}
+	h.DB.Create(&member)
+
+	h.recordAudit(c, audit.Event{Action: "team.create", TargetType: "team", TargetID: team.TeamID, After: req})
+
+	return c.JSON(http.StatusOK, TeamResponse{
+		TeamID:  team.TeamID,
+		Alias:   req.Alias,
+		Role:    "admin",
+		Team:    team,
+		Members: []models.TeamMember{member},
+	})
+}
+
+// ListTeams godoc
+// @Summary List my teams
+// @Description List the teams the current user belongs to
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Success 200 {array} TeamResponse
+// @Router /teams [get]
+func (h *Handler) ListTeams(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+
+	var memberships []models.TeamMember
+	h.DB.Where("user_id = ?", userID).Find(&memberships)
+
+	resp := []TeamResponse{}
+	for _, m := range memberships {
+		var team models.Team
+		if err := h.DB.Where("id = ?", m.TeamID).First(&team).Error; err != nil {
+			continue
+		}
+		resp = append(resp, TeamResponse{TeamID: team.ID, Alias: team.Alias, Role: m.Role})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetTeam godoc
+// @Summary Get a team
+// @Description Fetch team details from LiteLLM along with its members (members only)
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Param id path string true "Team ID"
+// @Success 200 {object} TeamResponse
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /teams/{id} [get]
+func (h *Handler) GetTeam(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	teamID := c.Param("id")
+
+	role, ok := h.teamRole(teamID, userID)
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Team not found"})
+	}
+
+	var team models.Team
+	if err := h.DB.Where("id = ?", teamID).First(&team).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Team not found"})
+	}
+
+	info, err := h.LiteLLMService.GetTeamInfo(c.Request().Context(), teamID)
+	if err != nil {
+		return apierror.Upstream(c, err, "LiteLLM unavailable")
+	}
+
+	var members []models.TeamMember
+	h.DB.Where("team_id = ?", teamID).Find(&members)
+
+	return c.JSON(http.StatusOK, TeamResponse{
+		TeamID:  team.ID,
+		Alias:   team.Alias,
+		Role:    role,
+		Team:    info,
+		Members: members,
+	})
+}
+
+// UpdateTeam godoc
+// @Summary Update a team
+// @Description Change a team's alias, allowed models or shared budget (team admins only)
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Param id path string true "Team ID"
+// @Param request body UpdateTeamRequest true "Update Team Request"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /teams/{id} [patch]
+func (h *Handler) UpdateTeam(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	teamID := c.Param("id")
+
+	if role, ok := h.teamRole(teamID, userID); !ok || role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Team admin access required"})
+	}
+
+	var req UpdateTeamRequest
+	if err := c.Bind(&req); err != nil || (req.MaxBudget != nil && *req.MaxBudget < 0) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.BudgetDuration != nil && !validBudgetDuration(*req.BudgetDuration) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid budget_duration"})
+	}
+
+	err := h.LiteLLMService.UpdateTeam(c.Request().Context(), services.UpdateTeamRequest{
+		TeamID:         teamID,
+		TeamAlias:      req.Alias,
+		Models:         req.Models,
+		MaxBudget:      req.MaxBudget,
+		BudgetDuration: req.BudgetDuration,
+	})
+	if err != nil {
+		log.Printf("Failed to update team: %v", err)
+		return apierror.Upstream(c, err, "Failed to update team")
+	}
+
+	if req.Alias != nil {
+		h.DB.Model(&models.Team{}).Where("id = ?", teamID).Update("alias", *req.Alias)
+	}
+
+	h.recordAudit(c, audit.Event{Action: "team.update", TargetType: "team", TargetID: teamID, After: req})
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "updated"})
+}
+
+// AddTeamMember godoc
+// @Summary Add a team member
+// @Description Add a user to a team (team admins only)
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Param id path string true "Team ID"
+// @Param request body AddTeamMemberRequest true "Member"
+// @Success 200 {object} models.TeamMember
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /teams/{id}/members [post]
+func (h *Handler) AddTeamMember(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	teamID := c.Param("id")
+
+	if role, ok := h.teamRole(teamID, userID); !ok || role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Team admin access required"})
+	}
+
+	var req AddTeamMemberRequest
+	if err := c.Bind(&req); err != nil || req.UserID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+	if req.Role == "" {
+		req.Role = "user"
+	}
+	if req.Role != "user" && req.Role != "admin" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Role must be user or admin"})
+	}
+	if req.MaxSpend != nil && *req.MaxSpend < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "max_spend must not be negative"})
+	}
+	memberID := strings.ToLower(req.UserID)
+
+	err := h.LiteLLMService.AddTeamMember(c.Request().Context(), teamID, services.LiteLLMTeamMember{
+		UserID:    memberID,
+		UserEmail: memberID,
+		Role:      req.Role,
+	})
+	if err != nil {
+		log.Printf("Failed to add team member: %v", err)
+		return apierror.Upstream(c, err, "Failed to add team member")
+	}
+
+	member := models.TeamMember{TeamID: teamID, UserID: memberID}
+	h.DB.Where(member).FirstOrCreate(&member)
+	before := member
+	member.Role = req.Role
+	// A manual add keeps the membership even if the user's groups stop
+	// granting the team.
+	member.Source = "manual"
+	if req.MaxSpend != nil {
+		member.MaxSpend = req.MaxSpend
+	}
+	h.DB.Save(&member)
+
+	h.recordAudit(c, audit.Event{
+		UserID:     memberID,
+		Action:     "team.member_add",
+		TargetType: "team",
+		TargetID:   teamID,
+		Before:     before,
+		After:      member,
+	})
+
+	return c.JSON(http.StatusOK, member)
+}
+
+// SetTeamMemberCap godoc
+// @Summary Set a member's spend cap
+// @Description Cap how much of the team budget a member's keys may draw, or remove the cap with null (team admins only)
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Param id path string true "Team ID"
+// @Param user_id path string true "Member user ID"
+// @Param request body SetTeamMemberCapRequest true "Cap"
+// @Success 200 {object} models.TeamMember
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /teams/{id}/members/{user_id}/max_spend [put]
+func (h *Handler) SetTeamMemberCap(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	teamID := c.Param("id")
+
+	if role, ok := h.teamRole(teamID, userID); !ok || role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Team admin access required"})
+	}
+
+	var req SetTeamMemberCapRequest
+	if err := c.Bind(&req); err != nil || (req.MaxSpend != nil && *req.MaxSpend < 0) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
+	}
+
+	var member models.TeamMember
+	if err := h.DB.Where("team_id = ? AND user_id = ?", teamID, strings.ToLower(c.Param("user_id"))).First(&member).Error; err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Member not found"})
+	}
+
+	before := member
+	member.MaxSpend = req.MaxSpend
+	// Select so that a nil cap is written as NULL
+	h.DB.Model(&member).Select("max_spend").Updates(&member)
+
+	h.recordAudit(c, audit.Event{
+		UserID:     member.UserID,
+		Action:     "team.member_cap",
+		TargetType: "team",
+		TargetID:   teamID,
+		Before:     before,
+		After:      member,
+	})
+
+	return c.JSON(http.StatusOK, member)
+}
+
+// GetTeamKeys godoc
+// @Summary List team keys
+// @Description List the active keys owned by a team (members only)
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Param id path string true "Team ID"
+// @Success 200 {array} TeamKeyResponse
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /teams/{id}/keys [get]
+func (h *Handler) GetTeamKeys(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	teamID := c.Param("id")
+
+	if _, ok := h.teamRole(teamID, userID); !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Team not found"})
+	}
+
+	resp, err := h.teamKeys(c.Request().Context(), teamID)
+	if err != nil {
+		return apierror.Upstream(c, err, "Failed to fetch keys from LiteLLM")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// GetTeamSpend godoc
+// @Summary Get team spend
+// @Description Show the team's shared budget with spend broken down by member and key (team admins only)
+// @Tags teams
+// @Accept json
+// @Produce json
+// @Param id path string true "Team ID"
+// @Success 200 {object} TeamSpendResponse
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 503 {object} map[string]string
+// @Router /teams/{id}/spend [get]
+func (h *Handler) GetTeamSpend(c echo.Context) error {
+	userID := c.Get("user_id").(string)
+	teamID := c.Param("id")
+
+	if role, ok := h.teamRole(teamID, userID); !ok || role != "admin" {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Team admin access required"})
+	}
+
+	info, err := h.LiteLLMService.GetTeamInfo(c.Request().Context(), teamID)
+	if err != nil {
+		return apierror.Upstream(c, err, "LiteLLM unavailable")
+	}
+	if info == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Team not found"})
+	}
+
+	keys, err := h.teamKeys(c.Request().Context(), teamID)
+	if err != nil {
+		return apierror.Upstream(c, err, "Failed to fetch keys from LiteLLM")
+	}
+
+	var members []models.TeamMember
+	h.DB.Where("team_id = ?", teamID).Order("user_id").Find(&members)
+
+	byMember := make(map[string]*TeamMemberSpend)
+	resp := TeamSpendResponse{
+		TeamID:        teamID,
+		Spend:         info.Spend,
+		MaxBudget:     info.MaxBudget,
+		BudgetResetAt: info.BudgetResetAt,
+		Members:       make([]TeamMemberSpend, 0, len(members)),
+		Keys:          keys,
+	}
+	for _, m := range members {
+		resp.Members = append(resp.Members, TeamMemberSpend{UserID: m.UserID, Role: m.Role, MaxSpend: m.MaxSpend})
+	}
+	for i := range resp.Members {
+		byMember[resp.Members[i].UserID] = &resp.Members[i]
+	}
+	for _, k := range keys {
+		// Keys created by former members are still listed under Keys
+		if m, ok := byMember[k.CreatedBy]; ok {
+			m.Spend += k.Spend
+			m.KeyCount++
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// teamKeys lists a team's unexpired LiteLLM keys, attributing each to the
+// member who created it.
+func (h *Handler) teamKeys(ctx context.Context, teamID string) ([]TeamKeyResponse, error) {
+	keys, err := h.LiteLLMService.ListTeamKeys(ctx, teamID)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbKeys []models.KeyHistory
+	h.DB.Where("team_id = ?", teamID).Find(&dbKeys)
+	dbKeyMap := make(map[string]*models.KeyHistory)
+	for i := range dbKeys {
+		dbKeyMap[dbKeys[i].LiteLLMKeyID] = &dbKeys[i]
+	}
+
+	resp := []TeamKeyResponse{}
+	for _, k := range keys {
+		if k.TeamId != teamID {
+			continue
+		}
+
+		var expiresAt *time.Time
+		if k.Expires != "" && k.Expires != "null" {
+			if t, err := time.Parse(time.RFC3339, k.Expires); err == nil {
+				if t.Before(time.Now()) {
+					continue
+				}
+				expiresAt = &t
+			}
+		}
+
+		item := TeamKeyResponse{
+			ActiveKeyResponse: ActiveKeyResponse{
+				Mask:      k.Key,
+				Name:      k.KeyAlias,
+				ExpiresAt: expiresAt,
+				Spend:     k.Spend,
+				Type:      "standard",
+				KeyID:     k.Key,
+			},
+		}
+		if createdBy, ok := k.Metadata["created_by"].(string); ok {
+			item.CreatedBy = createdBy
+		}
+		if dbKey, ok := dbKeyMap[k.Key]; ok {
+			item.Mask = dbKey.KeyMask
+			item.CreatedAt = dbKey.CreatedAt
+			item.Type = dbKey.KeyType
+			item.CreatedBy = dbKey.UserID
+		}
+		resp = append(resp, item)
+	}
+
+	return resp, nil
+}
+
+// memberTeamCommitted sums how much of the team budget the keys userID
+// created have drawn or may still draw. A key LiteLLM lists counts with its
+// full max_budget while it is usable and with its spend once it expired. A
+// key it no longer lists counts with its last recorded spend, or else with
+// the budget it was created with, so deleting keys does not reset the cap.
+func (h *Handler) memberTeamCommitted(ctx context.Context, teamID, userID string) (float64, error) {
+	keys, err := h.LiteLLMService.ListTeamKeys(ctx, teamID)
+	if err != nil {
+		return 0, err
+	}
+
+	var dbKeys []models.KeyHistory
+	if err := h.DB.Where("team_id = ? AND user_id = ?", teamID, userID).Find(&dbKeys).Error; err != nil {
+		return 0, err
+	}
+	created := make(map[string]*models.KeyHistory)
+	for i := range dbKeys {
+		created[dbKeys[i].LiteLLMKeyID] = &dbKeys[i]
+	}
+
+	now := time.Now()
+	var committed float64
+	listed := make(map[string]bool)
+	for _, k := range keys {
+		if k.TeamId != teamID {
+			continue
+		}
+		if createdBy, _ := k.Metadata["created_by"].(string); created[k.Key] == nil && createdBy != userID {
+			continue
+		}
+		listed[k.Key] = true
+		expired := false
+		if k.Expires != "" && k.Expires != "null" {
+			if t, err := time.Parse(time.RFC3339, k.Expires); err == nil {
+				expired = t.Before(now)
+			}
+		}
+		if expired {
+			committed += k.Spend
+		} else {
+			committed += max(k.Spend, k.MaxBudget)
+		}
+	}
+
+	for _, row := range dbKeys {
+		if listed[row.LiteLLMKeyID] {
+			continue
+		}
+		var last models.SpendSnapshot
+		err := h.DB.Where("litellm_key_id = ?", row.LiteLLMKeyID).Order("captured_at DESC").Limit(1).Find(&last).Error
+		if err != nil {
+			return 0, err
+		}
+		if last.ID != 0 {
+			committed += last.Spend
+		} else {
+			committed += row.MaxBudget
+		}
+	}
+	return committed, nil
+}
+
+// validBudgetDuration accepts an empty duration (no reset) or one that
+// LiteLLM understands, such as "30d" or "24h".
+func validBudgetDuration(d string) bool {
+	if d == "" {
+		return true
+	}
+	parsed, err := config.ParseDurationExtended(d)
+	return err == nil && parsed > 0
+}
+
+// teamRole returns the caller's role in a team and whether they belong to it.
+func (h *Handler) teamRole(teamID, userID string) (string, bool) {
+	var member models.TeamMember
+	if err := h.DB.Where("team_id = ? AND user_id = ?", teamID, userID).First(&member).Error; err != nil {
+		return "", false
+	}
+	return member.Role, true
+}
@@ -0,0 +1,401 @@
+package handlers
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+func TestCreateTeam(t *testing.T) {
+	var created services.NewTeamRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/team/new" {
+			_ = json.NewDecoder(r.Body).Decode(&created)
+			_ = json.NewEncoder(w).Encode(services.LiteLLMTeam{TeamID: "team-1", TeamAlias: created.TeamAlias})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/api/teams", strings.NewReader(`{"alias": "ops"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", "lead@example.com")
+
+	if err := h.CreateTeam(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if len(created.Members) != 1 || created.Members[0].UserID != "lead@example.com" || created.Members[0].Role != "admin" {
+		t.Errorf("Expected creator to be team admin, got %+v", created.Members)
+	}
+	if role, ok := h.teamRole("team-1", "lead@example.com"); !ok || role != "admin" {
+		t.Errorf("Expected local admin membership, got %q %v", role, ok)
+	}
+
+	// ListTeams
+	req = httptest.NewRequest(http.MethodGet, "/api/teams", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.Set("user_id", "lead@example.com")
+	_ = h.ListTeams(c)
+	var teams []TeamResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &teams)
+	if len(teams) != 1 || teams[0].TeamID != "team-1" || teams[0].Alias != "ops" {
+		t.Errorf("Unexpected teams %+v", teams)
+	}
+}
+
+func TestAddTeamMember(t *testing.T) {
+	var added map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/team/member_add" {
+			_ = json.NewDecoder(r.Body).Decode(&added)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+	db.Create(&models.Team{ID: "team-1", Alias: "ops"})
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "lead@example.com", Role: "admin"})
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "dev@example.com", Role: "user"})
+
+	add := func(caller, body string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/teams/team-1/members", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id")
+		c.SetParamValues("team-1")
+		c.Set("user_id", caller)
+		if err := h.AddTeamMember(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	if rec := add("dev@example.com", `{"user_id": "new@example.com"}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for non-admin, got %d", rec.Code)
+	}
+	if rec := add("lead@example.com", `{"user_id": "New@example.com"}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	if added["team_id"] != "team-1" {
+		t.Errorf("Unexpected member_add payload %v", added)
+	}
+	if role, ok := h.teamRole("team-1", "new@example.com"); !ok || role != "user" {
+		t.Errorf("Expected new member with role user, got %q %v", role, ok)
+	}
+}
+
+func TestCreateTeamKey(t *testing.T) {
+	var generated services.GenerateKeyRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key/generate":
+			_ = json.NewDecoder(r.Body).Decode(&generated)
+			_ = json.NewEncoder(w).Encode(services.GenerateKeyResponse{Key: "sk-team-12345678"})
+		case "/key/list":
+			if r.URL.Query().Get("team_id") == "team-1" {
+				_, _ = w.Write([]byte(`{"keys": [
+					{"key": "sk-t...5678", "key_alias": "svc", "team_id": "team-1", "spend": 0.25, "metadata": {"created_by": "dev@example.com"}},
+					{"key": "sk-x...0000", "key_alias": "legacy", "team_id": "team-1", "metadata": {"created_by": "someone@example.com"}}
+				]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"keys": []}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{
+		DefaultBudget:       1.0,
+		MaxActiveKeys:       10,
+		StandardKeyLifetime: 24 * time.Hour,
+	}
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+	db.Create(&models.Team{ID: "team-1", Alias: "ops"})
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "dev@example.com", Role: "user"})
+
+	createKey := func(caller string) *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(`{"name": "svc", "team_id": "team-1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user_id", caller)
+		if err := h.CreateKey(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	if rec := createKey("outsider@example.com"); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for non-member, got %d", rec.Code)
+	}
+
+	rec := createKey("dev@example.com")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if generated.TeamID != "team-1" || generated.UserID != "" || generated.Metadata["created_by"] != "dev@example.com" {
+		t.Errorf("Unexpected generate payload %+v", generated)
+	}
+
+	var key models.KeyHistory
+	db.Where("team_id = ?", "team-1").First(&key)
+	if key.UserID != "dev@example.com" || key.LiteLLMKeyID != "sk-t...5678" {
+		t.Errorf("Unexpected team key history %+v", key)
+	}
+
+	// Personal sync must not revoke the team key.
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api/keys/active", nil)
+	rec = httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.Set("user_id", "dev@example.com")
+	if err := h.GetActiveKeys(c); err != nil {
+		t.Fatal(err)
+	}
+	db.Where("team_id = ?", "team-1").First(&key)
+	if key.Status != "active" {
+		t.Errorf("Expected team key to stay active, got %s", key.Status)
+	}
+
+	// Team key listing
+	req = httptest.NewRequest(http.MethodGet, "/api/teams/team-1/keys", nil)
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("team-1")
+	c.Set("user_id", "dev@example.com")
+	if err := h.GetTeamKeys(c); err != nil {
+		t.Fatal(err)
+	}
+	var keys []TeamKeyResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &keys)
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 team keys, got %d", len(keys))
+	}
+	if keys[0].CreatedBy != "dev@example.com" || keys[0].Spend != 0.25 {
+		t.Errorf("Unexpected team key %+v", keys[0])
+	}
+	if keys[1].CreatedBy != "someone@example.com" {
+		t.Errorf("Expected creator from metadata, got %+v", keys[1])
+	}
+}
+
+func TestDeleteTeamKeyByTeamAdmin(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "lead@example.com", Role: "admin"})
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "dev2@example.com", Role: "user"})
+	db.Create(&models.KeyHistory{UserID: "dev@example.com", TeamID: "team-1", LiteLLMKeyID: "sk-team", Status: "active"})
+
+	del := func(caller string) int {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodDelete, "/api/keys/sk-team", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("key_id")
+		c.SetParamValues("sk-team")
+		c.Set("user_id", caller)
+		_ = h.DeleteKey(c)
+		return rec.Code
+	}
+
+	if code := del("dev2@example.com"); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for plain member, got %d", code)
+	}
+	if code := del("lead@example.com"); code != http.StatusOK {
+		t.Errorf("Expected 200 for team admin, got %d", code)
+	}
+}
+
+func TestCreateTeamKeyMemberCap(t *testing.T) {
+	var generated services.GenerateKeyRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/key/generate":
+			_ = json.NewDecoder(r.Body).Decode(&generated)
+			_ = json.NewEncoder(w).Encode(services.GenerateKeyResponse{Key: "sk-team-12345678"})
+		case "/key/list":
+			_, _ = w.Write([]byte(`{"keys": [
+				{"key": "sk-a", "team_id": "team-1", "spend": 1.5, "metadata": {"created_by": "dev@example.com"}},
+				{"key": "sk-b", "team_id": "team-1", "spend": 3.0, "metadata": {"created_by": "other@example.com"}}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{
+		DefaultBudget:       1.0,
+		MaxActiveKeys:       10,
+		StandardKeyLifetime: 24 * time.Hour,
+	}
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+
+	createKey := func() *httptest.ResponseRecorder {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodPost, "/api/keys", strings.NewReader(`{"name": "svc", "team_id": "team-1"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user_id", "dev@example.com")
+		if err := h.CreateKey(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	// 1.5 of a 2.0 cap spent: the key budget is trimmed to the remainder
+	capValue := 2.0
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "dev@example.com", Role: "user", MaxSpend: &capValue})
+	if rec := createKey(); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if generated.MaxBudget != 0.5 {
+		t.Errorf("Expected key budget trimmed to 0.5, got %v", generated.MaxBudget)
+	}
+
+	// The new key's budget is committed, so a second key does not get the
+	// same remainder again.
+	if rec := createKey(); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 once the cap is committed, got %d", rec.Code)
+	}
+
+	// A deleted key counts with its last recorded spend, or else with its
+	// full budget.
+	db.Where("team_id = ?", "team-1").Delete(&models.KeyHistory{})
+	db.Create(&[]models.KeyHistory{
+		{UserID: "dev@example.com", LiteLLMKeyID: "sk-deleted", TeamID: "team-1", MaxBudget: 0.3, Status: "revoked"},
+		{UserID: "dev@example.com", LiteLLMKeyID: "sk-snapshotted", TeamID: "team-1", MaxBudget: 1, Status: "revoked"},
+	})
+	db.Create(&models.SpendSnapshot{UserID: "dev@example.com", LiteLLMKeyID: "sk-snapshotted", Spend: 0.1, CapturedAt: time.Now()})
+	if rec := createKey(); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d, body: %s", rec.Code, rec.Body.String())
+	}
+	if math.Abs(generated.MaxBudget-0.1) > 1e-9 {
+		t.Errorf("Expected key budget trimmed to 0.1, got %v", generated.MaxBudget)
+	}
+}
+
+func TestTeamSpendAndCap(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/team/info":
+			_, _ = w.Write([]byte(`{"team_id": "team-1", "team_info": {"team_id": "team-1", "spend": 4.5, "max_budget": 10, "budget_reset_at": "2026-11-01T00:00:00Z"}}`))
+		case "/key/list":
+			_, _ = w.Write([]byte(`{"keys": [
+				{"key": "sk-a", "team_id": "team-1", "spend": 1.5, "metadata": {"created_by": "dev@example.com"}},
+				{"key": "sk-b", "team_id": "team-1", "spend": 3.0, "metadata": {"created_by": "lead@example.com"}}
+			]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	h := NewHandler(svc, db)
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "lead@example.com", Role: "admin"})
+	db.Create(&models.TeamMember{TeamID: "team-1", UserID: "dev@example.com", Role: "user"})
+
+	e := echo.New()
+	setCap := func(caller, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/teams/team-1/members/dev@example.com/max_spend", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.SetParamNames("id", "user_id")
+		c.SetParamValues("team-1", "dev@example.com")
+		c.Set("user_id", caller)
+		if err := h.SetTeamMemberCap(c); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	if rec := setCap("dev@example.com", `{"max_spend": 100}`); rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for non-admin, got %d", rec.Code)
+	}
+	if rec := setCap("lead@example.com", `{"max_spend": 5}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/teams/team-1/spend", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues("team-1")
+	c.Set("user_id", "lead@example.com")
+	if err := h.GetTeamSpend(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var spend TeamSpendResponse
+	_ = json.Unmarshal(rec.Body.Bytes(), &spend)
+	if spend.Spend != 4.5 || spend.MaxBudget != 10 || len(spend.Keys) != 2 || len(spend.Members) != 2 {
+		t.Fatalf("Unexpected team spend %+v", spend)
+	}
+	dev := spend.Members[0]
+	if dev.UserID != "dev@example.com" || dev.Spend != 1.5 || dev.KeyCount != 1 || dev.MaxSpend == nil || *dev.MaxSpend != 5 {
+		t.Errorf("Unexpected member spend %+v", dev)
+	}
+
+	// null removes the cap
+	if rec := setCap("lead@example.com", `{"max_spend": null}`); rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rec.Code)
+	}
+	var member models.TeamMember
+	db.Where("user_id = ?", "dev@example.com").First(&member)
+	if member.MaxSpend != nil {
+		t.Errorf("Expected cap removed, got %v", *member.MaxSpend)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 
+	"github.com/example/llmreq/apierror"
 	"github.com/labstack/echo/v4"
 )
 
@@ -19,9 +20,9 @@ import (
 func (h *Handler) GetMe(c echo.Context) error {
 	userID := c.Get("user_id").(string)
 
-	user, err := h.LiteLLMService.GetUserInfo(userID)
+	user, err := h.LiteLLMService.GetUserInfo(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "LiteLLM unavailable"})
+		return apierror.Upstream(c, err, "LiteLLM unavailable")
 	}
 	if user == nil {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "User not found"})
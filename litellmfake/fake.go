@@ -0,0 +1,345 @@
+// Package litellmfake is an in-process stand-in for a LiteLLM proxy. It
+// keeps real state for the admin endpoints llmreq calls (users, keys and
+// teams, with masking, expiry, budgets and blocking), charges spend for
+// simulated chat completions and can inject latency, errors and short
+// pages. Tests mount it with httptest.NewServer; `llmreq fake-litellm`
+// serves it for local development.
+//
+// Responses take the shapes of current LiteLLM releases; older shapes are
+// covered by the recorded fixtures in services/testdata/litellm.
+package litellmfake
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	mathrand "math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is what the fake reports as its LiteLLM release.
+const Version = "1.72.0"
+
+// Model is a model the fake serves. Costs are in USD per token.
+type Model struct {
+	Name               string
+	InputCostPerToken  float64
+	OutputCostPerToken float64
+	MaxInputTokens     int
+	MaxOutputTokens    int
+}
+
+// DefaultModels mirrors the mock model of litellm_config.yaml.
+var DefaultModels = []Model{{
+	Name:               "fake-gpt-test",
+	InputCostPerToken:  0.000001,
+	OutputCostPerToken: 0.000002,
+	MaxInputTokens:     16385,
+	MaxOutputTokens:    4096,
+}}
+
+// Faults are injected into every request before it is handled.
+type Faults struct {
+	// Latency delays each response.
+	Latency time.Duration
+	// ErrorRate is the fraction of requests, from 0 to 1, answered with
+	// ErrorStatus instead of being handled.
+	ErrorRate float64
+	// ErrorStatus defaults to 503.
+	ErrorStatus int
+	// MaxPageSize caps the page size of /key/list below LiteLLM's 100, to
+	// exercise pagination with few keys.
+	MaxPageSize int
+	// BareTokens makes /key/list ignore return_full_object and list only
+	// tokens, as some releases do.
+	BareTokens bool
+}
+
+// Server is a fake LiteLLM proxy. Its fields must be set before it serves
+// requests; faults may be changed at any time with SetFaults and FailNext.
+type Server struct {
+	// MasterKey authenticates admin requests. Empty accepts any.
+	MasterKey string
+	Models    []Model
+	// Now is the clock used for expiry and budget resets.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	faults   Faults
+	failNext []int
+	users    map[string]*User
+	keys     map[string]*Key // by token
+	teams    map[string]*Team
+	logs     []SpendLog
+	calls    map[string]int
+	seq      int
+}
+
+// New returns an empty fake serving DefaultModels.
+func New(masterKey string) *Server {
+	return &Server{
+		MasterKey: masterKey,
+		Models:    DefaultModels,
+		Now:       time.Now,
+		users:     make(map[string]*User),
+		keys:      make(map[string]*Key),
+		teams:     make(map[string]*Team),
+		calls:     make(map[string]int),
+	}
+}
+
+// SetFaults replaces the injected faults.
+func (s *Server) SetFaults(f Faults) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.faults = f
+}
+
+// FailNext answers the next n requests with status.
+func (s *Server) FailNext(n, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.failNext = append(s.failNext, status)
+	}
+}
+
+// Calls returns how many requests reached path, faulted or not.
+func (s *Server) Calls(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls[path]
+}
+
+type route struct {
+	method string
+	admin  bool
+	handle func(s *Server, w http.ResponseWriter, r *http.Request)
+}
+
+var routes = map[string]route{
+	"/user/new":            {http.MethodPost, true, (*Server).createUser},
+	"/user/update":         {http.MethodPost, true, (*Server).updateUser},
+	"/key/list":            {http.MethodGet, true, (*Server).listKeys},
+	"/key/info":            {http.MethodGet, true, (*Server).keyInfo},
+	"/key/generate":        {http.MethodPost, true, (*Server).generateKey},
+	"/key/update":          {http.MethodPost, true, (*Server).updateKey},
+	"/key/delete":          {http.MethodPost, true, (*Server).deleteKeys},
+	"/key/block":           {http.MethodPost, true, (*Server).blockKey},
+	"/key/unblock":         {http.MethodPost, true, (*Server).unblockKey},
+	"/team/new":            {http.MethodPost, true, (*Server).createTeam},
+	"/team/info":           {http.MethodGet, true, (*Server).teamInfo},
+	"/team/update":         {http.MethodPost, true, (*Server).updateTeam},
+	"/team/member_add":     {http.MethodPost, true, (*Server).addTeamMember},
+	"/team/member_update":  {http.MethodPost, true, (*Server).updateTeamMember},
+	"/team/member_delete":  {http.MethodPost, true, (*Server).removeTeamMember},
+	"/spend/logs":          {http.MethodGet, true, (*Server).spendLogs},
+	"/model/info":          {http.MethodGet, true, (*Server).modelInfo},
+	"/v1/model/info":       {http.MethodGet, true, (*Server).modelInfo},
+	"/models":              {http.MethodGet, false, (*Server).listModels},
+	"/v1/models":           {http.MethodGet, false, (*Server).listModels},
+	"/chat/completions":    {http.MethodPost, false, (*Server).chatCompletion},
+	"/v1/chat/completions": {http.MethodPost, false, (*Server).chatCompletion},
+	"/health/readiness":    {http.MethodGet, false, (*Server).readiness},
+	"/health/liveliness":   {http.MethodGet, false, (*Server).liveliness},
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+	if strings.HasPrefix(path, "/user/info") {
+		path = "/user/info"
+	}
+
+	s.mu.Lock()
+	s.calls[path]++
+	faults := s.faults
+	status := 0
+	if len(s.failNext) > 0 {
+		status, s.failNext = s.failNext[0], s.failNext[1:]
+	}
+	s.mu.Unlock()
+
+	if faults.Latency > 0 {
+		select {
+		case <-time.After(faults.Latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if status == 0 && faults.ErrorRate > 0 && mathrand.Float64() < faults.ErrorRate {
+		status = faults.ErrorStatus
+		if status == 0 {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if status != 0 {
+		writeError(w, status, "injected_fault", "Injected fault")
+		return
+	}
+
+	if path == "/user/info" {
+		if !s.authorizeAdmin(w, r) {
+			return
+		}
+		s.userInfo(w, r)
+		return
+	}
+	rt, ok := routes[path]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "Not Found")
+		return
+	}
+	if r.Method != rt.method {
+		writeError(w, http.StatusMethodNotAllowed, "method_not_allowed", "Method Not Allowed")
+		return
+	}
+	if rt.admin && !s.authorizeAdmin(w, r) {
+		return
+	}
+	rt.handle(s, w, r)
+}
+
+func bearer(r *http.Request) string {
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+func (s *Server) authorizeAdmin(w http.ResponseWriter, r *http.Request) bool {
+	if s.MasterKey == "" || bearer(r) == s.MasterKey {
+		return true
+	}
+	writeError(w, http.StatusUnauthorized, "auth_error", "Authentication Error, Invalid proxy server token passed")
+	return false
+}
+
+// writeError answers in LiteLLM's error shape.
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    errType,
+			"param":   nil,
+			"code":    strconv.Itoa(status),
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// decode reads a JSON request body into v, answering 400 if it is invalid.
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "bad_request_error", "Invalid request body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// newKey returns a random key in LiteLLM's sk- format.
+func newKey() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return "sk-" + hex.EncodeToString(b)
+}
+
+// hashToken is how LiteLLM stores and lists keys: the hex SHA-256 of the
+// raw key.
+func hashToken(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// maskKey is LiteLLM's key_name: the prefix and last four characters.
+func maskKey(key string) string {
+	if len(key) <= 8 {
+		return key
+	}
+	return "sk-..." + key[len(key)-4:]
+}
+
+// parseDuration reads LiteLLM durations: a number followed by s, m, h, d,
+// w or mo. Like LiteLLM it only looks at the first number and unit, so Go's
+// "1440h0m0s" is 1440 hours.
+func parseDuration(d string) (time.Duration, error) {
+	units := []struct {
+		prefix string
+		unit   time.Duration
+	}{
+		{"mo", 30 * 24 * time.Hour},
+		{"s", time.Second},
+		{"m", time.Minute},
+		{"h", time.Hour},
+		{"d", 24 * time.Hour},
+		{"w", 7 * 24 * time.Hour},
+	}
+	digits := len(d) - len(strings.TrimLeft(d, "0123456789"))
+	if count, err := strconv.Atoi(d[:digits]); err == nil {
+		for _, u := range units {
+			if strings.HasPrefix(d[digits:], u.prefix) {
+				return time.Duration(count) * u.unit, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("invalid duration %q", d)
+}
+
+// budget is the spend limit shared by users, keys and teams. A set
+// Duration resets Spend every period.
+type budget struct {
+	Spend     float64
+	MaxBudget *float64
+	Duration  string
+	ResetAt   *time.Time
+}
+
+// reset zeroes the spend once the budget period is over.
+func (b *budget) reset(now time.Time) {
+	if b.ResetAt == nil || now.Before(*b.ResetAt) {
+		return
+	}
+	b.Spend = 0
+	b.schedule(now)
+}
+
+// schedule sets when the current budget period ends.
+func (b *budget) schedule(now time.Time) {
+	b.ResetAt = nil
+	if d, err := parseDuration(b.Duration); err == nil && d > 0 {
+		at := now.Add(d)
+		b.ResetAt = &at
+	}
+}
+
+func (b *budget) exceeded() bool {
+	return b.MaxBudget != nil && b.Spend >= *b.MaxBudget
+}
+
+func timeOrNil(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func orEmpty(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
+
+func stringOrNil(v string) interface{} {
+	if v == "" {
+		return nil
+	}
+	return v
+}
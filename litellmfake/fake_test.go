@@ -0,0 +1,210 @@
+package litellmfake_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
+	"github.com/example/llmreq/services"
+	"gopkg.in/yaml.v2"
+)
+
+func newService(t *testing.T, fake *litellmfake.Server) *services.LiteLLMService {
+	srv := httptest.NewServer(fake)
+	t.Cleanup(srv.Close)
+	return &services.LiteLLMService{BaseURL: srv.URL, MasterKey: config.NewSecret("sk-master"), Client: srv.Client()}
+}
+
+// complete posts a chat completion with key and returns the status.
+func complete(t *testing.T, svc *services.LiteLLMService, key string) int {
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":    "fake-gpt-test",
+		"messages": []map[string]string{{"role": "user", "content": "say hello"}},
+	})
+	req, _ := http.NewRequest(http.MethodPost, svc.BaseURL+"/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+key)
+	resp, err := svc.Client.Do(req)
+	if err != nil {
+		t.Fatalf("completion failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestKeys(t *testing.T) {
+	ctx := context.Background()
+	fake := litellmfake.New("sk-master")
+	svc := newService(t, fake)
+
+	created, err := svc.GenerateKey(ctx, services.GenerateKeyRequest{UserID: "alice", KeyAlias: "laptop", MaxBudget: 10})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	if !strings.HasPrefix(created.Key, "sk-") || created.KeyName != "sk-..."+created.Key[len(created.Key)-4:] {
+		t.Errorf("Expected a raw key and its masked name, got %+v", created)
+	}
+
+	keys, err := svc.ListKeys(ctx, "alice")
+	if err != nil || len(keys) != 1 {
+		t.Fatalf("Expected alice's key to be listed, got %+v, %v", keys, err)
+	}
+	if keys[0].Key == created.Key || keys[0].KeyAlias != "laptop" || keys[0].MaxBudget != 10 {
+		t.Errorf("Expected the listing to hide the raw key, got %+v", keys[0])
+	}
+	if user, err := svc.GetUserInfo(ctx, "alice"); err != nil || user.UserID != "alice" {
+		t.Errorf("Expected the key's user to be created, got %+v, %v", user, err)
+	}
+
+	if err := svc.BlockKey(ctx, keys[0].Key); err != nil {
+		t.Fatalf("BlockKey failed: %v", err)
+	}
+	if status := complete(t, svc, created.Key); status != http.StatusUnauthorized {
+		t.Errorf("Expected a blocked key to be refused, got %d", status)
+	}
+	if err := svc.UnblockKey(ctx, keys[0].Key); err != nil {
+		t.Fatalf("UnblockKey failed: %v", err)
+	}
+	if status := complete(t, svc, created.Key); status != http.StatusOK {
+		t.Errorf("Expected an unblocked key to work, got %d", status)
+	}
+
+	if err := svc.DeleteKey(ctx, created.Key); err != nil {
+		t.Fatalf("DeleteKey failed: %v", err)
+	}
+	if keys, _ := svc.ListKeys(ctx, "alice"); len(keys) != 0 {
+		t.Errorf("Expected no keys after deletion, got %+v", keys)
+	}
+	if err := svc.DeleteKey(ctx, created.Key); !errors.Is(err, services.ErrNotFound) {
+		t.Errorf("Expected deleting a missing key to be not found, got %v", err)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	fake := litellmfake.New("sk-master")
+	fake.SetFaults(litellmfake.Faults{MaxPageSize: 2})
+	for i := 0; i < 5; i++ {
+		fake.AddKey(litellmfake.Key{UserID: "alice"})
+	}
+	fake.AddKey(litellmfake.Key{UserID: "bob"})
+
+	keys, err := newService(t, fake).ListKeys(context.Background(), "alice")
+	if err != nil || len(keys) != 5 {
+		t.Fatalf("Expected all 5 keys over 3 pages, got %d, %v", len(keys), err)
+	}
+	if calls := fake.Calls("/key/list"); calls != 3 {
+		t.Errorf("Expected 3 page requests, got %d", calls)
+	}
+}
+
+func TestExpiryAndBudgets(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := litellmfake.New("sk-master")
+	fake.Now = func() time.Time { return now }
+	svc := newService(t, fake)
+
+	// Durations come in Go's format, which LiteLLM reads as far as the
+	// first unit.
+	created, err := svc.GenerateKey(context.Background(), services.GenerateKeyRequest{UserID: "alice", Duration: "1h0m0s", MaxBudget: 0.000003})
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	// 2 prompt words and 4 response words cost 2e-6 + 8e-6.
+	if status := complete(t, svc, created.Key); status != http.StatusOK {
+		t.Fatalf("Expected the first completion to pass, got %d", status)
+	}
+	key, _ := fake.Key(created.Key)
+	user, _ := fake.User("alice")
+	if math.Abs(key.Spend-0.00001) > 1e-12 || user.Spend != key.Spend {
+		t.Errorf("Expected the key and user to be charged 1e-5, got %v and %v", key.Spend, user.Spend)
+	}
+	if logs := fake.SpendLogs(); len(logs) != 1 || logs[0].APIKey != key.Token || logs[0].UserID != "alice" {
+		t.Errorf("Expected a spend log for the key, got %+v", logs)
+	}
+	if status := complete(t, svc, created.Key); status != http.StatusBadRequest {
+		t.Errorf("Expected an exhausted budget to be refused, got %d", status)
+	}
+
+	now = now.Add(2 * time.Hour)
+	if status := complete(t, svc, created.Key); status != http.StatusUnauthorized {
+		t.Errorf("Expected an expired key to be refused, got %d", status)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	ctx := context.Background()
+	fake := litellmfake.New("sk-master")
+	svc := newService(t, fake)
+
+	fake.FailNext(1, http.StatusBadGateway)
+	if err := svc.Ping(ctx); !errors.Is(err, services.ErrUnavailable) {
+		t.Errorf("Expected the injected 502, got %v", err)
+	}
+	if err := svc.Ping(ctx); err != nil {
+		t.Errorf("Expected only one injected failure, got %v", err)
+	}
+
+	fake.SetFaults(litellmfake.Faults{ErrorRate: 1})
+	if err := svc.Ping(ctx); !errors.Is(err, services.ErrUnavailable) {
+		t.Errorf("Expected every request to fail, got %v", err)
+	}
+	fake.SetFaults(litellmfake.Faults{})
+
+	svc.MasterKey = config.NewSecret("sk-wrong")
+	if err := svc.Ping(ctx); !errors.Is(err, services.ErrUnauthorized) {
+		t.Errorf("Expected a wrong master key to be refused, got %v", err)
+	}
+	if version, err := svc.DetectVersion(ctx); err != nil || version != litellmfake.Version {
+		t.Errorf("Expected version %s, got %q, %v", litellmfake.Version, version, err)
+	}
+}
+
+// DefaultModels must describe the mock model the compose stack's LiteLLM
+// serves, so tests against the fake expect what a real proxy reports.
+func TestDefaultModelsMatchConfig(t *testing.T) {
+	data, err := os.ReadFile("../litellm_config.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg struct {
+		ModelList []struct {
+			ModelName     string `yaml:"model_name"`
+			LiteLLMParams struct {
+				InputCostPerToken  float64 `yaml:"input_cost_per_token"`
+				OutputCostPerToken float64 `yaml:"output_cost_per_token"`
+			} `yaml:"litellm_params"`
+			ModelInfo struct {
+				MaxInputTokens  int `yaml:"max_input_tokens"`
+				MaxOutputTokens int `yaml:"max_output_tokens"`
+			} `yaml:"model_info"`
+		} `yaml:"model_list"`
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	var models []litellmfake.Model
+	for _, m := range cfg.ModelList {
+		models = append(models, litellmfake.Model{
+			Name:               m.ModelName,
+			InputCostPerToken:  m.LiteLLMParams.InputCostPerToken,
+			OutputCostPerToken: m.LiteLLMParams.OutputCostPerToken,
+			MaxInputTokens:     m.ModelInfo.MaxInputTokens,
+			MaxOutputTokens:    m.ModelInfo.MaxOutputTokens,
+		})
+	}
+	if !reflect.DeepEqual(models, litellmfake.DefaultModels) {
+		t.Errorf("litellm_config.yaml describes %+v, DefaultModels %+v", models, litellmfake.DefaultModels)
+	}
+}
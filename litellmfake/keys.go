@@ -0,0 +1,334 @@
+package litellmfake
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Key is a LiteLLM virtual key. Only its hash, Token, identifies it after
+// generation; Key keeps the raw value for tests.
+type Key struct {
+	Key      string
+	Token    string
+	KeyAlias string
+	UserID   string
+	TeamID   string
+	Models   []string
+	Metadata map[string]interface{}
+	Expires  *time.Time
+	Blocked  bool
+	budget
+	CreatedAt time.Time
+}
+
+// Key returns a copy of the key with the given raw value or token.
+func (s *Server) Key(keyOrToken string) (Key, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.lookupKey(keyOrToken)
+	if k == nil {
+		return Key{}, false
+	}
+	k.reset(s.Now())
+	return *k, true
+}
+
+// lookupKey finds a key by raw value or token, as LiteLLM's key endpoints
+// accept either. The caller holds s.mu.
+func (s *Server) lookupKey(keyOrToken string) *Key {
+	if k, ok := s.keys[keyOrToken]; ok {
+		return k
+	}
+	return s.keys[hashToken(keyOrToken)]
+}
+
+// sortedKeys returns every key by creation. The caller holds s.mu.
+func (s *Server) sortedKeys() []*Key {
+	keys := make([]*Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].Token < keys[j].Token
+	})
+	return keys
+}
+
+// keyJSON is a key as /key/list returns it with return_full_object. The
+// caller holds s.mu.
+func (s *Server) keyJSON(k *Key) map[string]interface{} {
+	k.reset(s.Now())
+	metadata := k.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return map[string]interface{}{
+		"token":           k.Token,
+		"key_name":        maskKey(k.Key),
+		"key_alias":       stringOrNil(k.KeyAlias),
+		"spend":           k.Spend,
+		"max_budget":      k.MaxBudget,
+		"budget_duration": stringOrNil(k.Duration),
+		"budget_reset_at": timeOrNil(k.ResetAt),
+		"expires":         timeOrNil(k.Expires),
+		"models":          orEmpty(k.Models),
+		"user_id":         stringOrNil(k.UserID),
+		"team_id":         stringOrNil(k.TeamID),
+		"metadata":        metadata,
+		"blocked":         k.Blocked,
+		"created_at":      k.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// listKeys pages through the keys matching user_id, team_id and key_alias,
+// oldest first.
+func (s *Server) listKeys(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	page, _ := strconv.Atoi(q.Get("page"))
+	if page < 1 {
+		page = 1
+	}
+	size, _ := strconv.Atoi(q.Get("size"))
+	if size < 1 {
+		size = 10
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size = min(size, 100)
+	if s.faults.MaxPageSize > 0 {
+		size = min(size, s.faults.MaxPageSize)
+	}
+
+	var matched []*Key
+	for _, k := range s.sortedKeys() {
+		if (q.Get("user_id") == "" || k.UserID == q.Get("user_id")) &&
+			(q.Get("team_id") == "" || k.TeamID == q.Get("team_id")) &&
+			(q.Get("key_alias") == "" || k.KeyAlias == q.Get("key_alias")) {
+			matched = append(matched, k)
+		}
+	}
+
+	keys := []interface{}{}
+	for i := (page - 1) * size; i < len(matched) && i < page*size; i++ {
+		if q.Get("return_full_object") == "true" && !s.faults.BareTokens {
+			keys = append(keys, s.keyJSON(matched[i]))
+		} else {
+			keys = append(keys, matched[i].Token)
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys":         keys,
+		"total_count":  len(matched),
+		"current_page": page,
+		"total_pages":  (len(matched) + size - 1) / size,
+	})
+}
+
+func (s *Server) keyInfo(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.lookupKey(r.URL.Query().Get("key"))
+	if k == nil {
+		writeError(w, http.StatusNotFound, "not_found_error", "Key not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": k.Token, "info": s.keyJSON(k)})
+}
+
+type generateKeyRequest struct {
+	Key            string                 `json:"key"`
+	UserID         string                 `json:"user_id"`
+	TeamID         string                 `json:"team_id"`
+	KeyAlias       string                 `json:"key_alias"`
+	MaxBudget      *float64               `json:"max_budget"`
+	BudgetDuration string                 `json:"budget_duration"`
+	Duration       string                 `json:"duration"`
+	Models         []string               `json:"models"`
+	Metadata       map[string]interface{} `json:"metadata"`
+}
+
+// AddKey stores a key as /key/generate would and returns it with its raw
+// value, for seeding state without going through HTTP.
+func (s *Server) AddKey(k Key) Key {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *s.addKey(k)
+}
+
+// addKey fills in the key, token and creation time and stores k. The caller
+// holds s.mu.
+func (s *Server) addKey(k Key) *Key {
+	if k.Key == "" {
+		k.Key = newKey()
+	}
+	k.Token = hashToken(k.Key)
+	if k.CreatedAt.IsZero() {
+		k.CreatedAt = s.Now()
+	}
+	if k.ResetAt == nil {
+		k.schedule(k.CreatedAt)
+	}
+	if k.UserID != "" {
+		s.user(k.UserID)
+	}
+	s.keys[k.Token] = &k
+	return &k
+}
+
+func (s *Server) generateKey(w http.ResponseWriter, r *http.Request) {
+	var req generateKeyRequest
+	if !decode(w, r, &req) {
+		return
+	}
+	var expires *time.Time
+	if req.Duration != "" {
+		d, err := parseDuration(req.Duration)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "bad_request_error", err.Error())
+			return
+		}
+		at := s.Now().Add(d)
+		expires = &at
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.TeamID != "" && s.teams[req.TeamID] == nil {
+		writeError(w, http.StatusBadRequest, "bad_request_error", "Team not found, team_id="+req.TeamID)
+		return
+	}
+	if req.Key != "" && s.lookupKey(req.Key) != nil {
+		writeError(w, http.StatusBadRequest, "bad_request_error", "Key already exists")
+		return
+	}
+
+	k := s.addKey(Key{
+		Key:      req.Key,
+		KeyAlias: req.KeyAlias,
+		UserID:   req.UserID,
+		TeamID:   req.TeamID,
+		Models:   req.Models,
+		Metadata: req.Metadata,
+		Expires:  expires,
+		budget:   budget{MaxBudget: req.MaxBudget, Duration: req.BudgetDuration},
+	})
+	resp := s.keyJSON(k)
+	resp["key"] = k.Key
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// updateKey overwrites only the fields present in the request. A duration
+// moves the expiry to that long from now.
+func (s *Server) updateKey(w http.ResponseWriter, r *http.Request) {
+	var req map[string]json.RawMessage
+	if !decode(w, r, &req) {
+		return
+	}
+	var id string
+	_ = json.Unmarshal(req["key"], &id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.lookupKey(id)
+	if k == nil {
+		writeError(w, http.StatusNotFound, "not_found_error", "Key not found")
+		return
+	}
+	if raw, ok := req["duration"]; ok {
+		var duration string
+		_ = json.Unmarshal(raw, &duration)
+		var expires *time.Time
+		if duration != "" {
+			d, err := parseDuration(duration)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, "bad_request_error", err.Error())
+				return
+			}
+			at := s.Now().Add(d)
+			expires = &at
+		}
+		k.Expires = expires
+	}
+	if raw, ok := req["user_id"]; ok {
+		_ = json.Unmarshal(raw, &k.UserID)
+		if k.UserID != "" {
+			s.user(k.UserID)
+		}
+	}
+	if raw, ok := req["key_alias"]; ok {
+		_ = json.Unmarshal(raw, &k.KeyAlias)
+	}
+	if raw, ok := req["max_budget"]; ok {
+		k.MaxBudget = nil
+		_ = json.Unmarshal(raw, &k.MaxBudget)
+	}
+	if raw, ok := req["models"]; ok {
+		k.Models = nil
+		_ = json.Unmarshal(raw, &k.Models)
+	}
+	if raw, ok := req["metadata"]; ok {
+		k.Metadata = nil
+		_ = json.Unmarshal(raw, &k.Metadata)
+	}
+	writeJSON(w, http.StatusOK, s.keyJSON(k))
+}
+
+// deleteKeys deletes all of the listed keys or, if one is unknown, none.
+func (s *Server) deleteKeys(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Keys []string `json:"keys"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found []*Key
+	for _, id := range req.Keys {
+		k := s.lookupKey(id)
+		if k == nil {
+			writeError(w, http.StatusNotFound, "not_found_error", "Key not found: "+id)
+			return
+		}
+		found = append(found, k)
+	}
+	for _, k := range found {
+		delete(s.keys, k.Token)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"deleted_keys": req.Keys})
+}
+
+func (s *Server) blockKey(w http.ResponseWriter, r *http.Request) {
+	s.setBlocked(w, r, true)
+}
+
+func (s *Server) unblockKey(w http.ResponseWriter, r *http.Request) {
+	s.setBlocked(w, r, false)
+}
+
+func (s *Server) setBlocked(w http.ResponseWriter, r *http.Request, blocked bool) {
+	var req struct {
+		Key string `json:"key"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k := s.lookupKey(req.Key)
+	if k == nil {
+		writeError(w, http.StatusNotFound, "not_found_error", "Key not found")
+		return
+	}
+	k.Blocked = blocked
+	writeJSON(w, http.StatusOK, s.keyJSON(k))
+}
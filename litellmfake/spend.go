@@ -0,0 +1,248 @@
+package litellmfake
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SpendLog records the cost of one simulated completion.
+type SpendLog struct {
+	RequestID        string    `json:"request_id"`
+	APIKey           string    `json:"api_key"` // the key's token
+	UserID           string    `json:"user"`
+	TeamID           string    `json:"team_id"`
+	Model            string    `json:"model"`
+	PromptTokens     int       `json:"prompt_tokens"`
+	CompletionTokens int       `json:"completion_tokens"`
+	Spend            float64   `json:"spend"`
+	StartTime        time.Time `json:"startTime"`
+	Response         string    `json:"-"`
+}
+
+// defaultMockResponse is the reply of litellm_config.yaml's mock model.
+const defaultMockResponse = "Hello from mock provider"
+
+// Complete simulates a chat completion made with key and returns what it
+// cost, as POST /chat/completions would, without going through HTTP.
+func (s *Server) Complete(key, model, prompt string) (float64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log, status, err := s.complete(key, model, prompt, "")
+	if err != nil {
+		return 0, fmt.Errorf("%d: %v", status, err)
+	}
+	return log.Spend, nil
+}
+
+// complete checks key may call model and charges it, its user and its team
+// for the tokens. It returns the HTTP status and error LiteLLM would answer
+// with on refusal. The caller holds s.mu.
+func (s *Server) complete(key, model, prompt, response string) (*SpendLog, int, error) {
+	now := s.Now()
+	k := s.lookupKey(key)
+	switch {
+	case k == nil && (s.MasterKey == "" || key != s.MasterKey):
+		return nil, http.StatusUnauthorized, fmt.Errorf("Authentication Error, Invalid proxy server token passed")
+	case k != nil && k.Blocked:
+		return nil, http.StatusUnauthorized, fmt.Errorf("Authentication Error, Key is blocked")
+	case k != nil && k.Expires != nil && !now.Before(*k.Expires):
+		return nil, http.StatusUnauthorized, fmt.Errorf("Authentication Error - Expired Key")
+	}
+
+	var m *Model
+	for i := range s.Models {
+		if s.Models[i].Name == model {
+			m = &s.Models[i]
+		}
+	}
+	if m == nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("Invalid model name passed in model=%s", model)
+	}
+
+	// Budgets that apply, most specific first.
+	var budgets []*budget
+	if k != nil {
+		var allowed []string
+		budgets = append(budgets, &k.budget)
+		allowed = k.Models
+		if t := s.teams[k.TeamID]; t != nil {
+			budgets = append(budgets, &t.budget)
+			if len(allowed) == 0 {
+				allowed = t.Models
+			}
+		} else if u := s.users[k.UserID]; u != nil {
+			budgets = append(budgets, &u.budget)
+			if len(allowed) == 0 {
+				allowed = u.Models
+			}
+		}
+		if !allows(allowed, model) {
+			return nil, http.StatusUnauthorized, fmt.Errorf("Authentication Error, API Key not allowed to access model. This token can only access models=%v. Tried to access %s", allowed, model)
+		}
+	}
+	for _, b := range budgets {
+		b.reset(now)
+		if b.exceeded() {
+			return nil, http.StatusBadRequest, fmt.Errorf("Budget has been exceeded! Current cost: %v, Max budget: %v", b.Spend, *b.MaxBudget)
+		}
+	}
+
+	if response == "" {
+		response = defaultMockResponse
+	}
+	s.seq++
+	log := SpendLog{
+		RequestID:        fmt.Sprintf("chatcmpl-fake-%d", s.seq),
+		Model:            model,
+		PromptTokens:     max(len(strings.Fields(prompt)), 1),
+		CompletionTokens: len(strings.Fields(response)),
+		StartTime:        now,
+		Response:         response,
+	}
+	log.Spend = float64(log.PromptTokens)*m.InputCostPerToken + float64(log.CompletionTokens)*m.OutputCostPerToken
+	for _, b := range budgets {
+		b.Spend += log.Spend
+	}
+	if k != nil {
+		log.APIKey, log.UserID, log.TeamID = k.Token, k.UserID, k.TeamID
+	}
+	s.logs = append(s.logs, log)
+	return &s.logs[len(s.logs)-1], http.StatusOK, nil
+}
+
+// allows reports whether a models list permits model; an empty list allows
+// all of them.
+func allows(allowed []string, model string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if a == model || a == "all-proxy-models" || (strings.HasSuffix(a, "*") && strings.HasPrefix(model, strings.TrimSuffix(a, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// chatCompletion answers with the request's mock_response, or the mock
+// model's, and charges the tokens to the calling key. Tokens are counted as
+// words.
+func (s *Server) chatCompletion(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Model    string `json:"model"`
+		Messages []struct {
+			Role    string `json:"role"`
+			Content string `json:"content"`
+		} `json:"messages"`
+		MockResponse string `json:"mock_response"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	var prompt []string
+	for _, m := range req.Messages {
+		prompt = append(prompt, m.Content)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	log, status, err := s.complete(bearer(r), req.Model, strings.Join(prompt, " "), req.MockResponse)
+	if err != nil {
+		errType := "auth_error"
+		switch {
+		case strings.HasPrefix(err.Error(), "Budget"):
+			errType = "budget_exceeded"
+		case status == http.StatusBadRequest:
+			errType = "bad_request_error"
+		}
+		writeError(w, status, errType, err.Error())
+		return
+	}
+	w.Header().Set("x-litellm-response-cost", fmt.Sprint(log.Spend))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":      log.RequestID,
+		"object":  "chat.completion",
+		"created": log.StartTime.Unix(),
+		"model":   req.Model,
+		"choices": []interface{}{map[string]interface{}{
+			"index":         0,
+			"finish_reason": "stop",
+			"message":       map[string]string{"role": "assistant", "content": log.Response},
+		}},
+		"usage": map[string]int{
+			"prompt_tokens":     log.PromptTokens,
+			"completion_tokens": log.CompletionTokens,
+			"total_tokens":      log.PromptTokens + log.CompletionTokens,
+		},
+	})
+}
+
+// SpendLogs returns every recorded completion, oldest first.
+func (s *Server) SpendLogs() []SpendLog {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]SpendLog(nil), s.logs...)
+}
+
+// spendLogs filters the logs by api_key (raw or token), user_id and
+// request_id.
+func (s *Server) spendLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token := q.Get("api_key")
+	if k := s.lookupKey(token); k != nil {
+		token = k.Token
+	}
+	logs := []SpendLog{}
+	for _, l := range s.logs {
+		if (token == "" || l.APIKey == token) &&
+			(q.Get("user_id") == "" || l.UserID == q.Get("user_id")) &&
+			(q.Get("request_id") == "" || l.RequestID == q.Get("request_id")) {
+			logs = append(logs, l)
+		}
+	}
+	writeJSON(w, http.StatusOK, logs)
+}
+
+func (s *Server) modelInfo(w http.ResponseWriter, r *http.Request) {
+	data := make([]interface{}, 0, len(s.Models))
+	for i, m := range s.Models {
+		data = append(data, map[string]interface{}{
+			"model_name": m.Name,
+			"litellm_params": map[string]interface{}{
+				"model":                 "openai/" + m.Name,
+				"input_cost_per_token":  m.InputCostPerToken,
+				"output_cost_per_token": m.OutputCostPerToken,
+			},
+			"model_info": map[string]interface{}{
+				"id":                    fmt.Sprintf("fake-model-%d", i+1),
+				"mode":                  "chat",
+				"input_cost_per_token":  m.InputCostPerToken,
+				"output_cost_per_token": m.OutputCostPerToken,
+				"max_input_tokens":      m.MaxInputTokens,
+				"max_output_tokens":     m.MaxOutputTokens,
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data})
+}
+
+func (s *Server) listModels(w http.ResponseWriter, r *http.Request) {
+	data := make([]interface{}, 0, len(s.Models))
+	for _, m := range s.Models {
+		data = append(data, map[string]interface{}{"id": m.Name, "object": "model", "created": 1677610602, "owned_by": "openai"})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+func (s *Server) readiness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"status": "healthy", "db": "connected", "litellm_version": Version})
+}
+
+func (s *Server) liveliness(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, "I'm alive!")
+}
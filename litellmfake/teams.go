@@ -0,0 +1,253 @@
+package litellmfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Team is a LiteLLM team.
+type Team struct {
+	ID      string
+	Alias   string
+	Models  []string
+	Members []Member
+	budget
+	CreatedAt time.Time
+}
+
+type Member struct {
+	UserID    string `json:"user_id"`
+	UserEmail string `json:"user_email,omitempty"`
+	Role      string `json:"role"`
+}
+
+func (t *Team) member(userID string) int {
+	for i, m := range t.Members {
+		if m.UserID == userID {
+			return i
+		}
+	}
+	return -1
+}
+
+func (t *Team) json() map[string]interface{} {
+	members := t.Members
+	if members == nil {
+		members = []Member{}
+	}
+	return map[string]interface{}{
+		"team_id":            t.ID,
+		"team_alias":         stringOrNil(t.Alias),
+		"max_budget":         t.MaxBudget,
+		"spend":              t.Spend,
+		"budget_duration":    stringOrNil(t.Duration),
+		"budget_reset_at":    timeOrNil(t.ResetAt),
+		"models":             orEmpty(t.Models),
+		"members_with_roles": members,
+		"blocked":            false,
+		"metadata":           map[string]interface{}{},
+	}
+}
+
+// Team returns a copy of the team with id.
+func (s *Server) Team(id string) (Team, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.teams[id]
+	if !ok {
+		return Team{}, false
+	}
+	t.reset(s.Now())
+	return *t, true
+}
+
+// AddTeam stores a team as /team/new would and returns it, for seeding
+// state without going through HTTP.
+func (s *Server) AddTeam(t Team) Team {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.CreatedAt.IsZero() {
+		t.CreatedAt = s.Now()
+	}
+	if t.ResetAt == nil {
+		t.schedule(t.CreatedAt)
+	}
+	for _, m := range t.Members {
+		s.user(m.UserID)
+	}
+	s.teams[t.ID] = &t
+	return t
+}
+
+func (s *Server) createTeam(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TeamID         string   `json:"team_id"`
+		TeamAlias      string   `json:"team_alias"`
+		Models         []string `json:"models"`
+		MaxBudget      *float64 `json:"max_budget"`
+		BudgetDuration string   `json:"budget_duration"`
+		Members        []Member `json:"members_with_roles"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if req.TeamID == "" {
+		s.seq++
+		req.TeamID = fmt.Sprintf("team-%d", s.seq)
+	}
+	if _, ok := s.teams[req.TeamID]; ok {
+		writeError(w, http.StatusBadRequest, "bad_request_error", "Team already exists, team_id="+req.TeamID)
+		return
+	}
+	t := &Team{ID: req.TeamID, Alias: req.TeamAlias, Models: req.Models, Members: req.Members, CreatedAt: s.Now()}
+	t.MaxBudget, t.Duration = req.MaxBudget, req.BudgetDuration
+	t.schedule(s.Now())
+	for _, m := range t.Members {
+		s.user(m.UserID)
+	}
+	s.teams[t.ID] = t
+	writeJSON(w, http.StatusOK, t.json())
+}
+
+func (s *Server) teamInfo(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("team_id")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.teams[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found_error", "Team not found, team_id="+id)
+		return
+	}
+	t.reset(s.Now())
+	keys := []interface{}{}
+	for _, k := range s.sortedKeys() {
+		if k.TeamID == id {
+			keys = append(keys, s.keyJSON(k))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"team_id": id, "team_info": t.json(), "keys": keys})
+}
+
+// updateTeam overwrites only the fields present in the request.
+func (s *Server) updateTeam(w http.ResponseWriter, r *http.Request) {
+	var req map[string]json.RawMessage
+	if !decode(w, r, &req) {
+		return
+	}
+	var id string
+	_ = json.Unmarshal(req["team_id"], &id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.teams[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found_error", "Team not found, team_id="+id)
+		return
+	}
+	if raw, ok := req["team_alias"]; ok {
+		_ = json.Unmarshal(raw, &t.Alias)
+	}
+	if raw, ok := req["models"]; ok {
+		t.Models = nil
+		_ = json.Unmarshal(raw, &t.Models)
+	}
+	if raw, ok := req["max_budget"]; ok {
+		t.MaxBudget = nil
+		_ = json.Unmarshal(raw, &t.MaxBudget)
+	}
+	if raw, ok := req["budget_duration"]; ok {
+		t.Duration = ""
+		_ = json.Unmarshal(raw, &t.Duration)
+		t.schedule(s.Now())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"team_id": id, "data": t.json()})
+}
+
+func (s *Server) addTeamMember(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TeamID string `json:"team_id"`
+		Member Member `json:"member"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.teams[req.TeamID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found_error", "Team not found, team_id="+req.TeamID)
+		return
+	}
+	if t.member(req.Member.UserID) >= 0 {
+		writeError(w, http.StatusBadRequest, "bad_request_error", "User already in team, user_id="+req.Member.UserID)
+		return
+	}
+	if req.Member.Role == "" {
+		req.Member.Role = "user"
+	}
+	t.Members = append(t.Members, req.Member)
+	s.user(req.Member.UserID)
+	writeJSON(w, http.StatusOK, t.json())
+}
+
+type teamMemberRequest struct {
+	TeamID string `json:"team_id"`
+	UserID string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// teamMember finds the team and member index of req, answering 404 if
+// either is missing. The caller holds s.mu.
+func (s *Server) teamMember(w http.ResponseWriter, req teamMemberRequest) (*Team, int) {
+	t, ok := s.teams[req.TeamID]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found_error", "Team not found, team_id="+req.TeamID)
+		return nil, -1
+	}
+	i := t.member(req.UserID)
+	if i < 0 {
+		writeError(w, http.StatusNotFound, "not_found_error", "User not in team, user_id="+req.UserID)
+	}
+	return t, i
+}
+
+func (s *Server) updateTeamMember(w http.ResponseWriter, r *http.Request) {
+	var req teamMemberRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, i := s.teamMember(w, req)
+	if i < 0 {
+		return
+	}
+	if req.Role != "" {
+		t.Members[i].Role = req.Role
+	}
+	writeJSON(w, http.StatusOK, t.Members[i])
+}
+
+func (s *Server) removeTeamMember(w http.ResponseWriter, r *http.Request) {
+	var req teamMemberRequest
+	if !decode(w, r, &req) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, i := s.teamMember(w, req)
+	if i < 0 {
+		return
+	}
+	t.Members = append(t.Members[:i], t.Members[i+1:]...)
+	writeJSON(w, http.StatusOK, t.json())
+}
@@ -0,0 +1,174 @@
+package litellmfake
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// User is a LiteLLM internal user.
+type User struct {
+	ID     string
+	Email  string
+	Models []string
+	budget
+	CreatedAt time.Time
+}
+
+func (u *User) json() map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":         u.ID,
+		"user_email":      stringOrNil(u.Email),
+		"user_role":       "internal_user",
+		"max_budget":      u.MaxBudget,
+		"spend":           u.Spend,
+		"budget_duration": stringOrNil(u.Duration),
+		"budget_reset_at": timeOrNil(u.ResetAt),
+		"models":          orEmpty(u.Models),
+		"metadata":        map[string]interface{}{},
+	}
+}
+
+// User returns a copy of the user with id.
+func (s *Server) User(id string) (User, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		return User{}, false
+	}
+	u.reset(s.Now())
+	return *u, true
+}
+
+// AddUser stores a user as /user/new would and returns it, for seeding
+// state without going through HTTP.
+func (s *Server) AddUser(u User) User {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if u.CreatedAt.IsZero() {
+		u.CreatedAt = s.Now()
+	}
+	if u.ResetAt == nil {
+		u.schedule(u.CreatedAt)
+	}
+	s.users[u.ID] = &u
+	return u
+}
+
+// user returns the user with id, creating it as LiteLLM does when a key is
+// generated for an unknown user. The caller holds s.mu.
+func (s *Server) user(id string) *User {
+	u, ok := s.users[id]
+	if !ok {
+		u = &User{ID: id, CreatedAt: s.Now()}
+		s.users[id] = u
+	}
+	u.reset(s.Now())
+	return u
+}
+
+// userInfo answers an unknown user with a null user_info, as current
+// releases do.
+func (s *Server) userInfo(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/user/info"), "/")
+	if id == "" {
+		id = r.URL.Query().Get("user_id")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	resp := map[string]interface{}{"user_id": id, "user_info": nil, "keys": []interface{}{}, "teams": []interface{}{}}
+	u, ok := s.users[id]
+	if !ok {
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+	u.reset(s.Now())
+	resp["user_info"] = u.json()
+	var keys []map[string]interface{}
+	for _, k := range s.sortedKeys() {
+		if k.UserID == id && k.TeamID == "" {
+			keys = append(keys, s.keyJSON(k))
+		}
+	}
+	if keys != nil {
+		resp["keys"] = keys
+	}
+	var teams []string
+	for _, t := range s.teams {
+		if t.member(id) >= 0 {
+			teams = append(teams, t.ID)
+		}
+	}
+	sort.Strings(teams)
+	resp["teams"] = orEmpty(teams)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		UserID         string   `json:"user_id"`
+		UserEmail      string   `json:"user_email"`
+		MaxBudget      *float64 `json:"max_budget"`
+		BudgetDuration string   `json:"budget_duration"`
+		Models         []string `json:"models"`
+	}
+	if !decode(w, r, &req) {
+		return
+	}
+	if req.UserID == "" {
+		writeError(w, http.StatusBadRequest, "bad_request_error", "user_id is required")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[req.UserID]; ok {
+		writeError(w, http.StatusBadRequest, "bad_request_error", "User already exists")
+		return
+	}
+	u := &User{ID: req.UserID, Email: req.UserEmail, Models: req.Models, CreatedAt: s.Now()}
+	u.MaxBudget, u.Duration = req.MaxBudget, req.BudgetDuration
+	u.schedule(s.Now())
+	s.users[u.ID] = u
+	writeJSON(w, http.StatusOK, u.json())
+}
+
+// updateUser overwrites only the fields present in the request; a null
+// max_budget or budget_duration clears the limit.
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	var req map[string]json.RawMessage
+	if !decode(w, r, &req) {
+		return
+	}
+	var id string
+	_ = json.Unmarshal(req["user_id"], &id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.users[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found_error", "User not found, user_id="+id)
+		return
+	}
+	if raw, ok := req["max_budget"]; ok {
+		u.MaxBudget = nil
+		_ = json.Unmarshal(raw, &u.MaxBudget)
+	}
+	if raw, ok := req["budget_duration"]; ok {
+		u.Duration = ""
+		_ = json.Unmarshal(raw, &u.Duration)
+		u.schedule(s.Now())
+	}
+	if raw, ok := req["models"]; ok {
+		u.Models = nil
+		_ = json.Unmarshal(raw, &u.Models)
+	}
+	if raw, ok := req["user_email"]; ok {
+		_ = json.Unmarshal(raw, &u.Email)
+	}
+	writeJSON(w, http.StatusOK, u.json())
+}
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"expvar"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/example/llmreq/audit"
 	"github.com/example/llmreq/config"
 	"github.com/example/llmreq/docs"
 	"github.com/example/llmreq/handlers"
@@ -22,34 +32,109 @@ import (
 
 //go:generate swag init
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "fake-litellm" {
+		runFakeLiteLLM(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "capture-litellm" {
+		runCaptureLiteLLM(os.Args[2:])
+		return
+	}
+
 	// 1. Load Config
 	config.LoadConfig()
 
 	// 2. Initialize DB
 	models.InitDB(config.AppConfig.DatabaseURL)
 
+	// Audit sinks must be configured before anything creates an audit.Logger.
+	stream, err := audit.NewStreamFromConfig()
+	if err != nil {
+		log.Fatalf("Failed to configure audit sinks: %v", err)
+	}
+	audit.DefaultStream = stream
+
+	// Background workers stop as soon as a shutdown signal arrives. The
+	// audit stream is only closed once they, and any requests still being
+	// handled, have returned, since all of them may record audit events.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var workers sync.WaitGroup
+	goWorker := func(run func(context.Context)) {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			run(ctx)
+		}()
+	}
+
+	// Secrets read from _FILE settings are re-read on SIGHUP and when their
+	// files change.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go config.AppConfig.WatchSecrets(ctx, hup, config.AppConfig.SecretsReloadInterval)
+
 	// 3. Initialize Services
-	litellmService := services.NewLiteLLMService()
+	backends := services.NewLiteLLMServices()
+	for name, backend := range backends {
+		if version, err := backend.DetectVersion(ctx); err != nil {
+			log.Printf("Could not detect the version of LiteLLM instance %s: %v", name, err)
+		} else if version == "" {
+			log.Printf("LiteLLM instance %s does not report its version; assuming an older release", name)
+		} else {
+			log.Printf("Connected to LiteLLM instance %s, version %s", name, version)
+		}
+	}
+	litellmService := services.NewRoutedClient(backends, models.DB)
+
+	// Background spend snapshots
+	spendPoller := services.NewSpendPoller(litellmService, models.DB)
+	notifier := services.NewNotifier()
+	if notifier != nil {
+		spendPoller.Alerter = services.NewBudgetAlerter(models.DB, notifier)
+		if spendPoller.Interval <= 0 {
+			log.Printf("Budget alerts are off: they are checked with each spend snapshot and LLMREQ_SPEND_SNAPSHOT_INTERVAL is 0")
+		}
+	}
+	goWorker(spendPoller.Start)
+
+	// Key expiry reminders
+	if notifier != nil {
+		goWorker(services.NewExpiryReminder(models.DB, notifier).Start)
+	}
 
 	// 4. Initialize Handlers
 	h := handlers.NewHandler(litellmService, models.DB)
 
+	// Key history follows LiteLLM in the background; GETs only read it.
+	goWorker(h.Reconciler.Start)
+
 	// 5. Setup Echo
 	e := echo.New()
 
 	// Middleware
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			workers.Add(1)
+			defer workers.Done()
+			return next(c)
+		}
+	})
+	e.Use(echoMiddleware.RequestID())
 	e.Use(echoMiddleware.RequestLoggerWithConfig(echoMiddleware.RequestLoggerConfig{
-		LogStatus: true,
-		LogURI:    true,
+		LogStatus:    true,
+		LogURI:       true,
+		LogRequestID: true,
 		LogValuesFunc: func(c echo.Context, v echoMiddleware.RequestLoggerValues) error {
-			log.Printf("REQUEST: uri=%s status=%v", v.URI, v.Status)
+			log.Printf("REQUEST: uri=%s status=%v request_id=%s", v.URI, v.Status, v.RequestID)
 			return nil
 		},
 	}))
 	e.Use(echoMiddleware.Recover())
+	e.Use(middleware.Trace)
 
 	// Custom Auth Middleware
-	authMiddleware := middleware.NewAuthMiddleware(litellmService)
+	authMiddleware := middleware.NewAuthMiddleware(litellmService, models.DB)
 
 	// 6. Routes
 	// Serve OpenAPI spec
@@ -58,20 +143,95 @@ func main() {
 		return c.JSON(http.StatusOK, docs.SwaggerInfo)
 	})
 
+	// Liveness and readiness probes, unauthenticated for orchestrators.
+	health := handlers.NewHealthHandler(models.DB, backends)
+	e.GET("/healthz", health.Healthz)
+	e.GET("/readyz", health.Readyz)
+
 	api := e.Group(config.AppConfig.Prefix)
 	api.Use(authMiddleware.Middleware)
 
 	api.GET("/me", h.GetMe)
+	api.GET("/me/activity", h.GetMyActivity)
+	api.GET("/models", h.ListModels)
 	api.GET("/keys/active", h.GetActiveKeys)
 	api.GET("/keys/history", h.GetKeyHistory)
+	api.GET("/keys/expiring", h.GetExpiringKeys)
 	api.POST("/keys", h.CreateKey)
 	api.DELETE("/keys/:key_id", h.DeleteKey)
+	api.POST("/keys/:key_id/renew", h.RenewKey)
+	api.GET("/spend/history", h.GetSpendHistory)
+	api.GET("/spend/summary", h.GetSpendSummary)
+	api.POST("/teams", h.CreateTeam)
+	api.GET("/teams", h.ListTeams)
+	api.GET("/teams/:id", h.GetTeam)
+	api.PATCH("/teams/:id", h.UpdateTeam)
+	api.POST("/teams/:id/members", h.AddTeamMember)
+	api.PUT("/teams/:id/members/:user_id/max_spend", h.SetTeamMemberCap)
+	api.GET("/teams/:id/keys", h.GetTeamKeys)
+	api.GET("/teams/:id/spend", h.GetTeamSpend)
+
+	admin := api.Group("/admin", middleware.RequireAdmin)
+	admin.GET("/tiers", h.ListUserTiers)
+	admin.GET("/debug/vars", echo.WrapHandler(expvar.Handler()))
+	admin.GET("/audit", h.ListAuditEvents)
+	admin.GET("/audit/verify", h.VerifyAuditLog)
+	admin.GET("/reconcile", h.GetReconcileDrift)
+	admin.POST("/reconcile", h.RunReconcile)
+	admin.POST("/reconcile/reports", h.RequestReconcileReport)
+	admin.GET("/reconcile/runs", h.ListReconcileRuns)
+	admin.GET("/users/:id", h.GetUser)
+	admin.PUT("/users/:id/tier", h.SetUserTier)
+	admin.GET("/users/:id/events", h.GetUserEvents)
+	admin.POST("/users/:id/suspend", h.SuspendUser)
+	admin.POST("/users/:id/unsuspend", h.UnsuspendUser)
+	admin.POST("/users/:id/offboard", h.OffboardUser)
+	admin.POST("/users/:id/offboard/cancel", h.CancelOffboarding)
+	admin.POST("/users/:id/offboard/finalize", h.FinalizeOffboarding)
+
+	// SCIM provisioning authenticates with its own bearer token rather than
+	// the proxy headers, so it is only mounted when a token is configured.
+	if config.AppConfig.SCIMToken.Value() != "" {
+		scim := e.Group("/scim/v2", middleware.SCIMAuth(config.AppConfig.SCIMToken))
+		scim.GET("/Users", h.ListScimUsers)
+		scim.POST("/Users", h.CreateScimUser)
+		scim.GET("/Users/:id", h.GetScimUser)
+		scim.PUT("/Users/:id", h.ReplaceScimUser)
+		scim.PATCH("/Users/:id", h.PatchScimUser)
+		scim.DELETE("/Users/:id", h.DeleteScimUser)
+		scim.GET("/Groups", h.ListScimGroups)
+		scim.POST("/Groups", h.CreateScimGroup)
+		scim.GET("/Groups/:id", h.GetScimGroup)
+		scim.PUT("/Groups/:id", h.ReplaceScimGroup)
+		scim.PATCH("/Groups/:id", h.PatchScimGroup)
+		scim.DELETE("/Groups/:id", h.DeleteScimGroup)
+	}
 
 	// 7. Start Server
 	// Spec says "Environment: Dockerized". Port typically 8080 or 3000.
 	// I'll use 8080 as default.
-	log.Println("Starting server on :8080")
-	if err := e.Start(":8080"); err != nil {
-		log.Fatalf("Server failed: %v", err)
+	//
+	// Requests run under their own base context so that they can finish
+	// during the shutdown grace period; whatever is still running after it
+	// is cancelled, aborting its LiteLLM calls.
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	e.Server.BaseContext = func(net.Listener) context.Context { return requestCtx }
+
+	go func() {
+		log.Println("Starting server on :8080")
+		if err := e.Start(":8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatalf("Server failed: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Printf("Shutting down, waiting up to %s for requests", config.AppConfig.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), config.AppConfig.ShutdownTimeout)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Cancelling requests still in flight: %v", err)
 	}
+	cancelRequests()
+	workers.Wait()
+	stream.Close(5 * time.Second)
 }
@@ -1,22 +1,37 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
 	"log"
 	"net/http"
 	"strings"
 
+	"github.com/example/llmreq/apierror"
+	"github.com/example/llmreq/audit"
 	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
 	"github.com/example/llmreq/services"
 	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
 )
 
 type AuthMiddleware struct {
-	LiteLLMService *services.LiteLLMService
+	LiteLLMService services.LiteLLMClient
+	DB             *gorm.DB
+	TeamSyncer     *services.TeamSyncer // nil when no team mappings are configured
+	Tokens         *TokenVerifier       // nil when no JWKS URL is configured; token groups are then ignored
+	Audit          *audit.Logger
 }
 
-func NewAuthMiddleware(service *services.LiteLLMService) *AuthMiddleware {
+func NewAuthMiddleware(service services.LiteLLMClient, db *gorm.DB) *AuthMiddleware {
 	return &AuthMiddleware{
 		LiteLLMService: service,
+		DB:             db,
+		TeamSyncer:     services.NewTeamSyncer(service, db),
+		Tokens:         NewTokenVerifier(config.AppConfig.GroupsJWKSURL, config.AppConfig.GroupsIssuer, config.AppConfig.GroupsAudience),
+		Audit:          audit.NewLogger(db),
 	}
 }
 
@@ -31,29 +46,206 @@ func (m *AuthMiddleware) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
 		userID := strings.ToLower(email)
 		c.Set("user_id", userID)
 
+		var localUser models.User
+		if err := m.DB.Where("id = ?", userID).Limit(1).Find(&localUser).Error; err != nil {
+			log.Printf("Error loading user: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to provision user"})
+		}
+		// Deactivated (SCIM) and suspended or offboarded (admin) users are
+		// turned away before anything is provisioned for them again.
+		if localUser.ID != "" && localUser.Status != "active" {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden: account is not active"})
+		}
+
+		// Groups are known, even when there are none, if the proxy forwarded
+		// the header or a verified token carries the claim.
+		token := c.Request().Header.Get("X-Forwarded-Access-Token")
+		groups := parseGroups(c.Request().Header.Get("X-Forwarded-Groups"))
+		claimed, hasClaim := tokenGroups(m.Tokens, token, config.AppConfig.GroupsClaim)
+		groups = append(groups, claimed...)
+		groupsSent := len(c.Request().Header.Values("X-Forwarded-Groups")) > 0 || hasClaim
+		scimGroups, err := models.ScimGroupNames(m.DB, userID)
+		if err != nil {
+			log.Printf("Error loading groups: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to provision user"})
+		}
+		groups = append(groups, scimGroups...)
+		c.Set("groups", groups)
+
 		// JIT Provisioning
 		// Note: Doing this on *every* request might be slow if LiteLLM is slow.
 		// But spec says "On every authenticated request".
 		// We could cache this locally to improve performance, but sticking to spec first.
 
-		user, err := m.LiteLLMService.GetUserInfo(userID)
+		// New users are placed on a LiteLLM instance by the routing rules
+		// and stay there; the calls below run before their record exists.
+		instance := services.StoredInstance(config.AppConfig.ResolveInstance(config.Route{Email: userID, Groups: groups}))
+		ctx := c.Request().Context()
+		if localUser.ID != "" {
+			instance = localUser.Instance
+		}
+		if instance != "" {
+			ctx = services.WithInstance(ctx, instance)
+		}
+
+		user, err := m.LiteLLMService.GetUserInfo(ctx, userID)
 		if err != nil {
 			// If error, it might be that LiteLLM is down or returned error.
 			// But if it's 404 (user not found), GetUserInfo returns nil, nil.
 			// If it returns error, it's a real error.
 			log.Printf("Error checking user info: %v", err)
-			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "LiteLLM service unavailable"})
+			return apierror.Upstream(c, err, "LiteLLM service unavailable")
 		}
 
+		tier := userTier(&localUser, userID, groups)
+		provisioned := user == nil
+
 		if user == nil {
-			// User does not exist, create it
-			err := m.LiteLLMService.CreateUser(userID, userID, config.AppConfig.DefaultBudget)
+			// User does not exist, create it with the limits of their tier
+			err := m.LiteLLMService.CreateUser(ctx, services.NewUserRequestForTier(userID, tier))
 			if err != nil {
 				log.Printf("Error creating user: %v", err)
+				return apierror.Upstream(c, err, "Failed to provision user")
+			}
+		}
+
+		// Users provisioned before tiers existed still have the limits they
+		// were created with, so their tier is applied when their local record
+		// is first created.
+		if localUser.ID == "" {
+			if user != nil {
+				if err := m.LiteLLMService.UpdateUser(ctx, services.UpdateUserRequestForTier(userID, tier)); err != nil {
+					log.Printf("Error applying tier to user: %v", err)
+					return apierror.Upstream(c, err, "Failed to provision user")
+				}
+			}
+			localUser = models.User{ID: userID, Email: userID, Tier: tier.Name, TierSource: "auto", Instance: instance}
+			if err := m.DB.Create(&localUser).Error; err != nil {
+				log.Printf("Error recording user: %v", err)
 				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to provision user"})
 			}
+			provisioned = true
+		}
+
+		if provisioned {
+			m.Audit.RecordRequest(c, audit.Event{
+				Actor:      "system:provision",
+				UserID:     userID,
+				Action:     "user.provision",
+				TargetType: "user",
+				TargetID:   userID,
+				After:      map[string]interface{}{"tier": tier.Name, "litellm_created": user == nil, "litellm_updated": user != nil},
+			})
+		}
+
+		// Team membership follows the identity provider once per session.
+		// A request that carries no group information at all is not taken
+		// to mean the user lost every team, so it does not sync; groups sent
+		// empty do remove every membership the provider granted. A failed
+		// sync should not lock the user out; it is retried on the next
+		// request.
+		if m.TeamSyncer != nil && (groupsSent || len(groups) > 0) {
+			session := sessionHash(token, groups)
+			if session != localUser.SessionHash {
+				if err := m.TeamSyncer.Sync(c.Request().Context(), userID, groups); err != nil {
+					log.Printf("Error syncing teams: %v", err)
+				} else {
+					m.DB.Model(&models.User{}).Where("id = ?", userID).Update("session_hash", session)
+				}
+			}
+		}
+
+		return next(c)
+	}
+}
+
+// userTier returns the tier an admin assigned to the user, or else the tier
+// resolved from their email and groups.
+func userTier(localUser *models.User, userID string, groups []string) config.UserTier {
+	if localUser.TierSource == "admin" {
+		if tier, ok := config.AppConfig.UserTier(localUser.Tier); ok {
+			return tier
 		}
+	}
+	return config.AppConfig.ResolveUserTier(userID, groups)
+}
 
+// RequireAdmin rejects requests from users not listed in LLMREQ_ADMIN_EMAILS.
+// It must run after Middleware.
+func RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, _ := c.Get("user_id").(string)
+		if !config.AppConfig.IsAdmin(userID) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "Forbidden: admin access required"})
+		}
 		return next(c)
 	}
 }
+
+// SCIMAuth rejects requests that do not carry token as a bearer token. The
+// token is read on every request, so a reloaded one applies at once.
+func SCIMAuth(secret *config.Secret) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			given := strings.TrimPrefix(c.Request().Header.Get("Authorization"), "Bearer ")
+			token := secret.Value()
+			if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+				return c.JSON(http.StatusUnauthorized, map[string]interface{}{
+					"schemas": []string{"urn:ietf:params:scim:api:messages:2.0:Error"},
+					"status":  "401",
+					"detail":  "Invalid bearer token",
+				})
+			}
+			return next(c)
+		}
+	}
+}
+
+// tokenGroups reads the named claim from the JWT forwarded by the proxy,
+// and reports whether a verified token carried it. Unlike the X-Forwarded
+// headers the token can be replayed or forged by anyone who reaches
+// llmreq, so its groups are only used once the verifier has checked its
+// signature; without a verifier they are ignored.
+func tokenGroups(verifier *TokenVerifier, token, claim string) ([]string, bool) {
+	if verifier == nil || token == "" || claim == "" {
+		return nil, false
+	}
+	claims, err := verifier.Claims(token)
+	if err != nil {
+		log.Printf("Ignoring groups of the forwarded access token: %v", err)
+		return nil, false
+	}
+
+	switch value := claims[claim].(type) {
+	case string:
+		return parseGroups(value), true
+	case []interface{}:
+		var groups []string
+		for _, v := range value {
+			if g, ok := v.(string); ok && g != "" {
+				groups = append(groups, g)
+			}
+		}
+		return groups, true
+	}
+	return nil, false
+}
+
+// sessionHash identifies the proxy session a request belongs to by its
+// access token and groups, which stay the same until the user logs in
+// again or their token is refreshed.
+func sessionHash(token string, groups []string) string {
+	sum := sha256.Sum256([]byte(token + "\n" + strings.Join(groups, ",")))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseGroups splits the comma-separated group list forwarded by the proxy.
+func parseGroups(header string) []string {
+	var groups []string
+	for _, g := range strings.Split(header, ",") {
+		if g = strings.TrimSpace(g); g != "" {
+			groups = append(groups, g)
+		}
+	}
+	return groups
+}
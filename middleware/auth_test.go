@@ -1,17 +1,38 @@
 package middleware
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
 	"github.com/example/llmreq/services"
 	"github.com/labstack/echo/v4"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
 )
 
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return db
+}
+
 func TestAuthMiddleware(t *testing.T) {
 	// Mock LiteLLM
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -24,7 +45,25 @@ func TestAuthMiddleware(t *testing.T) {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
+		if strings.Contains(r.URL.Path, "/user/info/invalid@example.com") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
 		if r.URL.Path == "/user/new" {
+			var body services.NewUserRequest
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if body.UserID == "invalid@example.com" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"detail": "user_email is not a valid address"}`))
+				return
+			}
+		}
+		if strings.Contains(r.URL.Path, "/user/info/limited@example.com") {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.URL.Path == "/user/new" || r.URL.Path == "/user/update" {
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -38,7 +77,7 @@ func TestAuthMiddleware(t *testing.T) {
 	svc := services.NewLiteLLMService()
 	svc.BaseURL = server.URL
 
-	m := NewAuthMiddleware(svc)
+	m := NewAuthMiddleware(svc, setupTestDB(t))
 	e := echo.New()
 
 	// 1. Missing Header
@@ -93,4 +132,440 @@ func TestAuthMiddleware(t *testing.T) {
 	if rec.Code != http.StatusOK {
 		t.Errorf("Expected 200, got %d", rec.Code)
 	}
+
+	// 4. LiteLLM errors map like they do in the handlers
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-Email", "limited@example.com")
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+
+	err = m.Middleware(func(c echo.Context) error {
+		t.Error("Expected the request to be rejected")
+		return nil
+	})(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "7" {
+		t.Errorf("Expected 429 with Retry-After 7, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	// 5. A rejected provisioning says why, with the same body as handlers
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-Email", "invalid@example.com")
+	rec = httptest.NewRecorder()
+	c = e.NewContext(req, rec)
+
+	err = m.Middleware(func(c echo.Context) error {
+		t.Error("Expected the request to be rejected")
+		return nil
+	})(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body map[string]string
+	_ = json.Unmarshal(rec.Body.Bytes(), &body)
+	if rec.Code != http.StatusBadRequest || body["error"] != "Failed to provision user" || body["detail"] != "user_email is not a valid address" {
+		t.Errorf("Expected 400 with LiteLLM's detail, got %d %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestAuthMiddlewareUserTiers(t *testing.T) {
+	var created []services.NewUserRequest
+	var updated []services.UpdateUserRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/info/legacy@example.com" {
+			_ = json.NewEncoder(w).Encode(services.LiteLLMUser{UserID: "legacy@example.com", MaxBudget: 1})
+			return
+		}
+		if strings.HasPrefix(r.URL.Path, "/user/info/") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.URL.Path == "/user/update" {
+			var req services.UpdateUserRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			updated = append(updated, req)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.URL.Path == "/user/new" {
+			var req services.NewUserRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			created = append(created, req)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{
+		DefaultBudget: 1.0,
+		UserTiers: []config.UserTier{
+			{Name: "basic", MaxBudget: 5, BudgetDuration: "30d"},
+			{Name: "partner", MaxBudget: 10, EmailDomains: []string{"partner.com"}},
+			{Name: "research", MaxBudget: 100, BudgetDuration: "7d", Models: []string{"fake-gpt-test"}, Groups: []string{"ml-research"}},
+		},
+		DefaultUserTier: "basic",
+	}
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	m := NewAuthMiddleware(svc, db)
+	e := echo.New()
+
+	login := func(email, groups string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-Email", email)
+		if groups != "" {
+			req.Header.Set("X-Forwarded-Groups", groups)
+		}
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		if err := m.Middleware(func(c echo.Context) error { return c.NoContent(http.StatusOK) })(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+	}
+
+	login("a@example.com", "")
+	login("b@partner.com", "")
+	login("c@partner.com", "staff, ml-research")
+
+	if len(created) != 3 {
+		t.Fatalf("Expected 3 users created, got %d", len(created))
+	}
+	if created[0].MaxBudget != 5 || created[0].BudgetDuration != "30d" {
+		t.Errorf("Expected default tier limits, got %+v", created[0])
+	}
+	if created[1].MaxBudget != 10 {
+		t.Errorf("Expected domain tier limits, got %+v", created[1])
+	}
+	if created[2].MaxBudget != 100 || len(created[2].Models) != 1 {
+		t.Errorf("Expected group tier to win over domain, got %+v", created[2])
+	}
+
+	var user models.User
+	db.Where("id = ?", "c@partner.com").First(&user)
+	if user.Tier != "research" || user.TierSource != "auto" {
+		t.Errorf("Unexpected local user %+v", user)
+	}
+
+	// An admin-assigned tier is used when provisioning.
+	db.Model(&models.User{}).Where("id = ?", "a@example.com").Updates(models.User{Tier: "research", TierSource: "admin"})
+	login("a@example.com", "")
+	if created[3].MaxBudget != 100 {
+		t.Errorf("Expected admin-assigned tier, got %+v", created[3])
+	}
+
+	// A LiteLLM user provisioned before tiers existed gets their tier's
+	// limits once, when the local record is created.
+	login("legacy@example.com", "ml-research")
+	login("legacy@example.com", "ml-research")
+	if len(updated) != 1 || updated[0].MaxBudget == nil || *updated[0].MaxBudget != 100 || updated[0].BudgetDuration == nil || *updated[0].BudgetDuration != "7d" {
+		t.Errorf("Expected the research tier applied once, got %+v", updated)
+	}
+	if len(created) != 4 {
+		t.Errorf("Expected the existing LiteLLM user not to be created, got %d creations", len(created))
+	}
+}
+
+func TestRequireAdmin(t *testing.T) {
+	config.AppConfig = &config.Config{AdminEmails: []string{"admin@example.com"}}
+	e := echo.New()
+
+	for userID, expected := range map[string]int{
+		"admin@example.com": http.StatusOK,
+		"user@example.com":  http.StatusForbidden,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		c.Set("user_id", userID)
+		_ = RequireAdmin(func(c echo.Context) error { return c.NoContent(http.StatusOK) })(c)
+		if rec.Code != expected {
+			t.Errorf("%s: expected %d, got %d", userID, expected, rec.Code)
+		}
+	}
+}
+
+// The issuer and audience signer adds to tokens that do not set their own.
+const (
+	testIssuer   = "https://idp.example.com"
+	testAudience = "llmreq"
+)
+
+// signer issues RS256 tokens and serves the JWKS that verifies them,
+// counting the fetches.
+type signer struct {
+	key     *rsa.PrivateKey
+	jwks    *httptest.Server
+	fetches atomic.Int32
+}
+
+func newSigner(t *testing.T) *signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &signer{key: key}
+	s.jwks = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.fetches.Add(1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(s.jwks.Close)
+	return s
+}
+
+func (s *signer) sign(claims string) string {
+	parsed := map[string]interface{}{}
+	_ = json.Unmarshal([]byte(claims), &parsed)
+	for name, value := range map[string]string{"iss": testIssuer, "aud": testAudience} {
+		if _, ok := parsed[name]; !ok {
+			parsed[name] = value
+		}
+	}
+	payload, _ := json.Marshal(parsed)
+	unsigned := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k1"}`)) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, digest[:])
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestTokenGroups(t *testing.T) {
+	s := newSigner(t)
+	verifier := NewTokenVerifier(s.jwks.URL, testIssuer, testAudience)
+	forged := func(claims string) string {
+		return "eyJhbGciOiJSUzI1NiIsImtpZCI6ImsxIn0." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".c2ln"
+	}
+
+	tests := []struct {
+		token    string
+		claim    string
+		groups   []string
+		hasClaim bool
+	}{
+		{s.sign(`{"groups": ["eng", "ops"]}`), "groups", []string{"eng", "ops"}, true},
+		{"Bearer " + s.sign(`{"roles": "eng, ops"}`), "roles", []string{"eng", "ops"}, true},
+		{s.sign(`{"groups": []}`), "groups", nil, true},
+		{s.sign(`{"groups": ["eng"]}`), "roles", nil, false},
+		{s.sign(`{"groups": ["eng"]}`), "", nil, false},
+		{s.sign(`{"groups": ["eng"], "exp": 1000}`), "groups", nil, false},
+		{s.sign(`{"groups": ["eng"], "aud": ["other", "llmreq"]}`), "groups", []string{"eng"}, true},
+		{s.sign(`{"groups": ["eng"], "aud": "other"}`), "groups", nil, false},
+		{s.sign(`{"groups": ["eng"], "aud": null}`), "groups", nil, false},
+		{s.sign(`{"groups": ["eng"], "iss": "https://evil.example.com"}`), "groups", nil, false},
+		{forged(`{"groups": ["admins"]}`), "groups", nil, false},
+		{"not-a-jwt", "groups", nil, false},
+	}
+	for _, test := range tests {
+		groups, hasClaim := tokenGroups(verifier, test.token, test.claim)
+		if !reflect.DeepEqual(groups, test.groups) || hasClaim != test.hasClaim {
+			t.Errorf("%s/%s: expected %v (%t), got %v (%t)", test.token, test.claim, test.groups, test.hasClaim, groups, hasClaim)
+		}
+	}
+
+	// Without a key set the claim is not trusted at all.
+	if groups, hasClaim := tokenGroups(nil, s.sign(`{"groups": ["eng"]}`), "groups"); groups != nil || hasClaim {
+		t.Errorf("Expected no groups without a verifier, got %v", groups)
+	}
+}
+
+func TestTokenVerifierFetch(t *testing.T) {
+	s := newSigner(t)
+	verifier := NewTokenVerifier(s.jwks.URL, testIssuer, testAudience)
+	token := s.sign(`{"groups": ["eng"]}`)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := verifier.Claims(token); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := s.fetches.Load(); n != 1 {
+		t.Errorf("Expected concurrent requests to share one JWKS fetch, got %d", n)
+	}
+
+	// A known key is served while the key set is fetched again for an
+	// unknown one.
+	release := make(chan struct{})
+	var slowFetches atomic.Int32
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slowFetches.Add(1) > 1 {
+			<-release
+		}
+		s.jwks.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer slow.Close()
+	verifier = NewTokenVerifier(slow.URL, testIssuer, testAudience)
+	if _, err := verifier.Claims(token); err != nil {
+		t.Fatal(err)
+	}
+	verifier.Now = func() time.Time { return time.Now().Add(time.Hour) }
+	unknown := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k2"}`)) + ".e30.c2ln"
+	refetched := make(chan struct{})
+	go func() {
+		defer close(refetched)
+		_, _ = verifier.Claims(unknown)
+	}()
+	for deadline := time.Now().Add(5 * time.Second); slowFetches.Load() < 2 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	verified := make(chan error, 1)
+	go func() {
+		_, err := verifier.Claims(token)
+		verified <- err
+	}()
+	select {
+	case err := <-verified:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected a known key to be served during a fetch")
+	}
+	close(release)
+	<-refetched
+}
+
+func TestAuthMiddlewareTeamSync(t *testing.T) {
+	var added, removed []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		switch {
+		case r.URL.Path == "/team/member_add":
+			added = append(added, req["team_id"].(string))
+		case r.URL.Path == "/team/member_delete":
+			removed = append(removed, req["team_id"].(string))
+		case r.URL.Path == "/key/list":
+			_, _ = w.Write([]byte(`{"keys": []}`))
+		case strings.HasPrefix(r.URL.Path, "/user/info/"):
+			_, _ = w.Write([]byte(`{"user_id": "a@example.com"}`))
+		}
+	}))
+	defer server.Close()
+
+	s := newSigner(t)
+	config.AppConfig = &config.Config{
+		GroupsClaim:    "groups",
+		GroupsJWKSURL:  s.jwks.URL,
+		GroupsIssuer:   testIssuer,
+		GroupsAudience: testAudience,
+		TeamMappings: []config.TeamMapping{
+			{Group: "eng", TeamID: "team-eng", Role: "user"},
+			{Group: "data", TeamID: "team-data", Role: "user"},
+		},
+	}
+	svc := services.NewLiteLLMService()
+	svc.BaseURL = server.URL
+	db := setupTestDB(t)
+	m := NewAuthMiddleware(svc, db)
+
+	// login sends groups in X-Forwarded-Groups, or no header when nil.
+	login := func(groups []string, token string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-Forwarded-Email", "a@example.com")
+		if groups != nil {
+			req.Header.Set("X-Forwarded-Groups", strings.Join(groups, ","))
+		}
+		if token != "" {
+			req.Header.Set("X-Forwarded-Access-Token", token)
+		}
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		if err := m.Middleware(func(c echo.Context) error { return c.NoContent(http.StatusOK) })(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token := s.sign(`{"groups": ["data"]}`)
+	login([]string{"eng"}, token)
+	if len(added) != 2 {
+		t.Errorf("Expected membership in both mapped teams, got %v", added)
+	}
+
+	// Later requests of the same session do not sync again.
+	calls := len(added) + len(removed)
+	db.Where("team_id = ?", "team-data").Delete(&models.TeamMember{})
+	login([]string{"eng"}, token)
+	if len(added)+len(removed) != calls {
+		t.Errorf("Expected no sync within a session, got added %v, removed %v", added, removed)
+	}
+
+	// A request without groups, such as one whose token was stripped, does
+	// not remove anything.
+	login(nil, "")
+	if len(removed) != 0 {
+		t.Errorf("Expected no removals without a group source, got %v", removed)
+	}
+
+	// A new session with fewer groups does.
+	login([]string{"data"}, s.sign(`{"groups": []}`))
+	if len(removed) != 1 || removed[0] != "team-eng" {
+		t.Errorf("Expected team-eng to be removed in the new session, got %v", removed)
+	}
+
+	// Groups sent empty mean the user lost every mapped group.
+	login([]string{}, "")
+	if len(removed) != 2 || removed[1] != "team-data" {
+		t.Errorf("Expected team-data to be removed once no group is sent, got %v", removed)
+	}
+	var members int64
+	if db.Model(&models.TeamMember{}).Where("user_id = ?", "a@example.com").Count(&members); members != 0 {
+		t.Errorf("Expected no memberships left, got %d", members)
+	}
+}
+
+func TestAuthMiddlewareDeactivatedUser(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	db := setupTestDB(t)
+	db.Create(&models.User{ID: "gone@example.com", Email: "gone@example.com", Status: "deactivated"})
+	m := NewAuthMiddleware(services.NewLiteLLMService(), db)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("X-Forwarded-Email", "gone@example.com")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	called := false
+	_ = m.Middleware(func(c echo.Context) error { called = true; return nil })(c)
+
+	if rec.Code != http.StatusForbidden || called {
+		t.Errorf("Expected 403 for deactivated user, got %d", rec.Code)
+	}
+}
+
+func TestSCIMAuth(t *testing.T) {
+	e := echo.New()
+	handler := SCIMAuth(config.NewSecret("s3cret"))(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	for header, expected := range map[string]int{
+		"Bearer s3cret": http.StatusOK,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"":              http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		_ = handler(e.NewContext(req, rec))
+		if rec.Code != expected {
+			t.Errorf("%q: expected %d, got %d", header, expected, rec.Code)
+		}
+	}
 }
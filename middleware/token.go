@@ -0,0 +1,222 @@
+package middleware
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval limits how often an unknown key ID makes the verifier
+// fetch the key set again.
+const jwksRefreshInterval = time.Minute
+
+var errInvalidToken = errors.New("invalid access token")
+
+var tokenHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+// TokenVerifier checks the access tokens forwarded by the proxy against the
+// identity provider's JSON Web Key Set, so that group claims are only read
+// from tokens the provider signed for llmreq: their iss must be Issuer and
+// their aud must include Audience. RS256, RS384 and RS512 are supported.
+type TokenVerifier struct {
+	JWKSURL  string
+	Issuer   string
+	Audience string
+	Client   *http.Client
+	Now      func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+	fetching  *jwksFetch
+}
+
+// jwksFetch is a key set fetch in progress, which requests that need it
+// wait for instead of fetching again.
+type jwksFetch struct {
+	done chan struct{}
+	err  error
+}
+
+// NewTokenVerifier returns nil when no key set URL is configured.
+func NewTokenVerifier(jwksURL, issuer, audience string) *TokenVerifier {
+	if jwksURL == "" {
+		return nil
+	}
+	return &TokenVerifier{
+		JWKSURL:  jwksURL,
+		Issuer:   issuer,
+		Audience: audience,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+		Now:      time.Now,
+	}
+}
+
+// Claims returns the claims of token once its signature, expiry, issuer and
+// audience are verified.
+func (v *TokenVerifier) Claims(token string) (map[string]interface{}, error) {
+	parts := strings.Split(strings.TrimPrefix(token, "Bearer "), ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	hash, ok := tokenHashes[header.Alg]
+	if !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", errInvalidToken, header.Alg)
+	}
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	digest := hash.New()
+	digest.Write([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, hash, digest.Sum(nil), signature); err != nil {
+		return nil, fmt.Errorf("%w: bad signature", errInvalidToken)
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if exp, ok := claims["exp"].(float64); ok && v.Now().After(time.Unix(int64(exp), 0)) {
+		return nil, fmt.Errorf("%w: expired", errInvalidToken)
+	}
+	if iss, _ := claims["iss"].(string); iss != v.Issuer {
+		return nil, fmt.Errorf("%w: issued by %q", errInvalidToken, iss)
+	}
+	if !hasAudience(claims["aud"], v.Audience) {
+		return nil, fmt.Errorf("%w: not issued for %q", errInvalidToken, v.Audience)
+	}
+	return claims, nil
+}
+
+// hasAudience reports whether aud, a string or a list of them, names
+// audience.
+func hasAudience(aud interface{}, audience string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == audience
+	case []interface{}:
+		for _, a := range aud {
+			if a == audience {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// key returns the public key with the given ID, fetching the key set again
+// when the ID is unknown, such as after the provider rotated its keys. The
+// fetch runs without holding the lock, and concurrent requests share it.
+func (v *TokenVerifier) key(kid string) (*rsa.PublicKey, error) {
+	v.mu.Lock()
+	if key, ok := v.keys[kid]; ok {
+		v.mu.Unlock()
+		return key, nil
+	}
+
+	fetch := v.fetching
+	if fetch == nil {
+		if v.keys != nil && v.Now().Sub(v.fetchedAt) < jwksRefreshInterval {
+			v.mu.Unlock()
+			return nil, fmt.Errorf("%w: unknown key %q", errInvalidToken, kid)
+		}
+		fetch = &jwksFetch{done: make(chan struct{})}
+		v.fetching = fetch
+		v.mu.Unlock()
+
+		keys, err := v.fetchKeys()
+		v.mu.Lock()
+		if err == nil {
+			v.keys, v.fetchedAt = keys, v.Now()
+		}
+		fetch.err = err
+		v.fetching = nil
+		close(fetch.done)
+	} else {
+		v.mu.Unlock()
+		<-fetch.done
+		v.mu.Lock()
+	}
+	defer v.mu.Unlock()
+
+	if fetch.err != nil {
+		return nil, fetch.err
+	}
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("%w: unknown key %q", errInvalidToken, kid)
+}
+
+func (v *TokenVerifier) fetchKeys() (map[string]*rsa.PublicKey, error) {
+	resp, err := v.Client.Get(v.JWKSURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch JWKS: status %d", resp.StatusCode)
+	}
+
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("failed to decode JWKS: %w", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	return keys, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segment, "="))
+	if err != nil {
+		return errInvalidToken
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errInvalidToken
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/services"
+	"github.com/labstack/echo/v4"
+)
+
+// Trace stores the request ID and W3C trace context of the request in its
+// context, so that LiteLLM calls made while serving it carry them. It must
+// run after the request ID middleware.
+func Trace(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		ctx := services.WithTrace(req.Context(), services.Trace{
+			RequestID:   audit.RequestID(c),
+			TraceParent: req.Header.Get("traceparent"),
+		})
+		c.SetRequest(req.WithContext(ctx))
+		return next(c)
+	}
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"fmt"
 	"log"
+	"strings"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -17,8 +19,84 @@ func InitDB(databaseURL string) {
 	}
 
 	// Auto Migrate the schema
-	err = DB.AutoMigrate(&KeyHistory{})
+	err = Migrate(DB)
 	if err != nil {
 		log.Fatalf("Failed to migrate database: %v", err)
 	}
 }
+
+// tables lists every model Migrate creates a table for.
+var tables = []interface{}{&KeyHistory{}, &SpendSnapshot{}, &BudgetAlert{}, &ExpiryReminder{}, &User{}, &Team{}, &TeamMember{}, &ScimGroup{}, &ScimGroupMember{}, &AuditEvent{}, &ReconcileRun{}, &ReconcileReport{}}
+
+// Migrate brings the schema of db up to date with the models.
+func Migrate(db *gorm.DB) error {
+	// A LiteLLM key could once be recorded twice, by CreateKey and by the
+	// reconciler importing it. The older rows of a user's key are marked
+	// superseded so the unique index can be created; their history is
+	// kept. Keys recorded for more than one user are left for an admin.
+	migrator := db.Migrator()
+	if migrator.HasTable(&KeyHistory{}) && !migrator.HasIndex(&KeyHistory{}, "idx_key_histories_litellm_key_id") {
+		recorded := db.Model(&KeyHistory{}).Where("litellm_key_id <> ? AND status <> ?", "", "superseded")
+		var shared []string
+		if err := recorded.Session(&gorm.Session{}).Group("litellm_key_id").Having("COUNT(DISTINCT user_id) > 1").Pluck("litellm_key_id", &shared).Error; err != nil {
+			return err
+		}
+		if len(shared) > 0 {
+			return fmt.Errorf("key_history records LiteLLM keys for more than one user, resolve them before upgrading: %s", strings.Join(shared, ", "))
+		}
+		newest := recorded.Session(&gorm.Session{}).Select("MAX(id)").Group("litellm_key_id")
+		if err := recorded.Session(&gorm.Session{}).Where("id NOT IN (?)", newest).Update("status", "superseded").Error; err != nil {
+			return err
+		}
+	}
+
+	if err := db.AutoMigrate(tables...); err != nil {
+		return err
+	}
+
+	// SCIM deactivation was once kept in an active column next to status.
+	if db.Migrator().HasColumn(&User{}, "active") {
+		err := db.Model(&User{}).Where("active = ? AND status = ?", false, "active").
+			Updates(map[string]interface{}{"status": "deactivated", "prior_status": "active"}).Error
+		if err != nil {
+			return err
+		}
+		return db.Migrator().DropColumn(&User{}, "active")
+	}
+	return nil
+}
+
+// PendingMigrations returns the tables and columns of the models that db
+// lacks, as "table" or "table.column". It is empty once Migrate has run.
+func PendingMigrations(db *gorm.DB) ([]string, error) {
+	var pending []string
+	migrator := db.Migrator()
+	for _, model := range tables {
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(model); err != nil {
+			return nil, err
+		}
+		table := stmt.Schema.Table
+		if !migrator.HasTable(model) {
+			pending = append(pending, table)
+			continue
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" && !migrator.HasColumn(model, field.DBName) {
+				pending = append(pending, table+"."+field.DBName)
+			}
+		}
+	}
+	return pending, nil
+}
+
+// ScimGroupNames returns the names of the SCIM groups userID belongs to.
+func ScimGroupNames(db *gorm.DB, userID string) ([]string, error) {
+	var names []string
+	err := db.Model(&ScimGroup{}).
+		Joins("JOIN scim_group_members ON scim_group_members.group_id = scim_groups.id").
+		Where("scim_group_members.user_id = ?", userID).
+		Order("scim_groups.display_name").
+		Pluck("scim_groups.display_name", &names).Error
+	return names, err
+}
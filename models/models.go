@@ -5,14 +5,180 @@ import (
 )
 
 type KeyHistory struct {
+	ID            uint   `gorm:"primaryKey"`
+	UserID        string `gorm:"index"`
+	LiteLLMKeyID  string `gorm:"column:litellm_key_id;uniqueIndex:idx_key_histories_litellm_key_id,where:litellm_key_id <> '' AND status <> 'superseded'"` // unique among rows that have one, bar superseded ones
+	KeyName       string
+	KeyMask       string
+	KeyType       string
+	CreatedAt     time.Time
+	ExpiresAt     *time.Time
+	RevokedAt     *time.Time
+	RevokedReason string // why llmreq revoked the key on the owner's behalf, e.g. "user_deactivated"
+	Blocked       bool   // blocked in LiteLLM while the owner is suspended
+	Status        string // "active", "expired", "revoked", or "superseded" by a newer row of the same key
+	RenewCount    int
+	MaxBudget     float64 // budget the key was created with
+	TeamID        string  `gorm:"index;not null;default:''"` // set for team-owned keys; UserID is then the creator
+	Instance      string  `gorm:"not null;default:''"`       // LiteLLM instance the key lives on; "" is the default instance
+}
+
+// ReconcileRun reports one pass of the key reconciler over every user and
+// team, or over the changes of a reviewed drift report: how many
+// key_history rows it changed, by kind of change, and how many users and
+// teams it could not reconcile. Runs requested by admins are queued and
+// carried out in the background.
+type ReconcileRun struct {
+	ID          uint      `gorm:"primaryKey"`
+	Trigger     string    // "schedule" or "manual"
+	Status      string    `gorm:"not null;default:'finished'"` // "pending", "running" or "finished"
+	ReportID    *uint     // the drift report whose changes were applied; nil for a full run
+	Categories  string    // comma-separated drift categories applied; empty for all
+	StartedAt   time.Time `gorm:"index"`
+	FinishedAt  time.Time
+	Users       int
+	Teams       int
+	Imported    int
+	Rematched   int
+	Reactivated int
+	Expired     int
+	Revoked     int
+	Retyped     int
+	Redated     int // rows that took their key's expiry
+	Skipped     int // reviewed changes that no longer applied
+	Failed      int
+	Error       string // the first failure, if any
+}
+
+// ReconcileReport is a drift report requested by an admin, worked out in
+// the background. Its changes can then be applied as reviewed.
+type ReconcileReport struct {
+	ID         uint      `gorm:"primaryKey"`
+	Status     string    `gorm:"index"` // "pending", "ready" or "failed"
+	CreatedAt  time.Time `gorm:"index"`
+	FinishedAt *time.Time
+	Report     string // the report as JSON, once ready
+	Error      string
+}
+
+// SpendSnapshot records the cumulative spend reported by LiteLLM at a point in
+// time. Rows with an empty LiteLLMKeyID hold the user-level total.
+type SpendSnapshot struct {
 	ID           uint   `gorm:"primaryKey"`
-	UserID       string `gorm:"index"`
-	LiteLLMKeyID string `gorm:"column:litellm_key_id"`
+	UserID       string `gorm:"index:idx_spend_user_time"`
+	LiteLLMKeyID string `gorm:"column:litellm_key_id;index"`
 	KeyName      string
-	KeyMask      string
-	KeyType      string
+	Spend        float64
+	MaxBudget    float64
+	CapturedAt   time.Time `gorm:"index:idx_spend_user_time"`
+}
+
+// BudgetAlert marks a budget threshold as already notified for a subject (a
+// user total or a single key) within one budget period.
+type BudgetAlert struct {
+	ID          uint    `gorm:"primaryKey"`
+	SubjectType string  `gorm:"uniqueIndex:idx_budget_alert"`
+	SubjectID   string  `gorm:"uniqueIndex:idx_budget_alert"`
+	Threshold   float64 `gorm:"uniqueIndex:idx_budget_alert"`
+	Period      string  `gorm:"uniqueIndex:idx_budget_alert"`
+	UserID      string  `gorm:"index"`
+	Spend       float64
+	MaxBudget   float64
+	CreatedAt   time.Time
+}
+
+// ExpiryReminder marks that a key was already reminded about its upcoming
+// expiry at a given number of days before ExpiresAt. Including ExpiresAt in
+// the unique index re-arms reminders after a key is renewed.
+type ExpiryReminder struct {
+	ID           uint      `gorm:"primaryKey"`
+	KeyHistoryID uint      `gorm:"uniqueIndex:idx_expiry_reminder"`
+	DaysBefore   int       `gorm:"uniqueIndex:idx_expiry_reminder"`
+	ExpiresAt    time.Time `gorm:"uniqueIndex:idx_expiry_reminder"`
+	UserID       string    `gorm:"index"`
 	CreatedAt    time.Time
-	ExpiresAt    *time.Time
-	RevokedAt    *time.Time
-	Status       string
+}
+
+// User is the local record of a provisioned user. ID is the lower-cased email
+// used as the LiteLLM user_id.
+type User struct {
+	ID          string `gorm:"primaryKey"`
+	Email       string
+	Tier        string
+	TierSource  string // "auto" when resolved at provisioning, "admin" when set by an admin
+	ExternalID  string `gorm:"index"`               // the identity provider's id when provisioned over SCIM
+	Instance    string `gorm:"not null;default:''"` // LiteLLM instance chosen at provisioning; "" is the default instance
+	SessionHash string `json:"-"`                   // identifies the proxy session that last synced the user's teams
+
+	// Status is "active", "suspended", "offboarding" or "offboarded" as set
+	// by admins, or "deactivated" by the identity provider over SCIM.
+	// PriorStatus is restored when offboarding is cancelled or the user is
+	// reactivated.
+	Status        string `gorm:"not null;default:'active'"`
+	PriorStatus   string
+	SuspendedAt   *time.Time
+	DeactivatedAt *time.Time
+	Successor     string   // receives transferable keys when offboarding is finalized
+	FinalSpend    *float64 // LiteLLM spend captured when offboarding started
+	OffboardedAt  *time.Time
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+// Team mirrors a LiteLLM team managed through llmreq. ID is the LiteLLM
+// team_id.
+type Team struct {
+	ID        string `gorm:"primaryKey"`
+	Alias     string
+	CreatedBy string
+	Instance  string `gorm:"not null;default:''"` // LiteLLM instance the team was created on; "" is the default instance
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// TeamMember records a user's role in a team. MaxSpend optionally caps how
+// much of the team budget the member's keys may draw; nil means no cap.
+type TeamMember struct {
+	ID        uint   `gorm:"primaryKey"`
+	TeamID    string `gorm:"uniqueIndex:idx_team_member"`
+	UserID    string `gorm:"uniqueIndex:idx_team_member;index"`
+	Role      string // "admin" or "user"
+	Source    string `gorm:"not null;default:'manual'"` // "manual", or "idp" when granted by a group mapping
+	MaxSpend  *float64
+	CreatedAt time.Time
+}
+
+// ScimGroup is a group pushed by the identity provider over SCIM. Its
+// DisplayName is treated like a group name from X-Forwarded-Groups.
+type ScimGroup struct {
+	ID          string `gorm:"primaryKey"`
+	DisplayName string `gorm:"uniqueIndex"`
+	ExternalID  string `gorm:"index"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+type ScimGroupMember struct {
+	ID      uint   `gorm:"primaryKey"`
+	GroupID string `gorm:"uniqueIndex:idx_scim_group_member"`
+	UserID  string `gorm:"uniqueIndex:idx_scim_group_member;index"`
+}
+
+// AuditEvent is one entry of the append-only audit log. Each event's Hash
+// covers its content and the previous event's hash, so editing or deleting a
+// row breaks the chain from that point on.
+type AuditEvent struct {
+	ID         uint      `gorm:"primaryKey"`
+	CreatedAt  time.Time `gorm:"index"`
+	Actor      string    `gorm:"index"` // who acted: a user ID, or "system:..." for background jobs
+	UserID     string    `gorm:"index"` // whose account or key was affected
+	Action     string    `gorm:"index"`
+	TargetType string
+	TargetID   string
+	IP         string
+	RequestID  string
+	Before     string // JSON, empty when there was no prior state
+	After      string // JSON, empty when the target was removed
+	PrevHash   string
+	Hash       string `gorm:"uniqueIndex"`
 }
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// Response adapters. LiteLLM has changed the shape of several admin API
+// responses between releases; each decoder below accepts every shape we
+// know of and normalises it. Shapes are recognised from the body rather
+// than from the detected version, so upgrading LiteLLM needs no llmreq
+// change as long as the new shape is one listed here. testdata/litellm
+// holds a response set per release and contract_test.go runs every client
+// method against each.
+
+// decodeUserInfo reads a /user/info response. Current releases wrap the user
+// as {"user_id": ..., "user_info": {...}, "keys": [...]} and answer an
+// unknown user with a null user_info instead of a 404; older ones return the
+// bare user. It returns nil for an unknown user.
+func decodeUserInfo(body []byte) (*LiteLLMUser, error) {
+	var wrapped struct {
+		UserID   string          `json:"user_id"`
+		UserInfo json.RawMessage `json:"user_info"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, fmt.Errorf("failed to decode user: %v", err)
+	}
+
+	var user LiteLLMUser
+	if wrapped.UserInfo != nil {
+		if isNull(wrapped.UserInfo) {
+			return nil, nil
+		}
+		if err := json.Unmarshal(wrapped.UserInfo, &user); err != nil {
+			return nil, fmt.Errorf("failed to decode user_info: %v", err)
+		}
+		if user.UserID == "" {
+			user.UserID = wrapped.UserID
+		}
+		return &user, nil
+	}
+
+	if err := json.Unmarshal(body, &user); err != nil {
+		return nil, fmt.Errorf("failed to decode user: %v", err)
+	}
+	return &user, nil
+}
+
+// decodeKeyPage reads a /key/list response: a bare list in the oldest
+// releases, {"keys": [...]} later, and the same with total_count and
+// total_pages once the endpoint was paginated. Keys are full objects when
+// return_full_object is honoured and bare token strings when it is not.
+// Bare tokens say nothing about whose key it is, so they are counted in
+// Ownerless and make the listing incomplete.
+func decodeKeyPage(body []byte) (*keyPage, error) {
+	var page struct {
+		Keys       []json.RawMessage `json:"keys"`
+		TotalCount *int              `json:"total_count"`
+		TotalPages *int              `json:"total_pages"`
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+		if err := json.Unmarshal(body, &page.Keys); err != nil {
+			return nil, fmt.Errorf("failed to decode keys: %v", err)
+		}
+	} else if err := json.Unmarshal(body, &page); err != nil {
+		return nil, fmt.Errorf("failed to decode keys: %v", err)
+	}
+
+	result := &keyPage{Keys: make([]LiteLLMKey, 0, len(page.Keys)), TotalCount: page.TotalCount, TotalPages: page.TotalPages}
+	for _, raw := range page.Keys {
+		var k LiteLLMKey
+		var token string
+		if json.Unmarshal(raw, &token) == nil {
+			k.Key = token
+			result.Ownerless++
+		} else if err := json.Unmarshal(raw, &k); err != nil {
+			return nil, fmt.Errorf("failed to decode key: %v", err)
+		}
+		normalizeKey(&k)
+		result.Keys = append(result.Keys, k)
+	}
+	return result, nil
+}
+
+// normalizeKey fills Key from Token for releases that identify listed keys
+// only by their hashed token.
+func normalizeKey(k *LiteLLMKey) {
+	if k.Key == "" {
+		k.Key = k.Token
+	}
+}
+
+// decodeTeamInfo reads a /team/info response, which current releases wrap
+// as {"team_id": ..., "team_info": {...}, "keys": [...]}.
+func decodeTeamInfo(body []byte) (*LiteLLMTeam, error) {
+	var wrapped struct {
+		TeamInfo json.RawMessage `json:"team_info"`
+	}
+	if err := json.Unmarshal(body, &wrapped); err != nil {
+		return nil, fmt.Errorf("failed to decode team: %v", err)
+	}
+	if wrapped.TeamInfo != nil && !isNull(wrapped.TeamInfo) {
+		body = wrapped.TeamInfo
+	}
+
+	var team LiteLLMTeam
+	if err := json.Unmarshal(body, &team); err != nil {
+		return nil, fmt.Errorf("failed to decode team: %v", err)
+	}
+	return &team, nil
+}
+
+// decodeModelInfo reads a /model/info response: {"data": [...]} with one
+// entry per deployment. Pricing and limits are in model_info, except in
+// older releases, which leave them there as null and only have the costs set
+// in the proxy config's litellm_params. Deployments of the same model name
+// are merged, the first known value winning. Nothing else of litellm_params
+// is read, as it holds provider credentials.
+func decodeModelInfo(body []byte) ([]LiteLLMModel, error) {
+	type pricing struct {
+		Mode               *string  `json:"mode"`
+		InputCostPerToken  *float64 `json:"input_cost_per_token"`
+		OutputCostPerToken *float64 `json:"output_cost_per_token"`
+		MaxInputTokens     *int     `json:"max_input_tokens"`
+		MaxOutputTokens    *int     `json:"max_output_tokens"`
+		MaxTokens          *int     `json:"max_tokens"`
+	}
+	var info struct {
+		Data []struct {
+			ModelName     string  `json:"model_name"`
+			LiteLLMParams pricing `json:"litellm_params"`
+			ModelInfo     pricing `json:"model_info"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &info); err != nil {
+		return nil, fmt.Errorf("failed to decode model info: %v", err)
+	}
+
+	var models []LiteLLMModel
+	index := make(map[string]int)
+	for _, d := range info.Data {
+		i, ok := index[d.ModelName]
+		if !ok {
+			i = len(models)
+			index[d.ModelName] = i
+			models = append(models, LiteLLMModel{ModelName: d.ModelName})
+		}
+		m := &models[i]
+		for _, p := range []pricing{d.ModelInfo, d.LiteLLMParams} {
+			setIfZero(&m.Mode, p.Mode)
+			setIfZero(&m.InputCostPerToken, p.InputCostPerToken)
+			setIfZero(&m.OutputCostPerToken, p.OutputCostPerToken)
+			setIfZero(&m.MaxInputTokens, p.MaxInputTokens)
+			setIfZero(&m.MaxOutputTokens, p.MaxOutputTokens)
+			// Releases before max_input_tokens only have max_tokens.
+			setIfZero(&m.MaxInputTokens, p.MaxTokens)
+		}
+	}
+	return models, nil
+}
+
+func setIfZero[T comparable](dst *T, src *T) {
+	var zero T
+	if *dst == zero && src != nil {
+		*dst = *src
+	}
+}
+
+// decodeVersion reads the LiteLLM release from a /health/readiness
+// response. Releases that predate the litellm_version field yield "".
+func decodeVersion(body []byte) (string, error) {
+	var readiness struct {
+		Version string `json:"litellm_version"`
+	}
+	if err := json.Unmarshal(body, &readiness); err != nil {
+		return "", fmt.Errorf("failed to decode readiness: %v", err)
+	}
+	return readiness.Version, nil
+}
+
+func isNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states.
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// CircuitOpenError is returned without calling LiteLLM while the breaker is
+// open. RetryAfter is how long until the breaker lets a probe through.
+type CircuitOpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("LiteLLM circuit breaker is open, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// CircuitBreaker stops calling LiteLLM once it is clearly down, so requests
+// fail fast instead of piling up behind timeouts. After Threshold
+// consecutive transient failures it opens for Cooldown; then it lets one
+// call through as a probe and closes again if that succeeds. Failures that
+// are not transient, such as a rejected request, count as successes since
+// LiteLLM answered.
+type CircuitBreaker struct {
+	Threshold int
+	Cooldown  time.Duration
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+var litellmBreakerOpened = expvar.NewInt("litellm_breaker_opened")
+
+func init() {
+	expvar.Publish("litellm_breaker_state", expvar.Func(func() interface{} {
+		if DefaultBreaker == nil {
+			return "disabled"
+		}
+		return DefaultBreaker.State()
+	}))
+}
+
+func NewCircuitBreaker(threshold int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{Threshold: threshold, Cooldown: cooldown, state: BreakerClosed}
+}
+
+// Wrap returns a client whose calls pass through the breaker.
+func (b *CircuitBreaker) Wrap(next LiteLLMClient) LiteLLMClient {
+	return Intercept(next, b.Intercept)
+}
+
+func (b *CircuitBreaker) Intercept(ctx context.Context, op string, call func(context.Context) error) error {
+	if err := b.allow(); err != nil {
+		return err
+	}
+	err := call(ctx)
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up, which says nothing about LiteLLM.
+		b.abandon()
+		return err
+	}
+	b.record(IsTransient(err))
+	return err
+}
+
+// State returns BreakerClosed, BreakerOpen or BreakerHalfOpen.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.Cooldown {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// RetryAfter returns how long until an open breaker lets a probe through,
+// or 0 when calls are allowed.
+func (b *CircuitBreaker) RetryAfter() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != BreakerOpen {
+		return 0
+	}
+	return max(b.Cooldown-time.Since(b.openedAt), 0)
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen {
+		remaining := b.Cooldown - time.Since(b.openedAt)
+		if remaining > 0 {
+			return &CircuitOpenError{RetryAfter: remaining}
+		}
+		b.state = BreakerHalfOpen
+	}
+	if b.state == BreakerHalfOpen {
+		// Only one probe at a time; everyone else keeps failing fast.
+		if b.probing {
+			return &CircuitOpenError{RetryAfter: time.Second}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// abandon releases the probe slot of a call that was cancelled before
+// LiteLLM answered, leaving the state as it was for the next call to test.
+func (b *CircuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *CircuitBreaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.state == BreakerHalfOpen
+	b.probing = false
+	if !failed {
+		if wasProbe {
+			log.Printf("LiteLLM circuit breaker closed")
+		}
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if wasProbe || b.failures >= b.Threshold {
+		if b.state != BreakerOpen {
+			log.Printf("LiteLLM circuit breaker opened after %d consecutive failure(s)", b.failures)
+			litellmBreakerOpened.Add(1)
+		}
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
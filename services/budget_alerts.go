@@ -0,0 +1,170 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BudgetAlerter notifies users, and optionally admins, when a key or a user's
+// total spend crosses a percentage of its max_budget. Each threshold fires at
+// most once per budget period; the period is identified by LiteLLM's
+// budget_reset_at so that alerts re-arm after a reset. Thresholds are checked
+// against the data the SpendPoller fetches, so alerts stop when spend
+// snapshots are disabled.
+type BudgetAlerter struct {
+	DB          *gorm.DB
+	Notifier    Notifier
+	Thresholds  []float64
+	NotifyUsers bool
+	AdminEmails []string
+}
+
+func NewBudgetAlerter(db *gorm.DB, notifier Notifier) *BudgetAlerter {
+	thresholds := append([]float64(nil), config.AppConfig.AlertThresholds...)
+	sort.Float64s(thresholds)
+	return &BudgetAlerter{
+		DB:          db,
+		Notifier:    notifier,
+		Thresholds:  thresholds,
+		NotifyUsers: config.AppConfig.AlertNotifyUsers,
+		AdminEmails: config.AppConfig.AlertAdminEmails,
+	}
+}
+
+// CheckUser evaluates the user's total spend against their max_budget.
+func (a *BudgetAlerter) CheckUser(user *LiteLLMUser) {
+	if user == nil {
+		return
+	}
+	a.check(budgetSubject{
+		Type:          "user",
+		ID:            user.UserID,
+		UserID:        user.UserID,
+		Name:          user.UserID,
+		Spend:         user.Spend,
+		MaxBudget:     user.MaxBudget,
+		BudgetResetAt: user.BudgetResetAt,
+	})
+}
+
+// CheckKeys evaluates every key owned by userID against its max_budget.
+func (a *BudgetAlerter) CheckKeys(userID string, keys []LiteLLMKey) {
+	for _, k := range keys {
+		if k.User != userID {
+			continue
+		}
+		a.check(budgetSubject{
+			Type:          "key",
+			ID:            k.Key,
+			UserID:        userID,
+			Name:          k.KeyAlias,
+			Spend:         k.Spend,
+			MaxBudget:     k.MaxBudget,
+			BudgetResetAt: k.BudgetResetAt,
+		})
+	}
+}
+
+type budgetSubject struct {
+	Type          string
+	ID            string
+	UserID        string
+	Name          string
+	Spend         float64
+	MaxBudget     float64
+	BudgetResetAt string
+}
+
+func (a *BudgetAlerter) check(s budgetSubject) {
+	if s.MaxBudget <= 0 || s.ID == "" {
+		return
+	}
+	percent := s.Spend / s.MaxBudget * 100
+
+	// Every newly crossed threshold is recorded, but only the highest is
+	// notified so a jump from 40% to 100% produces a single message. They are
+	// recorded once the notification is delivered, so a failed delivery is
+	// retried on the next check.
+	var crossed []models.BudgetAlert
+	for _, threshold := range a.Thresholds {
+		if percent < threshold {
+			break
+		}
+		var recorded int64
+		err := a.DB.Model(&models.BudgetAlert{}).
+			Where("subject_type = ? AND subject_id = ? AND threshold = ? AND period = ?", s.Type, s.ID, threshold, s.BudgetResetAt).
+			Count(&recorded).Error
+		if err != nil {
+			log.Printf("Failed to look up budget alerts for %s %s: %v", s.Type, s.ID, err)
+			return
+		}
+		if recorded == 0 {
+			crossed = append(crossed, models.BudgetAlert{
+				SubjectType: s.Type,
+				SubjectID:   s.ID,
+				Threshold:   threshold,
+				Period:      s.BudgetResetAt,
+				UserID:      s.UserID,
+				Spend:       s.Spend,
+				MaxBudget:   s.MaxBudget,
+			})
+		}
+	}
+	if len(crossed) == 0 {
+		return
+	}
+
+	if a.Notifier != nil {
+		if err := a.Notifier.Notify(a.notification(s, crossed[len(crossed)-1].Threshold)); err != nil {
+			log.Printf("Failed to deliver budget alert for %s %s, retrying on the next check: %v", s.Type, s.ID, err)
+			return
+		}
+	}
+
+	if err := a.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&crossed).Error; err != nil {
+		log.Printf("Failed to record budget alert for %s %s: %v", s.Type, s.ID, err)
+	}
+}
+
+func (a *BudgetAlerter) notification(s budgetSubject, threshold float64) Notification {
+	var recipients []string
+	if a.NotifyUsers {
+		recipients = append(recipients, s.UserID)
+	}
+	recipients = append(recipients, a.AdminEmails...)
+
+	var subject, body string
+	if s.Type == "user" {
+		subject = fmt.Sprintf("Budget alert: %s reached %.0f%% of the account budget", s.UserID, threshold)
+		body = fmt.Sprintf("The LiteLLM account %s has spent $%.2f of its $%.2f budget (%.0f%% threshold).", s.UserID, s.Spend, s.MaxBudget, threshold)
+	} else {
+		subject = fmt.Sprintf("Budget alert: key %q reached %.0f%% of its budget", s.Name, threshold)
+		body = fmt.Sprintf("The key %q (%s) owned by %s has spent $%.2f of its $%.2f budget (%.0f%% threshold).", s.Name, s.ID, s.UserID, s.Spend, s.MaxBudget, threshold)
+	}
+	if s.BudgetResetAt != "" {
+		body += fmt.Sprintf("\nThe budget resets at %s.", s.BudgetResetAt)
+	}
+
+	return Notification{
+		Event:      "budget.threshold",
+		Recipients: recipients,
+		Subject:    subject,
+		Body:       body,
+		Data: map[string]interface{}{
+			"subject_type":    s.Type,
+			"subject_id":      s.ID,
+			"user_id":         s.UserID,
+			"name":            s.Name,
+			"threshold":       threshold,
+			"spend":           s.Spend,
+			"max_budget":      s.MaxBudget,
+			"budget_reset_at": s.BudgetResetAt,
+		},
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupAlertTestDB(t *testing.T) *gorm.DB {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	return db
+}
+
+func TestBudgetAlerter(t *testing.T) {
+	webhook := newWebhookSink(t)
+	smtpServer := newSMTPSink(t)
+	host, port := smtpServer.hostPort()
+
+	config.AppConfig = &config.Config{
+		AlertThresholds:  []float64{100, 50, 80},
+		AlertNotifyUsers: true,
+		AlertAdminEmails: []string{"admin@example.com"},
+	}
+	db := setupAlertTestDB(t)
+	alerter := NewBudgetAlerter(db, MultiNotifier{
+		NewWebhookNotifier(webhook.server.URL),
+		&EmailNotifier{Host: host, Port: port, From: "llmreq@example.com"},
+	})
+
+	keys := func(spend float64, resetAt string) []LiteLLMKey {
+		return []LiteLLMKey{
+			{Key: "sk-a", KeyAlias: "a", User: "test@example.com", Spend: spend, MaxBudget: 10, BudgetResetAt: resetAt},
+			{Key: "sk-x", User: "other@example.com", Spend: 100, MaxBudget: 10},
+		}
+	}
+
+	// Below every threshold
+	alerter.CheckKeys("test@example.com", keys(4, "2026-01-08"))
+	if n := len(webhook.received()); n != 0 {
+		t.Fatalf("Expected no alerts, got %d", n)
+	}
+
+	// Jumping past 50% and 80% sends one notification for 80%.
+	alerter.CheckKeys("test@example.com", keys(8.5, "2026-01-08"))
+	events := webhook.received()
+	if len(events) != 1 {
+		t.Fatalf("Expected 1 alert, got %d", len(events))
+	}
+	if events[0].Data["threshold"].(float64) != 80 {
+		t.Errorf("Expected 80%% threshold, got %v", events[0].Data["threshold"])
+	}
+	if len(events[0].Recipients) != 2 {
+		t.Errorf("Expected user and admin recipients, got %v", events[0].Recipients)
+	}
+
+	// Same period, same spend: nothing new.
+	alerter.CheckKeys("test@example.com", keys(9, "2026-01-08"))
+	if n := len(webhook.received()); n != 1 {
+		t.Fatalf("Expected thresholds to fire once per period, got %d alerts", n)
+	}
+
+	// A new budget period re-arms the thresholds.
+	alerter.CheckKeys("test@example.com", keys(9, "2026-01-15"))
+	if n := len(webhook.received()); n != 2 {
+		t.Fatalf("Expected alert in new period, got %d alerts", n)
+	}
+
+	// User totals
+	alerter.CheckUser(&LiteLLMUser{UserID: "test@example.com", Spend: 10, MaxBudget: 10})
+	events = webhook.received()
+	if len(events) != 3 || events[2].Data["subject_type"] != "user" || events[2].Data["threshold"].(float64) != 100 {
+		t.Fatalf("Unexpected user alert: %+v", events)
+	}
+
+	if messages, _ := smtpServer.received(); len(messages) != 3 {
+		t.Errorf("Expected 3 emails, got %d", len(messages))
+	}
+
+	var count int64
+	db.Model(&models.BudgetAlert{}).Count(&count)
+	if count != 7 {
+		t.Errorf("Expected 7 recorded thresholds, got %d", count)
+	}
+}
+
+// notifyFunc adapts a function to the Notifier interface.
+type notifyFunc func(n Notification) error
+
+func (f notifyFunc) Notify(n Notification) error { return f(n) }
+
+func TestBudgetAlerter_FailedDelivery(t *testing.T) {
+	config.AppConfig = &config.Config{AlertThresholds: []float64{50, 80}, AlertNotifyUsers: true}
+	db := setupAlertTestDB(t)
+
+	var delivered []Notification
+	fail := true
+	alerter := NewBudgetAlerter(db, notifyFunc(func(n Notification) error {
+		if fail {
+			return errors.New("smtp: connection refused")
+		}
+		delivered = append(delivered, n)
+		return nil
+	}))
+	user := &LiteLLMUser{UserID: "test@example.com", Spend: 9, MaxBudget: 10}
+
+	alerter.CheckUser(user)
+	var count int64
+	if db.Model(&models.BudgetAlert{}).Count(&count); count != 0 {
+		t.Fatalf("Expected an undelivered alert not to be recorded, got %d", count)
+	}
+
+	// The next check retries the delivery.
+	fail = false
+	alerter.CheckUser(user)
+	if len(delivered) != 1 || delivered[0].Data["threshold"].(float64) != 80 {
+		t.Fatalf("Expected the 80%% alert to be retried, got %+v", delivered)
+	}
+	if db.Model(&models.BudgetAlert{}).Count(&count); count != 2 {
+		t.Errorf("Expected both thresholds recorded after delivery, got %d", count)
+	}
+}
@@ -0,0 +1,433 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"math/rand/v2"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/llmreq/config"
+)
+
+// LiteLLMClient is the subset of the LiteLLM admin API that llmreq uses.
+// LiteLLMService implements it over HTTP; the decorators below wrap any
+// implementation, so embedders can substitute their own gateway.
+type LiteLLMClient interface {
+	GetUserInfo(ctx context.Context, userID string) (*LiteLLMUser, error)
+	CreateUser(ctx context.Context, req NewUserRequest) error
+	UpdateUser(ctx context.Context, req UpdateUserRequest) error
+
+	ListKeys(ctx context.Context, userID string) ([]LiteLLMKey, error)
+	ListTeamKeys(ctx context.Context, teamID string) ([]LiteLLMKey, error)
+	FindKeys(ctx context.Context, filter KeyFilter) ([]LiteLLMKey, error)
+	GenerateKey(ctx context.Context, req GenerateKeyRequest) (*GenerateKeyResponse, error)
+	UpdateKey(ctx context.Context, req UpdateKeyRequest) error
+	DeleteKey(ctx context.Context, keyID string) error
+	BlockKey(ctx context.Context, keyID string) error
+	UnblockKey(ctx context.Context, keyID string) error
+
+	CreateTeam(ctx context.Context, req NewTeamRequest) (*LiteLLMTeam, error)
+	GetTeamInfo(ctx context.Context, teamID string) (*LiteLLMTeam, error)
+	UpdateTeam(ctx context.Context, req UpdateTeamRequest) error
+	AddTeamMember(ctx context.Context, teamID string, member LiteLLMTeamMember) error
+	UpdateTeamMember(ctx context.Context, teamID, userID, role string) error
+	RemoveTeamMember(ctx context.Context, teamID, userID string) error
+
+	ListModels(ctx context.Context) ([]LiteLLMModel, error)
+}
+
+var _ LiteLLMClient = (*LiteLLMService)(nil)
+
+// DefaultBreaker is the circuit breaker of the default instance built by
+// NewLiteLLMClient or NewRoutedClient, or nil when breaking is disabled.
+// Breakers holds those of every instance, keyed by name.
+var (
+	DefaultBreaker *CircuitBreaker
+	Breakers       map[string]*CircuitBreaker
+)
+
+// NewLiteLLMClient wraps the HTTP service in the decorators enabled by
+// config.AppConfig. From the inside out: retries when LLMREQ_LITELLM_RETRIES
+// is above zero, the circuit breaker when LLMREQ_LITELLM_BREAKER_THRESHOLD
+// is above zero, metrics always, and caching of reads when
+// LLMREQ_LITELLM_CACHE_TTL is set.
+func NewLiteLLMClient(service *LiteLLMService) LiteLLMClient {
+	client, breaker := decorate(service)
+	DefaultBreaker = breaker
+	Breakers = map[string]*CircuitBreaker{}
+	if breaker != nil {
+		Breakers[config.DefaultInstance] = breaker
+	}
+	return client
+}
+
+func decorate(service *LiteLLMService) (LiteLLMClient, *CircuitBreaker) {
+	var client LiteLLMClient = service
+	if config.AppConfig.LiteLLMRetries > 0 {
+		client = NewRetryClient(client, config.AppConfig.LiteLLMRetries, config.AppConfig.LiteLLMRetryBackoff)
+	}
+	var breaker *CircuitBreaker
+	if config.AppConfig.LiteLLMBreakerThreshold > 0 {
+		breaker = NewCircuitBreaker(config.AppConfig.LiteLLMBreakerThreshold, config.AppConfig.LiteLLMBreakerCooldown)
+		client = breaker.Wrap(client)
+	}
+	client = NewMetricsClient(client)
+	if config.AppConfig.LiteLLMCacheTTL > 0 {
+		client = NewCachingClient(client, config.AppConfig.LiteLLMCacheTTL)
+	}
+	return client, breaker
+}
+
+// Interceptor runs call, the named operation on the wrapped client, and may
+// run it again, skip it or observe its outcome. Operation names are the
+// snake_case method names, e.g. "list_keys".
+type Interceptor func(ctx context.Context, op string, call func(context.Context) error) error
+
+// Intercept returns a client that passes every call of next through i.
+func Intercept(next LiteLLMClient, i Interceptor) LiteLLMClient {
+	return &interceptClient{next: next, intercept: i}
+}
+
+type interceptClient struct {
+	next      LiteLLMClient
+	intercept Interceptor
+}
+
+// intercepted adapts a method with a result to the Interceptor signature.
+func intercepted[T any](ctx context.Context, c *interceptClient, op string, call func(context.Context) (T, error)) (T, error) {
+	var result T
+	err := c.intercept(ctx, op, func(ctx context.Context) error {
+		var err error
+		result, err = call(ctx)
+		return err
+	})
+	return result, err
+}
+
+func (c *interceptClient) GetUserInfo(ctx context.Context, userID string) (*LiteLLMUser, error) {
+	return intercepted(ctx, c, "get_user_info", func(ctx context.Context) (*LiteLLMUser, error) { return c.next.GetUserInfo(ctx, userID) })
+}
+
+func (c *interceptClient) CreateUser(ctx context.Context, req NewUserRequest) error {
+	return c.intercept(ctx, "create_user", func(ctx context.Context) error { return c.next.CreateUser(ctx, req) })
+}
+
+func (c *interceptClient) UpdateUser(ctx context.Context, req UpdateUserRequest) error {
+	return c.intercept(ctx, "update_user", func(ctx context.Context) error { return c.next.UpdateUser(ctx, req) })
+}
+
+func (c *interceptClient) ListKeys(ctx context.Context, userID string) ([]LiteLLMKey, error) {
+	return intercepted(ctx, c, "list_keys", func(ctx context.Context) ([]LiteLLMKey, error) { return c.next.ListKeys(ctx, userID) })
+}
+
+func (c *interceptClient) ListTeamKeys(ctx context.Context, teamID string) ([]LiteLLMKey, error) {
+	return intercepted(ctx, c, "list_team_keys", func(ctx context.Context) ([]LiteLLMKey, error) { return c.next.ListTeamKeys(ctx, teamID) })
+}
+
+func (c *interceptClient) FindKeys(ctx context.Context, filter KeyFilter) ([]LiteLLMKey, error) {
+	return intercepted(ctx, c, "find_keys", func(ctx context.Context) ([]LiteLLMKey, error) { return c.next.FindKeys(ctx, filter) })
+}
+
+func (c *interceptClient) GenerateKey(ctx context.Context, req GenerateKeyRequest) (*GenerateKeyResponse, error) {
+	return intercepted(ctx, c, "generate_key", func(ctx context.Context) (*GenerateKeyResponse, error) { return c.next.GenerateKey(ctx, req) })
+}
+
+func (c *interceptClient) UpdateKey(ctx context.Context, req UpdateKeyRequest) error {
+	return c.intercept(ctx, "update_key", func(ctx context.Context) error { return c.next.UpdateKey(ctx, req) })
+}
+
+func (c *interceptClient) DeleteKey(ctx context.Context, keyID string) error {
+	return c.intercept(ctx, "delete_key", func(ctx context.Context) error { return c.next.DeleteKey(ctx, keyID) })
+}
+
+func (c *interceptClient) BlockKey(ctx context.Context, keyID string) error {
+	return c.intercept(ctx, "block_key", func(ctx context.Context) error { return c.next.BlockKey(ctx, keyID) })
+}
+
+func (c *interceptClient) UnblockKey(ctx context.Context, keyID string) error {
+	return c.intercept(ctx, "unblock_key", func(ctx context.Context) error { return c.next.UnblockKey(ctx, keyID) })
+}
+
+func (c *interceptClient) CreateTeam(ctx context.Context, req NewTeamRequest) (*LiteLLMTeam, error) {
+	return intercepted(ctx, c, "create_team", func(ctx context.Context) (*LiteLLMTeam, error) { return c.next.CreateTeam(ctx, req) })
+}
+
+func (c *interceptClient) GetTeamInfo(ctx context.Context, teamID string) (*LiteLLMTeam, error) {
+	return intercepted(ctx, c, "get_team_info", func(ctx context.Context) (*LiteLLMTeam, error) { return c.next.GetTeamInfo(ctx, teamID) })
+}
+
+func (c *interceptClient) UpdateTeam(ctx context.Context, req UpdateTeamRequest) error {
+	return c.intercept(ctx, "update_team", func(ctx context.Context) error { return c.next.UpdateTeam(ctx, req) })
+}
+
+func (c *interceptClient) AddTeamMember(ctx context.Context, teamID string, member LiteLLMTeamMember) error {
+	return c.intercept(ctx, "add_team_member", func(ctx context.Context) error { return c.next.AddTeamMember(ctx, teamID, member) })
+}
+
+func (c *interceptClient) UpdateTeamMember(ctx context.Context, teamID, userID, role string) error {
+	return c.intercept(ctx, "update_team_member", func(ctx context.Context) error { return c.next.UpdateTeamMember(ctx, teamID, userID, role) })
+}
+
+func (c *interceptClient) RemoveTeamMember(ctx context.Context, teamID, userID string) error {
+	return c.intercept(ctx, "remove_team_member", func(ctx context.Context) error { return c.next.RemoveTeamMember(ctx, teamID, userID) })
+}
+
+func (c *interceptClient) ListModels(ctx context.Context) ([]LiteLLMModel, error) {
+	return intercepted(ctx, c, "list_models", func(ctx context.Context) ([]LiteLLMModel, error) { return c.next.ListModels(ctx) })
+}
+
+// idempotentOps may be repeated without changing the outcome, so they are
+// retried after any transient failure. The remaining operations create
+// something and are only retried when the request never left llmreq.
+var idempotentOps = map[string]bool{
+	"get_user_info":      true,
+	"list_keys":          true,
+	"list_team_keys":     true,
+	"find_keys":          true,
+	"get_team_info":      true,
+	"list_models":        true,
+	"update_user":        true,
+	"update_key":         true,
+	"delete_key":         true,
+	"block_key":          true,
+	"unblock_key":        true,
+	"update_team":        true,
+	"update_team_member": true,
+	"remove_team_member": true,
+}
+
+// RetryPolicy retries transient failures with exponential backoff and full
+// jitter: before retry n it waits a random duration up to
+// Backoff * 2^(n-1), capped at MaxBackoff.
+type RetryPolicy struct {
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// NewRetryClient retries calls of next up to retries times.
+func NewRetryClient(next LiteLLMClient, retries int, backoff time.Duration) LiteLLMClient {
+	policy := &RetryPolicy{Retries: retries, Backoff: backoff, MaxBackoff: 10 * time.Second}
+	return Intercept(next, policy.Intercept)
+}
+
+// Intercept gives up early when ctx is done, returning the last error.
+func (p *RetryPolicy) Intercept(ctx context.Context, op string, call func(context.Context) error) error {
+	err := call(ctx)
+	ceiling := p.Backoff
+	for attempt := 1; err != nil && attempt <= p.Retries && p.retryable(op, err); attempt++ {
+		wait := time.Duration(0)
+		if ceiling > 0 {
+			wait = rand.N(ceiling)
+		}
+		log.Printf("LiteLLM %s failed, retrying in %s (%d/%d): %v", op, wait.Round(time.Millisecond), attempt, p.Retries, err)
+		litellmRetries.Add(op, 1)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+		ceiling = min(ceiling*2, p.MaxBackoff)
+		err = call(ctx)
+	}
+	return err
+}
+
+func (p *RetryPolicy) retryable(op string, err error) bool {
+	if idempotentOps[op] {
+		return IsTransient(err)
+	}
+	return notSent(err)
+}
+
+// IsTransient reports whether err is a failure that may go away on its own:
+// a network error or timeout talking to LiteLLM, or an error that says so
+// through a Transient method. Cancellation by the caller is not transient.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var open *CircuitOpenError
+	if errors.As(err, &open) {
+		return false
+	}
+	var t interface{ Transient() bool }
+	if errors.As(err, &t) {
+		return t.Transient()
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// notSent reports whether err happened before the request reached LiteLLM,
+// which makes even a non-idempotent call safe to repeat.
+func notSent(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// Metrics published under /debug/vars, keyed by operation name.
+var (
+	litellmCalls     = expvar.NewMap("litellm_calls")
+	litellmErrors    = expvar.NewMap("litellm_errors")
+	litellmLatencyMs = expvar.NewMap("litellm_latency_ms")
+	litellmRetries   = expvar.NewMap("litellm_retries")
+	litellmCacheHits = expvar.NewMap("litellm_cache_hits")
+)
+
+// NewMetricsClient counts calls and errors and sums the latency of every
+// operation of next.
+func NewMetricsClient(next LiteLLMClient) LiteLLMClient {
+	return Intercept(next, observe)
+}
+
+func observe(ctx context.Context, op string, call func(context.Context) error) error {
+	start := time.Now()
+	err := call(ctx)
+	litellmCalls.Add(op, 1)
+	litellmLatencyMs.Add(op, time.Since(start).Milliseconds())
+	if err != nil {
+		litellmErrors.Add(op, 1)
+	}
+	return err
+}
+
+// CachingClient serves user, team and key list reads from memory for TTL.
+// Writes made through it invalidate the entries they affect; changes made
+// directly in LiteLLM show up once the entry expires. Errors are never
+// cached.
+type CachingClient struct {
+	LiteLLMClient
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func NewCachingClient(next LiteLLMClient, ttl time.Duration) *CachingClient {
+	return &CachingClient{LiteLLMClient: next, TTL: ttl, entries: make(map[string]cacheEntry)}
+}
+
+func cached[T any](c *CachingClient, op, key string, call func() (T, error)) (T, error) {
+	key = op + ":" + key
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		litellmCacheHits.Add(op, 1)
+		return entry.value.(T), nil
+	}
+
+	result, err := call()
+	if err != nil {
+		return result, err
+	}
+	c.mu.Lock()
+	c.entries[key] = cacheEntry{value: result, expires: time.Now().Add(c.TTL)}
+	c.mu.Unlock()
+	return result, nil
+}
+
+// invalidate drops the entry for key, or every entry of op when key is "".
+func (c *CachingClient) invalidate(op, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if key != "" {
+		delete(c.entries, op+":"+key)
+		return
+	}
+	prefix := op + ":"
+	for k := range c.entries {
+		if strings.HasPrefix(k, prefix) {
+			delete(c.entries, k)
+		}
+	}
+}
+
+// invalidateKeys drops every cached key list. A key ID does not say which
+// user or team it belongs to, so key writes clear them all.
+func (c *CachingClient) invalidateKeys() {
+	c.invalidate("list_keys", "")
+	c.invalidate("list_team_keys", "")
+}
+
+func (c *CachingClient) GetUserInfo(ctx context.Context, userID string) (*LiteLLMUser, error) {
+	return cached(c, "get_user_info", userID, func() (*LiteLLMUser, error) { return c.LiteLLMClient.GetUserInfo(ctx, userID) })
+}
+
+func (c *CachingClient) CreateUser(ctx context.Context, req NewUserRequest) error {
+	defer c.invalidate("get_user_info", req.UserID)
+	return c.LiteLLMClient.CreateUser(ctx, req)
+}
+
+func (c *CachingClient) UpdateUser(ctx context.Context, req UpdateUserRequest) error {
+	defer c.invalidate("get_user_info", req.UserID)
+	return c.LiteLLMClient.UpdateUser(ctx, req)
+}
+
+func (c *CachingClient) ListKeys(ctx context.Context, userID string) ([]LiteLLMKey, error) {
+	return cached(c, "list_keys", userID, func() ([]LiteLLMKey, error) { return c.LiteLLMClient.ListKeys(ctx, userID) })
+}
+
+func (c *CachingClient) ListTeamKeys(ctx context.Context, teamID string) ([]LiteLLMKey, error) {
+	return cached(c, "list_team_keys", teamID, func() ([]LiteLLMKey, error) { return c.LiteLLMClient.ListTeamKeys(ctx, teamID) })
+}
+
+func (c *CachingClient) GenerateKey(ctx context.Context, req GenerateKeyRequest) (*GenerateKeyResponse, error) {
+	defer c.invalidateKeys()
+	return c.LiteLLMClient.GenerateKey(ctx, req)
+}
+
+func (c *CachingClient) UpdateKey(ctx context.Context, req UpdateKeyRequest) error {
+	defer c.invalidateKeys()
+	return c.LiteLLMClient.UpdateKey(ctx, req)
+}
+
+func (c *CachingClient) DeleteKey(ctx context.Context, keyID string) error {
+	defer c.invalidateKeys()
+	return c.LiteLLMClient.DeleteKey(ctx, keyID)
+}
+
+func (c *CachingClient) BlockKey(ctx context.Context, keyID string) error {
+	defer c.invalidateKeys()
+	return c.LiteLLMClient.BlockKey(ctx, keyID)
+}
+
+func (c *CachingClient) UnblockKey(ctx context.Context, keyID string) error {
+	defer c.invalidateKeys()
+	return c.LiteLLMClient.UnblockKey(ctx, keyID)
+}
+
+func (c *CachingClient) GetTeamInfo(ctx context.Context, teamID string) (*LiteLLMTeam, error) {
+	return cached(c, "get_team_info", teamID, func() (*LiteLLMTeam, error) { return c.LiteLLMClient.GetTeamInfo(ctx, teamID) })
+}
+
+func (c *CachingClient) UpdateTeam(ctx context.Context, req UpdateTeamRequest) error {
+	defer c.invalidate("get_team_info", req.TeamID)
+	return c.LiteLLMClient.UpdateTeam(ctx, req)
+}
+
+func (c *CachingClient) AddTeamMember(ctx context.Context, teamID string, member LiteLLMTeamMember) error {
+	defer c.invalidate("get_team_info", teamID)
+	return c.LiteLLMClient.AddTeamMember(ctx, teamID, member)
+}
+
+func (c *CachingClient) UpdateTeamMember(ctx context.Context, teamID, userID, role string) error {
+	defer c.invalidate("get_team_info", teamID)
+	return c.LiteLLMClient.UpdateTeamMember(ctx, teamID, userID, role)
+}
+
+func (c *CachingClient) RemoveTeamMember(ctx context.Context, teamID, userID string) error {
+	defer c.invalidate("get_team_info", teamID)
+	return c.LiteLLMClient.RemoveTeamMember(ctx, teamID, userID)
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// errDial is a transient failure that happened before anything was sent.
+var errDial = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// stubClient answers user and key reads from memory and fails the first
+// failures calls. Unimplemented operations panic via the nil embedded
+// interface.
+type stubClient struct {
+	LiteLLMClient
+	failures int
+	calls    int
+	deleted  []string
+}
+
+func (s *stubClient) GetUserInfo(ctx context.Context, userID string) (*LiteLLMUser, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, errDial
+	}
+	return &LiteLLMUser{UserID: userID, Spend: float64(s.calls)}, nil
+}
+
+func (s *stubClient) ListKeys(ctx context.Context, userID string) ([]LiteLLMKey, error) {
+	s.calls++
+	return []LiteLLMKey{{Key: "sk-1", User: userID}}, nil
+}
+
+func (s *stubClient) DeleteKey(ctx context.Context, keyID string) error {
+	s.deleted = append(s.deleted, keyID)
+	return nil
+}
+
+func (s *stubClient) GenerateKey(ctx context.Context, req GenerateKeyRequest) (*GenerateKeyResponse, error) {
+	s.calls++
+	if s.calls <= s.failures {
+		return nil, errDial
+	}
+	return nil, errors.New("upstream error")
+}
+
+func (s *stubClient) UpdateKey(ctx context.Context, req UpdateKeyRequest) error {
+	s.calls++
+	return &net.OpError{Op: "read", Net: "tcp", Err: errors.New("connection reset")}
+}
+
+func TestRetryClient(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{failures: 2}
+	client := NewRetryClient(stub, 2, time.Millisecond)
+
+	user, err := client.GetUserInfo(ctx, "a@example.com")
+	if err != nil || user == nil || stub.calls != 3 {
+		t.Fatalf("Expected success on the third attempt, got %v, %v after %d calls", user, err, stub.calls)
+	}
+
+	stub.calls, stub.failures = 0, 5
+	if _, err := client.GetUserInfo(ctx, "a@example.com"); err == nil || stub.calls != 3 {
+		t.Errorf("Expected failure after 3 attempts, got %v after %d calls", err, stub.calls)
+	}
+
+	// A cancelled context stops retrying.
+	stub.calls = 0
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := NewRetryClient(stub, 2, time.Minute).GetUserInfo(cancelled, "a@example.com"); err == nil || stub.calls != 1 {
+		t.Errorf("Expected no retries after cancellation, got %d calls", stub.calls)
+	}
+
+	// Creating writes are only retried when the request was never sent.
+	stub.calls, stub.failures = 0, 1
+	if _, err := client.GenerateKey(ctx, GenerateKeyRequest{}); err == nil || err == errDial || stub.calls != 2 {
+		t.Errorf("Expected GenerateKey to be retried after a dial error, got %v after %d calls", err, stub.calls)
+	}
+	stub.calls, stub.failures = 0, 0
+	if _, err := client.GenerateKey(ctx, GenerateKeyRequest{}); err == nil || stub.calls != 1 {
+		t.Errorf("Expected a single GenerateKey attempt, got %d", stub.calls)
+	}
+
+	// Idempotent writes are retried after any transient failure.
+	stub.calls = 0
+	if err := client.UpdateKey(ctx, UpdateKeyRequest{}); err == nil || stub.calls != 3 {
+		t.Errorf("Expected UpdateKey to be retried, got %d calls", stub.calls)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{failures: 100}
+	breaker := NewCircuitBreaker(3, 50*time.Millisecond)
+	client := breaker.Wrap(stub)
+
+	for i := 0; i < 3; i++ {
+		client.GetUserInfo(ctx, "a@example.com")
+	}
+	if breaker.State() != BreakerOpen {
+		t.Fatalf("Expected breaker to open after 3 failures, got %s", breaker.State())
+	}
+
+	// Open: fail fast without calling LiteLLM.
+	_, err := client.GetUserInfo(ctx, "a@example.com")
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || open.RetryAfter <= 0 || stub.calls != 3 {
+		t.Errorf("Expected a fast CircuitOpenError, got %v after %d calls", err, stub.calls)
+	}
+	if breaker.RetryAfter() <= 0 {
+		t.Error("Expected a positive RetryAfter while open")
+	}
+
+	// After the cooldown a failed probe reopens it at once.
+	time.Sleep(60 * time.Millisecond)
+	if breaker.State() != BreakerHalfOpen {
+		t.Errorf("Expected half-open after cooldown, got %s", breaker.State())
+	}
+	client.GetUserInfo(ctx, "a@example.com")
+	if breaker.State() != BreakerOpen || stub.calls != 4 {
+		t.Errorf("Expected failed probe to reopen, got %s after %d calls", breaker.State(), stub.calls)
+	}
+
+	// A probe the caller cancels neither closes nor reopens it, and the
+	// next call probes again.
+	time.Sleep(60 * time.Millisecond)
+	breaker.Intercept(ctx, "get_user_info", func(context.Context) error { return context.Canceled })
+	if breaker.State() != BreakerHalfOpen {
+		t.Errorf("Expected a cancelled probe to leave the breaker half-open, got %s", breaker.State())
+	}
+
+	// A successful probe closes it.
+	stub.failures = 0
+	if _, err := client.GetUserInfo(ctx, "a@example.com"); err != nil || breaker.State() != BreakerClosed {
+		t.Errorf("Expected successful probe to close the breaker, got %v, %s", err, breaker.State())
+	}
+
+	// Answers that are not transient do not trip it.
+	for i := 0; i < 5; i++ {
+		client.GenerateKey(ctx, GenerateKeyRequest{})
+	}
+	if breaker.State() != BreakerClosed {
+		t.Errorf("Expected rejected requests not to open the breaker, got %s", breaker.State())
+	}
+}
+
+func TestCachingClient(t *testing.T) {
+	ctx := context.Background()
+	stub := &stubClient{}
+	client := NewCachingClient(stub, time.Minute)
+
+	first, _ := client.GetUserInfo(ctx, "a@example.com")
+	second, _ := client.GetUserInfo(ctx, "a@example.com")
+	if stub.calls != 1 || first != second {
+		t.Errorf("Expected the second read to be cached, got %d calls", stub.calls)
+	}
+	client.GetUserInfo(ctx, "b@example.com")
+	if stub.calls != 2 {
+		t.Errorf("Expected users to be cached separately, got %d calls", stub.calls)
+	}
+
+	client.ListKeys(ctx, "a@example.com")
+	client.ListKeys(ctx, "a@example.com")
+	if stub.calls != 3 {
+		t.Errorf("Expected key list to be cached, got %d calls", stub.calls)
+	}
+	client.DeleteKey(ctx, "sk-1")
+	client.ListKeys(ctx, "a@example.com")
+	if stub.calls != 4 || len(stub.deleted) != 1 {
+		t.Errorf("Expected DeleteKey to invalidate key lists, got %d calls", stub.calls)
+	}
+
+	// Errors are not cached.
+	failing := NewCachingClient(&stubClient{failures: 1}, time.Minute)
+	if _, err := failing.GetUserInfo(ctx, "a@example.com"); err == nil {
+		t.Fatal("Expected the first read to fail")
+	}
+	if user, err := failing.GetUserInfo(ctx, "a@example.com"); err != nil || user == nil {
+		t.Errorf("Expected the failure not to be cached, got %v", err)
+	}
+}
+
+func TestMetricsClient(t *testing.T) {
+	ctx := context.Background()
+	client := NewMetricsClient(&stubClient{failures: 1})
+	before := litellmErrors.Get("get_user_info")
+
+	client.GetUserInfo(ctx, "a@example.com")
+	client.GetUserInfo(ctx, "a@example.com")
+
+	if litellmCalls.Get("get_user_info") == nil {
+		t.Fatal("Expected calls to be counted")
+	}
+	errs := litellmErrors.Get("get_user_info")
+	if errs == nil || (before != nil && errs.String() == before.String()) {
+		t.Errorf("Expected the failed call to be counted, got %v", errs)
+	}
+}
@@ -0,0 +1,180 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
+)
+
+// fixtureVersion is the version DetectVersion should report for a fixture
+// set: the release it is named after, unless that release reports none.
+func fixtureVersion(set string) string {
+	if set == "v1.30.0" {
+		return ""
+	}
+	return strings.TrimPrefix(set, "v")
+}
+
+// fixtureServer answers every request with the matching file of a fixture
+// set, and 404 for paths the set lacks.
+func fixtureServer(t *testing.T, dir string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+		if strings.HasPrefix(path, "user/info/") {
+			path = "user/info"
+		}
+		body, err := os.ReadFile(filepath.Join(dir, strings.ReplaceAll(path, "/", "_")+".json"))
+		if err != nil {
+			t.Errorf("No fixture for %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+	}))
+}
+
+// TestContract runs every client method against the responses of each
+// LiteLLM release under testdata/litellm, and under LITELLM_FIXTURES when
+// it names a directory of freshly captured sets.
+func TestContract(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	dirs, err := filepath.Glob("testdata/litellm/v*")
+	if err != nil || len(dirs) == 0 {
+		t.Fatalf("Expected fixture sets, found %v (%v)", dirs, err)
+	}
+	if captured := os.Getenv("LITELLM_FIXTURES"); captured != "" {
+		more, err := filepath.Glob(filepath.Join(captured, "v*"))
+		if err != nil || len(more) == 0 {
+			t.Fatalf("Expected captured fixture sets in %s, found %v (%v)", captured, more, err)
+		}
+		dirs = append(dirs, more...)
+	}
+
+	for _, dir := range dirs {
+		t.Run(dir, func(t *testing.T) {
+			server := fixtureServer(t, dir)
+			defer server.Close()
+			service := NewLiteLLMService()
+			service.BaseURL = server.URL
+			ctx := context.Background()
+
+			version, err := service.DetectVersion(ctx)
+			if err != nil || version != fixtureVersion(filepath.Base(dir)) {
+				t.Errorf("DetectVersion: got %q, %v", version, err)
+			}
+
+			user, err := service.GetUserInfo(ctx, "alice@example.com")
+			if err != nil || user == nil || user.UserID != "alice@example.com" || user.Spend != 1.25 || user.MaxBudget != 10 {
+				t.Errorf("GetUserInfo: got %+v, %v", user, err)
+			}
+
+			teamID := ""
+			for _, team := range []func() (*LiteLLMTeam, error){
+				func() (*LiteLLMTeam, error) { return service.CreateTeam(ctx, NewTeamRequest{TeamAlias: "platform"}) },
+				func() (*LiteLLMTeam, error) { return service.GetTeamInfo(ctx, teamID) },
+			} {
+				got, err := team()
+				if err != nil || got == nil || got.TeamID == "" || got.TeamAlias != "platform" || len(got.Members) != 2 || got.Members[0].Role != "admin" {
+					t.Errorf("Team: got %+v, %v", got, err)
+					continue
+				}
+				if teamID != "" && got.TeamID != teamID {
+					t.Errorf("Team: got ID %q, created %q", got.TeamID, teamID)
+				}
+				teamID = got.TeamID
+			}
+
+			for name, list := range map[string]func() ([]LiteLLMKey, error){
+				"ListKeys":     func() ([]LiteLLMKey, error) { return service.ListKeys(ctx, "alice@example.com") },
+				"ListTeamKeys": func() ([]LiteLLMKey, error) { return service.ListTeamKeys(ctx, teamID) },
+				"FindKeys":     func() ([]LiteLLMKey, error) { return service.FindKeys(ctx, KeyFilter{KeyAlias: "ci"}) },
+			} {
+				keys, err := list()
+				if err != nil || len(keys) != 2 {
+					t.Errorf("%s: got %d keys, %v", name, len(keys), err)
+					continue
+				}
+				// Captured sets list keys in LiteLLM's order, with generated
+				// tokens, so keys are matched by alias.
+				byAlias := map[string]LiteLLMKey{}
+				for _, key := range keys {
+					byAlias[key.KeyAlias] = key
+				}
+				if laptop, ci := byAlias["laptop"], byAlias["ci"]; laptop.Key == "" || laptop.User != "alice@example.com" || ci.Key == "" || ci.Spend != 0.25 {
+					t.Errorf("%s: unexpected keys %+v", name, keys)
+				}
+			}
+
+			gen, err := service.GenerateKey(ctx, GenerateKeyRequest{UserID: "alice@example.com", KeyAlias: "laptop2"})
+			if err != nil || !strings.HasPrefix(gen.Key, "sk-") || gen.KeyAlias != "laptop2" {
+				t.Errorf("GenerateKey: got %+v, %v", gen, err)
+			}
+
+			// The fake-gpt-test model of litellm_config.yaml with its mock
+			// pricing, which litellmfake checks DefaultModels against.
+			want := litellmfake.DefaultModels[0]
+			models, err := service.ListModels(ctx)
+			if err != nil || len(models) != 1 {
+				t.Errorf("ListModels: got %+v, %v", models, err)
+			} else if m := models[0]; m.ModelName != want.Name || m.InputCostPerToken != want.InputCostPerToken || m.OutputCostPerToken != want.OutputCostPerToken || m.MaxInputTokens != want.MaxInputTokens {
+				t.Errorf("ListModels: unexpected model %+v", m)
+			}
+
+			budget := 5.0
+			for name, call := range map[string]func() error{
+				"CreateUser": func() error { return service.CreateUser(ctx, NewUserRequest{UserID: "alice@example.com"}) },
+				"UpdateUser": func() error {
+					return service.UpdateUser(ctx, UpdateUserRequest{UserID: "alice@example.com", MaxBudget: &budget})
+				},
+				"UpdateKey":  func() error { return service.UpdateKey(ctx, UpdateKeyRequest{Key: "sk-...a1b2", Duration: "60d"}) },
+				"DeleteKey":  func() error { return service.DeleteKey(ctx, "sk-...a1b2") },
+				"BlockKey":   func() error { return service.BlockKey(ctx, "sk-...a1b2") },
+				"UnblockKey": func() error { return service.UnblockKey(ctx, "sk-...a1b2") },
+				"UpdateTeam": func() error { return service.UpdateTeam(ctx, UpdateTeamRequest{TeamID: teamID, MaxBudget: &budget}) },
+				"AddTeamMember": func() error {
+					return service.AddTeamMember(ctx, teamID, LiteLLMTeamMember{UserID: "carol@example.com", Role: "user"})
+				},
+				"UpdateTeamMember": func() error { return service.UpdateTeamMember(ctx, teamID, "bob@example.com", "admin") },
+				"RemoveTeamMember": func() error { return service.RemoveTeamMember(ctx, teamID, "bob@example.com") },
+			} {
+				if err := call(); err != nil {
+					t.Errorf("%s: %v", name, err)
+				}
+			}
+		})
+	}
+}
+
+// TestResponseAdapters covers shapes that are not part of a full fixture set.
+func TestResponseAdapters(t *testing.T) {
+	// Current releases answer an unknown user with a null user_info.
+	user, err := decodeUserInfo([]byte(`{"user_id": "ghost@example.com", "user_info": null, "keys": []}`))
+	if err != nil || user != nil {
+		t.Errorf("Expected no user, got %+v, %v", user, err)
+	}
+
+	// The wrapper's user_id fills in for a user_info without one.
+	user, err = decodeUserInfo([]byte(`{"user_id": "alice@example.com", "user_info": {"spend": 2}}`))
+	if err != nil || user.UserID != "alice@example.com" || user.Spend != 2 {
+		t.Errorf("Expected the wrapper's user_id, got %+v, %v", user, err)
+	}
+
+	// Without return_full_object keys are bare tokens, which do not say
+	// whose they are.
+	page, err := decodeKeyPage([]byte(`{"keys": ["hash-1", "hash-2"]}`))
+	if err != nil || len(page.Keys) != 2 || page.Keys[1].Key != "hash-2" || page.TotalPages != nil || page.Ownerless != 2 {
+		t.Errorf("Expected two token-only keys, got %+v, %v", page, err)
+	}
+
+	if _, err := decodeKeyPage([]byte(`{"keys": [42]}`)); err == nil {
+		t.Error("Expected an error for a key that is neither object nor string")
+	}
+}
@@ -0,0 +1,161 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/models"
+)
+
+// DriftItem is one difference between LiteLLM and key_history and the
+// change that would resolve it.
+type DriftItem struct {
+	Category string `json:"category"`
+	UserID   string `json:"user_id"`           // the key's owner, or for a team key its creator
+	TeamID   string `json:"team_id,omitempty"` // set for team keys
+	KeyID    string `json:"key_id"`
+	// FormerKeyID is, for an alias collision, the ID the row is recorded
+	// under.
+	FormerKeyID string                 `json:"former_key_id,omitempty"`
+	Name        string                 `json:"name,omitempty"`
+	Action      string                 `json:"action"`
+	Local       map[string]interface{} `json:"local,omitempty" swaggertype:"object"`    // the row now; omitted for keys unknown locally
+	Proposed    map[string]interface{} `json:"proposed,omitempty" swaggertype:"object"` // the row once the change is applied
+}
+
+// DriftFailure is a user or team whose drift could not be worked out.
+type DriftFailure struct {
+	UserID string `json:"user_id,omitempty"`
+	TeamID string `json:"team_id,omitempty"`
+	Error  string `json:"error"`
+}
+
+// DriftReport lists the changes a reconciliation would make, without making
+// them.
+type DriftReport struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Users       int            `json:"users"`
+	Teams       int            `json:"teams"`
+	Counts      map[string]int `json:"counts"`
+	Items       []DriftItem    `json:"items"`
+	// Incomplete and IncompleteTeams list the users and teams whose keys
+	// could not be listed in full; their missing_upstream drift is not
+	// reported.
+	Incomplete      []string       `json:"incomplete"`
+	IncompleteTeams []string       `json:"incomplete_teams"`
+	Failed          []DriftFailure `json:"failed"`
+}
+
+// Drift reports the drift of every user and team the reconciler walks, as
+// a dry run of Apply. It waits for a running reconciliation to finish.
+func (r *KeyReconciler) Drift(ctx context.Context) (*DriftReport, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	owners, err := r.owners()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &DriftReport{
+		GeneratedAt:     time.Now(),
+		Counts:          make(map[string]int),
+		Items:           []DriftItem{},
+		Incomplete:      []string{},
+		IncompleteTeams: []string{},
+		Failed:          []DriftFailure{},
+	}
+	for _, category := range DriftCategories {
+		report.Counts[category] = 0
+	}
+
+	for _, owner := range owners {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if owner.TeamID != "" {
+			report.Teams++
+		} else {
+			report.Users++
+		}
+		keys, complete, err := r.listOwnerKeys(ctx, owner)
+		if err != nil {
+			report.Failed = append(report.Failed, DriftFailure{UserID: owner.UserID, TeamID: owner.TeamID, Error: err.Error()})
+			continue
+		}
+		if !complete && owner.TeamID != "" {
+			report.IncompleteTeams = append(report.IncompleteTeams, owner.TeamID)
+		} else if !complete {
+			report.Incomplete = append(report.Incomplete, owner.UserID)
+		}
+		plan, err := r.planOwner(owner, keys, complete)
+		if err != nil {
+			return nil, err
+		}
+		for _, change := range plan.Changes {
+			report.Counts[change.Category]++
+			report.Items = append(report.Items, driftItem(owner, change))
+		}
+	}
+	return report, nil
+}
+
+func driftItem(owner keyOwner, change KeyChange) DriftItem {
+	return DriftItem{
+		Category:    change.Category,
+		UserID:      change.Key.UserID,
+		TeamID:      owner.TeamID,
+		KeyID:       change.Key.LiteLLMKeyID,
+		FormerKeyID: change.FormerKeyID,
+		Name:        change.Key.KeyName,
+		Action:      change.Action,
+		Local:       change.Before,
+		Proposed:    proposedState(change),
+	}
+}
+
+// id identifies the change an item proposes, so a reviewed report can be
+// matched with a fresh plan. A team key is identified by its team alone,
+// as its creator may be read differently.
+func (i DriftItem) id() string {
+	owner := i.UserID
+	if i.TeamID != "" {
+		owner = "team:" + i.TeamID
+	}
+	return strings.Join([]string{owner, i.Category, i.Action, i.KeyID, i.FormerKeyID}, "\x00")
+}
+
+// planOwner plans the owner's changes against their rows as stored.
+func (r *KeyReconciler) planOwner(owner keyOwner, keys []LiteLLMKey, complete bool) (KeyPlan, error) {
+	var rows []models.KeyHistory
+	if err := owner.rows(r.DB).Find(&rows).Error; err != nil {
+		return KeyPlan{}, err
+	}
+	return planKeys(owner, rows, keys, complete, time.Now()), nil
+}
+
+// proposedState is the audited state of the row once change alone is
+// applied.
+func proposedState(change KeyChange) map[string]interface{} {
+	if change.Updates == nil {
+		return audit.KeyState(change.Key)
+	}
+	state := make(map[string]interface{}, len(change.Before))
+	for k, v := range change.Before {
+		state[k] = v
+	}
+	delete(state, "key_id")
+	for column, v := range change.Updates {
+		switch column {
+		case "litellm_key_id":
+			continue
+		case "key_type":
+			state["type"] = v
+		default:
+			state[column] = v
+		}
+	}
+	return state
+}
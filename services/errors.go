@@ -0,0 +1,155 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Kinds of UpstreamError. Match them with errors.Is.
+var (
+	ErrNotFound     = errors.New("not found in LiteLLM")
+	ErrUnauthorized = errors.New("not authorized by LiteLLM")
+	ErrValidation   = errors.New("rejected by LiteLLM as invalid")
+	ErrRateLimited  = errors.New("rate limited by LiteLLM")
+	ErrUnavailable  = errors.New("LiteLLM unavailable")
+)
+
+// ErrIncompleteListing means a key listing could not be read in full. The
+// keys that were read are returned alongside it, so they can be shown, but
+// a key missing from them may still exist.
+var ErrIncompleteListing = errors.New("incomplete key listing from LiteLLM")
+
+// maxErrorBody caps how much of an error response is kept.
+const maxErrorBody = 4096
+
+// UpstreamError is a non-200 response from LiteLLM. Kind classifies it by
+// status so callers need not know LiteLLM's codes; Status and Body keep the
+// original answer for logs.
+type UpstreamError struct {
+	Action string
+	Status int
+	Body   string
+	Kind   error
+
+	// RetryAfter is LiteLLM's Retry-After header on a 429 or 503, if any.
+	RetryAfter time.Duration
+}
+
+// newUpstreamError reads the error response resp to action, e.g. "generate
+// key".
+func newUpstreamError(action string, resp *http.Response) *UpstreamError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &UpstreamError{
+		Action: action,
+		Status: resp.StatusCode,
+		Body:   string(body),
+		Kind:   errorKind(resp.StatusCode),
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+func errorKind(status int) error {
+	switch {
+	case status == http.StatusNotFound:
+		return ErrNotFound
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return ErrUnauthorized
+	case status == http.StatusTooManyRequests:
+		return ErrRateLimited
+	case status >= 500:
+		return ErrUnavailable
+	default:
+		return ErrValidation
+	}
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("failed to %s: status %d, body: %s", e.Action, e.Status, e.Body)
+}
+
+func (e *UpstreamError) Unwrap() error { return e.Kind }
+
+// ResponseStatus maps an error from a LiteLLM call to the status llmreq
+// answers with. LiteLLM rejecting our master key is a misconfiguration on our
+// side, not the caller's, so it becomes 502 rather than 401. Anything that is
+// not a LiteLLM answer, such as a timeout or an open circuit breaker, is 503.
+func ResponseStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrValidation):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRateLimited):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrUnauthorized):
+		return http.StatusBadGateway
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// RetryDelay returns how long the caller should wait before trying again:
+// LiteLLM's own Retry-After, or how long the circuit breaker of the instance
+// that failed stays open. It is 0 when there is nothing to wait for.
+func RetryDelay(err error) time.Duration {
+	var upstream *UpstreamError
+	var open *CircuitOpenError
+	switch {
+	case errors.As(err, &upstream):
+		return upstream.RetryAfter
+	case errors.As(err, &open):
+		return open.RetryAfter
+	}
+	return 0
+}
+
+// Transient reports whether retrying may help: LiteLLM or the proxy in front
+// of it was briefly unable to answer. A 500 usually means the request itself
+// broke something and is not retried.
+func (e *UpstreamError) Transient() bool {
+	switch e.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Message extracts LiteLLM's human readable explanation from Body, which is
+// either {"error": {"message": ...}} or FastAPI's {"detail": ...}. It
+// returns "" when there is none.
+func (e *UpstreamError) Message() string {
+	var body struct {
+		Error  json.RawMessage `json:"error"`
+		Detail json.RawMessage `json:"detail"`
+	}
+	if json.Unmarshal([]byte(e.Body), &body) != nil {
+		return ""
+	}
+	for _, raw := range []json.RawMessage{body.Error, body.Detail} {
+		var msg string
+		if json.Unmarshal(raw, &msg) == nil && msg != "" {
+			return msg
+		}
+		var nested struct {
+			Message string `json:"message"`
+			Error   string `json:"error"`
+		}
+		if json.Unmarshal(raw, &nested) == nil {
+			if nested.Message != "" {
+				return nested.Message
+			}
+			if nested.Error != "" {
+				return nested.Error
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ExpiryReminder warns key owners a configurable number of days before their
+// keys expire. Each reminder day fires at most once per expiry date.
+type ExpiryReminder struct {
+	DB       *gorm.DB
+	Notifier Notifier
+	Days     []int
+	Interval time.Duration
+}
+
+func NewExpiryReminder(db *gorm.DB, notifier Notifier) *ExpiryReminder {
+	days := append([]int(nil), config.AppConfig.ExpiryReminderDays...)
+	sort.Ints(days)
+	return &ExpiryReminder{
+		DB:       db,
+		Notifier: notifier,
+		Days:     days,
+		Interval: config.AppConfig.ExpiryReminderInterval,
+	}
+}
+
+// Start checks for expiring keys immediately and then once per Interval
+// until ctx is cancelled. It returns straight away if Interval is not
+// positive or no reminder days are configured.
+func (r *ExpiryReminder) Start(ctx context.Context) {
+	if r.Interval <= 0 || len(r.Days) == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := r.RunOnce(); err != nil {
+			log.Printf("Expiry reminder run failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce sends a reminder for every active key that has entered one of the
+// reminder windows since the last run.
+func (r *ExpiryReminder) RunOnce() error {
+	if len(r.Days) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	horizon := now.Add(time.Duration(r.Days[len(r.Days)-1]) * 24 * time.Hour)
+
+	var keys []models.KeyHistory
+	err := r.DB.Where("status = ? AND expires_at IS NOT NULL AND expires_at > ? AND expires_at <= ?", "active", now, horizon).
+		Find(&keys).Error
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		r.remind(key, now)
+	}
+	return nil
+}
+
+func (r *ExpiryReminder) remind(key models.KeyHistory, now time.Time) {
+	remaining := key.ExpiresAt.Sub(now)
+
+	// Only the closest newly entered window is announced, so a key first seen
+	// two days before expiry gets one reminder rather than one per window.
+	// The windows are recorded once the reminder is delivered, so a failed
+	// delivery is retried on the next run.
+	var entered []models.ExpiryReminder
+	for i := len(r.Days) - 1; i >= 0; i-- {
+		days := r.Days[i]
+		if remaining > time.Duration(days)*24*time.Hour {
+			break
+		}
+		var recorded int64
+		err := r.DB.Model(&models.ExpiryReminder{}).
+			Where("key_history_id = ? AND days_before = ? AND expires_at = ?", key.ID, days, *key.ExpiresAt).
+			Count(&recorded).Error
+		if err != nil {
+			log.Printf("Failed to look up expiry reminders for key %s: %v", key.LiteLLMKeyID, err)
+			return
+		}
+		if recorded == 0 {
+			entered = append(entered, models.ExpiryReminder{
+				KeyHistoryID: key.ID,
+				DaysBefore:   days,
+				ExpiresAt:    *key.ExpiresAt,
+				UserID:       key.UserID,
+			})
+		}
+	}
+	if len(entered) == 0 {
+		return
+	}
+
+	if r.Notifier != nil {
+		if err := r.Notifier.Notify(expiryNotification(key, remaining)); err != nil {
+			log.Printf("Failed to deliver expiry reminder for key %s, retrying on the next run: %v", key.LiteLLMKeyID, err)
+			return
+		}
+	}
+
+	if err := r.DB.Clauses(clause.OnConflict{DoNothing: true}).Create(&entered).Error; err != nil {
+		log.Printf("Failed to record expiry reminder for key %s: %v", key.LiteLLMKeyID, err)
+	}
+}
+
+func expiryNotification(key models.KeyHistory, remaining time.Duration) Notification {
+	daysLeft := int(remaining.Hours() / 24)
+	renewURL := RenewalURL(key.LiteLLMKeyID)
+
+	body := fmt.Sprintf("Your %s key %q (%s) expires on %s, in %d day(s).",
+		key.KeyType, key.KeyName, key.KeyMask, key.ExpiresAt.Format(time.RFC1123), daysLeft)
+	if renewURL != "" {
+		body += fmt.Sprintf("\nRenew or rotate it here: %s", renewURL)
+	}
+
+	return Notification{
+		Event:      "key.expiring",
+		Recipients: []string{key.UserID},
+		Subject:    fmt.Sprintf("Your API key %q expires in %d day(s)", key.KeyName, daysLeft),
+		Body:       body,
+		Data: map[string]interface{}{
+			"user_id":    key.UserID,
+			"key_id":     key.LiteLLMKeyID,
+			"key_name":   key.KeyName,
+			"key_type":   key.KeyType,
+			"expires_at": key.ExpiresAt,
+			"days_left":  daysLeft,
+			"renew_url":  renewURL,
+		},
+	}
+}
+
+// RenewalURL returns the dashboard link that renews or rotates keyID, or an
+// empty string when no dashboard URL is configured.
+func RenewalURL(keyID string) string {
+	if config.AppConfig.DashboardURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/keys/%s?action=renew", config.AppConfig.DashboardURL, url.PathEscape(keyID))
+}
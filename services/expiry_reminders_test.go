@@ -0,0 +1,116 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+)
+
+func TestExpiryReminder_RunOnce(t *testing.T) {
+	webhook := newWebhookSink(t)
+
+	config.AppConfig = &config.Config{
+		ExpiryReminderDays:     []int{1, 14, 3},
+		ExpiryReminderInterval: time.Hour,
+		DashboardURL:           "https://llmreq.example.com",
+	}
+	db := setupAlertTestDB(t)
+	reminder := NewExpiryReminder(db, NewWebhookNotifier(webhook.server.URL))
+
+	soon := time.Now().Add(2 * 24 * time.Hour)
+	later := time.Now().Add(10 * 24 * time.Hour)
+	far := time.Now().Add(60 * 24 * time.Hour)
+	db.Create(&[]models.KeyHistory{
+		{UserID: "a@example.com", LiteLLMKeyID: "sk-soon", KeyName: "soon", Status: "active", ExpiresAt: &soon},
+		{UserID: "b@example.com", LiteLLMKeyID: "sk-later", KeyName: "later", Status: "active", ExpiresAt: &later},
+		{UserID: "c@example.com", LiteLLMKeyID: "sk-far", KeyName: "far", Status: "active", ExpiresAt: &far},
+		{UserID: "d@example.com", LiteLLMKeyID: "sk-revoked", KeyName: "revoked", Status: "revoked", ExpiresAt: &soon},
+	})
+
+	if err := reminder.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+
+	events := webhook.received()
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 reminders, got %d", len(events))
+	}
+	for _, e := range events {
+		if e.Event != "key.expiring" {
+			t.Errorf("Unexpected event %s", e.Event)
+		}
+		if !strings.Contains(e.Body, "https://llmreq.example.com/keys/") {
+			t.Errorf("Expected renewal link in body: %s", e.Body)
+		}
+	}
+
+	// Second run sends nothing new.
+	if err := reminder.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(webhook.received()); n != 2 {
+		t.Errorf("Expected reminders to fire once, got %d", n)
+	}
+
+	// Renewal moves the expiry date and re-arms the reminders.
+	renewed := time.Now().Add(12 * 24 * time.Hour)
+	db.Model(&models.KeyHistory{}).Where("litellm_key_id = ?", "sk-soon").Update("expires_at", renewed)
+	if err := reminder.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(webhook.received()); n != 3 {
+		t.Errorf("Expected a reminder for the renewed expiry, got %d total", n)
+	}
+}
+
+func TestExpiryReminder_FailedDelivery(t *testing.T) {
+	config.AppConfig = &config.Config{ExpiryReminderDays: []int{3}, ExpiryReminderInterval: time.Hour}
+	db := setupAlertTestDB(t)
+
+	delivered := 0
+	fail := true
+	reminder := NewExpiryReminder(db, notifyFunc(func(n Notification) error {
+		if fail {
+			return errors.New("webhook delivery failed: status 502")
+		}
+		delivered++
+		return nil
+	}))
+	soon := time.Now().Add(2 * 24 * time.Hour)
+	db.Create(&models.KeyHistory{UserID: "a@example.com", LiteLLMKeyID: "sk-soon", KeyName: "soon", Status: "active", ExpiresAt: &soon})
+
+	if err := reminder.RunOnce(); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if db.Model(&models.ExpiryReminder{}).Count(&count); count != 0 {
+		t.Fatalf("Expected an undelivered reminder not to be recorded, got %d", count)
+	}
+
+	// The next run retries the delivery, once.
+	fail = false
+	reminder.RunOnce()
+	reminder.RunOnce()
+	if delivered != 1 {
+		t.Errorf("Expected the reminder to be delivered once on retry, got %d", delivered)
+	}
+	if db.Model(&models.ExpiryReminder{}).Count(&count); count != 1 {
+		t.Errorf("Expected the reminder recorded after delivery, got %d", count)
+	}
+}
+
+func TestRenewalURL(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	if u := RenewalURL("sk-1"); u != "" {
+		t.Errorf("Expected no URL without dashboard, got %s", u)
+	}
+
+	config.AppConfig = &config.Config{DashboardURL: "https://x.example.com"}
+	if u := RenewalURL("sk-a/b"); u != "https://x.example.com/keys/sk-a%2Fb?action=renew" {
+		t.Errorf("Unexpected URL %s", u)
+	}
+}
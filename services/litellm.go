@@ -2,11 +2,15 @@ package services
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"expvar"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
 
 	"github.com/example/llmreq/config"
@@ -14,45 +18,83 @@ import (
 
 type LiteLLMService struct {
 	BaseURL   string
-	MasterKey string
+	MasterKey *config.Secret
 	Client    *http.Client
+
+	// Timeout bounds each call unless Timeouts has an entry for the
+	// operation, keyed by names such as "list_keys". Zero means the call is
+	// only bounded by the caller's context.
+	Timeout  time.Duration
+	Timeouts map[string]time.Duration
+
+	// Version is the LiteLLM release found by DetectVersion, or "" if it
+	// has not run or LiteLLM does not report one.
+	Version string
 }
 
 func NewLiteLLMService() *LiteLLMService {
 	return &LiteLLMService{
 		BaseURL:   config.AppConfig.LiteLLMAPIURL,
 		MasterKey: config.AppConfig.LiteLLMMasterKey,
-		Client:    &http.Client{Timeout: 10 * time.Second},
+		Client:    &http.Client{},
+		Timeout:   config.AppConfig.LiteLLMTimeout,
+		Timeouts:  config.AppConfig.LiteLLMTimeouts,
 	}
 }
 
 // Structs for LiteLLM API
 
 type LiteLLMUser struct {
-	UserID    string  `json:"user_id"`
-	UserEmail string  `json:"user_email"`
-	MaxBudget float64 `json:"max_budget,omitempty"`
-	Spend     float64 `json:"spend"`
+	UserID        string  `json:"user_id"`
+	UserEmail     string  `json:"user_email"`
+	MaxBudget     float64 `json:"max_budget,omitempty"`
+	Spend         float64 `json:"spend"`
+	BudgetResetAt string  `json:"budget_reset_at,omitempty"`
+}
+
+type NewUserRequest struct {
+	UserID         string   `json:"user_id"`
+	UserEmail      string   `json:"user_email"`
+	MaxBudget      float64  `json:"max_budget,omitempty"`
+	BudgetDuration string   `json:"budget_duration,omitempty"`
+	Models         []string `json:"models,omitempty"`
+}
+
+// UpdateUserRequest overwrites a user's limits. A nil MaxBudget or
+// BudgetDuration clears the limit, and an empty Models list allows all models.
+type UpdateUserRequest struct {
+	UserID         string   `json:"user_id"`
+	MaxBudget      *float64 `json:"max_budget"`
+	BudgetDuration *string  `json:"budget_duration"`
+	Models         []string `json:"models"`
 }
 
 type LiteLLMKey struct {
-	KeyName  string                 `json:"key_name"`
-	KeyAlias string                 `json:"key_alias"`
-	Key      string                 `json:"key"`
-	Token    string                 `json:"token"` // Sometimes key is returned as token
-	Spend    float64                `json:"spend"`
-	Expires  string                 `json:"expires"`
-	User     string                 `json:"user_id"`
-	TeamId   string                 `json:"team_id"`
-	Models   []string               `json:"models"`
-	Metadata map[string]interface{} `json:"metadata"`
+	KeyName       string                 `json:"key_name"`
+	KeyAlias      string                 `json:"key_alias"`
+	Key           string                 `json:"key"`
+	Token         string                 `json:"token"` // Sometimes key is returned as token
+	Spend         float64                `json:"spend"`
+	MaxBudget     float64                `json:"max_budget"`
+	BudgetResetAt string                 `json:"budget_reset_at"`
+	Expires       string                 `json:"expires"`
+	User          string                 `json:"user_id"`
+	TeamId        string                 `json:"team_id"`
+	Models        []string               `json:"models"`
+	Metadata      map[string]interface{} `json:"metadata"`
+
+	// Instance is the LiteLLM instance the key was listed on, set by
+	// Router; "" is the default instance.
+	Instance string `json:"-"`
 }
 
 type GenerateKeyRequest struct {
-	UserID    string  `json:"user_id"`
-	KeyAlias  string  `json:"key_alias"`
-	MaxBudget float64 `json:"max_budget,omitempty"`
-	Duration  string  `json:"duration,omitempty"`
+	UserID    string                 `json:"user_id,omitempty"`
+	TeamID    string                 `json:"team_id,omitempty"`
+	KeyAlias  string                 `json:"key_alias"`
+	MaxBudget float64                `json:"max_budget,omitempty"`
+	Duration  string                 `json:"duration,omitempty"`
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
 }
 
 type GenerateKeyResponse struct {
@@ -64,6 +106,21 @@ type GenerateKeyResponse struct {
 	User      string  `json:"user_id"`
 	KeyAlias  string  `json:"key_alias"`
 	KeyName   string  `json:"key_name"`
+
+	// Instance is the LiteLLM instance the key was created on, set by
+	// Router; "" is the default instance.
+	Instance string `json:"-"`
+}
+
+type UpdateKeyRequest struct {
+	Key      string                 `json:"key"`
+	Duration string                 `json:"duration,omitempty"`
+	UserID   string                 `json:"user_id,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+}
+
+type blockKeyRequest struct {
+	Key string `json:"key"`
 }
 
 type DeleteKeyRequest struct {
@@ -72,13 +129,16 @@ type DeleteKeyRequest struct {
 
 // Methods
 
-func (s *LiteLLMService) GetUserInfo(userID string) (*LiteLLMUser, error) {
+func (s *LiteLLMService) GetUserInfo(ctx context.Context, userID string) (*LiteLLMUser, error) {
+	ctx, cancel := s.withTimeout(ctx, "get_user_info")
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/user/info/%s", s.BaseURL, userID)
-	req, err := http.NewRequest("GET", reqURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
 	if err != nil {
 		return nil, err
 	}
-	s.setAuth(req)
+	s.setHeaders(req)
 
 	resp, err := s.Client.Do(req)
 	if err != nil {
@@ -91,38 +151,59 @@ func (s *LiteLLMService) GetUserInfo(userID string) (*LiteLLMUser, error) {
 	}
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to get user info: status %d", resp.StatusCode)
+		return nil, newUpstreamError("get user info", resp)
 	}
 
-	var user LiteLLMUser
-	// LiteLLM /user/info response structure might vary slightly, but assuming it returns the user object directly or inside "user_info"
-	// Based on docs, it returns the user object.
-	if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
-		return nil, err
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
-
-	return &user, nil
+	return decodeUserInfo(bodyBytes)
 }
 
-func (s *LiteLLMService) CreateUser(userID, email string, maxBudget float64) error {
+func (s *LiteLLMService) CreateUser(ctx context.Context, reqPayload NewUserRequest) error {
+	ctx, cancel := s.withTimeout(ctx, "create_user")
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/user/new", s.BaseURL)
-	payload := map[string]interface{}{
-		"user_id":    userID,
-		"user_email": email,
+	body, err := json.Marshal(reqPayload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
 	}
-	if maxBudget > 0 {
-		payload["max_budget"] = maxBudget
+	s.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
-	body, err := json.Marshal(payload)
+	if resp.StatusCode != 200 {
+		return newUpstreamError("create user", resp)
+	}
+
+	return nil
+}
+
+func (s *LiteLLMService) UpdateUser(ctx context.Context, reqPayload UpdateUserRequest) error {
+	ctx, cancel := s.withTimeout(ctx, "update_user")
+	defer cancel()
+
+	reqURL := fmt.Sprintf("%s/user/update", s.BaseURL)
+	body, err := json.Marshal(reqPayload)
 	if err != nil {
 		return err
 	}
-	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
-	s.setAuth(req)
+	s.setHeaders(req)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.Client.Do(req)
@@ -132,34 +213,131 @@ func (s *LiteLLMService) CreateUser(userID, email string, maxBudget float64) err
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to create user: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return newUpstreamError("update user", resp)
 	}
 
 	return nil
 }
 
-func (s *LiteLLMService) ListKeys(userID string) ([]LiteLLMKey, error) {
-	reqURL := fmt.Sprintf("%s/key/list", s.BaseURL)
-	// Assuming GET /key/list accepts user_id as query param?
-	// Or maybe POST /key/list? Spec says "Call LiteLLM GET /key/list (filtered by user_id)".
-	// LiteLLM docs usually say GET /key/list returns all keys, but let's check if we can filter.
-	// Actually, typically LiteLLM /key/list is for admin to list all keys.
-	// But if we pass user_id, it might filter.
-	// If not, we have to filter client side? That would be bad if there are many keys.
-	// Let's assume query param.
+func (s *LiteLLMService) ListKeys(ctx context.Context, userID string) ([]LiteLLMKey, error) {
+	return s.listKeys(ctx, "list_keys", KeyFilter{UserID: userID})
+}
+
+// ListTeamKeys lists the keys owned by a team.
+func (s *LiteLLMService) ListTeamKeys(ctx context.Context, teamID string) ([]LiteLLMKey, error) {
+	return s.listKeys(ctx, "list_team_keys", KeyFilter{TeamID: teamID})
+}
+
+// FindKeys lists the keys matching every field set in filter.
+func (s *LiteLLMService) FindKeys(ctx context.Context, filter KeyFilter) ([]LiteLLMKey, error) {
+	return s.listKeys(ctx, "find_keys", filter)
+}
+
+// KeyFilter narrows a key listing on the LiteLLM side. Empty fields match
+// any key.
+type KeyFilter struct {
+	UserID   string
+	TeamID   string
+	KeyAlias string
+}
+
+func (f KeyFilter) query() url.Values {
+	q := url.Values{}
+	for name, value := range map[string]string{"user_id": f.UserID, "team_id": f.TeamID, "key_alias": f.KeyAlias} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	return q
+}
+
+const (
+	// keyPageSize is how many keys each /key/list request asks for, the
+	// most LiteLLM allows.
+	keyPageSize = 100
+	// maxKeyPages stops a server that never reports its last page from
+	// keeping us paging forever.
+	maxKeyPages = 1000
+)
+
+// keyPage is one /key/list response as decoded by decodeKeyPage. The totals
+// are nil for releases that do not paginate. Ownerless counts keys listed
+// as bare tokens, without their owner.
+type keyPage struct {
+	Keys       []LiteLLMKey
+	TotalCount *int
+	TotalPages *int
+	Ownerless  int
+}
+
+// listKeys reads every page of a listing. Keys created or deleted while
+// paging shift later pages, which shows as a changed total, so such a
+// listing is read once more; if it still does not add up, the keys read so
+// far are returned with an error wrapping ErrIncompleteListing.
+func (s *LiteLLMService) listKeys(ctx context.Context, op string, filter KeyFilter) ([]LiteLLMKey, error) {
+	ctx, cancel := s.withTimeout(ctx, op)
+	defer cancel()
+
+	var keys []LiteLLMKey
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		keys, err = s.listKeyPages(ctx, filter)
+		if !errors.Is(err, ErrIncompleteListing) {
+			break
+		}
+	}
+	return keys, err
+}
+
+// listKeyPages reads the listing page by page and returns the distinct keys.
+// The listing is complete when every page reported the same total and the
+// keys add up to it, or when LiteLLM reported no totals because it does not
+// paginate, and every key came with its owner.
+func (s *LiteLLMService) listKeyPages(ctx context.Context, filter KeyFilter) ([]LiteLLMKey, error) {
+	keys := []LiteLLMKey{}
+	seen := make(map[string]bool)
+	total, shifted, ownerless := -1, false, 0
+	for page := 1; page <= maxKeyPages; page++ {
+		resp, err := s.getKeyPage(ctx, filter, page)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range resp.Keys {
+			if k.Key == "" || !seen[k.Key] {
+				seen[k.Key] = true
+				keys = append(keys, k)
+			}
+		}
+		ownerless += resp.Ownerless
+		if resp.TotalCount != nil {
+			shifted = shifted || (total >= 0 && *resp.TotalCount != total)
+			total = *resp.TotalCount
+		}
+		if resp.TotalPages == nil || page >= *resp.TotalPages || len(resp.Keys) == 0 {
+			if shifted || (total >= 0 && len(keys) != total) {
+				return keys, fmt.Errorf("%w: read %d of %d keys", ErrIncompleteListing, len(keys), total)
+			}
+			if ownerless > 0 {
+				return keys, fmt.Errorf("%w: %d key(s) listed without their owner", ErrIncompleteListing, ownerless)
+			}
+			return keys, nil
+		}
+	}
+	return keys, fmt.Errorf("%w: gave up after %d pages", ErrIncompleteListing, maxKeyPages)
+}
 
-	u, _ := url.Parse(reqURL)
-	q := u.Query()
-	q.Set("user_id", userID)
-	u.RawQuery = q.Encode()
-	reqURL = u.String()
+func (s *LiteLLMService) getKeyPage(ctx context.Context, filter KeyFilter, page int) (*keyPage, error) {
+	query := filter.query()
+	query.Set("page", strconv.Itoa(page))
+	query.Set("size", strconv.Itoa(keyPageSize))
+	query.Set("return_full_object", "true")
+	reqURL := fmt.Sprintf("%s/key/list?%s", s.BaseURL, query.Encode())
 
-	req, err := http.NewRequest("GET", reqURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
 	if err != nil {
 		return nil, err
 	}
-	s.setAuth(req)
+	s.setHeaders(req)
 
 	resp, err := s.Client.Do(req)
 	if err != nil {
@@ -168,40 +346,31 @@ func (s *LiteLLMService) ListKeys(userID string) ([]LiteLLMKey, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("failed to list keys: status %d", resp.StatusCode)
+		return nil, newUpstreamError("list keys", resp)
 	}
 
-	var response struct {
-		Keys []LiteLLMKey `json:"keys"`
-	}
-	// Try to decode as list or wrapped in keys
 	bodyBytes, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
-	// First try wrapped
-	if err := json.Unmarshal(bodyBytes, &response); err != nil {
-		// If fail, try raw list
-		if err := json.Unmarshal(bodyBytes, &response.Keys); err != nil {
-			return nil, fmt.Errorf("failed to decode keys: %v", err)
-		}
-	}
-
-	return response.Keys, nil
+	return decodeKeyPage(bodyBytes)
 }
 
-func (s *LiteLLMService) GenerateKey(reqPayload GenerateKeyRequest) (*GenerateKeyResponse, error) {
+func (s *LiteLLMService) GenerateKey(ctx context.Context, reqPayload GenerateKeyRequest) (*GenerateKeyResponse, error) {
+	ctx, cancel := s.withTimeout(ctx, "generate_key")
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/key/generate", s.BaseURL)
 	body, err := json.Marshal(reqPayload)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
-	s.setAuth(req)
+	s.setHeaders(req)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.Client.Do(req)
@@ -211,8 +380,7 @@ func (s *LiteLLMService) GenerateKey(reqPayload GenerateKeyRequest) (*GenerateKe
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("failed to generate key: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return nil, newUpstreamError("generate key", resp)
 	}
 
 	var keyResp GenerateKeyResponse
@@ -223,7 +391,40 @@ func (s *LiteLLMService) GenerateKey(reqPayload GenerateKeyRequest) (*GenerateKe
 	return &keyResp, nil
 }
 
-func (s *LiteLLMService) DeleteKey(keyID string) error {
+func (s *LiteLLMService) UpdateKey(ctx context.Context, reqPayload UpdateKeyRequest) error {
+	ctx, cancel := s.withTimeout(ctx, "update_key")
+	defer cancel()
+
+	reqURL := fmt.Sprintf("%s/key/update", s.BaseURL)
+	body, err := json.Marshal(reqPayload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	s.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return newUpstreamError("update key", resp)
+	}
+
+	return nil
+}
+
+func (s *LiteLLMService) DeleteKey(ctx context.Context, keyID string) error {
+	ctx, cancel := s.withTimeout(ctx, "delete_key")
+	defer cancel()
+
 	reqURL := fmt.Sprintf("%s/key/delete", s.BaseURL)
 	payload := DeleteKeyRequest{
 		Keys: []string{keyID},
@@ -233,11 +434,11 @@ func (s *LiteLLMService) DeleteKey(keyID string) error {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", reqURL, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
-	s.setAuth(req)
+	s.setHeaders(req)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.Client.Do(req)
@@ -247,15 +448,112 @@ func (s *LiteLLMService) DeleteKey(keyID string) error {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to delete key: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+		return newUpstreamError("delete key", resp)
+	}
+
+	return nil
+}
+
+// BlockKey makes LiteLLM reject requests made with keyID until it is
+// unblocked.
+func (s *LiteLLMService) BlockKey(ctx context.Context, keyID string) error {
+	return s.postJSON(ctx, "/key/block", blockKeyRequest{Key: keyID}, nil, "block key")
+}
+
+func (s *LiteLLMService) UnblockKey(ctx context.Context, keyID string) error {
+	return s.postJSON(ctx, "/key/unblock", blockKeyRequest{Key: keyID}, nil, "unblock key")
+}
+
+// litellmVersions publishes the detected release of each instance, by URL,
+// under /debug/vars.
+var litellmVersions = expvar.NewMap("litellm_version")
+
+// DetectVersion asks LiteLLM for its release and records it in Version. The
+// response adapters do not depend on it; it is reported so a shape change
+// can be traced to an upgrade.
+func (s *LiteLLMService) DetectVersion(ctx context.Context) (string, error) {
+	ctx, cancel := s.withTimeout(ctx, "detect_version")
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.BaseURL+"/health/readiness", nil)
+	if err != nil {
+		return "", err
+	}
+	s.setHeaders(req)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", newUpstreamError("detect version", resp)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %v", err)
+	}
+	version, err := decodeVersion(bodyBytes)
+	if err != nil {
+		return "", err
+	}
+	s.Version = version
+	v := new(expvar.String)
+	v.Set(version)
+	litellmVersions.Set(s.BaseURL, v)
+	return version, nil
+}
+
+// Ping checks that LiteLLM answers an admin request with the master key. It
+// lists a single key, the cheapest call that needs one; a rejected key is
+// an UpstreamError of kind ErrUnauthorized.
+func (s *LiteLLMService) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx, "ping")
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.BaseURL+"/key/list?page=1&size=1", nil)
+	if err != nil {
+		return err
+	}
+	s.setHeaders(req)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
 	}
+	defer resp.Body.Close()
 
+	if resp.StatusCode != 200 {
+		return newUpstreamError("ping", resp)
+	}
 	return nil
 }
 
-func (s *LiteLLMService) setAuth(req *http.Request) {
-	if s.MasterKey != "" {
-		req.Header.Set("Authorization", "Bearer "+s.MasterKey)
+// withTimeout derives the context for one call to op.
+func (s *LiteLLMService) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout := s.Timeout
+	if t, ok := s.Timeouts[op]; ok {
+		timeout = t
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// setHeaders authenticates req and forwards the trace of the request that
+// caused it, so LiteLLM logs can be matched with ours.
+func (s *LiteLLMService) setHeaders(req *http.Request) {
+	if key := s.MasterKey.Value(); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	trace := TraceFromContext(req.Context())
+	if trace.RequestID != "" {
+		req.Header.Set("X-Request-ID", trace.RequestID)
+	}
+	if trace.TraceParent != "" {
+		req.Header.Set("traceparent", trace.TraceParent)
 	}
 }
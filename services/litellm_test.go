@@ -1,54 +1,50 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
 )
 
-func TestLiteLLMService_GetUserInfo(t *testing.T) {
-	// Setup Mock Server
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/user/info/test@example.com" {
-			w.WriteHeader(http.StatusOK)
-			_ = json.NewEncoder(w).Encode(LiteLLMUser{
-				UserID:    "test@example.com",
-				UserEmail: "test@example.com",
-				Spend:     10.0,
-			})
-			return
-		}
-		w.WriteHeader(http.StatusNotFound)
-	}))
-	defer server.Close()
+// newFakeService serves an empty fake LiteLLM for the test and returns it
+// with a client for it.
+func newFakeService(t *testing.T) (*litellmfake.Server, *LiteLLMService) {
+	fake := litellmfake.New("sk-master")
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, &LiteLLMService{BaseURL: server.URL, MasterKey: config.NewSecret("sk-master"), Client: server.Client()}
+}
 
-	// Setup Service
-	config.AppConfig = &config.Config{
-		LiteLLMAPIURL:    server.URL,
-		LiteLLMMasterKey: "test-key",
-	}
-	service := NewLiteLLMService()
-	service.BaseURL = server.URL // Override with mock URL
+func TestLiteLLMService_GetUserInfo(t *testing.T) {
+	fake, service := newFakeService(t)
+	fake.AddUser(litellmfake.User{ID: "test@example.com", Email: "test@example.com"})
 
 	// Test Success
-	user, err := service.GetUserInfo("test@example.com")
+	user, err := service.GetUserInfo(context.Background(), "test@example.com")
 	if err != nil {
 		t.Fatalf("Expected no error, got %v", err)
 	}
 	if user == nil {
 		t.Fatal("Expected user, got nil")
 	}
-	if user.UserID != "test@example.com" {
-		t.Errorf("Expected user ID test@example.com, got %s", user.UserID)
+	if user.UserID != "test@example.com" || user.UserEmail != "test@example.com" {
+		t.Errorf("Expected user test@example.com, got %+v", user)
 	}
 
 	// Test Not Found
-	user, err = service.GetUserInfo("nonexistent")
+	user, err = service.GetUserInfo(context.Background(), "nonexistent")
 	if err != nil {
-		t.Fatalf("Expected no error for 404, got %v", err)
+		t.Fatalf("Expected no error for an unknown user, got %v", err)
 	}
 	if user != nil {
 		t.Fatal("Expected nil user, got object")
@@ -56,90 +52,256 @@ func TestLiteLLMService_GetUserInfo(t *testing.T) {
 }
 
 func TestLiteLLMService_CreateUser(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/user/new" && r.Method == "POST" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-	}))
-	defer server.Close()
-
-	service := NewLiteLLMService()
-	service.BaseURL = server.URL
+	fake, service := newFakeService(t)
 
-	err := service.CreateUser("new@example.com", "new@example.com", 1.0)
+	err := service.CreateUser(context.Background(), NewUserRequest{UserID: "new@example.com", UserEmail: "new@example.com", MaxBudget: 1.0})
 	if err != nil {
 		t.Errorf("Expected no error, got %v", err)
 	}
+	if user, ok := fake.User("new@example.com"); !ok || user.MaxBudget == nil || *user.MaxBudget != 1.0 {
+		t.Errorf("Expected the user with a budget of 1, got %+v", user)
+	}
 }
 
 func TestLiteLLMService_ListKeys(t *testing.T) {
-	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/list" {
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write([]byte(`{"keys": [{"key": "sk-123", "spend": 0.5}]}`))
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
-	}))
-	defer server.Close()
+	fake, service := newFakeService(t)
+	listed := fake.AddKey(litellmfake.Key{UserID: "user", KeyAlias: "laptop"})
+	fake.AddKey(litellmfake.Key{UserID: "other"})
 
-	service := NewLiteLLMService()
-	service.BaseURL = server.URL
-
-	keys, err := service.ListKeys("user")
+	keys, err := service.ListKeys(context.Background(), "user")
 	if err != nil {
 		t.Fatal(err)
 	}
 	if len(keys) != 1 {
-		t.Errorf("Expected 1 key, got %d", len(keys))
+		t.Fatalf("Expected 1 key, got %d", len(keys))
 	}
-	if keys[0].Key != "sk-123" {
-		t.Errorf("Expected key sk-123, got %s", keys[0].Key)
+	if keys[0].Key != listed.Token || keys[0].KeyAlias != "laptop" {
+		t.Errorf("Expected key %s, got %+v", listed.Token, keys[0])
 	}
 }
 
-func TestLiteLLMService_GenerateKey(t *testing.T) {
+func TestLiteLLMService_ListKeysPaginated(t *testing.T) {
+	config.AppConfig = &config.Config{}
+	var keys []LiteLLMKey
+	for i := 0; i < 250; i++ {
+		keys = append(keys, LiteLLMKey{Key: fmt.Sprintf("sk-%03d", i), User: "u"})
+	}
+	// deletions is how many passes delete their first key after serving
+	// page 1, shifting every later key forward. The fake cannot change
+	// between pages of one listing, so this server serves it itself.
+	deletions := 0
+	var queries []url.Values
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/generate" && r.Method == "POST" {
-			w.WriteHeader(http.StatusOK)
-			_ = json.NewEncoder(w).Encode(GenerateKeyResponse{
-				Key: "sk-generated",
-			})
-			return
+		q := r.URL.Query()
+		queries = append(queries, q)
+		page, _ := strconv.Atoi(q.Get("page"))
+		size, _ := strconv.Atoi(q.Get("size"))
+		start, end := min((page-1)*size, len(keys)), min(page*size, len(keys))
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys":         keys[start:end],
+			"total_count":  len(keys),
+			"current_page": page,
+			"total_pages":  (len(keys) + size - 1) / size,
+		})
+		if page == 1 && deletions > 0 {
+			deletions--
+			keys = keys[1:]
 		}
-		w.WriteHeader(http.StatusBadRequest)
 	}))
 	defer server.Close()
 
 	service := NewLiteLLMService()
 	service.BaseURL = server.URL
 
-	resp, err := service.GenerateKey(GenerateKeyRequest{UserID: "u"})
+	got, err := service.FindKeys(context.Background(), KeyFilter{UserID: "u", KeyAlias: "ci"})
+	if err != nil || len(got) != 250 || got[249].Key != "sk-249" {
+		t.Fatalf("Expected all 250 keys, got %d, %v", len(got), err)
+	}
+	if q := queries[0]; q.Get("user_id") != "u" || q.Get("key_alias") != "ci" || q.Has("team_id") || q.Get("page") != "1" {
+		t.Errorf("Unexpected query %v", q)
+	}
+	if len(queries) != 3 {
+		t.Errorf("Expected 3 pages, got %d requests", len(queries))
+	}
+
+	// A key deleted mid-listing hides a later key; the listing is repeated.
+	deletions, queries = 1, nil
+	got, err = service.ListKeys(context.Background(), "u")
+	if err != nil || len(got) != 249 || len(queries) != 6 {
+		t.Errorf("Expected a repeated, complete listing of 249 keys, got %d after %d requests, %v", len(got), len(queries), err)
+	}
+
+	// A listing that keeps shifting is returned as incomplete.
+	deletions = 2
+	got, err = service.ListKeys(context.Background(), "u")
+	if !errors.Is(err, ErrIncompleteListing) || len(got) == 0 {
+		t.Errorf("Expected ErrIncompleteListing with the keys read, got %d keys, %v", len(got), err)
+	}
+
+	// A release that ignores return_full_object lists bare tokens, which do
+	// not say whose keys they are.
+	fake, service := newFakeService(t)
+	fake.SetFaults(litellmfake.Faults{BareTokens: true})
+	fake.AddKey(litellmfake.Key{UserID: "u"})
+	fake.AddKey(litellmfake.Key{UserID: "u"})
+	got, err = service.ListKeys(context.Background(), "u")
+	if !errors.Is(err, ErrIncompleteListing) || len(got) != 2 {
+		t.Errorf("Expected token-only keys as an incomplete listing, got %d keys, %v", len(got), err)
+	}
+}
+
+func TestLiteLLMService_GenerateKey(t *testing.T) {
+	fake, service := newFakeService(t)
+
+	resp, err := service.GenerateKey(context.Background(), GenerateKeyRequest{UserID: "u", KeyAlias: "ci", Duration: "720h0m0s"})
 	if err != nil {
 		t.Fatal(err)
 	}
-	if resp.Key != "sk-generated" {
-		t.Errorf("Expected sk-generated, got %s", resp.Key)
+	key, ok := fake.Key(resp.Key)
+	if !ok || key.UserID != "u" || key.KeyAlias != "ci" || key.Expires == nil {
+		t.Errorf("Expected the generated key in LiteLLM, got %+v", key)
+	}
+}
+
+func TestLiteLLMService_UpstreamErrors(t *testing.T) {
+	tests := []struct {
+		status    int
+		body      string
+		kind      error
+		transient bool
+		message   string
+	}{
+		{http.StatusBadRequest, `{"error": {"message": "max_budget must be positive", "type": "bad_request_error"}}`, ErrValidation, false, "max_budget must be positive"},
+		{http.StatusUnprocessableEntity, `{"detail": "field required"}`, ErrValidation, false, "field required"},
+		{http.StatusUnauthorized, `{"error": "invalid master key"}`, ErrUnauthorized, false, "invalid master key"},
+		{http.StatusNotFound, `not found`, ErrNotFound, false, ""},
+		{http.StatusTooManyRequests, `{}`, ErrRateLimited, false, ""},
+		{http.StatusInternalServerError, `boom`, ErrUnavailable, false, ""},
+		{http.StatusServiceUnavailable, ``, ErrUnavailable, true, ""},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(tt.status)
+			_, _ = w.Write([]byte(tt.body))
+		}))
+		service := NewLiteLLMService()
+		service.BaseURL = server.URL
+
+		_, err := service.GenerateKey(context.Background(), GenerateKeyRequest{UserID: "u"})
+		server.Close()
+
+		var upstream *UpstreamError
+		if !errors.As(err, &upstream) {
+			t.Fatalf("%d: expected an UpstreamError, got %v", tt.status, err)
+		}
+		if !errors.Is(err, tt.kind) || upstream.Status != tt.status || upstream.Body != tt.body {
+			t.Errorf("%d: unexpected error %+v", tt.status, upstream)
+		}
+		if IsTransient(err) != tt.transient {
+			t.Errorf("%d: expected transient=%v", tt.status, tt.transient)
+		}
+		if got := upstream.Message(); got != tt.message {
+			t.Errorf("%d: expected message %q, got %q", tt.status, tt.message, got)
+		}
+		if upstream.RetryAfter != 7*time.Second {
+			t.Errorf("%d: expected Retry-After to be kept, got %s", tt.status, upstream.RetryAfter)
+		}
 	}
 }
 
 func TestLiteLLMService_DeleteKey(t *testing.T) {
+	fake, service := newFakeService(t)
+	key := fake.AddKey(litellmfake.Key{UserID: "u"})
+
+	if err := service.DeleteKey(context.Background(), key.Token); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fake.Key(key.Token); ok {
+		t.Error("Expected the key to be deleted")
+	}
+	if err := service.DeleteKey(context.Background(), key.Token); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a deleted key to be not found, got %v", err)
+	}
+}
+
+func TestLiteLLMService_UpdateKey(t *testing.T) {
+	fake, service := newFakeService(t)
+	key := fake.AddKey(litellmfake.Key{UserID: "u"})
+
+	if err := service.UpdateKey(context.Background(), UpdateKeyRequest{Key: key.Token, Duration: "720h0m0s"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fake.Key(key.Token); got.Expires == nil || time.Until(*got.Expires) < 719*time.Hour {
+		t.Errorf("Expected the key to expire in 720h, got %v", got.Expires)
+	}
+}
+
+func TestLiteLLMService_UpdateUser(t *testing.T) {
+	fake, service := newFakeService(t)
+	budget := 10.0
+	user := litellmfake.User{ID: "u@example.com"}
+	user.MaxBudget = &budget
+	fake.AddUser(user)
+
+	// A tier without a budget clears the user's max_budget.
+	err := service.UpdateUser(context.Background(), UpdateUserRequestForTier("u@example.com", config.UserTier{Name: "unlimited"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fake.User("u@example.com"); got.MaxBudget != nil {
+		t.Errorf("Expected max_budget to be cleared, got %v", *got.MaxBudget)
+	}
+
+	err = service.UpdateUser(context.Background(), UpdateUserRequestForTier("u@example.com", config.UserTier{Name: "gold", MaxBudget: 50, BudgetDuration: "30d", Models: []string{"fake-gpt-test"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := fake.User("u@example.com"); got.MaxBudget == nil || *got.MaxBudget != 50 || got.Duration != "30d" || len(got.Models) != 1 {
+		t.Errorf("Unexpected user %+v", got)
+	}
+}
+
+func TestLiteLLMService_ContextAndTrace(t *testing.T) {
+	fake := litellmfake.New("sk-master")
+	fake.SetFaults(litellmfake.Faults{Latency: time.Minute})
+	key := fake.AddKey(litellmfake.Key{UserID: "u"})
+	var gotRequestID, gotTraceParent string
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.URL.Path == "/key/delete" && r.Method == "POST" {
-			w.WriteHeader(http.StatusOK)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
+		gotRequestID = r.Header.Get("X-Request-ID")
+		gotTraceParent = r.Header.Get("traceparent")
+		fake.ServeHTTP(w, r)
 	}))
 	defer server.Close()
 
+	config.AppConfig = &config.Config{LiteLLMAPIURL: server.URL, LiteLLMMasterKey: config.NewSecret("sk-master")}
 	service := NewLiteLLMService()
-	service.BaseURL = server.URL
+	service.Timeout = 5 * time.Second
+	service.Timeouts = map[string]time.Duration{"list_keys": 50 * time.Millisecond}
 
-	err := service.DeleteKey("sk-123")
-	if err != nil {
+	// The per-operation deadline applies.
+	start := time.Now()
+	if _, err := service.ListKeys(context.Background(), "a@example.com"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("ListKeys took %s despite a 50ms timeout", elapsed)
+	}
+
+	// Cancelling the caller's context aborts the call.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := service.ListTeamKeys(ctx, "team-1"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+
+	// The trace of the inbound request is forwarded.
+	fake.SetFaults(litellmfake.Faults{})
+	ctx = WithTrace(context.Background(), Trace{RequestID: "req-7", TraceParent: "00-abc-def-01"})
+	if err := service.BlockKey(ctx, key.Token); err != nil {
 		t.Fatal(err)
 	}
+	if gotRequestID != "req-7" || gotTraceParent != "00-abc-def-01" {
+		t.Errorf("Expected trace headers, got %q %q", gotRequestID, gotTraceParent)
+	}
 }
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LiteLLMModel is a model served by LiteLLM with its pricing. Costs are in
+// USD per token; zero means LiteLLM does not know them.
+type LiteLLMModel struct {
+	ModelName          string  `json:"model_name"`
+	Mode               string  `json:"mode,omitempty"` // e.g. "chat" or "embedding"
+	InputCostPerToken  float64 `json:"input_cost_per_token"`
+	OutputCostPerToken float64 `json:"output_cost_per_token"`
+	MaxInputTokens     int     `json:"max_input_tokens,omitempty"`
+	MaxOutputTokens    int     `json:"max_output_tokens,omitempty"`
+}
+
+// ListModels returns every model LiteLLM serves, one entry per model name
+// however many deployments back it.
+func (s *LiteLLMService) ListModels(ctx context.Context) ([]LiteLLMModel, error) {
+	ctx, cancel := s.withTimeout(ctx, "list_models")
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", s.BaseURL+"/model/info", nil)
+	if err != nil {
+		return nil, err
+	}
+	s.setHeaders(req)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newUpstreamError("list models", resp)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	return decodeModelInfo(bodyBytes)
+}
+
+// AllowsModel reports whether a LiteLLM models list permits model. An empty
+// list, or one naming "all-proxy-models", allows every model; entries
+// ending in "*" match by prefix, as LiteLLM's wildcard routes do.
+func AllowsModel(allowed []string, model string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		switch {
+		case a == model || a == "all-proxy-models" || a == "*":
+			return true
+		case strings.HasSuffix(a, "*") && strings.HasPrefix(model, strings.TrimSuffix(a, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// ModelCatalog caches LiteLLM's model list for TTL, separately for each
+// instance, since the list rarely changes and every dashboard load asks for
+// it. A TTL of 0 disables caching.
+type ModelCatalog struct {
+	Client LiteLLMClient
+	TTL    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]catalogEntry
+}
+
+type catalogEntry struct {
+	models  []LiteLLMModel
+	expires time.Time
+}
+
+func NewModelCatalog(client LiteLLMClient, ttl time.Duration) *ModelCatalog {
+	return &ModelCatalog{Client: client, TTL: ttl, entries: make(map[string]catalogEntry)}
+}
+
+// Models returns the model list of the instance pinned on ctx.
+func (m *ModelCatalog) Models(ctx context.Context) ([]LiteLLMModel, error) {
+	instance := InstanceFromContext(ctx)
+	m.mu.Lock()
+	entry, ok := m.entries[instance]
+	m.mu.Unlock()
+	if ok && time.Now().Before(entry.expires) {
+		return entry.models, nil
+	}
+
+	models, err := m.Client.ListModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if m.TTL > 0 {
+		m.mu.Lock()
+		m.entries[instance] = catalogEntry{models: models, expires: time.Now().Add(m.TTL)}
+		m.mu.Unlock()
+	}
+	return models, nil
+}
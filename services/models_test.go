@@ -0,0 +1,55 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type modelsClient struct {
+	LiteLLMClient
+	calls int
+}
+
+func (m *modelsClient) ListModels(ctx context.Context) ([]LiteLLMModel, error) {
+	m.calls++
+	return []LiteLLMModel{{ModelName: InstanceFromContext(ctx) + "-model"}}, nil
+}
+
+func TestModelCatalog(t *testing.T) {
+	ctx := context.Background()
+	client := &modelsClient{}
+	catalog := NewModelCatalog(client, time.Minute)
+
+	catalog.Models(ctx)
+	if models, _ := catalog.Models(ctx); client.calls != 1 || models[0].ModelName != "-model" {
+		t.Errorf("Expected the second read to be cached, got %d calls", client.calls)
+	}
+	if models, _ := catalog.Models(WithInstance(ctx, "eu")); client.calls != 2 || models[0].ModelName != "eu-model" {
+		t.Errorf("Expected instances to be cached separately, got %+v after %d calls", models, client.calls)
+	}
+
+	catalog.entries[""] = catalogEntry{models: nil, expires: time.Now().Add(-time.Second)}
+	if catalog.Models(ctx); client.calls != 3 {
+		t.Errorf("Expected an expired entry to be refreshed, got %d calls", client.calls)
+	}
+}
+
+func TestAllowsModel(t *testing.T) {
+	for _, tc := range []struct {
+		allowed []string
+		model   string
+		want    bool
+	}{
+		{nil, "gpt-4o", true},
+		{[]string{"all-proxy-models"}, "gpt-4o", true},
+		{[]string{"fake-gpt-test"}, "fake-gpt-test", true},
+		{[]string{"fake-gpt-test"}, "gpt-4o", false},
+		{[]string{"openai/*"}, "openai/gpt-4o", true},
+		{[]string{"openai/*"}, "anthropic/claude", false},
+	} {
+		if got := AllowsModel(tc.allowed, tc.model); got != tc.want {
+			t.Errorf("AllowsModel(%v, %q) = %v, want %v", tc.allowed, tc.model, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/example/llmreq/config"
+)
+
+// Notification is a message addressed to one or more people. Webhook sinks
+// receive it as JSON; email sinks render Subject and Body.
+type Notification struct {
+	Event      string                 `json:"event"`
+	Recipients []string               `json:"recipients"`
+	Subject    string                 `json:"subject"`
+	Body       string                 `json:"body"`
+	Data       map[string]interface{} `json:"data,omitempty"`
+}
+
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// NewNotifier builds the notifier described by the alert settings in
+// config.AppConfig. It returns nil when no delivery channel is configured.
+func NewNotifier() Notifier {
+	var notifiers MultiNotifier
+	if config.AppConfig.AlertWebhookURL != "" {
+		notifiers = append(notifiers, NewWebhookNotifier(config.AppConfig.AlertWebhookURL))
+	}
+	if config.AppConfig.SMTPHost != "" {
+		notifiers = append(notifiers, &EmailNotifier{
+			Host:     config.AppConfig.SMTPHost,
+			Port:     config.AppConfig.SMTPPort,
+			Username: config.AppConfig.SMTPUsername,
+			Password: config.AppConfig.SMTPPassword,
+			From:     config.AppConfig.SMTPFrom,
+		})
+	}
+
+	switch len(notifiers) {
+	case 0:
+		return nil
+	case 1:
+		return notifiers[0]
+	}
+	return notifiers
+}
+
+// MultiNotifier delivers each notification through every notifier it holds.
+type MultiNotifier []Notifier
+
+func (m MultiNotifier) Notify(n Notification) error {
+	var errs []error
+	for _, notifier := range m {
+		if err := notifier.Notify(n); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (w *WebhookNotifier) Notify(n Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("webhook delivery failed: status %d, body: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	return nil
+}
+
+type EmailNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password *config.Secret
+	From     string
+}
+
+func (e *EmailNotifier) Notify(n Notification) error {
+	if len(n.Recipients) == 0 {
+		return nil
+	}
+
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password.Value(), e.Host)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.Recipients, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", n.Subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(n.Body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	addr := fmt.Sprintf("%s:%d", e.Host, e.Port)
+	return smtp.SendMail(addr, auth, e.From, n.Recipients, msg.Bytes())
+}
@@ -0,0 +1,206 @@
+package services
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpSink is a minimal SMTP server that accepts every message and keeps it
+// in memory.
+type smtpSink struct {
+	listener net.Listener
+	mu       sync.Mutex
+	messages []string
+	rcpts    [][]string
+}
+
+func newSMTPSink(t *testing.T) *smtpSink {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &smtpSink{listener: l}
+	go s.serve()
+	t.Cleanup(func() { l.Close() })
+	return s
+}
+
+func (s *smtpSink) hostPort() (string, int) {
+	addr := s.listener.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+func (s *smtpSink) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpSink) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { _, _ = conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ESMTP sink")
+	var rcpts []string
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case strings.HasPrefix(cmd, "RCPT TO:"):
+			rcpts = append(rcpts, strings.Trim(strings.TrimSpace(line)[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "DATA"):
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.mu.Lock()
+			s.messages = append(s.messages, data.String())
+			s.rcpts = append(s.rcpts, rcpts)
+			s.mu.Unlock()
+			rcpts = nil
+			reply("250 OK")
+		case strings.HasPrefix(cmd, "QUIT"):
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (s *smtpSink) received() ([]string, [][]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.messages...), append([][]string(nil), s.rcpts...)
+}
+
+// webhookSink records every notification posted to it.
+type webhookSink struct {
+	server *httptest.Server
+	mu     sync.Mutex
+	events []Notification
+}
+
+func newWebhookSink(t *testing.T) *webhookSink {
+	s := &webhookSink{}
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n Notification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.events = append(s.events, n)
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(s.server.Close)
+	return s
+}
+
+func (s *webhookSink) received() []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Notification(nil), s.events...)
+}
+
+func TestEmailNotifier(t *testing.T) {
+	sink := newSMTPSink(t)
+	host, port := sink.hostPort()
+
+	notifier := &EmailNotifier{Host: host, Port: port, From: "llmreq@example.com"}
+	err := notifier.Notify(Notification{
+		Recipients: []string{"a@example.com", "b@example.com"},
+		Subject:    "Hello",
+		Body:       "line one\nline two",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	messages, rcpts := sink.received()
+	if len(messages) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(messages))
+	}
+	if !strings.Contains(messages[0], "Subject: Hello") || !strings.Contains(messages[0], "line two") {
+		t.Errorf("Unexpected message: %s", messages[0])
+	}
+	if len(rcpts[0]) != 2 {
+		t.Errorf("Expected 2 recipients, got %v", rcpts[0])
+	}
+
+	// No recipients is a no-op
+	if err := notifier.Notify(Notification{Subject: "nobody"}); err != nil {
+		t.Fatal(err)
+	}
+	if messages, _ := sink.received(); len(messages) != 1 {
+		t.Errorf("Expected no new message, got %d total", len(messages))
+	}
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	sink := newWebhookSink(t)
+
+	notifier := NewWebhookNotifier(sink.server.URL)
+	if err := notifier.Notify(Notification{Event: "test", Data: map[string]interface{}{"n": 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := sink.received()
+	if len(events) != 1 || events[0].Event != "test" {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	if err := NewWebhookNotifier(failing.URL).Notify(Notification{}); err == nil {
+		t.Error("Expected error for non-2xx webhook response")
+	}
+}
+
+func TestMultiNotifier(t *testing.T) {
+	webhook := newWebhookSink(t)
+	smtpServer := newSMTPSink(t)
+	host, port := smtpServer.hostPort()
+
+	notifier := MultiNotifier{
+		NewWebhookNotifier(webhook.server.URL),
+		&EmailNotifier{Host: host, Port: port, From: "llmreq@example.com"},
+	}
+	if err := notifier.Notify(Notification{Recipients: []string{"a@example.com"}, Subject: "s"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.received()) != 1 {
+		t.Error("Expected webhook delivery")
+	}
+	if messages, _ := smtpServer.received(); len(messages) != 1 {
+		t.Error("Expected email delivery")
+	}
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+)
+
+// ErrInvalidTransition is returned when a lifecycle action does not apply to
+// the user's current status, such as unsuspending an active user.
+var ErrInvalidTransition = errors.New("action not allowed in the user's current status")
+
+// ErrSuccessorKeyLimit is returned when the keys to transfer would take the
+// successor past their active or long-term key limit.
+var ErrSuccessorKeyLimit = errors.New("successor would exceed a key limit")
+
+// Suspend blocks all of the user's keys in LiteLLM and marks them suspended so
+// that the auth middleware turns them away. Unsuspend reverses it, applying
+// the user's tier.
+func (l *UserLifecycle) Suspend(ctx context.Context, user *models.User) error {
+	if user.Status != "active" {
+		return ErrInvalidTransition
+	}
+
+	if err := l.blockKeys(ctx, user.ID); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	user.Status = "suspended"
+	user.SuspendedAt = &now
+	return l.DB.Model(user).Select("status", "suspended_at").Updates(user).Error
+}
+
+func (l *UserLifecycle) Unsuspend(ctx context.Context, user *models.User) error {
+	if user.Status != "suspended" {
+		return ErrInvalidTransition
+	}
+
+	if err := l.applyTier(ctx, user); err != nil {
+		return err
+	}
+	if err := l.unblockKeys(ctx, user.ID); err != nil {
+		return err
+	}
+
+	user.Status = "active"
+	user.SuspendedAt = nil
+	return l.DB.Model(user).Select("status", "suspended_at").Updates(user).Error
+}
+
+// StartOffboarding blocks the user's keys, captures their final spend and
+// records the successor who will receive transferable keys. Nothing is
+// deleted until FinalizeOffboarding, so CancelOffboarding can undo it.
+func (l *UserLifecycle) StartOffboarding(ctx context.Context, user *models.User, successor string) error {
+	if user.Status != "active" && user.Status != "suspended" {
+		return ErrInvalidTransition
+	}
+
+	if user.Status == "active" {
+		if err := l.blockKeys(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	spend, err := l.captureSpend(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+
+	user.PriorStatus = user.Status
+	user.Status = "offboarding"
+	user.Successor = successor
+	user.FinalSpend = &spend
+	return l.DB.Model(user).Select("status", "prior_status", "successor", "final_spend").Updates(user).Error
+}
+
+// CancelOffboarding returns the user to the status they had before
+// offboarding started, applying their tier and unblocking their keys if
+// they were active.
+func (l *UserLifecycle) CancelOffboarding(ctx context.Context, user *models.User) error {
+	if user.Status != "offboarding" {
+		return ErrInvalidTransition
+	}
+
+	restored := user.PriorStatus
+	if restored == "" {
+		restored = "active"
+	}
+	if restored == "active" {
+		if err := l.applyTier(ctx, user); err != nil {
+			return err
+		}
+		if err := l.unblockKeys(ctx, user.ID); err != nil {
+			return err
+		}
+	}
+
+	user.Status = restored
+	user.PriorStatus = ""
+	user.Successor = ""
+	user.FinalSpend = nil
+	return l.DB.Model(user).Select("status", "prior_status", "successor", "final_spend").Updates(user).Error
+}
+
+// FinalizeOffboarding transfers long-term keys, and team keys of teams the
+// successor belongs to, to the successor and permanently deletes every other
+// key. The user's LiteLLM budget and model access are zeroed and their team
+// memberships are removed. This cannot be undone. A failure part-way leaves
+// the user in "offboarding" so that finalizing can be retried.
+func (l *UserLifecycle) FinalizeOffboarding(ctx context.Context, user *models.User) error {
+	if user.Status != "offboarding" {
+		return ErrInvalidTransition
+	}
+
+	keyIDs, err := l.userKeys(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if err := l.checkSuccessorLimits(user, keyIDs); err != nil {
+		return err
+	}
+	for _, keyID := range keyIDs {
+		var dbKey models.KeyHistory
+		l.DB.Where("litellm_key_id = ? AND status = ?", keyID, "active").Limit(1).Find(&dbKey)
+
+		if l.transferable(user.Successor, &dbKey) {
+			if err := l.transferKey(ctx, user, &dbKey); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := l.revokeKey(ctx, user.ID, keyID, "offboarded"); err != nil {
+			return err
+		}
+	}
+
+	if err := l.LiteLLMService.UpdateUser(ctx, CutOffUserRequest(user.ID)); err != nil {
+		return fmt.Errorf("failed to cut off user's budget and models: %w", err)
+	}
+
+	var memberships []models.TeamMember
+	l.DB.Where("user_id = ?", user.ID).Find(&memberships)
+	for _, member := range memberships {
+		if err := l.LiteLLMService.RemoveTeamMember(ctx, member.TeamID, user.ID); err != nil {
+			return fmt.Errorf("failed to remove team membership %s: %w", member.TeamID, err)
+		}
+		l.DB.Delete(&member)
+		l.record(user.ID, "team.member_remove", "team", member.TeamID, map[string]string{"role": member.Role, "source": member.Source}, nil)
+	}
+
+	now := time.Now()
+	user.Status = "offboarded"
+	user.PriorStatus = ""
+	user.OffboardedAt = &now
+	return l.DB.Model(user).Select("status", "prior_status", "offboarded_at").Updates(user).Error
+}
+
+// applyTier sets the limits of the user's tier in LiteLLM, which an admin
+// may have changed while the user was not active.
+func (l *UserLifecycle) applyTier(ctx context.Context, user *models.User) error {
+	if err := l.LiteLLMService.UpdateUser(ctx, UpdateUserRequestForTier(user.ID, l.tier(user))); err != nil {
+		return fmt.Errorf("failed to apply tier: %w", err)
+	}
+	return nil
+}
+
+// transferable reports whether a key goes to the successor instead of being
+// deleted.
+func (l *UserLifecycle) transferable(successor string, dbKey *models.KeyHistory) bool {
+	if successor == "" || dbKey.ID == 0 {
+		return false
+	}
+	if dbKey.TeamID != "" {
+		var count int64
+		l.DB.Model(&models.TeamMember{}).Where("team_id = ? AND user_id = ?", dbKey.TeamID, successor).Count(&count)
+		return count > 0
+	}
+	return dbKey.KeyType == "long-term"
+}
+
+// checkSuccessorLimits makes sure the personal keys about to be transferred
+// fit within the successor's key limits before anything is changed. Team
+// keys stay in their team, so they count against no personal limit.
+func (l *UserLifecycle) checkSuccessorLimits(user *models.User, keyIDs []string) error {
+	var total, longTerm int
+	for _, keyID := range keyIDs {
+		var dbKey models.KeyHistory
+		l.DB.Where("litellm_key_id = ? AND status = ?", keyID, "active").Limit(1).Find(&dbKey)
+		if dbKey.TeamID == "" && l.transferable(user.Successor, &dbKey) {
+			total++
+			if dbKey.KeyType == "long-term" {
+				longTerm++
+			}
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	var active, activeLongTerm int64
+	query := l.DB.Model(&models.KeyHistory{}).Where("user_id = ? AND team_id = ? AND status = ?", user.Successor, "", "active")
+	if err := query.Count(&active).Error; err != nil {
+		return err
+	}
+	if err := query.Where("key_type = ?", "long-term").Count(&activeLongTerm).Error; err != nil {
+		return err
+	}
+	if int(active)+total > config.AppConfig.MaxActiveKeys {
+		return fmt.Errorf("%w: %s has %d of %d active keys and would receive %d", ErrSuccessorKeyLimit, user.Successor, active, config.AppConfig.MaxActiveKeys, total)
+	}
+	if int(activeLongTerm)+longTerm > config.AppConfig.LongTermKeyLimit {
+		return fmt.Errorf("%w: %s has %d of %d long-term keys and would receive %d", ErrSuccessorKeyLimit, user.Successor, activeLongTerm, config.AppConfig.LongTermKeyLimit, longTerm)
+	}
+	return nil
+}
+
+func (l *UserLifecycle) transferKey(ctx context.Context, user *models.User, dbKey *models.KeyHistory) error {
+	req := UpdateKeyRequest{Key: dbKey.LiteLLMKeyID}
+	if dbKey.TeamID != "" {
+		req.Metadata = map[string]interface{}{"created_by": user.Successor, "key_type": dbKey.KeyType}
+	} else {
+		req.UserID = user.Successor
+	}
+	if err := l.LiteLLMService.UpdateKey(ctx, req); err != nil {
+		return fmt.Errorf("failed to transfer key %s: %w", dbKey.LiteLLMKeyID, err)
+	}
+	if dbKey.Blocked {
+		if err := l.LiteLLMService.UnblockKey(ctx, dbKey.LiteLLMKeyID); err != nil {
+			return fmt.Errorf("failed to unblock key %s: %w", dbKey.LiteLLMKeyID, err)
+		}
+	}
+	before := audit.KeyState(dbKey)
+	if err := l.DB.Model(dbKey).Updates(map[string]interface{}{"user_id": user.Successor, "blocked": false}).Error; err != nil {
+		return err
+	}
+	l.record(user.ID, "key.transfer", "key", dbKey.LiteLLMKeyID, before, audit.KeyState(dbKey))
+	return nil
+}
+
+func (l *UserLifecycle) blockKeys(ctx context.Context, userID string) error {
+	keyIDs, err := l.userKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, keyID := range keyIDs {
+		if err := l.LiteLLMService.BlockKey(ctx, keyID); err != nil {
+			return fmt.Errorf("failed to block key %s: %w", keyID, err)
+		}
+		l.DB.Model(&models.KeyHistory{}).Where("litellm_key_id = ?", keyID).Update("blocked", true)
+		l.record(userID, "key.block", "key", keyID, map[string]bool{"blocked": false}, map[string]bool{"blocked": true})
+	}
+	return nil
+}
+
+func (l *UserLifecycle) unblockKeys(ctx context.Context, userID string) error {
+	keyIDs, err := l.userKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+	for _, keyID := range keyIDs {
+		if err := l.LiteLLMService.UnblockKey(ctx, keyID); err != nil {
+			return fmt.Errorf("failed to unblock key %s: %w", keyID, err)
+		}
+		l.DB.Model(&models.KeyHistory{}).Where("litellm_key_id = ?", keyID).Update("blocked", false)
+		l.record(userID, "key.unblock", "key", keyID, map[string]bool{"blocked": true}, map[string]bool{"blocked": false})
+	}
+	return nil
+}
+
+// captureSpend records the user's total and per-key spend as snapshots and
+// returns the total.
+func (l *UserLifecycle) captureSpend(ctx context.Context, userID string) (float64, error) {
+	info, err := l.LiteLLMService.GetUserInfo(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch spend: %w", err)
+	}
+	keys, err := l.LiteLLMService.ListKeys(ctx, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	now := time.Now()
+	var total, maxBudget float64
+	if info != nil {
+		total, maxBudget = info.Spend, info.MaxBudget
+	}
+	snapshots := []models.SpendSnapshot{{UserID: userID, Spend: total, MaxBudget: maxBudget, CapturedAt: now}}
+	for _, k := range keys {
+		if k.User != userID {
+			continue
+		}
+		snapshots = append(snapshots, models.SpendSnapshot{
+			UserID:       userID,
+			LiteLLMKeyID: k.Key,
+			KeyName:      k.KeyAlias,
+			Spend:        k.Spend,
+			MaxBudget:    k.MaxBudget,
+			CapturedAt:   now,
+		})
+	}
+	if err := l.DB.Create(&snapshots).Error; err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// record audits one step of a lifecycle action. The action itself is
+// audited by the caller, under the admin who requested it.
+func (l *UserLifecycle) record(userID, action, targetType, targetID string, before, after interface{}) {
+	l.Audit.Record(audit.Event{
+		Actor:      "system:lifecycle",
+		UserID:     userID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+	})
+}
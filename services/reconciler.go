@@ -0,0 +1,749 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Audit actions of the changes PlanKeys makes to key_history rows.
+const (
+	ActionSyncImport     = "key.sync_import"
+	ActionSyncRematch    = "key.sync_rematch"
+	ActionSyncReactivate = "key.sync_reactivate"
+	ActionSyncExpire     = "key.sync_expire"
+	ActionSyncRevoke     = "key.sync_revoke"
+	ActionSyncRetype     = "key.sync_retype"
+	ActionSyncExpiry     = "key.sync_expiry"
+)
+
+// Kinds of drift between LiteLLM and key_history. Every KeyChange resolves
+// one of them, so a reconciliation can be limited to some.
+const (
+	DriftUnknownUpstream = "unknown_upstream" // a LiteLLM key with no row
+	DriftMissingUpstream = "missing_upstream" // an active row whose key LiteLLM does not list
+	DriftAliasCollision  = "alias_collision"  // a LiteLLM key named like a row recorded under another ID
+	DriftTypeMismatch    = "type_mismatch"    // a row whose type differs from the key's metadata
+	DriftExpiredActive   = "expired_active"   // an active row whose key has expired
+	DriftInactiveListed  = "inactive_listed"  // a revoked row whose key LiteLLM still lists
+	DriftExpiryMismatch  = "expiry_mismatch"  // a row whose expiry differs from the key's
+)
+
+// DriftCategories lists every kind of drift, in report order.
+var DriftCategories = []string{DriftUnknownUpstream, DriftMissingUpstream, DriftAliasCollision, DriftTypeMismatch, DriftExpiredActive, DriftInactiveListed, DriftExpiryMismatch}
+
+// KeyChange is one change to a key_history row.
+type KeyChange struct {
+	Category string
+	Action   string
+	Before   map[string]interface{} // nil for imported keys
+	// Key is the row with every planned change applied. Updates holds the
+	// columns this change sets; it is nil for an import, which creates Key.
+	Key     *models.KeyHistory
+	Updates map[string]interface{}
+	// FormerKeyID is the ID a rematched row was recorded under.
+	FormerKeyID string
+}
+
+// ListedKey is a key listed by LiteLLM with the key_history row it maps to
+// once the changes are applied.
+type ListedKey struct {
+	Row   *models.KeyHistory
+	Spend float64
+}
+
+// KeyPlan is the outcome of matching a user's LiteLLM keys with their
+// key_history rows.
+type KeyPlan struct {
+	Keys    []ListedKey
+	Changes []KeyChange
+}
+
+// keyOwner is whose keys a reconciliation covers: a user's personal keys,
+// or a team's keys when TeamID is set.
+type keyOwner struct {
+	UserID string
+	TeamID string
+}
+
+func (o keyOwner) String() string {
+	if o.TeamID != "" {
+		return "team " + o.TeamID
+	}
+	return o.UserID
+}
+
+// owns reports whether k is one of the owner's keys. A key with a team
+// belongs to the team even if it names a user.
+func (o keyOwner) owns(k LiteLLMKey) bool {
+	if o.TeamID != "" {
+		return k.TeamId == o.TeamID
+	}
+	return k.User == o.UserID && k.TeamId == ""
+}
+
+// rows scopes a key_history query to the owner's rows.
+func (o keyOwner) rows(db *gorm.DB) *gorm.DB {
+	db = db.Where("status <> ?", "superseded")
+	if o.TeamID != "" {
+		return db.Where("team_id = ?", o.TeamID)
+	}
+	return db.Where("user_id = ? AND team_id = ?", o.UserID, "")
+}
+
+// PlanKeys matches the personal LiteLLM keys of userID with rows, their
+// personal key_history rows, and works out the changes that bring the rows
+// in line:
+//
+//   - a row whose key is listed is marked expired or, if it was not active,
+//     reactivated, and otherwise takes the key's expiry;
+//   - a listed key with no row takes over the row of the same name, as its
+//     ID may have been guessed wrong at creation;
+//   - any other listed key is imported, as a standard key unless its
+//     metadata says otherwise;
+//   - a row whose type differs from the key_type in its key's metadata
+//     takes that type;
+//   - an active row whose key is not listed is revoked, but only when
+//     complete says the listing was read in full.
+//
+// rows are not modified.
+func PlanKeys(userID string, rows []models.KeyHistory, keys []LiteLLMKey, complete bool, now time.Time) KeyPlan {
+	return planKeys(keyOwner{UserID: userID}, rows, keys, complete, now)
+}
+
+// PlanTeamKeys is PlanKeys for the keys of teamID and its key_history rows.
+// Imported keys are recorded under the user named as their creator in
+// their metadata, if any.
+func PlanTeamKeys(teamID string, rows []models.KeyHistory, keys []LiteLLMKey, complete bool, now time.Time) KeyPlan {
+	return planKeys(keyOwner{TeamID: teamID}, rows, keys, complete, now)
+}
+
+func planKeys(owner keyOwner, rows []models.KeyHistory, keys []LiteLLMKey, complete bool, now time.Time) KeyPlan {
+	rows = append([]models.KeyHistory(nil), rows...)
+	byID := make(map[string]*models.KeyHistory)
+	for i := range rows {
+		byID[rows[i].LiteLLMKeyID] = &rows[i]
+	}
+
+	plan := KeyPlan{Keys: []ListedKey{}}
+	processed := make(map[*models.KeyHistory]bool)
+	for _, k := range keys {
+		if !owner.owns(k) {
+			continue
+		}
+		id := k.Key
+		expiresAt := parseKeyExpiry(k.Expires)
+		isExpired := expiresAt != nil && expiresAt.Before(now)
+
+		row, exists := byID[id]
+		var renamed *models.KeyHistory
+		if !exists {
+			renamed = aliasMatch(rows, processed, k.KeyAlias, id)
+		}
+		switch {
+		case exists:
+			before := audit.KeyState(row)
+			category := DriftInactiveListed
+			if row.Status == "active" {
+				category = DriftExpiredActive
+			}
+			expiryChanged := !sameExpiry(row.ExpiresAt, expiresAt)
+			row.ExpiresAt = expiresAt
+			if isExpired && row.Status != "expired" {
+				row.Status = "expired"
+				plan.Changes = append(plan.Changes, KeyChange{
+					Category: category, Action: ActionSyncExpire, Before: before, Key: row,
+					Updates: map[string]interface{}{"status": "expired", "expires_at": expiresAt},
+				})
+			} else if !isExpired && row.Status != "active" {
+				row.Status = "active"
+				row.RevokedAt = nil
+				plan.Changes = append(plan.Changes, KeyChange{
+					Category: DriftInactiveListed, Action: ActionSyncReactivate, Before: before, Key: row,
+					Updates: map[string]interface{}{"status": "active", "revoked_at": nil, "expires_at": expiresAt},
+				})
+			} else if expiryChanged {
+				plan.Changes = append(plan.Changes, KeyChange{
+					Category: DriftExpiryMismatch, Action: ActionSyncExpiry, Before: before, Key: row,
+					Updates: map[string]interface{}{"expires_at": expiresAt},
+				})
+			}
+
+		case renamed != nil:
+			row = renamed
+			before := audit.KeyState(row)
+			before["key_id"] = row.LiteLLMKeyID
+			change := KeyChange{Category: DriftAliasCollision, Action: ActionSyncRematch, Before: before, Key: row, FormerKeyID: row.LiteLLMKeyID}
+			row.LiteLLMKeyID = id
+			row.ExpiresAt = expiresAt
+			if isExpired {
+				row.Status = "expired"
+				change.Action = ActionSyncExpire
+			} else {
+				row.Status = "active"
+				row.RevokedAt = nil
+			}
+			change.Updates = map[string]interface{}{"litellm_key_id": id, "status": row.Status, "revoked_at": row.RevokedAt, "expires_at": expiresAt}
+			plan.Changes = append(plan.Changes, change)
+
+		default:
+			row = &models.KeyHistory{
+				UserID:       owner.UserID,
+				TeamID:       owner.TeamID,
+				LiteLLMKeyID: id,
+				Instance:     k.Instance,
+				KeyName:      k.KeyAlias,
+				KeyMask:      k.Key,
+				KeyType:      "standard",
+				CreatedAt:    now,
+				ExpiresAt:    expiresAt,
+				Status:       "active",
+			}
+			if owner.TeamID != "" {
+				row.UserID = keyCreator(k)
+			}
+			if keyType := metadataKeyType(k); keyType != "" {
+				row.KeyType = keyType
+			}
+			if isExpired {
+				row.Status = "expired"
+			}
+			plan.Changes = append(plan.Changes, KeyChange{Category: DriftUnknownUpstream, Action: ActionSyncImport, Key: row})
+		}
+
+		if keyType := metadataKeyType(k); keyType != "" && keyType != row.KeyType {
+			before := audit.KeyState(row)
+			row.KeyType = keyType
+			plan.Changes = append(plan.Changes, KeyChange{
+				Category: DriftTypeMismatch, Action: ActionSyncRetype, Before: before, Key: row,
+				Updates: map[string]interface{}{"key_type": keyType},
+			})
+		}
+		processed[row] = true
+		plan.Keys = append(plan.Keys, ListedKey{Row: row, Spend: k.Spend})
+	}
+
+	if complete {
+		for i := range rows {
+			row := &rows[i]
+			if processed[row] || row.Status != "active" {
+				continue
+			}
+			before := audit.KeyState(row)
+			row.Status = "revoked"
+			revokedAt := now
+			row.RevokedAt = &revokedAt
+			plan.Changes = append(plan.Changes, KeyChange{
+				Category: DriftMissingUpstream, Action: ActionSyncRevoke, Before: before, Key: row,
+				Updates: map[string]interface{}{"status": "revoked", "revoked_at": &revokedAt},
+			})
+		}
+	}
+	return plan
+}
+
+// metadataKeyType is the key_type llmreq records in the metadata of the
+// keys it creates, or "" for keys created elsewhere or before it did.
+func metadataKeyType(k LiteLLMKey) string {
+	keyType, _ := k.Metadata["key_type"].(string)
+	if keyType != "standard" && keyType != "long-term" {
+		return ""
+	}
+	return keyType
+}
+
+// keyCreator is the user llmreq records in the metadata of the team keys it
+// creates, or the key's own user for keys created elsewhere.
+func keyCreator(k LiteLLMKey) string {
+	if creator, _ := k.Metadata["created_by"].(string); creator != "" {
+		return creator
+	}
+	return k.User
+}
+
+// aliasMatch finds an unmatched row named alias that is recorded under an
+// ID other than id.
+func aliasMatch(rows []models.KeyHistory, processed map[*models.KeyHistory]bool, alias, id string) *models.KeyHistory {
+	for i := range rows {
+		if rows[i].KeyName == alias && rows[i].LiteLLMKeyID != id && !processed[&rows[i]] {
+			return &rows[i]
+		}
+	}
+	return nil
+}
+
+// sameExpiry reports whether a and b are the same expiry. LiteLLM works out
+// the expiry of the keys llmreq creates itself, so it can be off from the
+// recorded one by the time the request took.
+func sameExpiry(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Sub(*b).Abs() < time.Minute
+}
+
+func parseKeyExpiry(expires string) *time.Time {
+	if expires == "" || expires == "null" {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+// KeyReconciler brings the key_history rows of every user and team in line
+// with their LiteLLM keys, on an interval and on demand. Drift reports and
+// runs requested by admins are queued and carried out by Start, so no
+// request waits on a walk over every user. Runs never overlap, and each
+// owner's changes are saved in one transaction.
+type KeyReconciler struct {
+	LiteLLMService LiteLLMClient
+	DB             *gorm.DB
+	Audit          *audit.Logger
+	Interval       time.Duration
+
+	mu   sync.Mutex
+	wake chan struct{}
+}
+
+// Errors of QueueRun.
+var (
+	ErrReportNotFound = errors.New("drift report not found")
+	ErrReportNotReady = errors.New("drift report is not ready")
+)
+
+func NewKeyReconciler(service LiteLLMClient, db *gorm.DB) *KeyReconciler {
+	return &KeyReconciler{
+		LiteLLMService: service,
+		DB:             db,
+		Audit:          audit.NewLogger(db),
+		Interval:       config.AppConfig.KeyReconcileInterval,
+		wake:           make(chan struct{}, 1),
+	}
+}
+
+// Start carries out queued reports and runs until ctx is cancelled. If
+// Interval is positive it also reconciles immediately and then once per
+// Interval.
+func (r *KeyReconciler) Start(ctx context.Context) {
+	var tick <-chan time.Time
+	if r.Interval > 0 {
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+		tick = ticker.C
+		r.runScheduled(ctx)
+	}
+
+	for {
+		r.ProcessPending(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			r.runScheduled(ctx)
+		case <-r.wake:
+		}
+	}
+}
+
+func (r *KeyReconciler) runScheduled(ctx context.Context) {
+	if _, err := r.RunOnce(ctx, "schedule"); err != nil {
+		log.Printf("Key reconciliation failed: %v", err)
+	}
+}
+
+// notify wakes Start to pick up a queued job.
+func (r *KeyReconciler) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// QueueReport requests a drift report, to be worked out in the background.
+func (r *KeyReconciler) QueueReport() (*models.ReconcileReport, error) {
+	report := &models.ReconcileReport{Status: "pending"}
+	if err := r.DB.Create(report).Error; err != nil {
+		return nil, err
+	}
+	r.notify()
+	return report, nil
+}
+
+// QueueRun requests a manual run, limited to the given drift categories;
+// none means all of them. With a reportID the run applies only the changes
+// of that drift report, as reviewed, and only those that still apply when
+// it is carried out; otherwise it reconciles every user and team.
+func (r *KeyReconciler) QueueRun(reportID uint, categories []string) (*models.ReconcileRun, error) {
+	run := &models.ReconcileRun{Trigger: "manual", Status: "pending", Categories: strings.Join(categories, ",")}
+	if reportID != 0 {
+		var report models.ReconcileReport
+		if err := r.DB.First(&report, reportID).Error; errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReportNotFound
+		} else if err != nil {
+			return nil, err
+		}
+		if report.Status != "ready" {
+			return nil, ErrReportNotReady
+		}
+		run.ReportID = &report.ID
+	}
+	if err := r.DB.Create(run).Error; err != nil {
+		return nil, err
+	}
+	r.notify()
+	return run, nil
+}
+
+// ProcessPending carries out the queued reports, then the queued runs,
+// oldest first, until none are left. A job interrupted by ctx stays
+// pending for the next start.
+func (r *KeyReconciler) ProcessPending(ctx context.Context) {
+	for ctx.Err() == nil {
+		var err error
+		var report models.ReconcileReport
+		var run models.ReconcileRun
+		if r.DB.Where("status = ?", "pending").Order("id").Limit(1).Find(&report); report.ID != 0 {
+			err = r.runReport(ctx, &report)
+		} else if r.DB.Where("status = ?", "pending").Order("id").Limit(1).Find(&run); run.ID != 0 {
+			err = r.runQueued(ctx, &run)
+		} else {
+			return
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Queued key reconciliation failed: %v", err)
+			}
+			return
+		}
+	}
+}
+
+// runReport works out a queued drift report and stores it.
+func (r *KeyReconciler) runReport(ctx context.Context, record *models.ReconcileReport) error {
+	report, err := r.Drift(ctx)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	now := time.Now()
+	record.FinishedAt = &now
+	if err != nil {
+		record.Status, record.Error = "failed", err.Error()
+	} else {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		record.Status, record.Report = "ready", string(data)
+	}
+	return r.DB.Save(record).Error
+}
+
+// runQueued carries out a queued run. A run interrupted by ctx is queued
+// again, to start over; one that could not take place is finished with
+// its error.
+func (r *KeyReconciler) runQueued(ctx context.Context, run *models.ReconcileRun) error {
+	err := r.applyQueued(ctx, run)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		queued := models.ReconcileRun{ID: run.ID, Trigger: run.Trigger, Status: "pending", ReportID: run.ReportID, Categories: run.Categories}
+		r.DB.Save(&queued)
+		return err
+	}
+	run.Status, run.FinishedAt, run.Error = "finished", time.Now(), err.Error()
+	r.DB.Save(run)
+	return nil
+}
+
+// applyQueued applies a queued run. A run of a drift report only visits
+// the report's users and teams and only makes the changes it lists; the
+// ones that no longer apply are counted as skipped.
+func (r *KeyReconciler) applyQueued(ctx context.Context, run *models.ReconcileRun) error {
+	var categories []string
+	if run.Categories != "" {
+		categories = strings.Split(run.Categories, ",")
+	}
+	if run.ReportID == nil {
+		return r.apply(ctx, run, nil, categoryFilter(categories))
+	}
+
+	var record models.ReconcileReport
+	if err := r.DB.First(&record, *run.ReportID).Error; err != nil {
+		return err
+	}
+	var report DriftReport
+	if err := json.Unmarshal([]byte(record.Report), &report); err != nil {
+		return err
+	}
+	reviewed := make(map[string]bool)
+	owners := []keyOwner{}
+	for _, item := range report.Items {
+		if len(categories) > 0 && !slices.Contains(categories, item.Category) {
+			continue
+		}
+		reviewed[item.id()] = true
+		owner := keyOwner{UserID: item.UserID}
+		if item.TeamID != "" {
+			owner = keyOwner{TeamID: item.TeamID}
+		}
+		if !slices.Contains(owners, owner) {
+			owners = append(owners, owner)
+		}
+	}
+
+	keep := func(owner keyOwner, change KeyChange) bool {
+		return reviewed[driftItem(owner, change).id()]
+	}
+	if err := r.apply(ctx, run, owners, keep); err != nil {
+		return err
+	}
+	applied := run.Imported + run.Rematched + run.Reactivated + run.Expired + run.Revoked + run.Retyped + run.Redated
+	run.Skipped = max(len(reviewed)-applied, 0)
+	return r.DB.Save(run).Error
+}
+
+// RunOnce reconciles every provisioned user and every user with a personal
+// key_history row, then every team llmreq manages or has key_history rows
+// for, and records the run. A failure for one user or team is counted and
+// does not stop the others; an error is only returned when the run could
+// not take place.
+func (r *KeyReconciler) RunOnce(ctx context.Context, trigger string) (*models.ReconcileRun, error) {
+	return r.Apply(ctx, trigger, nil)
+}
+
+// Apply is RunOnce limited to the changes of the given drift categories;
+// none means all of them. Changes of other categories are left for a later
+// run, though their keys still count as matched, so a key left under an
+// old ID is not revoked as missing.
+func (r *KeyReconciler) Apply(ctx context.Context, trigger string, categories []string) (*models.ReconcileRun, error) {
+	run := &models.ReconcileRun{Trigger: trigger, Categories: strings.Join(categories, ",")}
+	if err := r.apply(ctx, run, nil, categoryFilter(categories)); err != nil {
+		return nil, err
+	}
+	return run, nil
+}
+
+func categoryFilter(categories []string) func(keyOwner, KeyChange) bool {
+	return func(_ keyOwner, change KeyChange) bool {
+		return len(categories) == 0 || slices.Contains(categories, change.Category)
+	}
+}
+
+// apply reconciles owners, or every user and team if owners is nil,
+// making only the changes keep allows, and saves run with its counts.
+func (r *KeyReconciler) apply(ctx context.Context, run *models.ReconcileRun, owners []keyOwner, keep func(keyOwner, KeyChange) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if owners == nil {
+		var err error
+		if owners, err = r.owners(); err != nil {
+			return err
+		}
+	}
+
+	run.Status, run.StartedAt = "running", time.Now()
+	if run.ID != 0 {
+		if err := r.DB.Save(run).Error; err != nil {
+			return err
+		}
+	}
+	for _, owner := range owners {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if owner.TeamID != "" {
+			run.Teams++
+		} else {
+			run.Users++
+		}
+		changes, err := r.reconcileOwner(ctx, owner, func(change KeyChange) bool { return keep(owner, change) })
+		if err != nil {
+			run.Failed++
+			if run.Error == "" {
+				run.Error = fmt.Sprintf("%s: %v", owner, err)
+			}
+			log.Printf("Key reconciliation for %s failed: %v", owner, err)
+			continue
+		}
+		for _, change := range changes {
+			countChange(run, change.Action)
+		}
+	}
+	run.Status, run.FinishedAt = "finished", time.Now()
+
+	if err := r.DB.Save(run).Error; err != nil {
+		return err
+	}
+	log.Printf("Reconciled keys of %d users and %d teams: %d imported, %d rematched, %d reactivated, %d expired, %d revoked, %d retyped, %d redated, %d failed",
+		run.Users, run.Teams, run.Imported, run.Rematched, run.Reactivated, run.Expired, run.Revoked, run.Retyped, run.Redated, run.Failed)
+	return nil
+}
+
+// owners returns the users, then the teams, whose keys are reconciled.
+func (r *KeyReconciler) owners() ([]keyOwner, error) {
+	var provisioned, withKeys, teams, withTeamKeys []string
+	if err := r.DB.Model(&models.User{}).Pluck("id", &provisioned).Error; err != nil {
+		return nil, err
+	}
+	if err := r.DB.Model(&models.KeyHistory{}).Where("team_id = ?", "").Distinct().Pluck("user_id", &withKeys).Error; err != nil {
+		return nil, err
+	}
+	if err := r.DB.Model(&models.Team{}).Pluck("id", &teams).Error; err != nil {
+		return nil, err
+	}
+	if err := r.DB.Model(&models.KeyHistory{}).Where("team_id <> ?", "").Distinct().Pluck("team_id", &withTeamKeys).Error; err != nil {
+		return nil, err
+	}
+
+	var owners []keyOwner
+	for _, id := range uniqueSorted(append(provisioned, withKeys...)) {
+		owners = append(owners, keyOwner{UserID: id})
+	}
+	for _, id := range uniqueSorted(append(teams, withTeamKeys...)) {
+		owners = append(owners, keyOwner{TeamID: id})
+	}
+	return owners, nil
+}
+
+func uniqueSorted(ids []string) []string {
+	seen := make(map[string]bool)
+	var unique []string
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	sort.Strings(unique)
+	return unique
+}
+
+// listOwnerKeys lists the owner's keys and reports whether the listing is
+// complete. A key missing from a partial listing may still exist, so
+// partial listings are used for everything but revoking.
+func (r *KeyReconciler) listOwnerKeys(ctx context.Context, owner keyOwner) ([]LiteLLMKey, bool, error) {
+	var keys []LiteLLMKey
+	var err error
+	if owner.TeamID != "" {
+		keys, err = r.LiteLLMService.ListTeamKeys(ctx, owner.TeamID)
+	} else {
+		keys, err = r.LiteLLMService.ListKeys(ctx, owner.UserID)
+	}
+	if errors.Is(err, ErrIncompleteListing) {
+		log.Printf("Not revoking missing keys of %s: %v", owner, err)
+		return keys, false, nil
+	}
+	return keys, err == nil, err
+}
+
+// reconcileOwner applies the owner's planned changes that keep allows to
+// their rows as they stand once the listing is read, then audits them as
+// the sync's doing. Rows are read and changed in one transaction; an
+// import whose key was recorded in the meantime, by CreateKey, is dropped,
+// and a row created or revoked after the listing was requested is left
+// alone, as the listing may predate it.
+func (r *KeyReconciler) reconcileOwner(ctx context.Context, owner keyOwner, keep func(KeyChange) bool) ([]KeyChange, error) {
+	listedAt := time.Now()
+	keys, complete, err := r.listOwnerKeys(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []KeyChange
+	var after []models.KeyHistory
+	err = r.DB.Transaction(func(tx *gorm.DB) error {
+		applied, after = nil, nil
+		var rows []models.KeyHistory
+		if err := owner.rows(tx).Find(&rows).Error; err != nil {
+			return err
+		}
+		// Rows created or revoked since the listing was requested are newer
+		// than it, and left for the next run.
+		newer := make(map[uint]bool)
+		for _, row := range rows {
+			if row.CreatedAt.After(listedAt) || (row.RevokedAt != nil && row.RevokedAt.After(listedAt)) {
+				newer[row.ID] = true
+			}
+		}
+		for _, change := range planKeys(owner, rows, keys, complete, time.Now()).Changes {
+			if !keep(change) || newer[change.Key.ID] {
+				continue
+			}
+			var saved models.KeyHistory
+			if change.Updates == nil {
+				saved = *change.Key
+				result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&saved)
+				if result.Error != nil {
+					return result.Error
+				}
+				if result.RowsAffected == 0 {
+					continue
+				}
+			} else {
+				if err := tx.Model(&models.KeyHistory{}).Where("id = ?", change.Key.ID).Updates(change.Updates).Error; err != nil {
+					return err
+				}
+				if err := tx.First(&saved, change.Key.ID).Error; err != nil {
+					return err
+				}
+			}
+			applied = append(applied, change)
+			after = append(after, saved)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, change := range applied {
+		r.Audit.Record(audit.Event{
+			Actor:      "system:sync",
+			UserID:     after[i].UserID,
+			Action:     change.Action,
+			TargetType: "key",
+			TargetID:   after[i].LiteLLMKeyID,
+			Before:     change.Before,
+			After:      audit.KeyState(&after[i]),
+		})
+	}
+	return applied, nil
+}
+
+func countChange(run *models.ReconcileRun, action string) {
+	switch action {
+	case ActionSyncImport:
+		run.Imported++
+	case ActionSyncRematch:
+		run.Rematched++
+	case ActionSyncReactivate:
+		run.Reactivated++
+	case ActionSyncExpire:
+		run.Expired++
+	case ActionSyncRevoke:
+		run.Revoked++
+	case ActionSyncRetype:
+		run.Retyped++
+	case ActionSyncExpiry:
+		run.Redated++
+	}
+}
@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
+	"github.com/example/llmreq/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestKeyReconciler(t *testing.T) {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := litellmfake.New("sk-master")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	svc := &LiteLLMService{BaseURL: srv.URL, MasterKey: config.NewSecret("sk-master"), Client: srv.Client()}
+	r := &KeyReconciler{LiteLLMService: svc, DB: db, Audit: audit.NewLogger(db)}
+
+	past := time.Now().Add(-time.Hour)
+	known := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "known"})
+	renamed := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "renamed"})
+	expired := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "expired", Expires: &past})
+	revoked := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "revoked"})
+	imported := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "imported"})
+	fake.AddKey(litellmfake.Key{UserID: "bob@example.com", KeyAlias: "bob"})
+
+	db.Create(&[]models.KeyHistory{
+		{UserID: "alice@example.com", LiteLLMKeyID: known.Token, KeyName: "known", Status: "active"},
+		{UserID: "alice@example.com", LiteLLMKeyID: "sk-guessed", KeyName: "renamed", Status: "active"},
+		{UserID: "alice@example.com", LiteLLMKeyID: expired.Token, KeyName: "expired", Status: "active"},
+		{UserID: "alice@example.com", LiteLLMKeyID: revoked.Token, KeyName: "revoked", Status: "revoked", RevokedAt: &past},
+		{UserID: "alice@example.com", LiteLLMKeyID: "sk-gone", KeyName: "gone", Status: "active"},
+	})
+	db.Create(&models.User{ID: "bob@example.com"})
+
+	run, err := r.RunOnce(context.Background(), "manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := models.ReconcileRun{Users: 2, Imported: 2, Rematched: 1, Reactivated: 1, Expired: 1, Revoked: 1}
+	if run.Users != want.Users || run.Imported != want.Imported || run.Rematched != want.Rematched ||
+		run.Reactivated != want.Reactivated || run.Expired != want.Expired || run.Revoked != want.Revoked || run.Failed != 0 {
+		t.Errorf("Expected counts %+v, got %+v", want, run)
+	}
+
+	status := func(id string) string {
+		var key models.KeyHistory
+		db.Where("litellm_key_id = ?", id).First(&key)
+		return key.Status
+	}
+	for id, want := range map[string]string{
+		known.Token:    "active",
+		renamed.Token:  "active",
+		expired.Token:  "expired",
+		revoked.Token:  "active",
+		imported.Token: "active",
+		"sk-gone":      "revoked",
+	} {
+		if got := status(id); got != want {
+			t.Errorf("Expected %s to be %s, got %q", id, want, got)
+		}
+	}
+
+	var events int64
+	db.Model(&models.AuditEvent{}).Where("actor = ?", "system:sync").Count(&events)
+	if events != 6 {
+		t.Errorf("Expected an audit event per change, got %d", events)
+	}
+
+	// A second run finds nothing to do.
+	run, _ = r.RunOnce(context.Background(), "schedule")
+	if run.Imported+run.Rematched+run.Reactivated+run.Expired+run.Revoked != 0 {
+		t.Errorf("Expected no changes on the second run, got %+v", run)
+	}
+
+	// A user whose keys cannot be listed is counted and skipped.
+	fake.FailNext(1, http.StatusServiceUnavailable)
+	run, _ = r.RunOnce(context.Background(), "manual")
+	if run.Failed != 1 || !strings.HasPrefix(run.Error, "alice@example.com") {
+		t.Errorf("Expected alice to fail, got %+v", run)
+	}
+
+	var runs int64
+	db.Model(&models.ReconcileRun{}).Count(&runs)
+	if runs != 3 {
+		t.Errorf("Expected 3 recorded runs, got %d", runs)
+	}
+}
+
+func TestKeyDrift(t *testing.T) {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := litellmfake.New("sk-master")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	svc := &LiteLLMService{BaseURL: srv.URL, MasterKey: config.NewSecret("sk-master"), Client: srv.Client()}
+	r := &KeyReconciler{LiteLLMService: svc, DB: db, Audit: audit.NewLogger(db)}
+
+	past := time.Now().Add(-time.Hour)
+	longTerm := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "ci", Metadata: map[string]interface{}{"key_type": "long-term"}})
+	renamed := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "renamed"})
+	expired := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "expired", Expires: &past})
+	fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "unknown"})
+	db.Create(&[]models.KeyHistory{
+		{UserID: "alice@example.com", LiteLLMKeyID: longTerm.Token, KeyName: "ci", KeyType: "standard", Status: "active"},
+		{UserID: "alice@example.com", LiteLLMKeyID: "sk-guessed", KeyName: "renamed", Status: "active"},
+		{UserID: "alice@example.com", LiteLLMKeyID: expired.Token, KeyName: "expired", Status: "active"},
+		{UserID: "alice@example.com", LiteLLMKeyID: "sk-gone", KeyName: "gone", Status: "active"},
+	})
+
+	report, err := r.Drift(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for category, want := range map[string]int{
+		DriftUnknownUpstream: 1,
+		DriftMissingUpstream: 1,
+		DriftAliasCollision:  1,
+		DriftTypeMismatch:    1,
+		DriftExpiredActive:   1,
+		DriftInactiveListed:  0,
+	} {
+		if report.Counts[category] != want {
+			t.Errorf("Expected %d %s, got %d", want, category, report.Counts[category])
+		}
+	}
+	for _, item := range report.Items {
+		if item.Category == DriftAliasCollision && (item.KeyID != renamed.Token || item.FormerKeyID != "sk-guessed") {
+			t.Errorf("Expected the collision to name both IDs, got %+v", item)
+		}
+		if item.Category == DriftTypeMismatch && (item.Local["type"] != "standard" || item.Proposed["type"] != "long-term") {
+			t.Errorf("Expected the mismatch to show both types, got %+v", item)
+		}
+	}
+
+	var count int64
+	if db.Model(&models.KeyHistory{}).Where("status = ?", "active").Count(&count); count != 4 {
+		t.Errorf("Expected the dry run to change nothing, got %d active rows", count)
+	}
+
+	// Applying only some categories leaves the others, and a key left under
+	// its old ID is not revoked as missing.
+	run, err := r.Apply(context.Background(), "manual", []string{DriftTypeMismatch, DriftMissingUpstream})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Retyped != 1 || run.Revoked != 1 || run.Imported+run.Rematched+run.Expired != 0 || run.Categories != "type_mismatch,missing_upstream" {
+		t.Errorf("Expected only the selected changes, got %+v", run)
+	}
+	var guessed models.KeyHistory
+	db.Where("litellm_key_id = ?", "sk-guessed").First(&guessed)
+	if guessed.Status != "active" {
+		t.Errorf("Expected the unmatched row to be left alone, got %s", guessed.Status)
+	}
+
+	report, _ = r.Drift(context.Background())
+	if len(report.Items) != 3 || report.Counts[DriftTypeMismatch] != 0 || report.Counts[DriftMissingUpstream] != 0 {
+		t.Errorf("Expected the other categories to remain, got %+v", report.Counts)
+	}
+}
+
+func TestKeyReconcilerTeams(t *testing.T) {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := litellmfake.New("sk-master")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	svc := &LiteLLMService{BaseURL: srv.URL, MasterKey: config.NewSecret("sk-master"), Client: srv.Client()}
+	r := &KeyReconciler{LiteLLMService: svc, DB: db, Audit: audit.NewLogger(db)}
+
+	fake.AddTeam(litellmfake.Team{ID: "team-1"})
+	shared := fake.AddKey(litellmfake.Key{TeamID: "team-1", KeyAlias: "shared", Metadata: map[string]interface{}{"created_by": "lead@example.com"}})
+	// A team key that also names a user is the team's, not the user's.
+	named := fake.AddKey(litellmfake.Key{TeamID: "team-1", UserID: "alice@example.com", KeyAlias: "named"})
+	db.Create(&models.User{ID: "alice@example.com"})
+	db.Create(&models.Team{ID: "team-1"})
+	db.Create(&models.KeyHistory{UserID: "lead@example.com", TeamID: "team-1", LiteLLMKeyID: "sk-team-gone", KeyName: "gone", Status: "active"})
+
+	report, err := r.Drift(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Users != 1 || report.Teams != 1 || report.Counts[DriftUnknownUpstream] != 2 || report.Counts[DriftMissingUpstream] != 1 {
+		t.Fatalf("Expected the team's drift to be reported, got %+v", report)
+	}
+	for _, item := range report.Items {
+		if item.TeamID != "team-1" {
+			t.Errorf("Expected only team-1 drift, got %+v", item)
+		}
+		if item.KeyID == shared.Token && item.UserID != "lead@example.com" {
+			t.Errorf("Expected the shared key to be recorded under its creator, got %+v", item)
+		}
+	}
+
+	run, err := r.RunOnce(context.Background(), "manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Users != 1 || run.Teams != 1 || run.Imported != 2 || run.Revoked != 1 || run.Failed != 0 {
+		t.Errorf("Expected the team's keys to be reconciled, got %+v", run)
+	}
+	for id, want := range map[string]string{shared.Token: "lead@example.com", named.Token: "alice@example.com"} {
+		var key models.KeyHistory
+		db.Where("litellm_key_id = ?", id).First(&key)
+		if key.TeamID != "team-1" || key.UserID != want || key.Status != "active" {
+			t.Errorf("Expected %s as a team key of %s, got %+v", id, want, key)
+		}
+	}
+	var gone models.KeyHistory
+	db.Where("litellm_key_id = ?", "sk-team-gone").First(&gone)
+	if gone.Status != "revoked" {
+		t.Errorf("Expected the missing team key to be revoked, got %s", gone.Status)
+	}
+}
+
+func TestKeyReconcilerAppliesReviewedReport(t *testing.T) {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := litellmfake.New("sk-master")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	svc := &LiteLLMService{BaseURL: srv.URL, MasterKey: config.NewSecret("sk-master"), Client: srv.Client()}
+	r := &KeyReconciler{LiteLLMService: svc, DB: db, Audit: audit.NewLogger(db)}
+	ctx := context.Background()
+
+	reviewed := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "laptop"})
+	db.Create(&models.KeyHistory{UserID: "alice@example.com", LiteLLMKeyID: "sk-gone", KeyName: "gone", Status: "active"})
+
+	if _, err := r.QueueRun(99, nil); !errors.Is(err, ErrReportNotFound) {
+		t.Errorf("Expected an unknown report to be rejected, got %v", err)
+	}
+	record, err := r.QueueReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.QueueRun(record.ID, nil); !errors.Is(err, ErrReportNotReady) {
+		t.Errorf("Expected a pending report to be rejected, got %v", err)
+	}
+	r.ProcessPending(ctx)
+	db.First(record, record.ID)
+	if record.Status != "ready" || record.FinishedAt == nil {
+		t.Fatalf("Expected the report to be ready, got %+v", record)
+	}
+
+	// Drift that appears after the review is left for a later run, and a
+	// reviewed change that no longer applies is skipped.
+	fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "later"})
+	db.Create(&models.KeyHistory{UserID: "bob@example.com", LiteLLMKeyID: "sk-bob", KeyName: "bob", Status: "active"})
+	db.Model(&models.KeyHistory{}).Where("litellm_key_id = ?", "sk-gone").Update("status", "revoked")
+
+	queued, err := r.QueueRun(record.ID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queued.Status != "pending" || queued.ReportID == nil || *queued.ReportID != record.ID {
+		t.Errorf("Expected a pending run of the report, got %+v", queued)
+	}
+	r.ProcessPending(ctx)
+
+	var run models.ReconcileRun
+	db.First(&run, queued.ID)
+	if run.Status != "finished" || run.Users != 1 || run.Imported != 1 || run.Revoked != 0 || run.Skipped != 1 {
+		t.Errorf("Expected only the reviewed import, got %+v", run)
+	}
+	var imported []models.KeyHistory
+	db.Where("user_id = ? AND status = ?", "alice@example.com", "active").Find(&imported)
+	if len(imported) != 1 || imported[0].LiteLLMKeyID != reviewed.Token {
+		t.Errorf("Expected only the reviewed key to be imported, got %+v", imported)
+	}
+	var bob models.KeyHistory
+	db.Where("litellm_key_id = ?", "sk-bob").First(&bob)
+	if bob.Status != "active" {
+		t.Errorf("Expected drift outside the report to be left alone, got %s", bob.Status)
+	}
+}
+
+// listHookClient calls afterList once a user's keys have been listed, as a
+// request racing the reconciler would.
+type listHookClient struct {
+	LiteLLMClient
+	afterList func()
+}
+
+func (c *listHookClient) ListKeys(ctx context.Context, userID string) ([]LiteLLMKey, error) {
+	keys, err := c.LiteLLMClient.ListKeys(ctx, userID)
+	c.afterList()
+	return keys, err
+}
+
+func TestKeyReconcilerListingRace(t *testing.T) {
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	fake := litellmfake.New("sk-master")
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	svc := &LiteLLMService{BaseURL: srv.URL, MasterKey: config.NewSecret("sk-master"), Client: srv.Client()}
+
+	recorded := time.Now().Add(30 * 24 * time.Hour)
+	renewed := recorded.Add(30 * 24 * time.Hour).Truncate(time.Second)
+	deleted := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "deleted"})
+	extended := fake.AddKey(litellmfake.Key{UserID: "alice@example.com", KeyAlias: "extended", Expires: &renewed})
+	db.Create(&[]models.KeyHistory{
+		{UserID: "alice@example.com", LiteLLMKeyID: deleted.Token, KeyName: "deleted", Status: "active"},
+		{UserID: "alice@example.com", LiteLLMKeyID: extended.Token, KeyName: "extended", Status: "active", ExpiresAt: &recorded},
+	})
+
+	// The key is deleted once LiteLLM has listed it, so the listing is
+	// stale by the time it is applied.
+	client := &listHookClient{LiteLLMClient: svc, afterList: func() {
+		now := time.Now()
+		db.Model(&models.KeyHistory{}).Where("litellm_key_id = ?", deleted.Token).
+			Updates(map[string]interface{}{"status": "revoked", "revoked_at": &now})
+	}}
+	r := &KeyReconciler{LiteLLMService: client, DB: db, Audit: audit.NewLogger(db)}
+
+	run, err := r.RunOnce(context.Background(), "manual")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run.Reactivated != 0 || run.Redated != 1 {
+		t.Errorf("Expected only the expiry to change, got %+v", run)
+	}
+	var rows []models.KeyHistory
+	db.Order("id").Find(&rows)
+	if rows[0].Status != "revoked" {
+		t.Errorf("Expected the key revoked during the run to stay revoked, got %s", rows[0].Status)
+	}
+	if rows[1].ExpiresAt == nil || !rows[1].ExpiresAt.Equal(renewed) {
+		t.Errorf("Expected the key's expiry %v to be recorded, got %v", renewed, rows[1].ExpiresAt)
+	}
+}
@@ -0,0 +1,438 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/gorm"
+)
+
+type instanceKey struct{}
+
+// WithInstance pins the LiteLLM calls made with ctx to the named instance.
+// Callers use it when they placed something themselves: a user before their
+// record is saved, or a key that a key_types rule sends away from its owner.
+func WithInstance(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, instanceKey{}, name)
+}
+
+func InstanceFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(instanceKey{}).(string)
+	return name
+}
+
+// StoredInstance returns how name is recorded on users, teams and keys: ""
+// for the default instance, so rows from before instances existed need no
+// migration.
+func StoredInstance(name string) string {
+	if name == config.DefaultInstance {
+		return ""
+	}
+	return name
+}
+
+func orDefault(name string) string {
+	if name == "" {
+		return config.DefaultInstance
+	}
+	return name
+}
+
+var litellmFailovers = expvar.NewMap("litellm_failovers")
+
+// Router sends each LiteLLM call to the instance holding the user, team or
+// key it concerns. Placement is recorded locally: users and teams keep the
+// instance they were created on and key_history the instance of each key.
+// Reads fall back to an instance's fallback while it is unreachable.
+type Router struct {
+	Clients   map[string]LiteLLMClient
+	Fallbacks map[string]string
+	DB        *gorm.DB
+
+	// listed remembers where keys seen in listings live, for keys llmreq
+	// did not create and so has no key_history row for. Entries expire
+	// listedTTL after the key was last listed, so it holds the keys of
+	// recent listings rather than of every owner ever listed.
+	mu     sync.Mutex
+	listed map[string]listedKey
+}
+
+// listedTTL is how long a listing is trusted to place its keys: long enough
+// for an action taken on a listed key, such as deleting it.
+const listedTTL = time.Hour
+
+type listedKey struct {
+	instance string
+	expires  time.Time
+}
+
+var _ LiteLLMClient = (*Router)(nil)
+
+// NewLiteLLMServices returns an HTTP service for every configured instance,
+// keyed by name.
+func NewLiteLLMServices() map[string]*LiteLLMService {
+	backends := make(map[string]*LiteLLMService)
+	for _, inst := range config.AppConfig.Instances() {
+		service := NewLiteLLMService()
+		service.BaseURL = inst.URL
+		service.MasterKey = inst.Key
+		backends[inst.Name] = service
+	}
+	return backends
+}
+
+// NewRoutedClient decorates every backend as NewLiteLLMClient does and routes
+// between them. With only the default instance it returns that instance's
+// client unrouted.
+func NewRoutedClient(backends map[string]*LiteLLMService, db *gorm.DB) LiteLLMClient {
+	if len(backends) == 1 && backends[config.DefaultInstance] != nil {
+		return NewLiteLLMClient(backends[config.DefaultInstance])
+	}
+
+	r := &Router{
+		Clients:   make(map[string]LiteLLMClient),
+		Fallbacks: make(map[string]string),
+		DB:        db,
+	}
+	Breakers = make(map[string]*CircuitBreaker)
+	for name, service := range backends {
+		client, breaker := decorate(service)
+		r.Clients[name] = client
+		if breaker != nil {
+			Breakers[name] = breaker
+		}
+	}
+	DefaultBreaker = Breakers[config.DefaultInstance]
+	for _, inst := range config.AppConfig.Instances() {
+		if inst.Fallback != "" {
+			r.Fallbacks[inst.Name] = inst.Fallback
+		}
+	}
+	return r
+}
+
+func (r *Router) client(name string) (LiteLLMClient, error) {
+	client, ok := r.Clients[orDefault(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown LiteLLM instance %q", name)
+	}
+	return client, nil
+}
+
+// routedRead runs call on the named instance and, while that instance is
+// unreachable, on its fallback.
+func routedRead[T any](r *Router, name string, call func(LiteLLMClient) (T, error)) (T, error) {
+	result, _, err := routedReadFrom(r, name, call)
+	return result, err
+}
+
+// routedReadFrom is routedRead that also returns the instance that answered.
+func routedReadFrom[T any](r *Router, name string, call func(LiteLLMClient) (T, error)) (T, string, error) {
+	name = orDefault(name)
+	client, err := r.client(name)
+	if err != nil {
+		var zero T
+		return zero, name, err
+	}
+	result, err := call(client)
+	fallback := r.Fallbacks[name]
+	if err == nil || fallback == "" || !unreachable(err) {
+		return result, name, err
+	}
+
+	client, ferr := r.client(fallback)
+	if ferr != nil {
+		return result, name, err
+	}
+	log.Printf("LiteLLM instance %s unreachable, reading from %s: %v", name, fallback, err)
+	litellmFailovers.Add(name, 1)
+	result, err = call(client)
+	return result, fallback, err
+}
+
+// unreachable reports whether err means the instance could not answer, as
+// opposed to answering with an error.
+func unreachable(err error) bool {
+	var open *CircuitOpenError
+	return IsTransient(err) || errors.As(err, &open) || errors.Is(err, ErrUnavailable)
+}
+
+// userInstance is the instance a user was provisioned on. Users without a
+// local record yet are placed by the routing rules on their email domain.
+func (r *Router) userInstance(ctx context.Context, userID string) string {
+	if name := InstanceFromContext(ctx); name != "" {
+		return name
+	}
+	var user models.User
+	if r.DB.Select("instance").Where("id = ?", userID).Limit(1).Find(&user).RowsAffected > 0 {
+		return orDefault(user.Instance)
+	}
+	return config.AppConfig.ResolveInstance(config.Route{Email: userID})
+}
+
+// teamInstance is the instance a team was created on, or for teams llmreq
+// did not create, the one the routing rules give its ID.
+func (r *Router) teamInstance(ctx context.Context, teamID string) string {
+	if name := InstanceFromContext(ctx); name != "" {
+		return name
+	}
+	var team models.Team
+	if r.DB.Select("instance").Where("id = ?", teamID).Limit(1).Find(&team).RowsAffected > 0 {
+		return orDefault(team.Instance)
+	}
+	return config.AppConfig.ResolveInstance(config.Route{TeamID: teamID})
+}
+
+// keyInstance is where key_history, or failing that a listing, saw keyID.
+func (r *Router) keyInstance(ctx context.Context, keyID string) string {
+	var key models.KeyHistory
+	if r.DB.Select("instance").Where("litellm_key_id = ? AND status <> ?", keyID, "superseded").Limit(1).Find(&key).RowsAffected > 0 {
+		return orDefault(key.Instance)
+	}
+	r.mu.Lock()
+	listed, ok := r.listed[keyID]
+	r.mu.Unlock()
+	if ok && time.Now().Before(listed.expires) {
+		return listed.instance
+	}
+	return orDefault(InstanceFromContext(ctx))
+}
+
+// keyInstances returns primary followed by every other instance holding keys
+// that match query on key_history.
+func (r *Router) keyInstances(primary string, query string, args ...interface{}) []string {
+	var stored []string
+	r.DB.Model(&models.KeyHistory{}).Where(query, args...).Distinct().Pluck("instance", &stored)
+	names := []string{primary}
+	seen := map[string]bool{primary: true}
+	for _, name := range stored {
+		if name = orDefault(name); !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names
+}
+
+// listAcross merges a key listing from every named instance. Keys are
+// labelled with the instance that actually listed them. A partial listing
+// from any of them makes the merged one partial, and so does a listing read
+// from a fallback, since the keys of the unreachable instance are missing.
+func (r *Router) listAcross(names []string, list func(LiteLLMClient) ([]LiteLLMKey, error)) ([]LiteLLMKey, error) {
+	var keys []LiteLLMKey
+	var incomplete error
+	seen := make(map[string]bool)
+	for _, name := range names {
+		found, answered, err := routedReadFrom(r, name, list)
+		if errors.Is(err, ErrIncompleteListing) {
+			incomplete = err
+		} else if err != nil {
+			return nil, err
+		}
+		if answered != orDefault(name) {
+			incomplete = fmt.Errorf("%w: instance %s unreachable, listed %s instead", ErrIncompleteListing, orDefault(name), answered)
+		}
+		r.remember(answered, found)
+		for _, k := range found {
+			if seen[k.Key] {
+				continue
+			}
+			seen[k.Key] = true
+			k.Instance = StoredInstance(answered)
+			keys = append(keys, k)
+		}
+	}
+	return keys, incomplete
+}
+
+// remember records where keys were listed and drops expired entries.
+func (r *Router) remember(name string, keys []LiteLLMKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if r.listed == nil {
+		r.listed = make(map[string]listedKey)
+	}
+	for id, k := range r.listed {
+		if !now.Before(k.expires) {
+			delete(r.listed, id)
+		}
+	}
+	for _, k := range keys {
+		r.listed[k.Key] = listedKey{instance: name, expires: now.Add(listedTTL)}
+	}
+}
+
+func (r *Router) GetUserInfo(ctx context.Context, userID string) (*LiteLLMUser, error) {
+	return routedRead(r, r.userInstance(ctx, userID), func(c LiteLLMClient) (*LiteLLMUser, error) { return c.GetUserInfo(ctx, userID) })
+}
+
+func (r *Router) CreateUser(ctx context.Context, req NewUserRequest) error {
+	client, err := r.client(r.userInstance(ctx, req.UserID))
+	if err != nil {
+		return err
+	}
+	return client.CreateUser(ctx, req)
+}
+
+func (r *Router) UpdateUser(ctx context.Context, req UpdateUserRequest) error {
+	client, err := r.client(r.userInstance(ctx, req.UserID))
+	if err != nil {
+		return err
+	}
+	return client.UpdateUser(ctx, req)
+}
+
+// ListKeys lists the user's keys on their own instance and on every other
+// instance key_history places one of their keys on.
+func (r *Router) ListKeys(ctx context.Context, userID string) ([]LiteLLMKey, error) {
+	names := r.keyInstances(r.userInstance(ctx, userID), "user_id = ? AND team_id = ?", userID, "")
+	return r.listAcross(names, func(c LiteLLMClient) ([]LiteLLMKey, error) { return c.ListKeys(ctx, userID) })
+}
+
+func (r *Router) ListTeamKeys(ctx context.Context, teamID string) ([]LiteLLMKey, error) {
+	names := r.keyInstances(r.teamInstance(ctx, teamID), "team_id = ?", teamID)
+	return r.listAcross(names, func(c LiteLLMClient) ([]LiteLLMKey, error) { return c.ListTeamKeys(ctx, teamID) })
+}
+
+// FindKeys searches the instance pinned on ctx, else those of the filtered
+// team or user, else all of them.
+func (r *Router) FindKeys(ctx context.Context, filter KeyFilter) ([]LiteLLMKey, error) {
+	var names []string
+	switch {
+	case InstanceFromContext(ctx) != "":
+		names = []string{InstanceFromContext(ctx)}
+	case filter.TeamID != "":
+		names = r.keyInstances(r.teamInstance(ctx, filter.TeamID), "team_id = ?", filter.TeamID)
+	case filter.UserID != "":
+		names = r.keyInstances(r.userInstance(ctx, filter.UserID), "user_id = ? AND team_id = ?", filter.UserID, "")
+	default:
+		for name := range r.Clients {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	return r.listAcross(names, func(c LiteLLMClient) ([]LiteLLMKey, error) { return c.FindKeys(ctx, filter) })
+}
+
+// GenerateKey creates the key on the instance pinned on ctx, else on its
+// owner's, and reports which in the response's Instance.
+func (r *Router) GenerateKey(ctx context.Context, req GenerateKeyRequest) (*GenerateKeyResponse, error) {
+	name := InstanceFromContext(ctx)
+	if name == "" && req.TeamID != "" {
+		name = r.teamInstance(ctx, req.TeamID)
+	} else if name == "" {
+		name = r.userInstance(ctx, req.UserID)
+	}
+	client, err := r.client(name)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.GenerateKey(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Instance = StoredInstance(orDefault(name))
+	return resp, nil
+}
+
+func (r *Router) UpdateKey(ctx context.Context, req UpdateKeyRequest) error {
+	client, err := r.client(r.keyInstance(ctx, req.Key))
+	if err != nil {
+		return err
+	}
+	return client.UpdateKey(ctx, req)
+}
+
+func (r *Router) DeleteKey(ctx context.Context, keyID string) error {
+	client, err := r.client(r.keyInstance(ctx, keyID))
+	if err != nil {
+		return err
+	}
+	return client.DeleteKey(ctx, keyID)
+}
+
+func (r *Router) BlockKey(ctx context.Context, keyID string) error {
+	client, err := r.client(r.keyInstance(ctx, keyID))
+	if err != nil {
+		return err
+	}
+	return client.BlockKey(ctx, keyID)
+}
+
+func (r *Router) UnblockKey(ctx context.Context, keyID string) error {
+	client, err := r.client(r.keyInstance(ctx, keyID))
+	if err != nil {
+		return err
+	}
+	return client.UnblockKey(ctx, keyID)
+}
+
+// CreateTeam creates the team on the instance pinned on ctx, else on that of
+// its first member, and reports which in the team's Instance.
+func (r *Router) CreateTeam(ctx context.Context, req NewTeamRequest) (*LiteLLMTeam, error) {
+	name := InstanceFromContext(ctx)
+	if name == "" && len(req.Members) > 0 {
+		name = r.userInstance(ctx, req.Members[0].UserID)
+	}
+	client, err := r.client(name)
+	if err != nil {
+		return nil, err
+	}
+	team, err := client.CreateTeam(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	team.Instance = StoredInstance(orDefault(name))
+	return team, nil
+}
+
+func (r *Router) GetTeamInfo(ctx context.Context, teamID string) (*LiteLLMTeam, error) {
+	return routedRead(r, r.teamInstance(ctx, teamID), func(c LiteLLMClient) (*LiteLLMTeam, error) { return c.GetTeamInfo(ctx, teamID) })
+}
+
+func (r *Router) UpdateTeam(ctx context.Context, req UpdateTeamRequest) error {
+	client, err := r.client(r.teamInstance(ctx, req.TeamID))
+	if err != nil {
+		return err
+	}
+	return client.UpdateTeam(ctx, req)
+}
+
+func (r *Router) AddTeamMember(ctx context.Context, teamID string, member LiteLLMTeamMember) error {
+	client, err := r.client(r.teamInstance(ctx, teamID))
+	if err != nil {
+		return err
+	}
+	return client.AddTeamMember(ctx, teamID, member)
+}
+
+func (r *Router) UpdateTeamMember(ctx context.Context, teamID, userID, role string) error {
+	client, err := r.client(r.teamInstance(ctx, teamID))
+	if err != nil {
+		return err
+	}
+	return client.UpdateTeamMember(ctx, teamID, userID, role)
+}
+
+func (r *Router) RemoveTeamMember(ctx context.Context, teamID, userID string) error {
+	client, err := r.client(r.teamInstance(ctx, teamID))
+	if err != nil {
+		return err
+	}
+	return client.RemoveTeamMember(ctx, teamID, userID)
+}
+
+// ListModels lists the models of the instance pinned on ctx.
+func (r *Router) ListModels(ctx context.Context) ([]LiteLLMModel, error) {
+	return routedRead(r, InstanceFromContext(ctx), func(c LiteLLMClient) ([]LiteLLMModel, error) { return c.ListModels(ctx) })
+}
@@ -0,0 +1,117 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/litellmfake"
+	"github.com/example/llmreq/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRouter(t *testing.T) {
+	ctx := context.Background()
+	config.AppConfig = &config.Config{
+		LiteLLMInstances: []config.LiteLLMInstance{
+			{Name: "eu", URL: "http://eu", Fallback: "eu-replica"},
+			{Name: "eu-replica", URL: "http://eu-replica"},
+		},
+		InstanceRules: []config.InstanceRule{{Instance: "eu", EmailDomains: []string{"example.eu"}}},
+	}
+
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+
+	// Every instance knows every user, with its own name as their email, so
+	// answers show which instance gave them.
+	fakes := map[string]*litellmfake.Server{}
+	r := &Router{Clients: map[string]LiteLLMClient{}, Fallbacks: map[string]string{"eu": "eu-replica"}, DB: db}
+	for _, name := range []string{"default", "eu", "eu-replica"} {
+		fakes[name] = litellmfake.New("sk-master")
+		for _, id := range []string{"ana@example.com", "bob@example.eu", "carol@example.com"} {
+			fakes[name].AddUser(litellmfake.User{ID: id, Email: name})
+		}
+		server := httptest.NewServer(fakes[name])
+		defer server.Close()
+		r.Clients[name] = &LiteLLMService{BaseURL: server.URL, MasterKey: config.NewSecret("sk-master"), Client: server.Client()}
+	}
+
+	// Users are read from the instance they were provisioned on, and those
+	// without a record from the one the rules give their email.
+	db.Create(&models.User{ID: "ana@example.com", Instance: "eu"})
+	if user, err := r.GetUserInfo(ctx, "ana@example.com"); err != nil || user.UserEmail != "eu" {
+		t.Errorf("Expected ana to be read from eu, got %+v, %v", user, err)
+	}
+	if user, err := r.GetUserInfo(ctx, "bob@example.eu"); err != nil || user.UserEmail != "eu" {
+		t.Errorf("Expected an example.eu user to be placed on eu, got %+v, %v", user, err)
+	}
+	if user, err := r.GetUserInfo(ctx, "carol@example.com"); err != nil || user.UserEmail != "default" {
+		t.Errorf("Expected carol to be read from default, got %+v, %v", user, err)
+	}
+
+	// A pinned context overrides the owner's instance and is reported back.
+	resp, err := r.GenerateKey(WithInstance(ctx, "default"), GenerateKeyRequest{UserID: "ana@example.com"})
+	if _, ok := fakes["default"].Key(resp.Key); err != nil || !ok || resp.Instance != "" {
+		t.Errorf("Expected key on the default instance, got %+v, %v", resp, err)
+	}
+	resp, err = r.GenerateKey(ctx, GenerateKeyRequest{UserID: "ana@example.com"})
+	if _, ok := fakes["eu"].Key(resp.Key); err != nil || !ok || resp.Instance != "eu" {
+		t.Errorf("Expected key on eu, got %+v, %v", resp, err)
+	}
+
+	// Listings merge every instance key_history places the user's keys on.
+	db.Create(&models.KeyHistory{UserID: "ana@example.com", LiteLLMKeyID: "sk-default", Status: "active"})
+	keys, err := r.ListKeys(ctx, "ana@example.com")
+	if err != nil || len(keys) != 2 || keys[0].Instance != "eu" || keys[1].Instance != "" {
+		t.Errorf("Expected keys from eu and default, got %+v, %v", keys, err)
+	}
+
+	// Key writes go where key_history recorded the key.
+	carol := fakes["eu"].AddKey(litellmfake.Key{UserID: "carol@example.com"})
+	db.Create(&models.KeyHistory{UserID: "carol@example.com", LiteLLMKeyID: carol.Token, Instance: "eu", Status: "active"})
+	if err := r.DeleteKey(ctx, carol.Token); err != nil || fakes["eu"].Calls("/key/delete") != 1 || fakes["default"].Calls("/key/delete") != 0 {
+		t.Errorf("Expected carol's key to be deleted on eu, got %v", err)
+	}
+
+	// A key llmreq has no record of is written where it was last listed,
+	// until that listing is too old to trust, and the entry is dropped by the
+	// next listing.
+	imported := fakes["eu"].AddKey(litellmfake.Key{UserID: "bob@example.eu"})
+	if _, err := r.ListKeys(ctx, "bob@example.eu"); err != nil || r.keyInstance(ctx, imported.Token) != "eu" {
+		t.Errorf("Expected the listed key to be placed on eu, got %q, %v", r.keyInstance(ctx, imported.Token), err)
+	}
+	r.mu.Lock()
+	r.listed[imported.Token] = listedKey{instance: "eu", expires: time.Now()}
+	r.mu.Unlock()
+	if name := r.keyInstance(ctx, imported.Token); name != "default" {
+		t.Errorf("Expected an expired listing to be ignored, got %q", name)
+	}
+	_, _ = r.ListKeys(ctx, "carol@example.com")
+	if _, ok := r.listed[imported.Token]; ok {
+		t.Error("Expected the expired entry to be pruned")
+	}
+
+	// Reads fail over while an instance is down; writes do not.
+	replicated := fakes["eu-replica"].AddKey(litellmfake.Key{UserID: "ana@example.com"})
+	fakes["eu"].SetFaults(litellmfake.Faults{ErrorRate: 1})
+	if user, err := r.GetUserInfo(ctx, "ana@example.com"); err != nil || user.UserEmail != "eu-replica" {
+		t.Errorf("Expected read from eu-replica, got %+v, %v", user, err)
+	}
+	if err := r.UpdateUser(ctx, UpdateUserRequest{UserID: "ana@example.com"}); err == nil || fakes["eu-replica"].Calls("/user/update") != 0 {
+		t.Errorf("Expected write to fail without failover, got %v", err)
+	}
+
+	// A listing read from the fallback lacks the unreachable instance's keys,
+	// so it is incomplete, and its keys are labelled with the fallback.
+	keys, err = r.ListKeys(ctx, "ana@example.com")
+	if !errors.Is(err, ErrIncompleteListing) || len(keys) != 2 || keys[0].Key != replicated.Token || keys[0].Instance != "eu-replica" {
+		t.Errorf("Expected an incomplete listing from eu-replica, got %+v, %v", keys, err)
+	}
+}
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/gorm"
+)
+
+// SpendPoller periodically records the cumulative spend of every known user
+// and their keys into the spend_snapshots table. LiteLLM only reports current
+// totals, so these snapshots are the only source of spend history, including
+// for keys that have since been deleted upstream.
+type SpendPoller struct {
+	LiteLLMService LiteLLMClient
+	DB             *gorm.DB
+	Interval       time.Duration
+	Retention      time.Duration
+
+	// Alerter, when set, evaluates budget thresholds against the data fetched
+	// for each snapshot.
+	Alerter *BudgetAlerter
+}
+
+func NewSpendPoller(service LiteLLMClient, db *gorm.DB) *SpendPoller {
+	return &SpendPoller{
+		LiteLLMService: service,
+		DB:             db,
+		Interval:       config.AppConfig.SpendSnapshotInterval,
+		Retention:      config.AppConfig.SpendSnapshotRetention,
+	}
+}
+
+// Start takes a snapshot immediately and then once per Interval until ctx is
+// cancelled. It returns straight away if Interval is not positive.
+func (p *SpendPoller) Start(ctx context.Context) {
+	if p.Interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+
+	for {
+		if err := p.RunOnce(ctx); err != nil {
+			log.Printf("Spend snapshot failed: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunOnce records one snapshot for every user that has a key history entry.
+// A failure for one user is logged and does not stop the others.
+func (p *SpendPoller) RunOnce(ctx context.Context) error {
+	var userIDs []string
+	if err := p.DB.Model(&models.KeyHistory{}).Distinct().Pluck("user_id", &userIDs).Error; err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, userID := range userIDs {
+		if err := p.snapshotUser(ctx, userID, now); err != nil {
+			log.Printf("Spend snapshot for %s failed: %v", userID, err)
+		}
+	}
+
+	if p.Retention > 0 {
+		cutoff := now.Add(-p.Retention)
+		if err := p.DB.Where("captured_at < ?", cutoff).Delete(&models.SpendSnapshot{}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *SpendPoller) snapshotUser(ctx context.Context, userID string, capturedAt time.Time) error {
+	user, err := p.LiteLLMService.GetUserInfo(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	keys, err := p.LiteLLMService.ListKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	if p.Alerter != nil {
+		p.Alerter.CheckUser(user)
+		p.Alerter.CheckKeys(userID, keys)
+	}
+
+	var snapshots []models.SpendSnapshot
+	if user != nil {
+		snapshots = append(snapshots, models.SpendSnapshot{
+			UserID:     userID,
+			Spend:      user.Spend,
+			MaxBudget:  user.MaxBudget,
+			CapturedAt: capturedAt,
+		})
+	}
+	for _, k := range keys {
+		if k.User != userID {
+			continue
+		}
+		snapshots = append(snapshots, models.SpendSnapshot{
+			UserID:       userID,
+			LiteLLMKeyID: k.Key,
+			KeyName:      k.KeyAlias,
+			Spend:        k.Spend,
+			MaxBudget:    k.MaxBudget,
+			CapturedAt:   capturedAt,
+		})
+	}
+
+	if len(snapshots) == 0 {
+		return nil
+	}
+	return p.DB.Create(&snapshots).Error
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestSpendPoller_RunOnce(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/user/info/test@example.com" {
+			w.WriteHeader(http.StatusOK)
+			_ = json.NewEncoder(w).Encode(LiteLLMUser{UserID: "test@example.com", Spend: 2.5, MaxBudget: 10})
+			return
+		}
+		if r.URL.Path == "/key/list" {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`{"keys": [
+				{"key": "sk-a", "key_alias": "a", "spend": 1.5, "user_id": "test@example.com"},
+				{"key": "sk-other", "key_alias": "other", "spend": 9, "user_id": "other@example.com"}
+			]}`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	db, _ := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{})
+	if err := models.Migrate(db); err != nil {
+		t.Fatalf("failed to migrate: %v", err)
+	}
+	db.Create(&models.KeyHistory{UserID: "test@example.com", LiteLLMKeyID: "sk-a", Status: "active"})
+
+	// A snapshot older than the retention window should be pruned.
+	db.Create(&models.SpendSnapshot{UserID: "test@example.com", Spend: 1, CapturedAt: time.Now().Add(-48 * time.Hour)})
+
+	config.AppConfig = &config.Config{SpendSnapshotInterval: time.Hour, SpendSnapshotRetention: 24 * time.Hour}
+	service := NewLiteLLMService()
+	service.BaseURL = server.URL
+	poller := NewSpendPoller(service, db)
+
+	if err := poller.RunOnce(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	var snapshots []models.SpendSnapshot
+	db.Order("litellm_key_id").Find(&snapshots)
+	if len(snapshots) != 2 {
+		t.Fatalf("Expected 2 snapshots, got %d", len(snapshots))
+	}
+	if snapshots[0].LiteLLMKeyID != "" || snapshots[0].Spend != 2.5 || snapshots[0].MaxBudget != 10 {
+		t.Errorf("Unexpected user snapshot: %+v", snapshots[0])
+	}
+	if snapshots[1].LiteLLMKeyID != "sk-a" || snapshots[1].Spend != 1.5 || snapshots[1].KeyName != "a" {
+		t.Errorf("Unexpected key snapshot: %+v", snapshots[1])
+	}
+}
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/gorm"
+)
+
+// TeamSyncer keeps a user's team memberships in line with the teams their
+// identity provider groups map to. Only memberships it created (source
+// "idp") are changed or removed; memberships added by a team admin are left
+// alone.
+type TeamSyncer struct {
+	LiteLLMService LiteLLMClient
+	DB             *gorm.DB
+	Audit          *audit.Logger
+}
+
+// NewTeamSyncer returns nil when no team mappings are configured.
+func NewTeamSyncer(service LiteLLMClient, db *gorm.DB) *TeamSyncer {
+	if len(config.AppConfig.TeamMappings) == 0 {
+		return nil
+	}
+	return &TeamSyncer{
+		LiteLLMService: service,
+		DB:             db,
+		Audit:          audit.NewLogger(db),
+	}
+}
+
+// Sync adds the user to newly granted teams, updates roles that changed and
+// removes the user from teams no group grants any more, revoking the keys
+// they created there. LiteLLM is only called for memberships that changed.
+// A failed change is left for the next sync to retry.
+func (s *TeamSyncer) Sync(ctx context.Context, userID string, groups []string) error {
+	desired := config.AppConfig.ResolveTeams(groups)
+
+	var current []models.TeamMember
+	if err := s.DB.Where("user_id = ?", userID).Find(&current).Error; err != nil {
+		return err
+	}
+
+	var errs []error
+	existing := make(map[string]bool)
+	for _, member := range current {
+		existing[member.TeamID] = true
+		if member.Source != "idp" {
+			continue
+		}
+
+		mapping, ok := desired[member.TeamID]
+		if !ok {
+			if err := s.removeMember(ctx, member); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if mapping.Role != member.Role {
+			if err := s.LiteLLMService.UpdateTeamMember(ctx, member.TeamID, userID, mapping.Role); err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			s.DB.Model(&member).Update("role", mapping.Role)
+			s.record(userID, "team.member_role", "team", member.TeamID,
+				map[string]string{"role": member.Role}, map[string]string{"role": mapping.Role})
+		}
+	}
+
+	for teamID, mapping := range desired {
+		if existing[teamID] {
+			continue
+		}
+		if err := s.addMember(ctx, userID, mapping); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("team sync for %s: %d change(s) failed, first: %w", userID, len(errs), errs[0])
+	}
+	return nil
+}
+
+func (s *TeamSyncer) addMember(ctx context.Context, userID string, mapping config.TeamMapping) error {
+	err := s.LiteLLMService.AddTeamMember(ctx, mapping.TeamID, LiteLLMTeamMember{
+		UserID:    userID,
+		UserEmail: userID,
+		Role:      mapping.Role,
+	})
+	if err != nil {
+		return err
+	}
+
+	team := models.Team{ID: mapping.TeamID}
+	instance := StoredInstance(config.AppConfig.ResolveInstance(config.Route{TeamID: mapping.TeamID}))
+	s.DB.Where(team).Attrs(models.Team{Alias: mapping.TeamAlias, Instance: instance}).FirstOrCreate(&team)
+
+	member := models.TeamMember{TeamID: mapping.TeamID, UserID: userID, Role: mapping.Role, Source: "idp"}
+	if err := s.DB.Create(&member).Error; err != nil {
+		return err
+	}
+	s.record(userID, "team.member_add", "team", mapping.TeamID, nil, map[string]string{"role": mapping.Role, "source": "idp"})
+	return nil
+}
+
+// removeMember revokes the user's keys in the team before dropping the
+// membership, so a failed revocation keeps the membership for a retry.
+func (s *TeamSyncer) removeMember(ctx context.Context, member models.TeamMember) error {
+	keys, err := s.LiteLLMService.ListTeamKeys(ctx, member.TeamID)
+	if err != nil {
+		return err
+	}
+
+	var dbKeys []models.KeyHistory
+	s.DB.Where("team_id = ? AND user_id = ? AND status = ?", member.TeamID, member.UserID, "active").Find(&dbKeys)
+	created := make(map[string]bool)
+	for _, k := range dbKeys {
+		created[k.LiteLLMKeyID] = true
+	}
+
+	now := time.Now()
+	for _, k := range keys {
+		if k.TeamId != member.TeamID {
+			continue
+		}
+		if createdBy, _ := k.Metadata["created_by"].(string); !created[k.Key] && createdBy != member.UserID {
+			continue
+		}
+		if err := s.LiteLLMService.DeleteKey(ctx, k.Key); err != nil {
+			return err
+		}
+		s.DB.Model(&models.KeyHistory{}).
+			Where("litellm_key_id = ? AND team_id = ?", k.Key, member.TeamID).
+			Updates(map[string]interface{}{"status": "revoked", "revoked_at": now, "revoked_reason": "team_access_lost"})
+		s.record(member.UserID, "key.revoke", "key", k.Key,
+			map[string]string{"status": "active", "team_id": member.TeamID},
+			map[string]string{"status": "revoked", "revoked_reason": "team_access_lost"})
+		log.Printf("Revoked key %s of %s after losing access to team %s", k.Key, member.UserID, member.TeamID)
+	}
+
+	if err := s.LiteLLMService.RemoveTeamMember(ctx, member.TeamID, member.UserID); err != nil {
+		return err
+	}
+	if err := s.DB.Delete(&member).Error; err != nil {
+		return err
+	}
+	s.record(member.UserID, "team.member_remove", "team", member.TeamID, map[string]string{"role": member.Role, "source": member.Source}, nil)
+	return nil
+}
+
+func (s *TeamSyncer) record(userID, action, targetType, targetID string, before, after interface{}) {
+	s.Audit.Record(audit.Event{
+		Actor:      "system:team-sync",
+		UserID:     userID,
+		Action:     action,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Before:     before,
+		After:      after,
+	})
+}
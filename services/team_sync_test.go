@@ -0,0 +1,90 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+)
+
+func TestTeamSyncer(t *testing.T) {
+	var calls []string
+	var deleted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		calls = append(calls, r.URL.Path)
+		switch r.URL.Path {
+		case "/key/list":
+			_, _ = w.Write([]byte(`{"keys": [
+				{"key": "sk-mine", "team_id": "team-old", "metadata": {"created_by": "a@example.com"}},
+				{"key": "sk-other", "team_id": "team-old", "metadata": {"created_by": "b@example.com"}}
+			]}`))
+		case "/key/delete":
+			for _, k := range body["keys"].([]interface{}) {
+				deleted = append(deleted, k.(string))
+			}
+		}
+	}))
+	defer server.Close()
+
+	config.AppConfig = &config.Config{TeamMappings: []config.TeamMapping{
+		{Group: "eng", TeamID: "team-eng", TeamAlias: "Engineering", Role: "user"},
+		{Group: "eng-leads", TeamID: "team-eng", Role: "admin"},
+		{Group: "old", TeamID: "team-old", Role: "user"},
+	}}
+	db := setupAlertTestDB(t)
+	svc := NewLiteLLMService()
+	svc.BaseURL = server.URL
+	s := NewTeamSyncer(svc, db)
+
+	db.Create(&models.TeamMember{TeamID: "team-old", UserID: "a@example.com", Role: "user", Source: "idp"})
+	db.Create(&models.TeamMember{TeamID: "team-manual", UserID: "a@example.com", Role: "user", Source: "manual"})
+	db.Create(&models.KeyHistory{UserID: "a@example.com", TeamID: "team-old", LiteLLMKeyID: "sk-mine", Status: "active"})
+
+	if err := s.Sync(context.Background(), "a@example.com", []string{"eng"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var members []models.TeamMember
+	db.Where("user_id = ?", "a@example.com").Order("team_id").Find(&members)
+	if len(members) != 2 || members[0].TeamID != "team-eng" || members[0].Source != "idp" || members[1].TeamID != "team-manual" {
+		t.Fatalf("Unexpected memberships %+v", members)
+	}
+	var team models.Team
+	db.Where("id = ?", "team-eng").First(&team)
+	if team.Alias != "Engineering" {
+		t.Errorf("Expected mapped team recorded locally, got %+v", team)
+	}
+	if len(deleted) != 1 || deleted[0] != "sk-mine" {
+		t.Errorf("Expected only the user's key in the lost team revoked, got %v", deleted)
+	}
+	var key models.KeyHistory
+	db.Where("litellm_key_id = ?", "sk-mine").First(&key)
+	if key.Status != "revoked" || key.RevokedAt == nil {
+		t.Errorf("Expected key history revoked, got %+v", key)
+	}
+
+	// Unchanged groups make no LiteLLM calls; a new group upgrades the role.
+	calls = nil
+	if err := s.Sync(context.Background(), "a@example.com", []string{"eng"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 0 {
+		t.Errorf("Expected no LiteLLM calls, got %v", calls)
+	}
+	if err := s.Sync(context.Background(), "a@example.com", []string{"eng", "eng-leads"}); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 1 || calls[0] != "/team/member_update" {
+		t.Errorf("Expected a member update, got %v", calls)
+	}
+	db.Where("team_id = ? AND user_id = ?", "team-eng", "a@example.com").First(&members[0])
+	if members[0].Role != "admin" {
+		t.Errorf("Expected admin role, got %s", members[0].Role)
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type LiteLLMTeam struct {
+	TeamID         string              `json:"team_id"`
+	TeamAlias      string              `json:"team_alias"`
+	MaxBudget      float64             `json:"max_budget,omitempty"`
+	BudgetDuration string              `json:"budget_duration,omitempty"`
+	BudgetResetAt  string              `json:"budget_reset_at,omitempty"`
+	Spend          float64             `json:"spend"`
+	Models         []string            `json:"models"`
+	Members        []LiteLLMTeamMember `json:"members_with_roles"`
+
+	// Instance is the LiteLLM instance the team was created on, set by
+	// Router; "" is the default instance.
+	Instance string `json:"-"`
+}
+
+type LiteLLMTeamMember struct {
+	UserID    string `json:"user_id"`
+	UserEmail string `json:"user_email,omitempty"`
+	Role      string `json:"role"` // "admin" or "user"
+}
+
+type NewTeamRequest struct {
+	TeamAlias      string              `json:"team_alias"`
+	Models         []string            `json:"models,omitempty"`
+	MaxBudget      float64             `json:"max_budget,omitempty"`
+	BudgetDuration string              `json:"budget_duration,omitempty"`
+	Members        []LiteLLMTeamMember `json:"members_with_roles,omitempty"`
+}
+
+// UpdateTeamRequest changes the fields that are set; nil fields are left
+// untouched by LiteLLM.
+type UpdateTeamRequest struct {
+	TeamID         string   `json:"team_id"`
+	TeamAlias      *string  `json:"team_alias,omitempty"`
+	Models         []string `json:"models,omitempty"`
+	MaxBudget      *float64 `json:"max_budget,omitempty"`
+	BudgetDuration *string  `json:"budget_duration,omitempty"`
+}
+
+type teamMemberAddRequest struct {
+	TeamID string            `json:"team_id"`
+	Member LiteLLMTeamMember `json:"member"`
+}
+
+func (s *LiteLLMService) CreateTeam(ctx context.Context, reqPayload NewTeamRequest) (*LiteLLMTeam, error) {
+	var team LiteLLMTeam
+	if err := s.postJSON(ctx, "/team/new", reqPayload, &team, "create team"); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// GetTeamInfo returns nil, nil when the team does not exist.
+func (s *LiteLLMService) GetTeamInfo(ctx context.Context, teamID string) (*LiteLLMTeam, error) {
+	ctx, cancel := s.withTimeout(ctx, "get_team_info")
+	defer cancel()
+
+	reqURL := fmt.Sprintf("%s/team/info?%s", s.BaseURL, url.Values{"team_id": {teamID}}.Encode())
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.setHeaders(req)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, newUpstreamError("get team info", resp)
+	}
+
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	return decodeTeamInfo(bodyBytes)
+}
+
+func (s *LiteLLMService) UpdateTeam(ctx context.Context, reqPayload UpdateTeamRequest) error {
+	return s.postJSON(ctx, "/team/update", reqPayload, nil, "update team")
+}
+
+type teamMemberRequest struct {
+	TeamID string `json:"team_id"`
+	UserID string `json:"user_id"`
+	Role   string `json:"role,omitempty"`
+}
+
+func (s *LiteLLMService) AddTeamMember(ctx context.Context, teamID string, member LiteLLMTeamMember) error {
+	return s.postJSON(ctx, "/team/member_add", teamMemberAddRequest{TeamID: teamID, Member: member}, nil, "add team member")
+}
+
+func (s *LiteLLMService) UpdateTeamMember(ctx context.Context, teamID, userID, role string) error {
+	return s.postJSON(ctx, "/team/member_update", teamMemberRequest{TeamID: teamID, UserID: userID, Role: role}, nil, "update team member")
+}
+
+func (s *LiteLLMService) RemoveTeamMember(ctx context.Context, teamID, userID string) error {
+	return s.postJSON(ctx, "/team/member_delete", teamMemberRequest{TeamID: teamID, UserID: userID}, nil, "remove team member")
+}
+
+// postJSON POSTs payload to path and decodes the response into out unless out
+// is nil. action describes the call in error messages and, with spaces
+// replaced by underscores, names the operation for per-operation timeouts.
+func (s *LiteLLMService) postJSON(ctx context.Context, path string, payload, out interface{}, action string) error {
+	ctx, cancel := s.withTimeout(ctx, strings.ReplaceAll(action, " ", "_"))
+	defer cancel()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.BaseURL+path, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	s.setHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return newUpstreamError(action, resp)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
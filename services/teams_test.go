@@ -0,0 +1,102 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLiteLLMService_Teams(t *testing.T) {
+	var memberAdd map[string]interface{}
+	var update map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/team/new":
+			var req NewTeamRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			_ = json.NewEncoder(w).Encode(LiteLLMTeam{TeamID: "team-1", TeamAlias: req.TeamAlias, Members: req.Members})
+		case "/team/info":
+			switch r.URL.Query().Get("team_id") {
+			case "team-1":
+				_, _ = w.Write([]byte(`{"team_id": "team-1", "team_info": {"team_id": "team-1", "team_alias": "ops", "spend": 1.5, "members_with_roles": [{"user_id": "a@example.com", "role": "admin"}]}}`))
+			case "team-bare":
+				_, _ = w.Write([]byte(`{"team_id": "team-bare", "team_alias": "bare"}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case "/team/member_add":
+			_ = json.NewDecoder(r.Body).Decode(&memberAdd)
+		case "/team/update":
+			_ = json.NewDecoder(r.Body).Decode(&update)
+		case "/key/list":
+			if r.URL.Query().Get("team_id") != "team-1" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"keys": [{"key": "sk-team", "team_id": "team-1"}]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	service := NewLiteLLMService()
+	service.BaseURL = server.URL
+
+	team, err := service.CreateTeam(context.Background(), NewTeamRequest{TeamAlias: "ops", Members: []LiteLLMTeamMember{{UserID: "a@example.com", Role: "admin"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if team.TeamID != "team-1" || len(team.Members) != 1 {
+		t.Errorf("Unexpected team %+v", team)
+	}
+
+	info, err := service.GetTeamInfo(context.Background(), "team-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TeamAlias != "ops" || info.Spend != 1.5 || len(info.Members) != 1 {
+		t.Errorf("Unexpected wrapped team info %+v", info)
+	}
+
+	info, err = service.GetTeamInfo(context.Background(), "team-bare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.TeamAlias != "bare" {
+		t.Errorf("Unexpected bare team info %+v", info)
+	}
+
+	info, err = service.GetTeamInfo(context.Background(), "missing")
+	if err != nil || info != nil {
+		t.Errorf("Expected nil, nil for missing team, got %v, %v", info, err)
+	}
+
+	if err := service.AddTeamMember(context.Background(), "team-1", LiteLLMTeamMember{UserID: "b@example.com", Role: "user"}); err != nil {
+		t.Fatal(err)
+	}
+	if memberAdd["team_id"] != "team-1" || memberAdd["member"].(map[string]interface{})["user_id"] != "b@example.com" {
+		t.Errorf("Unexpected member_add payload %v", memberAdd)
+	}
+
+	alias := "platform"
+	if err := service.UpdateTeam(context.Background(), UpdateTeamRequest{TeamID: "team-1", TeamAlias: &alias}); err != nil {
+		t.Fatal(err)
+	}
+	if update["team_alias"] != "platform" {
+		t.Errorf("Unexpected update payload %v", update)
+	}
+	if _, ok := update["max_budget"]; ok {
+		t.Errorf("Expected unset fields to be omitted, got %v", update)
+	}
+
+	keys, err := service.ListTeamKeys(context.Background(), "team-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0].TeamId != "team-1" {
+		t.Errorf("Unexpected team keys %+v", keys)
+	}
+}
@@ -0,0 +1,22 @@
+package services
+
+import "context"
+
+// Trace identifies the inbound request on whose behalf LiteLLM is called.
+type Trace struct {
+	RequestID   string
+	TraceParent string // W3C traceparent header, forwarded unchanged
+}
+
+type traceKey struct{}
+
+// WithTrace returns a context carrying t, which LiteLLMService forwards as
+// request headers.
+func WithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+func TraceFromContext(ctx context.Context) Trace {
+	t, _ := ctx.Value(traceKey{}).(Trace)
+	return t
+}
@@ -0,0 +1,48 @@
+package services
+
+import "github.com/example/llmreq/config"
+
+// NewUserRequestForTier builds the /user/new payload that provisions userID
+// with the limits of tier.
+func NewUserRequestForTier(userID string, tier config.UserTier) NewUserRequest {
+	return NewUserRequest{
+		UserID:         userID,
+		UserEmail:      userID,
+		MaxBudget:      tier.MaxBudget,
+		BudgetDuration: tier.BudgetDuration,
+		Models:         tier.Models,
+	}
+}
+
+// NoDefaultModels is LiteLLM's model list entry that grants a user no
+// models of their own; an empty list would grant every model.
+const NoDefaultModels = "no-default-models"
+
+// CutOffUserRequest builds the /user/update payload that leaves userID no
+// budget and no models of their own, so that nothing they still hold can
+// spend. Their tier is restored with UpdateUserRequestForTier.
+func CutOffUserRequest(userID string) UpdateUserRequest {
+	zero := 0.0
+	return UpdateUserRequest{UserID: userID, MaxBudget: &zero, Models: []string{NoDefaultModels}}
+}
+
+// UpdateUserRequestForTier builds the /user/update payload that replaces
+// userID's limits with those of tier, clearing any the tier does not set.
+func UpdateUserRequestForTier(userID string, tier config.UserTier) UpdateUserRequest {
+	req := UpdateUserRequest{
+		UserID: userID,
+		Models: tier.Models,
+	}
+	if req.Models == nil {
+		req.Models = []string{}
+	}
+	if tier.MaxBudget > 0 {
+		maxBudget := tier.MaxBudget
+		req.MaxBudget = &maxBudget
+	}
+	if tier.BudgetDuration != "" {
+		duration := tier.BudgetDuration
+		req.BudgetDuration = &duration
+	}
+	return req
+}
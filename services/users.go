@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/example/llmreq/audit"
+	"github.com/example/llmreq/config"
+	"github.com/example/llmreq/models"
+	"gorm.io/gorm"
+)
+
+// UserLifecycle activates and deactivates users on behalf of the identity
+// provider.
+type UserLifecycle struct {
+	LiteLLMService LiteLLMClient
+	DB             *gorm.DB
+	Audit          *audit.Logger
+}
+
+func NewUserLifecycle(service LiteLLMClient, db *gorm.DB) *UserLifecycle {
+	return &UserLifecycle{
+		LiteLLMService: service,
+		DB:             db,
+		Audit:          audit.NewLogger(db),
+	}
+}
+
+// Provision creates the user in LiteLLM with the limits of their tier unless
+// they already exist there.
+func (l *UserLifecycle) Provision(ctx context.Context, user *models.User) error {
+	ctx = WithInstance(ctx, orDefault(user.Instance))
+	existing, err := l.LiteLLMService.GetUserInfo(ctx, user.ID)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return nil
+	}
+	return l.LiteLLMService.CreateUser(ctx, NewUserRequestForTier(user.ID, l.tier(user)))
+}
+
+// Deactivate cuts the user off in LiteLLM by zeroing their budget and
+// model access, revokes every key they own or created and marks the user
+// deactivated so that the auth middleware turns them away. It is safe to
+// call again after a partial failure.
+func (l *UserLifecycle) Deactivate(ctx context.Context, user *models.User) error {
+	if err := l.LiteLLMService.UpdateUser(ctx, CutOffUserRequest(user.ID)); err != nil {
+		return fmt.Errorf("failed to cut off user's budget and models: %w", err)
+	}
+
+	if err := l.RevokeKeys(ctx, user.ID, "user_deactivated"); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if user.Status != "deactivated" {
+		user.PriorStatus = user.Status
+	}
+	user.Status = "deactivated"
+	user.DeactivatedAt = &now
+	return l.DB.Model(user).Select("status", "prior_status", "deactivated_at").Updates(user).Error
+}
+
+// Reactivate returns the user to the status they had before deactivation
+// and, unless they were offboarded, restores the limits of their tier.
+// Revoked keys stay revoked.
+func (l *UserLifecycle) Reactivate(ctx context.Context, user *models.User) error {
+	restored := user.PriorStatus
+	if restored == "" {
+		restored = "active"
+	}
+	if restored != "offboarded" {
+		if err := l.applyTier(ctx, user); err != nil {
+			return err
+		}
+	}
+
+	user.Status = restored
+	user.PriorStatus = ""
+	user.DeactivatedAt = nil
+	return l.DB.Model(user).Select("status", "prior_status", "deactivated_at").Updates(user).Error
+}
+
+// RevokeKeys deletes the user's personal keys and the team keys they created,
+// recording reason on their key history.
+func (l *UserLifecycle) RevokeKeys(ctx context.Context, userID, reason string) error {
+	keyIDs, err := l.userKeys(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, keyID := range keyIDs {
+		if err := l.revokeKey(ctx, userID, keyID, reason); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (l *UserLifecycle) revokeKey(ctx context.Context, userID, keyID, reason string) error {
+	if err := l.LiteLLMService.DeleteKey(ctx, keyID); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete key %s: %w", keyID, err)
+	}
+	var dbKey models.KeyHistory
+	l.DB.Where("litellm_key_id = ? AND status = ?", keyID, "active").Limit(1).Find(&dbKey)
+	before := audit.KeyState(&dbKey)
+	l.DB.Model(&models.KeyHistory{}).
+		Where("litellm_key_id = ? AND status = ?", keyID, "active").
+		Updates(map[string]interface{}{"status": "revoked", "revoked_at": time.Now(), "revoked_reason": reason, "blocked": false})
+	l.record(userID, "key.revoke", "key", keyID, before, map[string]interface{}{"status": "revoked", "revoked_reason": reason})
+	log.Printf("Revoked key %s of %s (%s)", keyID, userID, reason)
+	return nil
+}
+
+// userKeys returns the IDs of the user's personal keys in LiteLLM together
+// with every active key in their key history, which includes team keys they
+// created.
+func (l *UserLifecycle) userKeys(ctx context.Context, userID string) ([]string, error) {
+	keys, err := l.LiteLLMService.ListKeys(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var keyIDs []string
+	add := func(keyID string) {
+		if keyID != "" && !seen[keyID] {
+			seen[keyID] = true
+			keyIDs = append(keyIDs, keyID)
+		}
+	}
+	for _, k := range keys {
+		if k.User == userID {
+			add(k.Key)
+		}
+	}
+	var dbKeys []models.KeyHistory
+	l.DB.Where("user_id = ? AND status = ?", userID, "active").Order("id").Find(&dbKeys)
+	for _, k := range dbKeys {
+		add(k.LiteLLMKeyID)
+	}
+	return keyIDs, nil
+}
+
+// tier returns the user's recorded tier, or else the one resolved from their
+// email.
+func (l *UserLifecycle) tier(user *models.User) config.UserTier {
+	if tier, ok := config.AppConfig.UserTier(user.Tier); ok {
+		return tier
+	}
+	return config.AppConfig.ResolveUserTier(user.ID, nil)
+}